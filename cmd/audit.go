@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cmmorrow/b3tty/src"
+)
+
+// auditCmd groups subcommands that operate on the audit log.
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audit log utilities",
+	Long:  `Utilities for working with the audit log written to server.audit-log.`,
+}
+
+// auditVerifyCmd checks the hash chain of an audit log
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Check that an audit log has not been tampered with",
+	Long: `Checks the hash chain of an audit log. Each record carries the SHA-256 hash of
+the record before it, so a record that was changed or removed, other than the
+last one, is reported with its line number. The command exits with a non-zero
+status when the chain is broken.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, err := src.VerifyAuditLog(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %d records, hash chain intact\n", args[0], n)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cmmorrow/b3tty/src"
+)
+
+// configCmd groups subcommands that operate on the b3tty config file.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Config file utilities",
+	Long:  `Utilities for working with the b3tty config file.`,
+}
+
+// configSchemaCmd prints the config file JSON Schema
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the config file JSON Schema",
+	Long: `Prints a JSON Schema describing the b3tty config file to stdout. Editors such
+as VS Code can use the schema for autocomplete and inline validation of
+conf.yaml. For example, with the YAML extension installed, save the output and
+add the following comment to the top of the config file:
+
+	# yaml-language-server: $schema=/path/to/b3tty.schema.json
+
+A running server also serves the same schema at /config-schema.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, err := src.MarshalConfigSchema()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(out))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configSchemaCmd)
+}
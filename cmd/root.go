@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"os"
+	"time"
 
 	"github.com/cmmorrow/b3tty/src"
 	"github.com/spf13/cobra"
@@ -57,6 +58,28 @@ func init() {
 	viper.BindPFlags(startCmd.Flags())
 }
 
+// durationSetting parses the config value at key as a Go duration such as
+// "30s", exiting when it is malformed.
+func durationSetting(key string) time.Duration {
+	d, err := time.ParseDuration(viper.GetString(key))
+	if err != nil {
+		src.Errorf("invalid %s: %v", key, err)
+		os.Exit(1)
+	}
+	return d
+}
+
+// sizeSetting parses the config value at key as a size in bytes such as
+// "512M", exiting when it is malformed.
+func sizeSetting(key string) int64 {
+	n, err := src.ParseSize(viper.GetString(key))
+	if err != nil {
+		src.Errorf("invalid %s: %v", key, err)
+		os.Exit(1)
+	}
+	return n
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	profiles = make(map[string]src.Profile)
@@ -101,12 +124,81 @@ func initConfig() {
 		if viper.IsSet("server.key-file") {
 			keyFile = viper.GetString("server.key-file")
 		}
+		if viper.IsSet("server.client-ca-file") {
+			clientCAFile = viper.GetString("server.client-ca-file")
+		}
 		if viper.IsSet("server.no-auth") {
 			noAuth = viper.GetBool("server.no-auth")
 		}
 		if viper.IsSet("server.no-browser") {
 			noBrowser = viper.GetBool("server.no-browser")
 		}
+		if viper.IsSet("server.ping-interval") {
+			pingInterval = durationSetting("server.ping-interval")
+		}
+		if viper.IsSet("server.pong-timeout") {
+			pongTimeout = durationSetting("server.pong-timeout")
+		}
+		if viper.IsSet("server.detach-grace-period") {
+			detachGracePeriod = durationSetting("server.detach-grace-period")
+		}
+		if viper.IsSet("server.kill-grace-period") {
+			killGracePeriod = durationSetting("server.kill-grace-period")
+		}
+		if viper.IsSet("server.shutdown-drain-period") {
+			shutdownDrainPeriod = durationSetting("server.shutdown-drain-period")
+			if shutdownDrainPeriod == 0 {
+				// A zero drain period in the config file closes sessions at
+				// once.
+				shutdownDrainPeriod = -1
+			}
+		}
+		if viper.IsSet("server.read-buffer-size") {
+			readBufferSize = viper.GetInt("server.read-buffer-size")
+		}
+		if viper.IsSet("server.output-batch-window") {
+			outputBatchWindow = durationSetting("server.output-batch-window")
+			if outputBatchWindow == 0 {
+				// A zero window in the config file disables batching.
+				outputBatchWindow = -1
+			}
+		}
+		if viper.IsSet("server.output-batch-size") {
+			outputBatchSize = viper.GetInt("server.output-batch-size")
+		}
+		if viper.IsSet("server.flow-control-window") {
+			flowControlWindow = viper.GetInt("server.flow-control-window")
+		}
+		if viper.IsSet("server.max-sessions") {
+			maxSessions = viper.GetInt("server.max-sessions")
+		}
+		auditLogPath = viper.GetString("server.audit-log")
+		if viper.IsSet("server.compression.enabled") {
+			compression.Enabled = viper.GetBool("server.compression.enabled")
+		}
+		if viper.IsSet("server.compression.level") {
+			level := viper.GetInt("server.compression.level")
+			compression.Level = &level
+		}
+		if viper.IsSet("server.compression.threshold") {
+			threshold := viper.GetInt("server.compression.threshold")
+			compression.Threshold = &threshold
+		}
+		metrics.Enabled = viper.GetBool("server.metrics.enabled")
+		metrics.Address = viper.GetString("server.metrics.address")
+		logConfig.Format = viper.GetString("log.format")
+		logConfig.Level = viper.GetString("log.level")
+		logConfig.File = viper.GetString("log.file")
+		if viper.IsSet("log.max-size") {
+			logConfig.MaxSize = sizeSetting("log.max-size")
+		}
+		logConfig.MaxFiles = viper.GetInt("log.max-files")
+		if viper.IsSet("terminal.idle-timeout") {
+			idleTimeout = durationSetting("terminal.idle-timeout")
+		}
+		if viper.IsSet("terminal.max-session-duration") {
+			maxSessionDuration = durationSetting("terminal.max-session-duration")
+		}
 		if viper.IsSet("terminal.rows") {
 			rows = viper.GetInt("terminal.rows")
 		}
@@ -155,6 +247,12 @@ func initConfig() {
 		}
 
 		if viper.IsSet("profiles") {
+			// ReadProfileEnv reads directly from YAML to preserve the case
+			// of variable names; viper lowercases all keys.
+			profileEnvs, err := src.ReadProfileEnv(viper.ConfigFileUsed())
+			if err != nil {
+				src.Warnf("could not read profile environments from config: %v", err)
+			}
 			profileNames := viper.GetStringMap("profiles")
 			for name := range profileNames {
 				profileCfg := viper.Sub("profiles." + name)
@@ -170,8 +268,87 @@ func initConfig() {
 				workingDirectory := profileCfg.GetString("working-directory")
 				shell := profileCfg.GetString("shell")
 				title := profileCfg.GetString("title")
-				commands := profileCfg.GetStringSlice("commands")
-				profiles[name] = src.NewProfile(shell, workingDirectory, root, title, commands)
+				raw, _ := profileCfg.Get("commands").([]any)
+				commands, waits, err := src.ParseStartupCommands(raw)
+				if err != nil {
+					src.Errorf("invalid profiles.%s.commands: %v", name, err)
+					os.Exit(1)
+				}
+				if raw == nil {
+					commands = profileCfg.GetStringSlice("commands")
+				}
+				profile := src.NewProfile(shell, workingDirectory, root, title, commands)
+				profile.CommandWaits = waits
+				profile.CommandWait.Until = profileCfg.GetString("command-wait.until")
+				profile.CommandWait.Pattern = profileCfg.GetString("command-wait.pattern")
+				if profileCfg.IsSet("command-wait.quiet") {
+					profile.CommandWait.Quiet = durationSetting("profiles." + name + ".command-wait.quiet")
+				}
+				if profileCfg.IsSet("command-wait.timeout") {
+					profile.CommandWait.Timeout = durationSetting("profiles." + name + ".command-wait.timeout")
+				}
+				profile.Type = profileCfg.GetString("type")
+				if command, ok := profileCfg.Get("command").(string); ok {
+					argv, err := src.SplitCommand(command)
+					if err != nil {
+						src.Errorf("invalid profiles.%s.command: %v", name, err)
+						os.Exit(1)
+					}
+					profile.Command = argv
+				} else {
+					profile.Command = profileCfg.GetStringSlice("command")
+				}
+				profile.LoginShell = profileCfg.GetBool("login-shell")
+				profile.Argv0 = profileCfg.GetString("argv0")
+				profile.User = profileCfg.GetString("user")
+				profile.Group = profileCfg.GetString("group")
+				if profileCfg.IsSet("idle-timeout") {
+					profile.IdleTimeout = durationSetting("profiles." + name + ".idle-timeout")
+					if profile.IdleTimeout == 0 {
+						// A zero timeout in the config file disables the
+						// terminal setting for this profile.
+						profile.IdleTimeout = -1
+					}
+				}
+				if profileCfg.IsSet("max-session-duration") {
+					profile.MaxSessionDuration = durationSetting("profiles." + name + ".max-session-duration")
+					if profile.MaxSessionDuration == 0 {
+						profile.MaxSessionDuration = -1
+					}
+				}
+				profile.MaxSessions = profileCfg.GetInt("max-sessions")
+				profile.OnExit = profileCfg.GetString("on-exit")
+				profile.RestartLimit = profileCfg.GetInt("restart-limit")
+				if profileCfg.IsSet("restart-backoff") {
+					profile.RestartBackoff = durationSetting("profiles." + name + ".restart-backoff")
+				}
+				profile.Env = profileEnvs[name]
+				profile.EnvFiles = profileCfg.GetStringSlice("env-file")
+				profile.InheritEnv = src.EnvFilter{
+					Allow: profileCfg.GetStringSlice("inherit-env.allow"),
+					Deny:  profileCfg.GetStringSlice("inherit-env.deny"),
+				}
+				profile.Sandbox = src.Sandbox{
+					ReadOnly:   profileCfg.GetStringSlice("sandbox.read-only"),
+					PrivateTmp: profileCfg.GetBool("sandbox.private-tmp"),
+				}
+				if profileCfg.IsSet("limits.memory") {
+					profile.Limits.Memory = sizeSetting("profiles." + name + ".limits.memory")
+				}
+				if profileCfg.IsSet("limits.cpu-time") {
+					profile.Limits.CPUTime = durationSetting("profiles." + name + ".limits.cpu-time")
+				}
+				profile.Limits.OpenFiles = profileCfg.GetInt("limits.open-files")
+				profile.Limits.Processes = profileCfg.GetInt("limits.processes")
+				if profileCfg.IsSet("limits.core-size") {
+					profile.Limits.CoreSize = sizeSetting("profiles." + name + ".limits.core-size")
+					if profile.Limits.CoreSize == 0 {
+						// A core size of 0 disables core dumps, which
+						// Limits spells as a negative size.
+						profile.Limits.CoreSize = -1
+					}
+				}
+				profiles[name] = profile
 			}
 		}
 
@@ -22,9 +22,26 @@ var theme src.Theme
 var tls bool
 var certFile string
 var keyFile string
+var clientCAFile string
 var noAuth bool
 var noBrowser bool
 var startupProfile string
+var pingInterval time.Duration
+var pongTimeout time.Duration
+var detachGracePeriod time.Duration
+var killGracePeriod time.Duration
+var shutdownDrainPeriod time.Duration
+var readBufferSize int
+var outputBatchWindow time.Duration
+var outputBatchSize int
+var flowControlWindow int
+var compression src.Compression
+var idleTimeout time.Duration
+var maxSessionDuration time.Duration
+var maxSessions int
+var metrics src.Metrics
+var logConfig src.LogConfig
+var auditLogPath string
 
 // startCmd represents the start command
 var startCmd = &cobra.Command{
@@ -37,7 +54,14 @@ server is started to prevent a user without access to the shell where b3tty is
 running from accessing the user's shell. This behavior can be disabled through
 configuration. For additional security, b3tty supports TLS over https and wss.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		src.SetDebug(debug)
+		if debug {
+			logConfig.Level = "debug"
+		}
+		logFile, err := src.ConfigureLogging(logConfig)
+		if err != nil {
+			src.Fatalf("log configuration error: %v", err)
+		}
+		defer logFile.Close()
 		if cfgPath := viper.ConfigFileUsed(); cfgPath != "" {
 			if err := src.ValidateConfig(cfgPath); err != nil {
 				src.Fatalf("config validation error: %v", err)
@@ -49,12 +73,7 @@ configuration. For additional security, b3tty supports TLS over https and wss.`,
 		if !src.ValidatePortNumber(port) {
 			src.Fatalf("port number must be 1 - 65535")
 		}
-		if tls {
-			// Remap the default TLS port
-			if port == 8080 {
-				port = 8443
-			}
-		}
+		port = listenPort()
 		if startupProfile != "" {
 			if _, ok := profiles[startupProfile]; !ok {
 				src.Fatalf("profile %q not found in config", startupProfile)
@@ -62,9 +81,27 @@ configuration. For additional security, b3tty supports TLS over https and wss.`,
 		} else {
 			startupProfile = src.DEFAULT_PROFILE_NAME
 		}
+		server := src.NewServer(&uri, &port, &noAuth, &src.TLS{CertFilePath: certFile, KeyFilePath: keyFile, ClientCAFilePath: clientCAFile, Enabled: tls})
+		server.PingInterval = pingInterval
+		server.PongTimeout = pongTimeout
+		server.DetachGracePeriod = detachGracePeriod
+		server.KillGracePeriod = killGracePeriod
+		server.ShutdownDrainPeriod = shutdownDrainPeriod
+		server.ReadBufferSize = readBufferSize
+		server.OutputBatchWindow = outputBatchWindow
+		server.OutputBatchSize = outputBatchSize
+		server.FlowControlWindow = flowControlWindow
+		server.Compression = compression
+		server.IdleTimeout = idleTimeout
+		server.MaxSessionDuration = maxSessionDuration
+		server.MaxSessions = maxSessions
+		server.Metrics = metrics
+		if err := server.Validate(); err != nil {
+			src.Fatalf("server validation error: %v", err)
+		}
 		ts := src.TerminalServer{
 			Client:         src.NewClient(&rows, &columns, &cursorBlink, &fontFamily, &fontSize, &theme),
-			Server:         src.NewServer(&uri, &port, &noAuth, &src.TLS{CertFilePath: certFile, KeyFilePath: keyFile, Enabled: tls}),
+			Server:         server,
 			Profiles:       profiles,
 			Themes:         themes,
 			OrgCols:        src.DEFAULT_COLS,
@@ -76,10 +113,30 @@ configuration. For additional security, b3tty supports TLS over https and wss.`,
 			FirstRun:       !configFileFound,
 			AuthSleep:      time.Sleep,
 		}
+		if err := ts.ValidateProfiles(); err != nil {
+			src.Fatalf("profile validation error: %v", err)
+		}
+		if auditLogPath != "" {
+			audit, err := src.OpenAuditLog(auditLogPath)
+			if err != nil {
+				src.Fatalf("%v", err)
+			}
+			defer audit.Close()
+			ts.Audit = audit
+		}
 		src.Serve(&ts, !noBrowser, tls)
 	},
 }
 
+// listenPort returns the port the server listens on, remapping the default
+// port to 8443 when TLS is enabled.
+func listenPort() int {
+	if tls && port == 8080 {
+		return 8443
+	}
+	return port
+}
+
 func init() {
 	rootCmd.AddCommand(startCmd)
 
@@ -98,6 +155,7 @@ func init() {
 	startCmd.Flags().BoolVar(&tls, "tls", false, "Enable HTTPS via TLS. Requires cert-file and key-file to be provided.")
 	startCmd.Flags().StringVar(&certFile, "cert-file", "", "Path to TLS certificate file.")
 	startCmd.Flags().StringVar(&keyFile, "key-file", "", "Path to TLS private key file.")
+	startCmd.Flags().StringVar(&clientCAFile, "client-ca-file", "", "Path to a PEM file of CAs whose client certificates are verified and recorded in the audit log.")
 	startCmd.Flags().BoolVar(&noAuth, "no-auth", false, "Disable API token verification. Using this flag will reduce security posture.")
 	startCmd.Flags().BoolVar(&noBrowser, "no-browser", false, "Disables opening b3tty in the default browser.")
 	startCmd.Flags().BoolVar(&debug, "debug", false, "Enable debug logging.")
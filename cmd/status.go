@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cmmorrow/b3tty/src"
+)
+
+var statusURL string
+var statusTimeout time.Duration
+
+// statusCmd queries the health endpoints of a running server
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report whether a running b3tty server is healthy and ready",
+	Long: `Queries the /healthz and /readyz endpoints of a running b3tty server and prints
+the result of each readiness check. The server is looked up at the address in
+the config file, or at --url. With TLS enabled, the configured certificate is
+trusted in addition to the system's certificate authorities, so a self-signed
+certificate can be verified. The command exits with a non-zero status when the
+server cannot be reached or is not ready.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		baseURL := statusURL
+		if baseURL == "" {
+			scheme := "http"
+			if tls {
+				scheme = "https"
+			}
+			baseURL = scheme + "://" + net.JoinHostPort(uri, strconv.Itoa(listenPort()))
+		}
+		client, err := statusClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+		defer cancel()
+		status, err := src.QueryStatus(ctx, client, baseURL)
+		if err != nil {
+			return fmt.Errorf("cannot query b3tty at %s: %w", baseURL, err)
+		}
+
+		health, readiness := "healthy", "ready"
+		if !status.Healthy {
+			health = "unhealthy"
+		}
+		if !status.Ready {
+			readiness = "not ready"
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "%s: %s, %s\n", baseURL, health, readiness)
+		for _, name := range status.CheckNames() {
+			fmt.Fprintf(out, "  %-8s %s\n", name, status.Checks[name])
+		}
+		if !status.Healthy || !status.Ready {
+			return errors.New("b3tty is not ready")
+		}
+		return nil
+	},
+}
+
+// statusClient returns the HTTP client used to query the server. When TLS is
+// enabled, the configured certificate is trusted alongside the system roots.
+func statusClient() (*http.Client, error) {
+	client := &http.Client{}
+	if !tls || certFile == "" {
+		return client, nil
+	}
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", certFile)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &cryptotls.Config{RootCAs: roots}}
+	return client, nil
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVar(&statusURL, "url", "", "Base URL of the server to query, such as https://localhost:8443. Defaults to the configured address.")
+	statusCmd.Flags().DurationVar(&statusTimeout, "timeout", 5*time.Second, "How long to wait for the server to respond.")
+}
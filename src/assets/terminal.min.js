@@ -54,7 +54,385 @@ ${V.join(`
 
 ... and ${H.length-G} more leaking disposables
 
-`),{leaks:H,details:F}}};s7.idx=0;function r7(q){U3=q}if(n7){let q="__is_disposable_tracked__";r7(new class{trackDisposable(G){let Y=Error("Potentially leaked disposable").stack;setTimeout(()=>{G[q]||console.log(Y)},3000)}setParent(G,Y){if(G&&G!==K3.None)try{G[q]=!0}catch{}}markAsDisposed(G){if(G&&G!==K3.None)try{G[q]=!0}catch{}}markAsSingleton(G){}})}function D3(q){return U3?.trackDisposable(q),q}function C3(q){U3?.markAsDisposed(q)}function I1(q,G){U3?.setParent(q,G)}function i7(q){if(A9.is(q)){let G=[];for(let Y of q)if(Y)try{Y.dispose()}catch(H){G.push(H)}if(G.length===1)throw G[0];if(G.length>1)throw AggregateError(G,"Encountered errors while disposing of store");return Array.isArray(q)?[]:q}else if(q)return q.dispose(),q}function t7(q){let G=D3({dispose:o7(()=>{C3(G),q()})});return G}var m9=class q{constructor(){this._toDispose=new Set,this._isDisposed=!1,D3(this)}dispose(){this._isDisposed||(C3(this),this._isDisposed=!0,this.clear())}get isDisposed(){return this._isDisposed}clear(){if(this._toDispose.size!==0)try{i7(this._toDispose)}finally{this._toDispose.clear()}}add(G){if(!G)return G;if(G===this)throw Error("Cannot register a disposable on itself!");return I1(G,this),this._isDisposed?q.DISABLE_DISPOSED_WARNING||console.warn(Error("Trying to add a disposable to a DisposableStore that has already been disposed of. The added object will be leaked!").stack):this._toDispose.add(G),G}delete(G){if(G){if(G===this)throw Error("Cannot dispose a disposable on itself!");this._toDispose.delete(G),G.dispose()}}deleteAndLeak(G){G&&this._toDispose.has(G)&&(this._toDispose.delete(G),I1(G,null))}};m9.DISABLE_DISPOSED_WARNING=!1;var e7=m9,K3=class{constructor(){this._store=new e7,D3(this),I1(this._store,this)}dispose(){C3(this),this._store.dispose()}_register(q){if(q===this)throw Error("Cannot register a disposable on itself!");return this._store.add(q)}};K3.None=Object.freeze({dispose(){}});var qq=class{constructor(){this._isDisposed=!1,D3(this)}get value(){return this._isDisposed?void 0:this._value}set value(q){this._isDisposed||q===this._value||(this._value?.dispose(),q&&I1(q,this),this._value=q)}clear(){this.value=void 0}dispose(){this._isDisposed=!0,C3(this),this._value?.dispose(),this._value=void 0}clearAndLeak(){let q=this._value;return this._value=void 0,q&&I1(q,null),q}},Gq=4096,x8=24,R3=class q extends K3{constructor(G){super();this._terminal=G,this._optionsRefresh=this._register(new qq),this._oldOpen=this._terminal._core.open,this._terminal._core.open=(Y)=>{this._oldOpen?.call(this._terminal._core,Y),this._open()},this._terminal._core.screenElement&&this._open(),this._optionsRefresh.value=this._terminal._core.optionsService.onOptionChange((Y)=>{Y==="fontSize"&&(this.rescaleCanvas(),this._renderService?.refreshRows(0,this._terminal.rows))}),this._register(t7(()=>{this.removeLayerFromDom(),this._terminal._core&&this._oldOpen&&(this._terminal._core.open=this._oldOpen,this._oldOpen=void 0),this._renderService&&this._oldSetRenderer&&(this._renderService.setRenderer=this._oldSetRenderer,this._oldSetRenderer=void 0),this._renderService=void 0,this.canvas=void 0,this._ctx=void 0,this._placeholderBitmap?.close(),this._placeholderBitmap=void 0,this._placeholder=void 0}))}static createCanvas(G,Y,H){let Z=(G||document).createElement("canvas");return Z.width=Y|0,Z.height=H|0,Z}static createImageData(G,Y,H,Z){if(typeof ImageData!="function"){let $=G.createImageData(Y,H);return Z&&$.data.set(new Uint8ClampedArray(Z,0,Y*H*4)),$}return Z?new ImageData(new Uint8ClampedArray(Z,0,Y*H*4),Y,H):new ImageData(Y,H)}static createImageBitmap(G){return typeof createImageBitmap!="function"?Promise.resolve(void 0):createImageBitmap(G)}showPlaceholder(G){G?!this._placeholder&&this.cellSize.height!==-1&&this._createPlaceHolder(Math.max(this.cellSize.height+1,x8)):(this._placeholderBitmap?.close(),this._placeholderBitmap=void 0,this._placeholder=void 0),this._renderService?.refreshRows(0,this._terminal.rows)}get dimensions(){return this._renderService?.dimensions}get cellSize(){return{width:this.dimensions?.css.cell.width||-1,height:this.dimensions?.css.cell.height||-1}}clearLines(G,Y){this._ctx?.clearRect(0,G*(this.dimensions?.css.cell.height||0),this.dimensions?.css.canvas.width||0,(++Y-G)*(this.dimensions?.css.cell.height||0))}clearAll(){this._ctx?.clearRect(0,0,this.canvas?.width||0,this.canvas?.height||0)}draw(G,Y,H,Z,$=1){if(!this._ctx)return;let{width:F,height:J}=this.cellSize;if(F===-1||J===-1)return;this._rescaleImage(G,F,J);let z=G.actual,X=Math.ceil(z.width/F),V=Y%X*F,j=Math.floor(Y/X)*J,N=H*F,K=Z*J,M=$*F+V>z.width?z.width-V:$*F,W=j+J>z.height?z.height-j:J;this._ctx.drawImage(z,Math.floor(V),Math.floor(j),Math.ceil(M),Math.ceil(W),Math.floor(N),Math.floor(K),Math.ceil(M),Math.ceil(W))}extractTile(G,Y){let{width:H,height:Z}=this.cellSize;if(H===-1||Z===-1)return;this._rescaleImage(G,H,Z);let $=G.actual,F=Math.ceil($.width/H),J=Y%F*H,z=Math.floor(Y/F)*Z,X=H+J>$.width?$.width-J:H,V=z+Z>$.height?$.height-z:Z,j=q.createCanvas(this.document,X,V),N=j.getContext("2d");if(N)return N.drawImage($,Math.floor(J),Math.floor(z),Math.floor(X),Math.floor(V),0,0,Math.floor(X),Math.floor(V)),j}drawPlaceholder(G,Y,H=1){if(this._ctx){let{width:Z,height:$}=this.cellSize;if(Z===-1||$===-1||(this._placeholder?$>=this._placeholder.height&&this._createPlaceHolder($+1):this._createPlaceHolder(Math.max($+1,x8)),!this._placeholder))return;this._ctx.drawImage(this._placeholderBitmap||this._placeholder,G*Z,Y*$%2?0:1,Z*H,$,G*Z,Y*$,Z*H,$)}}rescaleCanvas(){this.canvas&&(this.canvas.width!==this.dimensions.css.canvas.width||this.canvas.height!==this.dimensions.css.canvas.height)&&(this.canvas.width=this.dimensions.css.canvas.width||0,this.canvas.height=this.dimensions.css.canvas.height||0)}_rescaleImage(G,Y,H){if(Y===G.actualCellSize.width&&H===G.actualCellSize.height)return;let{width:Z,height:$}=G.origCellSize;if(Y===Z&&H===$){G.actual=G.orig,G.actualCellSize.width=Z,G.actualCellSize.height=$;return}let F=q.createCanvas(this.document,Math.ceil(G.orig.width*Y/Z),Math.ceil(G.orig.height*H/$)),J=F.getContext("2d");J&&(J.drawImage(G.orig,0,0,F.width,F.height),G.actual=F,G.actualCellSize.width=Y,G.actualCellSize.height=H)}_open(){this._renderService=this._terminal._core._renderService,this._oldSetRenderer=this._renderService.setRenderer.bind(this._renderService),this._renderService.setRenderer=(G)=>{this.removeLayerFromDom(),this._oldSetRenderer?.call(this._renderService,G)}}insertLayerToDom(){this.document&&this._terminal._core.screenElement?this.canvas||(this.canvas=q.createCanvas(this.document,this.dimensions?.css.canvas.width||0,this.dimensions?.css.canvas.height||0),this.canvas.classList.add("xterm-image-layer"),this._terminal._core.screenElement.appendChild(this.canvas),this._ctx=this.canvas.getContext("2d",{alpha:!0,desynchronized:!0}),this.clearAll()):console.warn("image addon: cannot insert output canvas to DOM, missing document or screenElement")}removeLayerFromDom(){this.canvas&&(this._ctx=void 0,this.canvas.remove(),this.canvas=void 0)}_createPlaceHolder(G=x8){this._placeholderBitmap?.close(),this._placeholderBitmap=void 0;let Y=32,H=q.createCanvas(this.document,Y,G),Z=H.getContext("2d",{alpha:!1});if(!Z)return;let $=q.createImageData(Z,Y,G),F=new Uint32Array($.data.buffer),J=(0,x9.toRGBA8888)(0,0,0),z=(0,x9.toRGBA8888)(255,255,255);F.fill(J);for(let j=0;j<G;++j){let N=j%2,K=j*Y;for(let M=0;M<Y;M+=2)F[K+M+N]=z}Z.putImageData($,0,0);let X=screen.width+Y-1&~(Y-1)||Gq;this._placeholder=q.createCanvas(this.document,X,G);let V=this._placeholder.getContext("2d",{alpha:!1});if(!V){this._placeholder=void 0;return}for(let j=0;j<X;j+=Y)V.drawImage(H,j,0);q.createImageBitmap(this._placeholder).then((j)=>this._placeholderBitmap=j)}get document(){return this._terminal._core._coreBrowserService?.window.document}},_1={width:7,height:14},S8=class q{constructor(G=0,Y=0,H=-1,Z=-1){this.imageId=H,this.tileId=Z,this._ext=0,this._urlId=0,this._ext=G,this._urlId=Y}get ext(){return this._urlId?this._ext&-469762049|this.underlineStyle<<26:this._ext}set ext(G){this._ext=G}get underlineStyle(){return this._urlId?5:(this._ext&469762048)>>26}set underlineStyle(G){this._ext&=-469762049,this._ext|=G<<26&469762048}get underlineColor(){return this._ext&67108863}set underlineColor(G){this._ext&=-67108864,this._ext|=G&67108863}get underlineVariantOffset(){let G=(this._ext&3758096384)>>29;return G<0?G^4294967288:G}set underlineVariantOffset(G){this._ext&=536870911,this._ext|=G<<29&3758096384}get urlId(){return this._urlId}set urlId(G){this._urlId=G}clone(){return new q(this._ext,this._urlId,this.imageId,this.tileId)}isEmpty(){return this.underlineStyle===0&&this._urlId===0&&this.imageId===-1}},T1=new S8,Yq=class{constructor(q,G,Y){this._terminal=q,this._renderer=G,this._opts=Y,this._images=new Map,this._lastId=0,this._lowestId=0,this._fullyCleared=!1,this._needsFullClear=!1,this._pixelLimit=2500000;try{this.setLimit(this._opts.storageLimit)}catch(H){console.error(H.message),console.warn(`storageLimit is set to ${this.getLimit()} MB`)}this._viewportMetrics={cols:this._terminal.cols,rows:this._terminal.rows}}dispose(){this.reset()}reset(){for(let q of this._images.values())q.marker?.dispose();this._images.clear(),this._renderer.clearAll()}getLimit(){return this._pixelLimit*4/1e6}setLimit(q){if(q<0.5||q>1000)throw RangeError("invalid storageLimit, should be at least 0.5 MB and not exceed 1G");this._pixelLimit=q/4*1e6>>>0,this._evictOldest(0)}getUsage(){return this._getStoredPixels()*4/1e6}_getStoredPixels(){let q=0;for(let G of this._images.values())G.orig&&(q+=G.orig.width*G.orig.height,G.actual&&G.actual!==G.orig&&(q+=G.actual.width*G.actual.height));return q}_delImg(q){let G=this._images.get(q);this._images.delete(q),G&&window.ImageBitmap&&G.orig instanceof ImageBitmap&&G.orig.close()}wipeAlternate(){let q=[];for(let[G,Y]of this._images.entries())Y.bufferType==="alternate"&&(Y.marker?.dispose(),q.push(G));for(let G of q)this._delImg(G);this._needsFullClear=!0,this._fullyCleared=!1}advanceCursor(q){if(this._opts.sixelScrolling){let G=this._renderer.cellSize;(G.width===-1||G.height===-1)&&(G=_1);let Y=Math.ceil(q/G.height);for(let H=1;H<Y;++H)this._terminal._core._inputHandler.lineFeed()}}addImage(q){this._evictOldest(q.width*q.height);let G=this._renderer.cellSize;(G.width===-1||G.height===-1)&&(G=_1);let Y=Math.ceil(q.width/G.width),H=Math.ceil(q.height/G.height),Z=++this._lastId,$=this._terminal._core.buffer,F=this._terminal.cols,J=this._terminal.rows,z=$.x,X=$.y,V=z,j=0;this._opts.sixelScrolling||($.x=0,$.y=0,V=0),this._terminal._core._inputHandler._dirtyRowTracker.markDirty($.y);for(let W=0;W<H;++W){let U=$.lines.get($.y+$.ybase);for(let R=0;R<Y&&!(V+R>=F);++R)this._writeToCell(U,V+R,Z,W*Y+R),j++;if(this._opts.sixelScrolling)W<H-1&&this._terminal._core._inputHandler.lineFeed();else if(++$.y>=J)break;$.x=V}this._terminal._core._inputHandler._dirtyRowTracker.markDirty($.y),this._opts.sixelScrolling?$.x=V:($.x=z,$.y=X);let N=[];for(let[W,U]of this._images.entries())U.tileCount<1&&(U.marker?.dispose(),N.push(W));for(let W of N)this._delImg(W);let K=this._terminal.registerMarker(0);K?.onDispose(()=>{this._images.get(Z)&&this._delImg(Z)}),this._terminal.buffer.active.type==="alternate"&&this._evictOnAlternate();let M={orig:q,origCellSize:G,actual:q,actualCellSize:{...G},marker:K||void 0,tileCount:j,bufferType:this._terminal.buffer.active.type};this._images.set(Z,M)}render(q){if(!this._renderer.canvas&&this._images.size&&(this._renderer.insertLayerToDom(),!this._renderer.canvas))return;if(this._renderer.rescaleCanvas(),!this._images.size){this._fullyCleared||(this._renderer.clearAll(),this._fullyCleared=!0,this._needsFullClear=!1),this._renderer.canvas&&this._renderer.removeLayerFromDom();return}this._needsFullClear&&(this._renderer.clearAll(),this._fullyCleared=!0,this._needsFullClear=!1);let{start:G,end:Y}=q,H=this._terminal._core.buffer,Z=this._terminal._core.cols;this._renderer.clearLines(G,Y);for(let $=G;$<=Y;++$){let F=H.lines.get($+H.ydisp);if(!F)return;for(let J=0;J<Z;++J)if(F.getBg(J)&268435456){let z=F._extendedAttrs[J]||T1,X=z.imageId;if(X===void 0||X===-1)continue;let V=this._images.get(X);if(z.tileId!==-1){let j=z.tileId,N=J,K=1;for(;++J<Z&&F.getBg(J)&268435456&&(z=F._extendedAttrs[J]||T1)&&z.imageId===X&&z.tileId===j+K;)K++;J--,V?V.actual&&this._renderer.draw(V,j,N,$,K):this._opts.showPlaceholder&&this._renderer.drawPlaceholder(N,$,K),this._fullyCleared=!1}}}}viewportResize(q){if(!this._images.size){this._viewportMetrics=q;return}if(this._viewportMetrics.cols>=q.cols){this._viewportMetrics=q;return}let G=this._terminal._core.buffer,Y=G.lines.length,H=this._viewportMetrics.cols-1;for(let Z=0;Z<Y;++Z){let $=G.lines.get(Z);if($.getBg(H)&268435456){let F=$._extendedAttrs[H]||T1,J=F.imageId;if(J===void 0||J===-1)continue;let z=this._images.get(J);if(!z)continue;let X=Math.ceil((z.actual?.width||0)/z.actualCellSize.width);if(F.tileId%X+1>=X)continue;let V=!1;for(let K=H+1;K>q.cols;++K)if($._data[K*3+0]&4194303){V=!0;break}if(V)continue;let j=Math.min(q.cols,X-F.tileId%X+H),N=F.tileId;for(let K=H+1;K<j;++K)this._writeToCell($,K,J,++N),z.tileCount++}}this._viewportMetrics=q}getImageAtBufferCell(q,G){let Y=this._terminal._core.buffer.lines.get(G);if(Y&&Y.getBg(q)&268435456){let H=Y._extendedAttrs[q]||T1;if(H.imageId&&H.imageId!==-1){let Z=this._images.get(H.imageId)?.orig;if(window.ImageBitmap&&Z instanceof ImageBitmap){let $=R3.createCanvas(window.document,Z.width,Z.height);return $.getContext("2d")?.drawImage(Z,0,0,Z.width,Z.height),$}return Z}}}extractTileAtBufferCell(q,G){let Y=this._terminal._core.buffer.lines.get(G);if(Y&&Y.getBg(q)&268435456){let H=Y._extendedAttrs[q]||T1;if(H.imageId&&H.imageId!==-1&&H.tileId!==-1){let Z=this._images.get(H.imageId);if(Z)return this._renderer.extractTile(Z,H.tileId)}}}_evictOldest(q){let G=this._getStoredPixels(),Y=G;for(;this._pixelLimit<Y+q&&this._images.size;){let H=this._images.get(++this._lowestId);H&&H.orig&&(Y-=H.orig.width*H.orig.height,H.actual&&H.orig!==H.actual&&(Y-=H.actual.width*H.actual.height),H.marker?.dispose(),this._delImg(this._lowestId))}return G-Y}_writeToCell(q,G,Y,H){if(q._data[G*3+2]&268435456){let Z=q._extendedAttrs[G];if(Z){if(Z.imageId!==void 0){let $=this._images.get(Z.imageId);$&&$.tileCount--,Z.imageId=Y,Z.tileId=H;return}q._extendedAttrs[G]=new S8(Z.ext,Z.urlId,Y,H);return}}q._data[G*3+2]|=268435456,q._extendedAttrs[G]=new S8(0,0,Y,H)}_evictOnAlternate(){for(let Y of this._images.values())Y.bufferType==="alternate"&&(Y.tileCount=0);let q=this._terminal._core.buffer;for(let Y=0;Y<this._terminal.rows;++Y){let H=q.lines.get(Y);if(H){for(let Z=0;Z<this._terminal.cols;++Z)if(H._data[Z*3+2]&268435456){let $=H._extendedAttrs[Z]?.imageId;if($){let F=this._images.get($);F&&F.tileCount++}}}}let G=[];for(let[Y,H]of this._images.entries())H.bufferType==="alternate"&&!H.tileCount&&(H.marker?.dispose(),G.push(Y));for(let Y of G)this._delImg(Y)}},Hq=N3(m7());function M3(q){let G="";for(let Y=0;Y<q.length;++Y)G+=String.fromCharCode(q[Y]);return G}function y8(q){let G=0;for(let Y=0;Y<q.length;++Y){if(q[Y]<48||q[Y]>57)throw Error("illegal char");G=G*10+q[Y]-48}return G}function w9(q){let G=M3(q);if(!G.match(/^((auto)|(\d+?((px)|(%)){0,1}))$/))throw Error("illegal size");return G}function Zq(q){if(typeof Buffer<"u")return Buffer.from(M3(q),"base64").toString();let G=atob(M3(q)),Y=new Uint8Array(G.length);for(let H=0;H<Y.length;++H)Y[H]=G.charCodeAt(H);return new TextDecoder().decode(Y)}var v9={inline:y8,size:y8,name:Zq,width:w9,height:w9,preserveAspectRatio:y8},T9=[70,105,108,101],Q8=1024,$q=class{constructor(){this.state=0,this._buffer=new Uint32Array(Q8),this._position=0,this._key="",this.fields={}}reset(){this._buffer.fill(0),this.state=0,this._position=0,this.fields={},this._key=""}parse(q,G,Y){let H=this.state,Z=this._position,$=this._buffer;if(H===1||H===4||H===0&&Z>6)return-1;for(let F=G;F<Y;++F){let J=q[F];switch(J){case 59:if(!this._storeValue(Z))return this._a();H=2,Z=0;break;case 61:if(H===0){for(let z=0;z<T9.length;++z)if($[z]!==T9[z])return this._a();H=2,Z=0}else if(H===2){if(!this._storeKey(Z))return this._a();H=3,Z=0}else if(H===3){if(Z>=Q8)return this._a();$[Z++]=J}break;case 58:return H===3&&!this._storeValue(Z)?this._a():(this.state=4,F+1);default:if(Z>=Q8)return this._a();$[Z++]=J}}return this.state=H,this._position=Z,-2}_a(){return this.state=1,-1}_storeKey(q){let G=M3(this._buffer.subarray(0,q));return G?(this._key=G,this.fields[G]=null,!0):!1}_storeValue(q){if(this._key){try{let G=this._buffer.slice(0,q);this.fields[this._key]=v9[this._key]?v9[this._key](G):G}catch{return!1}return!0}return!1}},W3={mime:"unsupported",width:0,height:0};function Fq(q){if(q.length<24)return W3;let G=new Uint32Array(q.buffer,q.byteOffset,6);if(G[0]===1196314761&&G[1]===169478669&&G[3]===1380206665)return{mime:"image/png",width:q[16]<<24|q[17]<<16|q[18]<<8|q[19],height:q[20]<<24|q[21]<<16|q[22]<<8|q[23]};if(q[0]===255&&q[1]===216&&q[2]===255){let[Y,H]=Jq(q);return{mime:"image/jpeg",width:Y,height:H}}return G[0]===944130375&&(q[4]===55||q[4]===57)&&q[5]===97?{mime:"image/gif",width:q[7]<<8|q[6],height:q[9]<<8|q[8]}:W3}function Jq(q){let G=q.length,Y=4,H=q[Y]<<8|q[Y+1];for(;;){if(Y+=H,Y>=G)return[0,0];if(q[Y]!==255)return[0,0];if(q[Y+1]===192||q[Y+1]===194)return Y+8<G?[q[Y+7]<<8|q[Y+8],q[Y+5]<<8|q[Y+6]]:[0,0];Y+=2,H=q[Y]<<8|q[Y+1]}}var Xq=4194304,k8={name:"Unnamed file",size:0,width:"auto",height:"auto",preserveAspectRatio:1,inline:0},zq=class{constructor(q,G,Y,H){this._opts=q,this._renderer=G,this._storage=Y,this._coreTerminal=H,this._aborted=!1,this._hp=new $q,this._header=k8,this._dec=new Hq.default(Xq),this._metrics=W3}reset(){}start(){this._aborted=!1,this._header=k8,this._metrics=W3,this._hp.reset()}put(q,G,Y){if(!this._aborted)if(this._hp.state===4)this._dec.put(q,G,Y)&&(this._dec.release(),this._aborted=!0);else{let H=this._hp.parse(q,G,Y);if(H===-1){this._aborted=!0;return}if(H>0){if(this._header=Object.assign({},k8,this._hp.fields),!this._header.inline||!this._header.size||this._header.size>this._opts.iipSizeLimit){this._aborted=!0;return}this._dec.init(this._header.size),this._dec.put(q,H,Y)&&(this._dec.release(),this._aborted=!0)}}}end(q){if(this._aborted)return!0;let G=0,Y=0,H=!0;if((H=q)&&(H=!this._dec.end())&&(this._metrics=Fq(this._dec.data8),(H=this._metrics.mime!=="unsupported")&&(G=this._metrics.width,Y=this._metrics.height,(H=G&&Y&&G*Y<this._opts.pixelLimit)&&([G,Y]=this._resize(G,Y).map(Math.floor),H=G&&Y&&G*Y<this._opts.pixelLimit))),!H)return this._dec.release(),!0;let Z=new Blob([this._dec.data8],{type:this._metrics.mime});if(this._dec.release(),!window.createImageBitmap){let $=URL.createObjectURL(Z),F=new Image;return new Promise((J)=>{F.addEventListener("load",()=>{URL.revokeObjectURL($);let z=R3.createCanvas(window.document,G,Y);z.getContext("2d")?.drawImage(F,0,0,G,Y),this._storage.addImage(z),J(!0)}),F.src=$,setTimeout(()=>J(!0),1000)})}return createImageBitmap(Z,{resizeWidth:G,resizeHeight:Y}).then(($)=>(this._storage.addImage($),!0))}_resize(q,G){let Y=this._renderer.dimensions?.css.cell.width||_1.width,H=this._renderer.dimensions?.css.cell.height||_1.height,Z=this._renderer.dimensions?.css.canvas.width||Y*this._coreTerminal.cols,$=this._renderer.dimensions?.css.canvas.height||H*this._coreTerminal.rows,F=this._dim(this._header.width,Z,Y),J=this._dim(this._header.height,$,H);if(!F&&!J){let z=Z/q,X=($-H)/G,V=Math.min(z,X);return V<1?[q*V,G*V]:[q,G]}return F?this._header.preserveAspectRatio||!F||!J?[F,G*F/q]:[F,J]:[q*J/G,J]}_dim(q,G,Y){return q==="auto"?0:q.endsWith("%")?parseInt(q.slice(0,-1))*G/100:q.endsWith("px")?parseInt(q.slice(0,-2)):parseInt(q)*Y}},E1=N3(v8()),jq=N3(g7()),Vq=4194304,w8=E1.PALETTE_ANSI_256;w8.set(E1.PALETTE_VT340_COLOR);var Kq=class{constructor(q,G,Y){this._opts=q,this._storage=G,this._coreTerminal=Y,this._size=0,this._aborted=!1,(0,jq.DecoderAsync)({memoryLimit:this._opts.pixelLimit*4,palette:w8,paletteLimit:this._opts.sixelPaletteLimit}).then((H)=>this._dec=H)}reset(){this._dec&&(this._dec.release(),this._dec._palette.fill(0),this._dec.init(0,w8,this._opts.sixelPaletteLimit))}hook(q){if(this._size=0,this._aborted=!1,this._dec){let G=q.params[1]===1?0:Mq(this._coreTerminal._core._inputHandler._curAttrData,this._coreTerminal._core._themeService?.colors);this._dec.init(G,null,this._opts.sixelPaletteLimit)}}put(q,G,Y){if(!(this._aborted||!this._dec)){if(this._size+=Y-G,this._size>this._opts.sixelSizeLimit){console.warn("SIXEL: too much data, aborting"),this._aborted=!0,this._dec.release();return}try{this._dec.decode(q,G,Y)}catch(H){console.warn(`SIXEL: error while decoding image - ${H}`),this._aborted=!0,this._dec.release()}}}unhook(q){if(this._aborted||!q||!this._dec)return!0;let G=this._dec.width,Y=this._dec.height;if(!G||!Y)return Y&&this._storage.advanceCursor(Y),!0;let H=R3.createCanvas(void 0,G,Y);return H.getContext("2d")?.putImageData(new ImageData(this._dec.data8,G,Y),0,0),this._dec.memoryUsage>Vq&&this._dec.release(),this._storage.addImage(H),!0}};function Mq(q,G){let Y=0;if(!G)return Y;if(q.isInverse())if(q.isFgDefault())Y=V3(G.foreground.rgba);else if(q.isFgRGB()){let H=q.constructor.toColorRGB(q.getFgColor());Y=(0,E1.toRGBA8888)(...H)}else Y=V3(G.ansi[q.getFgColor()].rgba);else if(q.isBgDefault())Y=V3(G.background.rgba);else if(q.isBgRGB()){let H=q.constructor.toColorRGB(q.getBgColor());Y=(0,E1.toRGBA8888)(...H)}else Y=V3(G.ansi[q.getBgColor()].rgba);return Y}function V3(q){return E1.BIG_ENDIAN?q:(q&255)<<24|(q>>>8&255)<<16|(q>>>16&255)<<8|q>>>24&255}var I9={enableSizeReports:!0,pixelLimit:16777216,sixelSupport:!0,sixelScrolling:!0,sixelPaletteLimit:256,sixelSizeLimit:25000000,storageLimit:128,showPlaceholder:!0,iipSupport:!0,iipSizeLimit:20000000},_9=4096,p9=class{constructor(q){this._disposables=[],this._handlers=new Map,this._opts=Object.assign({},I9,q),this._defaultOpts=Object.assign({},I9,q)}dispose(){for(let q of this._disposables)q.dispose();this._disposables.length=0,this._handlers.clear()}_disposeLater(...q){for(let G of q)this._disposables.push(G)}activate(q){if(this._terminal=q,this._renderer=new R3(q),this._storage=new Yq(q,this._renderer,this._opts),this._opts.enableSizeReports){let G=q.options.windowOptions||{};G.getWinSizePixels=!0,G.getCellSizePixels=!0,G.getWinSizeChars=!0,q.options.windowOptions=G}if(this._disposeLater(this._renderer,this._storage,q.parser.registerCsiHandler({prefix:"?",final:"h"},(G)=>this._decset(G)),q.parser.registerCsiHandler({prefix:"?",final:"l"},(G)=>this._decrst(G)),q.parser.registerCsiHandler({final:"c"},(G)=>this._da1(G)),q.parser.registerCsiHandler({prefix:"?",final:"S"},(G)=>this._xtermGraphicsAttributes(G)),q.onRender((G)=>this._storage?.render(G)),q.parser.registerCsiHandler({intermediates:"!",final:"p"},()=>this.reset()),q.parser.registerEscHandler({final:"c"},()=>this.reset()),q._core._inputHandler.onRequestReset(()=>this.reset()),q.buffer.onBufferChange(()=>this._storage?.wipeAlternate()),q.onResize((G)=>this._storage?.viewportResize(G))),this._opts.sixelSupport){let G=new Kq(this._opts,this._storage,q);this._handlers.set("sixel",G),this._disposeLater(q._core._inputHandler._parser.registerDcsHandler({final:"q"},G))}if(this._opts.iipSupport){let G=new zq(this._opts,this._renderer,this._storage,q);this._handlers.set("iip",G),this._disposeLater(q._core._inputHandler._parser.registerOscHandler(1337,G))}}reset(){this._opts.sixelScrolling=this._defaultOpts.sixelScrolling,this._opts.sixelPaletteLimit=this._defaultOpts.sixelPaletteLimit,this._storage?.reset();for(let q of this._handlers.values())q.reset();return!1}get storageLimit(){return this._storage?.getLimit()||-1}set storageLimit(q){this._storage?.setLimit(q),this._opts.storageLimit=q}get storageUsage(){return this._storage?this._storage.getUsage():-1}get showPlaceholder(){return this._opts.showPlaceholder}set showPlaceholder(q){this._opts.showPlaceholder=q,this._renderer?.showPlaceholder(q)}getImageAtBufferCell(q,G){return this._storage?.getImageAtBufferCell(q,G)}extractTileAtBufferCell(q,G){return this._storage?.extractTileAtBufferCell(q,G)}_report(q){this._terminal?._core.coreService.triggerDataEvent(q)}_decset(q){for(let G=0;G<q.length;++G)switch(q[G]){case 80:this._opts.sixelScrolling=!1;break}return!1}_decrst(q){for(let G=0;G<q.length;++G)switch(q[G]){case 80:this._opts.sixelScrolling=!0;break}return!1}_da1(q){return q[0]?!0:this._opts.sixelSupport?(this._report("\x1B[?62;4;9;22c"),!0):!1}_xtermGraphicsAttributes(q){if(q.length<2)return!0;if(q[0]===1)switch(q[1]){case 1:return this._report(`\x1B[?${q[0]};0;${this._opts.sixelPaletteLimit}S`),!0;case 2:this._opts.sixelPaletteLimit=this._defaultOpts.sixelPaletteLimit,this._report(`\x1B[?${q[0]};0;${this._opts.sixelPaletteLimit}S`);for(let G of this._handlers.values())G.reset();return!0;case 3:return q.length>2&&!(q[2]instanceof Array)&&q[2]<=_9?(this._opts.sixelPaletteLimit=q[2],this._report(`\x1B[?${q[0]};0;${this._opts.sixelPaletteLimit}S`)):this._report(`\x1B[?${q[0]};2S`),!0;case 4:return this._report(`\x1B[?${q[0]};0;${_9}S`),!0;default:return this._report(`\x1B[?${q[0]};2S`),!0}if(q[0]===2)switch(q[1]){case 1:let G=this._renderer?.dimensions?.css.canvas.width,Y=this._renderer?.dimensions?.css.canvas.height;if(!G||!Y){let Z=_1;G=(this._terminal?.cols||80)*Z.width,Y=(this._terminal?.rows||24)*Z.height}if(G*Y<this._opts.pixelLimit)this._report(`\x1B[?${q[0]};0;${G.toFixed(0)};${Y.toFixed(0)}S`);else{let Z=Math.floor(Math.sqrt(this._opts.pixelLimit));this._report(`\x1B[?${q[0]};0;${Z};${Z}S`)}return!0;case 4:let H=Math.floor(Math.sqrt(this._opts.pixelLimit));return this._report(`\x1B[?${q[0]};0;${H};${H}S`),!0;default:return this._report(`\x1B[?${q[0]};2S`),!0}return this._report(`\x1B[?${q[0]};1S`),!0}};function g9(q){return q==="http"||q==="https"}function f9(q){return q==="ws"||q==="wss"}function T8(q){return Number.isInteger(q)&&q>=1&&q<=65535}function I8(q){if(!q)return!1;let G=/^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$/;return q.split(".").every((Y)=>G.test(Y))}if(typeof HTMLElement<"u"){class q extends HTMLElement{constructor(){super();let G=this.attachShadow({mode:"open"});G.innerHTML=`
+`),{leaks:H,details:F}}};s7.idx=0;function r7(q){U3=q}if(n7){let q="__is_disposable_tracked__";r7(new class{trackDisposable(G){let Y=Error("Potentially leaked disposable").stack;setTimeout(()=>{G[q]||console.log(Y)},3000)}setParent(G,Y){if(G&&G!==K3.None)try{G[q]=!0}catch{}}markAsDisposed(G){if(G&&G!==K3.None)try{G[q]=!0}catch{}}markAsSingleton(G){}})}function D3(q){return U3?.trackDisposable(q),q}function C3(q){U3?.markAsDisposed(q)}function I1(q,G){U3?.setParent(q,G)}function i7(q){if(A9.is(q)){let G=[];for(let Y of q)if(Y)try{Y.dispose()}catch(H){G.push(H)}if(G.length===1)throw G[0];if(G.length>1)throw AggregateError(G,"Encountered errors while disposing of store");return Array.isArray(q)?[]:q}else if(q)return q.dispose(),q}function t7(q){let G=D3({dispose:o7(()=>{C3(G),q()})});return G}var m9=class q{constructor(){this._toDispose=new Set,this._isDisposed=!1,D3(this)}dispose(){this._isDisposed||(C3(this),this._isDisposed=!0,this.clear())}get isDisposed(){return this._isDisposed}clear(){if(this._toDispose.size!==0)try{i7(this._toDispose)}finally{this._toDispose.clear()}}add(G){if(!G)return G;if(G===this)throw Error("Cannot register a disposable on itself!");return I1(G,this),this._isDisposed?q.DISABLE_DISPOSED_WARNING||console.warn(Error("Trying to add a disposable to a DisposableStore that has already been disposed of. The added object will be leaked!").stack):this._toDispose.add(G),G}delete(G){if(G){if(G===this)throw Error("Cannot dispose a disposable on itself!");this._toDispose.delete(G),G.dispose()}}deleteAndLeak(G){G&&this._toDispose.has(G)&&(this._toDispose.delete(G),I1(G,null))}};m9.DISABLE_DISPOSED_WARNING=!1;var e7=m9,K3=class{constructor(){this._store=new e7,D3(this),I1(this._store,this)}dispose(){C3(this),this._store.dispose()}_register(q){if(q===this)throw Error("Cannot register a disposable on itself!");return this._store.add(q)}};K3.None=Object.freeze({dispose(){}});var qq=class{constructor(){this._isDisposed=!1,D3(this)}get value(){return this._isDisposed?void 0:this._value}set value(q){this._isDisposed||q===this._value||(this._value?.dispose(),q&&I1(q,this),this._value=q)}clear(){this.value=void 0}dispose(){this._isDisposed=!0,C3(this),this._value?.dispose(),this._value=void 0}clearAndLeak(){let q=this._value;return this._value=void 0,q&&I1(q,null),q}},Gq=4096,x8=24,R3=class q extends K3{constructor(G){super();this._terminal=G,this._optionsRefresh=this._register(new qq),this._oldOpen=this._terminal._core.open,this._terminal._core.open=(Y)=>{this._oldOpen?.call(this._terminal._core,Y),this._open()},this._terminal._core.screenElement&&this._open(),this._optionsRefresh.value=this._terminal._core.optionsService.onOptionChange((Y)=>{Y==="fontSize"&&(this.rescaleCanvas(),this._renderService?.refreshRows(0,this._terminal.rows))}),this._register(t7(()=>{this.removeLayerFromDom(),this._terminal._core&&this._oldOpen&&(this._terminal._core.open=this._oldOpen,this._oldOpen=void 0),this._renderService&&this._oldSetRenderer&&(this._renderService.setRenderer=this._oldSetRenderer,this._oldSetRenderer=void 0),this._renderService=void 0,this.canvas=void 0,this._ctx=void 0,this._placeholderBitmap?.close(),this._placeholderBitmap=void 0,this._placeholder=void 0}))}static createCanvas(G,Y,H){let Z=(G||document).createElement("canvas");return Z.width=Y|0,Z.height=H|0,Z}static createImageData(G,Y,H,Z){if(typeof ImageData!="function"){let $=G.createImageData(Y,H);return Z&&$.data.set(new Uint8ClampedArray(Z,0,Y*H*4)),$}return Z?new ImageData(new Uint8ClampedArray(Z,0,Y*H*4),Y,H):new ImageData(Y,H)}static createImageBitmap(G){return typeof createImageBitmap!="function"?Promise.resolve(void 0):createImageBitmap(G)}showPlaceholder(G){G?!this._placeholder&&this.cellSize.height!==-1&&this._createPlaceHolder(Math.max(this.cellSize.height+1,x8)):(this._placeholderBitmap?.close(),this._placeholderBitmap=void 0,this._placeholder=void 0),this._renderService?.refreshRows(0,this._terminal.rows)}get dimensions(){return this._renderService?.dimensions}get cellSize(){return{width:this.dimensions?.css.cell.width||-1,height:this.dimensions?.css.cell.height||-1}}clearLines(G,Y){this._ctx?.clearRect(0,G*(this.dimensions?.css.cell.height||0),this.dimensions?.css.canvas.width||0,(++Y-G)*(this.dimensions?.css.cell.height||0))}clearAll(){this._ctx?.clearRect(0,0,this.canvas?.width||0,this.canvas?.height||0)}draw(G,Y,H,Z,$=1){if(!this._ctx)return;let{width:F,height:J}=this.cellSize;if(F===-1||J===-1)return;this._rescaleImage(G,F,J);let z=G.actual,X=Math.ceil(z.width/F),V=Y%X*F,j=Math.floor(Y/X)*J,N=H*F,K=Z*J,M=$*F+V>z.width?z.width-V:$*F,W=j+J>z.height?z.height-j:J;this._ctx.drawImage(z,Math.floor(V),Math.floor(j),Math.ceil(M),Math.ceil(W),Math.floor(N),Math.floor(K),Math.ceil(M),Math.ceil(W))}extractTile(G,Y){let{width:H,height:Z}=this.cellSize;if(H===-1||Z===-1)return;this._rescaleImage(G,H,Z);let $=G.actual,F=Math.ceil($.width/H),J=Y%F*H,z=Math.floor(Y/F)*Z,X=H+J>$.width?$.width-J:H,V=z+Z>$.height?$.height-z:Z,j=q.createCanvas(this.document,X,V),N=j.getContext("2d");if(N)return N.drawImage($,Math.floor(J),Math.floor(z),Math.floor(X),Math.floor(V),0,0,Math.floor(X),Math.floor(V)),j}drawPlaceholder(G,Y,H=1){if(this._ctx){let{width:Z,height:$}=this.cellSize;if(Z===-1||$===-1||(this._placeholder?$>=this._placeholder.height&&this._createPlaceHolder($+1):this._createPlaceHolder(Math.max($+1,x8)),!this._placeholder))return;this._ctx.drawImage(this._placeholderBitmap||this._placeholder,G*Z,Y*$%2?0:1,Z*H,$,G*Z,Y*$,Z*H,$)}}rescaleCanvas(){this.canvas&&(this.canvas.width!==this.dimensions.css.canvas.width||this.canvas.height!==this.dimensions.css.canvas.height)&&(this.canvas.width=this.dimensions.css.canvas.width||0,this.canvas.height=this.dimensions.css.canvas.height||0)}_rescaleImage(G,Y,H){if(Y===G.actualCellSize.width&&H===G.actualCellSize.height)return;let{width:Z,height:$}=G.origCellSize;if(Y===Z&&H===$){G.actual=G.orig,G.actualCellSize.width=Z,G.actualCellSize.height=$;return}let F=q.createCanvas(this.document,Math.ceil(G.orig.width*Y/Z),Math.ceil(G.orig.height*H/$)),J=F.getContext("2d");J&&(J.drawImage(G.orig,0,0,F.width,F.height),G.actual=F,G.actualCellSize.width=Y,G.actualCellSize.height=H)}_open(){this._renderService=this._terminal._core._renderService,this._oldSetRenderer=this._renderService.setRenderer.bind(this._renderService),this._renderService.setRenderer=(G)=>{this.removeLayerFromDom(),this._oldSetRenderer?.call(this._renderService,G)}}insertLayerToDom(){this.document&&this._terminal._core.screenElement?this.canvas||(this.canvas=q.createCanvas(this.document,this.dimensions?.css.canvas.width||0,this.dimensions?.css.canvas.height||0),this.canvas.classList.add("xterm-image-layer"),this._terminal._core.screenElement.appendChild(this.canvas),this._ctx=this.canvas.getContext("2d",{alpha:!0,desynchronized:!0}),this.clearAll()):console.warn("image addon: cannot insert output canvas to DOM, missing document or screenElement")}removeLayerFromDom(){this.canvas&&(this._ctx=void 0,this.canvas.remove(),this.canvas=void 0)}_createPlaceHolder(G=x8){this._placeholderBitmap?.close(),this._placeholderBitmap=void 0;let Y=32,H=q.createCanvas(this.document,Y,G),Z=H.getContext("2d",{alpha:!1});if(!Z)return;let $=q.createImageData(Z,Y,G),F=new Uint32Array($.data.buffer),J=(0,x9.toRGBA8888)(0,0,0),z=(0,x9.toRGBA8888)(255,255,255);F.fill(J);for(let j=0;j<G;++j){let N=j%2,K=j*Y;for(let M=0;M<Y;M+=2)F[K+M+N]=z}Z.putImageData($,0,0);let X=screen.width+Y-1&~(Y-1)||Gq;this._placeholder=q.createCanvas(this.document,X,G);let V=this._placeholder.getContext("2d",{alpha:!1});if(!V){this._placeholder=void 0;return}for(let j=0;j<X;j+=Y)V.drawImage(H,j,0);q.createImageBitmap(this._placeholder).then((j)=>this._placeholderBitmap=j)}get document(){return this._terminal._core._coreBrowserService?.window.document}},_1={width:7,height:14},S8=class q{constructor(G=0,Y=0,H=-1,Z=-1){this.imageId=H,this.tileId=Z,this._ext=0,this._urlId=0,this._ext=G,this._urlId=Y}get ext(){return this._urlId?this._ext&-469762049|this.underlineStyle<<26:this._ext}set ext(G){this._ext=G}get underlineStyle(){return this._urlId?5:(this._ext&469762048)>>26}set underlineStyle(G){this._ext&=-469762049,this._ext|=G<<26&469762048}get underlineColor(){return this._ext&67108863}set underlineColor(G){this._ext&=-67108864,this._ext|=G&67108863}get underlineVariantOffset(){let G=(this._ext&3758096384)>>29;return G<0?G^4294967288:G}set underlineVariantOffset(G){this._ext&=536870911,this._ext|=G<<29&3758096384}get urlId(){return this._urlId}set urlId(G){this._urlId=G}clone(){return new q(this._ext,this._urlId,this.imageId,this.tileId)}isEmpty(){return this.underlineStyle===0&&this._urlId===0&&this.imageId===-1}},T1=new S8,Yq=class{constructor(q,G,Y){this._terminal=q,this._renderer=G,this._opts=Y,this._images=new Map,this._lastId=0,this._lowestId=0,this._fullyCleared=!1,this._needsFullClear=!1,this._pixelLimit=2500000;try{this.setLimit(this._opts.storageLimit)}catch(H){console.error(H.message),console.warn(`storageLimit is set to ${this.getLimit()} MB`)}this._viewportMetrics={cols:this._terminal.cols,rows:this._terminal.rows}}dispose(){this.reset()}reset(){for(let q of this._images.values())q.marker?.dispose();this._images.clear(),this._renderer.clearAll()}getLimit(){return this._pixelLimit*4/1e6}setLimit(q){if(q<0.5||q>1000)throw RangeError("invalid storageLimit, should be at least 0.5 MB and not exceed 1G");this._pixelLimit=q/4*1e6>>>0,this._evictOldest(0)}getUsage(){return this._getStoredPixels()*4/1e6}_getStoredPixels(){let q=0;for(let G of this._images.values())G.orig&&(q+=G.orig.width*G.orig.height,G.actual&&G.actual!==G.orig&&(q+=G.actual.width*G.actual.height));return q}_delImg(q){let G=this._images.get(q);this._images.delete(q),G&&window.ImageBitmap&&G.orig instanceof ImageBitmap&&G.orig.close()}wipeAlternate(){let q=[];for(let[G,Y]of this._images.entries())Y.bufferType==="alternate"&&(Y.marker?.dispose(),q.push(G));for(let G of q)this._delImg(G);this._needsFullClear=!0,this._fullyCleared=!1}advanceCursor(q){if(this._opts.sixelScrolling){let G=this._renderer.cellSize;(G.width===-1||G.height===-1)&&(G=_1);let Y=Math.ceil(q/G.height);for(let H=1;H<Y;++H)this._terminal._core._inputHandler.lineFeed()}}addImage(q){this._evictOldest(q.width*q.height);let G=this._renderer.cellSize;(G.width===-1||G.height===-1)&&(G=_1);let Y=Math.ceil(q.width/G.width),H=Math.ceil(q.height/G.height),Z=++this._lastId,$=this._terminal._core.buffer,F=this._terminal.cols,J=this._terminal.rows,z=$.x,X=$.y,V=z,j=0;this._opts.sixelScrolling||($.x=0,$.y=0,V=0),this._terminal._core._inputHandler._dirtyRowTracker.markDirty($.y);for(let W=0;W<H;++W){let U=$.lines.get($.y+$.ybase);for(let R=0;R<Y&&!(V+R>=F);++R)this._writeToCell(U,V+R,Z,W*Y+R),j++;if(this._opts.sixelScrolling)W<H-1&&this._terminal._core._inputHandler.lineFeed();else if(++$.y>=J)break;$.x=V}this._terminal._core._inputHandler._dirtyRowTracker.markDirty($.y),this._opts.sixelScrolling?$.x=V:($.x=z,$.y=X);let N=[];for(let[W,U]of this._images.entries())U.tileCount<1&&(U.marker?.dispose(),N.push(W));for(let W of N)this._delImg(W);let K=this._terminal.registerMarker(0);K?.onDispose(()=>{this._images.get(Z)&&this._delImg(Z)}),this._terminal.buffer.active.type==="alternate"&&this._evictOnAlternate();let M={orig:q,origCellSize:G,actual:q,actualCellSize:{...G},marker:K||void 0,tileCount:j,bufferType:this._terminal.buffer.active.type};this._images.set(Z,M)}render(q){if(!this._renderer.canvas&&this._images.size&&(this._renderer.insertLayerToDom(),!this._renderer.canvas))return;if(this._renderer.rescaleCanvas(),!this._images.size){this._fullyCleared||(this._renderer.clearAll(),this._fullyCleared=!0,this._needsFullClear=!1),this._renderer.canvas&&this._renderer.removeLayerFromDom();return}this._needsFullClear&&(this._renderer.clearAll(),this._fullyCleared=!0,this._needsFullClear=!1);let{start:G,end:Y}=q,H=this._terminal._core.buffer,Z=this._terminal._core.cols;this._renderer.clearLines(G,Y);for(let $=G;$<=Y;++$){let F=H.lines.get($+H.ydisp);if(!F)return;for(let J=0;J<Z;++J)if(F.getBg(J)&268435456){let z=F._extendedAttrs[J]||T1,X=z.imageId;if(X===void 0||X===-1)continue;let V=this._images.get(X);if(z.tileId!==-1){let j=z.tileId,N=J,K=1;for(;++J<Z&&F.getBg(J)&268435456&&(z=F._extendedAttrs[J]||T1)&&z.imageId===X&&z.tileId===j+K;)K++;J--,V?V.actual&&this._renderer.draw(V,j,N,$,K):this._opts.showPlaceholder&&this._renderer.drawPlaceholder(N,$,K),this._fullyCleared=!1}}}}viewportResize(q){if(!this._images.size){this._viewportMetrics=q;return}if(this._viewportMetrics.cols>=q.cols){this._viewportMetrics=q;return}let G=this._terminal._core.buffer,Y=G.lines.length,H=this._viewportMetrics.cols-1;for(let Z=0;Z<Y;++Z){let $=G.lines.get(Z);if($.getBg(H)&268435456){let F=$._extendedAttrs[H]||T1,J=F.imageId;if(J===void 0||J===-1)continue;let z=this._images.get(J);if(!z)continue;let X=Math.ceil((z.actual?.width||0)/z.actualCellSize.width);if(F.tileId%X+1>=X)continue;let V=!1;for(let K=H+1;K>q.cols;++K)if($._data[K*3+0]&4194303){V=!0;break}if(V)continue;let j=Math.min(q.cols,X-F.tileId%X+H),N=F.tileId;for(let K=H+1;K<j;++K)this._writeToCell($,K,J,++N),z.tileCount++}}this._viewportMetrics=q}getImageAtBufferCell(q,G){let Y=this._terminal._core.buffer.lines.get(G);if(Y&&Y.getBg(q)&268435456){let H=Y._extendedAttrs[q]||T1;if(H.imageId&&H.imageId!==-1){let Z=this._images.get(H.imageId)?.orig;if(window.ImageBitmap&&Z instanceof ImageBitmap){let $=R3.createCanvas(window.document,Z.width,Z.height);return $.getContext("2d")?.drawImage(Z,0,0,Z.width,Z.height),$}return Z}}}extractTileAtBufferCell(q,G){let Y=this._terminal._core.buffer.lines.get(G);if(Y&&Y.getBg(q)&268435456){let H=Y._extendedAttrs[q]||T1;if(H.imageId&&H.imageId!==-1&&H.tileId!==-1){let Z=this._images.get(H.imageId);if(Z)return this._renderer.extractTile(Z,H.tileId)}}}_evictOldest(q){let G=this._getStoredPixels(),Y=G;for(;this._pixelLimit<Y+q&&this._images.size;){let H=this._images.get(++this._lowestId);H&&H.orig&&(Y-=H.orig.width*H.orig.height,H.actual&&H.orig!==H.actual&&(Y-=H.actual.width*H.actual.height),H.marker?.dispose(),this._delImg(this._lowestId))}return G-Y}_writeToCell(q,G,Y,H){if(q._data[G*3+2]&268435456){let Z=q._extendedAttrs[G];if(Z){if(Z.imageId!==void 0){let $=this._images.get(Z.imageId);$&&$.tileCount--,Z.imageId=Y,Z.tileId=H;return}q._extendedAttrs[G]=new S8(Z.ext,Z.urlId,Y,H);return}}q._data[G*3+2]|=268435456,q._extendedAttrs[G]=new S8(0,0,Y,H)}_evictOnAlternate(){for(let Y of this._images.values())Y.bufferType==="alternate"&&(Y.tileCount=0);let q=this._terminal._core.buffer;for(let Y=0;Y<this._terminal.rows;++Y){let H=q.lines.get(Y);if(H){for(let Z=0;Z<this._terminal.cols;++Z)if(H._data[Z*3+2]&268435456){let $=H._extendedAttrs[Z]?.imageId;if($){let F=this._images.get($);F&&F.tileCount++}}}}let G=[];for(let[Y,H]of this._images.entries())H.bufferType==="alternate"&&!H.tileCount&&(H.marker?.dispose(),G.push(Y));for(let Y of G)this._delImg(Y)}},Hq=N3(m7());function M3(q){let G="";for(let Y=0;Y<q.length;++Y)G+=String.fromCharCode(q[Y]);return G}function y8(q){let G=0;for(let Y=0;Y<q.length;++Y){if(q[Y]<48||q[Y]>57)throw Error("illegal char");G=G*10+q[Y]-48}return G}function w9(q){let G=M3(q);if(!G.match(/^((auto)|(\d+?((px)|(%)){0,1}))$/))throw Error("illegal size");return G}function Zq(q){if(typeof Buffer<"u")return Buffer.from(M3(q),"base64").toString();let G=atob(M3(q)),Y=new Uint8Array(G.length);for(let H=0;H<Y.length;++H)Y[H]=G.charCodeAt(H);return new TextDecoder().decode(Y)}var v9={inline:y8,size:y8,name:Zq,width:w9,height:w9,preserveAspectRatio:y8},T9=[70,105,108,101],Q8=1024,$q=class{constructor(){this.state=0,this._buffer=new Uint32Array(Q8),this._position=0,this._key="",this.fields={}}reset(){this._buffer.fill(0),this.state=0,this._position=0,this.fields={},this._key=""}parse(q,G,Y){let H=this.state,Z=this._position,$=this._buffer;if(H===1||H===4||H===0&&Z>6)return-1;for(let F=G;F<Y;++F){let J=q[F];switch(J){case 59:if(!this._storeValue(Z))return this._a();H=2,Z=0;break;case 61:if(H===0){for(let z=0;z<T9.length;++z)if($[z]!==T9[z])return this._a();H=2,Z=0}else if(H===2){if(!this._storeKey(Z))return this._a();H=3,Z=0}else if(H===3){if(Z>=Q8)return this._a();$[Z++]=J}break;case 58:return H===3&&!this._storeValue(Z)?this._a():(this.state=4,F+1);default:if(Z>=Q8)return this._a();$[Z++]=J}}return this.state=H,this._position=Z,-2}_a(){return this.state=1,-1}_storeKey(q){let G=M3(this._buffer.subarray(0,q));return G?(this._key=G,this.fields[G]=null,!0):!1}_storeValue(q){if(this._key){try{let G=this._buffer.slice(0,q);this.fields[this._key]=v9[this._key]?v9[this._key](G):G}catch{return!1}return!0}return!1}},W3={mime:"unsupported",width:0,height:0};function Fq(q){if(q.length<24)return W3;let G=new Uint32Array(q.buffer,q.byteOffset,6);if(G[0]===1196314761&&G[1]===169478669&&G[3]===1380206665)return{mime:"image/png",width:q[16]<<24|q[17]<<16|q[18]<<8|q[19],height:q[20]<<24|q[21]<<16|q[22]<<8|q[23]};if(q[0]===255&&q[1]===216&&q[2]===255){let[Y,H]=Jq(q);return{mime:"image/jpeg",width:Y,height:H}}return G[0]===944130375&&(q[4]===55||q[4]===57)&&q[5]===97?{mime:"image/gif",width:q[7]<<8|q[6],height:q[9]<<8|q[8]}:W3}function Jq(q){let G=q.length,Y=4,H=q[Y]<<8|q[Y+1];for(;;){if(Y+=H,Y>=G)return[0,0];if(q[Y]!==255)return[0,0];if(q[Y+1]===192||q[Y+1]===194)return Y+8<G?[q[Y+7]<<8|q[Y+8],q[Y+5]<<8|q[Y+6]]:[0,0];Y+=2,H=q[Y]<<8|q[Y+1]}}var Xq=4194304,k8={name:"Unnamed file",size:0,width:"auto",height:"auto",preserveAspectRatio:1,inline:0},zq=class{constructor(q,G,Y,H){this._opts=q,this._renderer=G,this._storage=Y,this._coreTerminal=H,this._aborted=!1,this._hp=new $q,this._header=k8,this._dec=new Hq.default(Xq),this._metrics=W3}reset(){}start(){this._aborted=!1,this._header=k8,this._metrics=W3,this._hp.reset()}put(q,G,Y){if(!this._aborted)if(this._hp.state===4)this._dec.put(q,G,Y)&&(this._dec.release(),this._aborted=!0);else{let H=this._hp.parse(q,G,Y);if(H===-1){this._aborted=!0;return}if(H>0){if(this._header=Object.assign({},k8,this._hp.fields),!this._header.inline||!this._header.size||this._header.size>this._opts.iipSizeLimit){this._aborted=!0;return}this._dec.init(this._header.size),this._dec.put(q,H,Y)&&(this._dec.release(),this._aborted=!0)}}}end(q){if(this._aborted)return!0;let G=0,Y=0,H=!0;if((H=q)&&(H=!this._dec.end())&&(this._metrics=Fq(this._dec.data8),(H=this._metrics.mime!=="unsupported")&&(G=this._metrics.width,Y=this._metrics.height,(H=G&&Y&&G*Y<this._opts.pixelLimit)&&([G,Y]=this._resize(G,Y).map(Math.floor),H=G&&Y&&G*Y<this._opts.pixelLimit))),!H)return this._dec.release(),!0;let Z=new Blob([this._dec.data8],{type:this._metrics.mime});if(this._dec.release(),!window.createImageBitmap){let $=URL.createObjectURL(Z),F=new Image;return new Promise((J)=>{F.addEventListener("load",()=>{URL.revokeObjectURL($);let z=R3.createCanvas(window.document,G,Y);z.getContext("2d")?.drawImage(F,0,0,G,Y),this._storage.addImage(z),J(!0)}),F.src=$,setTimeout(()=>J(!0),1000)})}return createImageBitmap(Z,{resizeWidth:G,resizeHeight:Y}).then(($)=>(this._storage.addImage($),!0))}_resize(q,G){let Y=this._renderer.dimensions?.css.cell.width||_1.width,H=this._renderer.dimensions?.css.cell.height||_1.height,Z=this._renderer.dimensions?.css.canvas.width||Y*this._coreTerminal.cols,$=this._renderer.dimensions?.css.canvas.height||H*this._coreTerminal.rows,F=this._dim(this._header.width,Z,Y),J=this._dim(this._header.height,$,H);if(!F&&!J){let z=Z/q,X=($-H)/G,V=Math.min(z,X);return V<1?[q*V,G*V]:[q,G]}return F?this._header.preserveAspectRatio||!F||!J?[F,G*F/q]:[F,J]:[q*J/G,J]}_dim(q,G,Y){return q==="auto"?0:q.endsWith("%")?parseInt(q.slice(0,-1))*G/100:q.endsWith("px")?parseInt(q.slice(0,-2)):parseInt(q)*Y}},E1=N3(v8()),jq=N3(g7()),Vq=4194304,w8=E1.PALETTE_ANSI_256;w8.set(E1.PALETTE_VT340_COLOR);var Kq=class{constructor(q,G,Y){this._opts=q,this._storage=G,this._coreTerminal=Y,this._size=0,this._aborted=!1,(0,jq.DecoderAsync)({memoryLimit:this._opts.pixelLimit*4,palette:w8,paletteLimit:this._opts.sixelPaletteLimit}).then((H)=>this._dec=H)}reset(){this._dec&&(this._dec.release(),this._dec._palette.fill(0),this._dec.init(0,w8,this._opts.sixelPaletteLimit))}hook(q){if(this._size=0,this._aborted=!1,this._dec){let G=q.params[1]===1?0:Mq(this._coreTerminal._core._inputHandler._curAttrData,this._coreTerminal._core._themeService?.colors);this._dec.init(G,null,this._opts.sixelPaletteLimit)}}put(q,G,Y){if(!(this._aborted||!this._dec)){if(this._size+=Y-G,this._size>this._opts.sixelSizeLimit){console.warn("SIXEL: too much data, aborting"),this._aborted=!0,this._dec.release();return}try{this._dec.decode(q,G,Y)}catch(H){console.warn(`SIXEL: error while decoding image - ${H}`),this._aborted=!0,this._dec.release()}}}unhook(q){if(this._aborted||!q||!this._dec)return!0;let G=this._dec.width,Y=this._dec.height;if(!G||!Y)return Y&&this._storage.advanceCursor(Y),!0;let H=R3.createCanvas(void 0,G,Y);return H.getContext("2d")?.putImageData(new ImageData(this._dec.data8,G,Y),0,0),this._dec.memoryUsage>Vq&&this._dec.release(),this._storage.addImage(H),!0}};function Mq(q,G){let Y=0;if(!G)return Y;if(q.isInverse())if(q.isFgDefault())Y=V3(G.foreground.rgba);else if(q.isFgRGB()){let H=q.constructor.toColorRGB(q.getFgColor());Y=(0,E1.toRGBA8888)(...H)}else Y=V3(G.ansi[q.getFgColor()].rgba);else if(q.isBgDefault())Y=V3(G.background.rgba);else if(q.isBgRGB()){let H=q.constructor.toColorRGB(q.getBgColor());Y=(0,E1.toRGBA8888)(...H)}else Y=V3(G.ansi[q.getBgColor()].rgba);return Y}function V3(q){return E1.BIG_ENDIAN?q:(q&255)<<24|(q>>>8&255)<<16|(q>>>16&255)<<8|q>>>24&255}var I9={enableSizeReports:!0,pixelLimit:16777216,sixelSupport:!0,sixelScrolling:!0,sixelPaletteLimit:256,sixelSizeLimit:25000000,storageLimit:128,showPlaceholder:!0,iipSupport:!0,iipSizeLimit:20000000},_9=4096,p9=class{constructor(q){this._disposables=[],this._handlers=new Map,this._opts=Object.assign({},I9,q),this._defaultOpts=Object.assign({},I9,q)}dispose(){for(let q of this._disposables)q.dispose();this._disposables.length=0,this._handlers.clear()}_disposeLater(...q){for(let G of q)this._disposables.push(G)}activate(q){if(this._terminal=q,this._renderer=new R3(q),this._storage=new Yq(q,this._renderer,this._opts),this._opts.enableSizeReports){let G=q.options.windowOptions||{};G.getWinSizePixels=!0,G.getCellSizePixels=!0,G.getWinSizeChars=!0,q.options.windowOptions=G}if(this._disposeLater(this._renderer,this._storage,q.parser.registerCsiHandler({prefix:"?",final:"h"},(G)=>this._decset(G)),q.parser.registerCsiHandler({prefix:"?",final:"l"},(G)=>this._decrst(G)),q.parser.registerCsiHandler({final:"c"},(G)=>this._da1(G)),q.parser.registerCsiHandler({prefix:"?",final:"S"},(G)=>this._xtermGraphicsAttributes(G)),q.onRender((G)=>this._storage?.render(G)),q.parser.registerCsiHandler({intermediates:"!",final:"p"},()=>this.reset()),q.parser.registerEscHandler({final:"c"},()=>this.reset()),q._core._inputHandler.onRequestReset(()=>this.reset()),q.buffer.onBufferChange(()=>this._storage?.wipeAlternate()),q.onResize((G)=>this._storage?.viewportResize(G))),this._opts.sixelSupport){let G=new Kq(this._opts,this._storage,q);this._handlers.set("sixel",G),this._disposeLater(q._core._inputHandler._parser.registerDcsHandler({final:"q"},G))}if(this._opts.iipSupport){let G=new zq(this._opts,this._renderer,this._storage,q);this._handlers.set("iip",G),this._disposeLater(q._core._inputHandler._parser.registerOscHandler(1337,G))}}reset(){this._opts.sixelScrolling=this._defaultOpts.sixelScrolling,this._opts.sixelPaletteLimit=this._defaultOpts.sixelPaletteLimit,this._storage?.reset();for(let q of this._handlers.values())q.reset();return!1}get storageLimit(){return this._storage?.getLimit()||-1}set storageLimit(q){this._storage?.setLimit(q),this._opts.storageLimit=q}get storageUsage(){return this._storage?this._storage.getUsage():-1}get showPlaceholder(){return this._opts.showPlaceholder}set showPlaceholder(q){this._opts.showPlaceholder=q,this._renderer?.showPlaceholder(q)}getImageAtBufferCell(q,G){return this._storage?.getImageAtBufferCell(q,G)}extractTileAtBufferCell(q,G){return this._storage?.extractTileAtBufferCell(q,G)}_report(q){this._terminal?._core.coreService.triggerDataEvent(q)}_decset(q){for(let G=0;G<q.length;++G)switch(q[G]){case 80:this._opts.sixelScrolling=!1;break}return!1}_decrst(q){for(let G=0;G<q.length;++G)switch(q[G]){case 80:this._opts.sixelScrolling=!0;break}return!1}_da1(q){return q[0]?!0:this._opts.sixelSupport?(this._report("\x1B[?62;4;9;22c"),!0):!1}_xtermGraphicsAttributes(q){if(q.length<2)return!0;if(q[0]===1)switch(q[1]){case 1:return this._report(`\x1B[?${q[0]};0;${this._opts.sixelPaletteLimit}S`),!0;case 2:this._opts.sixelPaletteLimit=this._defaultOpts.sixelPaletteLimit,this._report(`\x1B[?${q[0]};0;${this._opts.sixelPaletteLimit}S`);for(let G of this._handlers.values())G.reset();return!0;case 3:return q.length>2&&!(q[2]instanceof Array)&&q[2]<=_9?(this._opts.sixelPaletteLimit=q[2],this._report(`\x1B[?${q[0]};0;${this._opts.sixelPaletteLimit}S`)):this._report(`\x1B[?${q[0]};2S`),!0;case 4:return this._report(`\x1B[?${q[0]};0;${_9}S`),!0;default:return this._report(`\x1B[?${q[0]};2S`),!0}if(q[0]===2)switch(q[1]){case 1:let G=this._renderer?.dimensions?.css.canvas.width,Y=this._renderer?.dimensions?.css.canvas.height;if(!G||!Y){let Z=_1;G=(this._terminal?.cols||80)*Z.width,Y=(this._terminal?.rows||24)*Z.height}if(G*Y<this._opts.pixelLimit)this._report(`\x1B[?${q[0]};0;${G.toFixed(0)};${Y.toFixed(0)}S`);else{let Z=Math.floor(Math.sqrt(this._opts.pixelLimit));this._report(`\x1B[?${q[0]};0;${Z};${Z}S`)}return!0;case 4:let H=Math.floor(Math.sqrt(this._opts.pixelLimit));return this._report(`\x1B[?${q[0]};0;${H};${H}S`),!0;default:return this._report(`\x1B[?${q[0]};2S`),!0}return this._report(`\x1B[?${q[0]};1S`),!0}};/* b3tty app */
+const Terminal = L9, FitAddon = O9, WebLinksAddon = P9, ImageAddon = p9;
+const MAX_UINT16 = 65535;
+function isValidHttpProtocol(protocol) {
+    return protocol === "http" || protocol === "https";
+}
+function isValidWsProtocol(protocol) {
+    return protocol === "ws" || protocol === "wss";
+}
+function isValidPort(port) {
+    return Number.isInteger(port) && port >= 1 && port <= MAX_UINT16;
+}
+function isValidUri(uri) {
+    if (!uri) return false;
+    const labelRe = /^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$/;
+    return uri.split(".").every((label)=>labelRe.test(label));
+}
+function isValidThemeColor(value) {
+    if (value === "") return true;
+    if (/^#[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$/.test(value)) return true;
+    if (/^[a-zA-Z]+$/.test(value)) return true;
+    return false;
+}
+function isThemeActivateResponse(val) {
+    return typeof val === "object" && val !== null && typeof val["hasBackgroundImage"] === "boolean";
+}
+function isEditProfileResponse(val) {
+    return typeof val === "object" && val !== null && Array.isArray(val["profileNames"]);
+}
+
+async function postAddTheme(name) {
+    const res = await fetch("/add-theme", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({
+            theme: name
+        })
+    });
+    if (!res.ok) throw new Error(`Failed to select theme "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (!isThemeActivateResponse(parsed)) throw new Error(`Unexpected add-theme response shape`);
+    return parsed;
+}
+async function postSize(url) {
+    const res = await fetch(url, {
+        method: "POST"
+    });
+    if (!res.ok) throw new Error(`Failed to set terminal size: ${res.status}`);
+}
+async function postThemeConfig(name) {
+    const res = await fetch(`/theme-config?name=${encodeURIComponent(name)}`, {
+        method: "POST"
+    });
+    if (!res.ok) throw new Error(`Failed to activate theme "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (!isThemeActivateResponse(parsed)) throw new Error(`Unexpected theme-config response shape`);
+    return parsed;
+}
+async function getThemePalette(name) {
+    const res = await fetch(`/theme?name=${encodeURIComponent(name)}`);
+    if (!res.ok) throw new Error(`Failed to fetch palette for theme "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (typeof parsed !== "object" || parsed === null || !Array.isArray(parsed["normal"]) || !Array.isArray(parsed["bright"])) {
+        throw new Error(`Unexpected palette response shape for theme "${name}"`);
+    }
+    return parsed;
+}
+async function postSaveConfig(theme) {
+    await fetch("/save-config", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({
+            theme
+        })
+    });
+}
+async function getThemeConfig(name) {
+    const res = await fetch(`/theme-config?name=${encodeURIComponent(name)}`);
+    if (!res.ok) throw new Error(`Failed to fetch config for theme "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (!isThemeActivateResponse(parsed)) throw new Error(`Unexpected theme-config response shape`);
+    return parsed;
+}
+async function getProfileConfig(name) {
+    const res = await fetch(`/profile-config?name=${encodeURIComponent(name)}`);
+    if (!res.ok) throw new Error(`Failed to fetch config for profile "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (typeof parsed !== "object" || parsed === null || !Array.isArray(parsed["commands"])) {
+        throw new Error(`Unexpected profile-config response shape for profile "${name}"`);
+    }
+    return parsed;
+}
+async function postEditProfile(name, profile) {
+    const res = await fetch("/edit-profile", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({
+            name,
+            profile
+        })
+    });
+    if (!res.ok) throw new Error(`Failed to edit profile "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (!isEditProfileResponse(parsed)) throw new Error(`Unexpected edit-profile response shape`);
+    return parsed;
+}
+async function postDeleteProfile(name) {
+    const res = await fetch("/delete-profile", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({
+            name
+        })
+    });
+    if (!res.ok) throw new Error(`Failed to delete profile "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (!isEditProfileResponse(parsed)) throw new Error(`Unexpected delete-profile response shape`);
+    return parsed;
+}
+async function postEditTheme(name, theme) {
+    const res = await fetch("/edit-theme", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({
+            name,
+            theme
+        })
+    });
+    if (!res.ok) throw new Error(`Failed to edit theme "${name}": ${res.status}`);
+    const parsed = await res.json();
+    if (!isThemeActivateResponse(parsed)) throw new Error(`Unexpected edit-theme response shape`);
+    return parsed;
+}
+
+
+function isB3ttyDialog(el) {
+    const r = el;
+    return typeof r["show"] === "function" && typeof r["hide"] === "function";
+}
+function isB3ttyMenuBar(el) {
+    const r = el;
+    return typeof r["setup"] === "function" && typeof r["updateColors"] === "function";
+}
+function isB3ttyThemePicker(el) {
+    const r = el;
+    return typeof r["open"] === "function" && typeof r["close"] === "function";
+}
+function isB3ttyThemeEditor(el) {
+    return el.tagName.toLowerCase() === "b3tty-theme-editor";
+}
+function isB3ttyProfileEditor(el) {
+    return el.tagName.toLowerCase() === "b3tty-profile-editor";
+}
+function isB3ttyPaletteCard(el) {
+    const r = el;
+    return typeof r["setup"] === "function" && "value" in r && "selected" in r;
+}
+function formatThemeName(name) {
+    return name.split("-").map((part)=>part.charAt(0).toUpperCase() + part.slice(1)).join(" ");
+}
+const BUTTON_STYLES = `
+    .cancel-btn {
+        padding: 8px 20px; border-radius: 5px;
+        border: 1px solid #aaa; background: #c8c8c8;
+        font-size: 14px; font-family: sans-serif; cursor: pointer;
+    }
+    .cancel-btn:hover { background: #b8b8b8; }
+    .ok-btn {
+        padding: 8px 28px; border-radius: 5px; border: none;
+        background: #444; color: #fff;
+        font-size: 14px; font-family: sans-serif; cursor: pointer;
+    }
+    .ok-btn:disabled { background: #aaa; cursor: not-allowed; }
+    .ok-btn:not(:disabled):hover { background: #222; }`;
+const PALETTE_CARD_VARS = `
+    b3tty-palette-card {
+        --palette-card-padding: 0;
+        --palette-card-gap: 0;
+        --palette-card-overflow: hidden;
+        --palette-card-header-bg: #c8c8c8;
+        --palette-card-header-padding: 8px 10px;
+        --palette-card-header-font-size: 12px;
+        --palette-card-terminal-gap: 6px;
+        --palette-card-terminal-shadow: none;
+        --palette-card-terminal-min-width: 0;
+    }`;
+function fetchPaletteCards(themeNames) {
+    return Promise.all(themeNames.map((name)=>getThemePalette(name).then((p)=>({
+                name,
+                palette: p
+            })).catch(()=>({
+                name,
+                palette: null
+            })))).then((results)=>{
+        const entries = [];
+        for (const { name, palette } of results){
+            if (palette) {
+                const card = document.createElement("b3tty-palette-card");
+                card.setup(name, formatThemeName(name), palette);
+                entries.push({
+                    card,
+                    name,
+                    palette
+                });
+            }
+        }
+        return entries;
+    });
+}
+if (typeof HTMLElement !== "undefined") {
+    class B3ttyPaletteCardImpl extends HTMLElement {
+        #shadow;
+        #value = "";
+        #radio = null;
+        static get observedAttributes() {
+            return [
+                "selected"
+            ];
+        }
+        get selected() {
+            return this.hasAttribute("selected");
+        }
+        get value() {
+            return this.#value;
+        }
+        attributeChangedCallback(name, _oldValue, newValue) {
+            if (name === "selected" && this.#radio) {
+                this.#radio.checked = newValue !== null;
+            }
+        }
+        constructor(){
+            super();
+            this.#shadow = this.attachShadow({
+                mode: "open"
+            });
+            const style = document.createElement("style");
+            style.textContent = `
+                :host {
+                    display: flex;
+                    flex-direction: column;
+                    gap: var(--palette-card-gap, 10px);
+                    padding: var(--palette-card-padding, 12px);
+                    border-radius: 8px;
+                    border: 2px solid transparent;
+                    background: #cecece;
+                    cursor: pointer;
+                    transition: border-color 0.15s;
+                    user-select: none;
+                    overflow: var(--palette-card-overflow, visible);
+                    box-sizing: border-box;
+                }
+                :host([selected]) { border-color: #444; }
+                .card-header {
+                    display: flex; align-items: center; gap: 7px;
+                    padding: var(--palette-card-header-padding, 0);
+                    font-family: sans-serif;
+                    font-size: var(--palette-card-header-font-size, 13px);
+                    font-weight: 600; color: #222;
+                    background: var(--palette-card-header-bg, transparent);
+                }
+                input[type=radio] { cursor: pointer; accent-color: #444; }
+                .terminal {
+                    border-radius: 6px;
+                    padding: 10px 10px 8px;
+                    display: flex; flex-direction: column;
+                    gap: var(--palette-card-terminal-gap, 7px);
+                    font-family: monospace; font-size: 11px;
+                    box-shadow: var(--palette-card-terminal-shadow, 0 2px 10px rgba(0,0,0,0.35));
+                    min-width: var(--palette-card-terminal-min-width, 196px);
+                }
+                .titlebar { display: flex; gap: 5px; margin-bottom: 1px; }
+                .dot { width: 9px; height: 9px; border-radius: 50%; }
+                .preview-text { padding: 1px 2px; line-height: 1.5; letter-spacing: 0.01em; }
+                .sel { padding: 0 2px; border-radius: 2px; }
+                .swatch-row { display: flex; gap: 3px; }
+                .swatch {
+                    width: 20px; height: 20px; border-radius: 4px;
+                    box-shadow: inset 0 0 0 1px rgba(128,128,128,0.25);
+                }
+            `;
+            this.#shadow.appendChild(style);
+            this.addEventListener("click", ()=>{
+                if (!this.#value) return;
+                this.setAttribute("selected", "");
+                this.dispatchEvent(new CustomEvent("b3tty-card-select", {
+                    detail: {
+                        value: this.#value
+                    },
+                    bubbles: true,
+                    composed: true
+                }));
+            });
+        }
+        setup(value, label, palette) {
+            this.#value = value;
+            const style = this.#shadow.querySelector("style");
+            while(this.#shadow.lastChild !== style){
+                this.#shadow.removeChild(this.#shadow.lastChild);
+            }
+            const header = document.createElement("div");
+            header.className = "card-header";
+            const radio = document.createElement("input");
+            radio.type = "radio";
+            radio.name = "theme";
+            radio.id = value;
+            radio.value = value;
+            radio.checked = this.hasAttribute("selected");
+            this.#radio = radio;
+            const labelSpan = document.createElement("span");
+            labelSpan.textContent = label;
+            header.appendChild(radio);
+            header.appendChild(labelSpan);
+            const terminal = document.createElement("div");
+            terminal.className = "terminal";
+            terminal.style.background = palette.bg;
+            const titlebar = document.createElement("div");
+            titlebar.className = "titlebar";
+            for (const color of [
+                "#ff5f57",
+                "#ffbd2e",
+                "#28c841"
+            ]){
+                const dot = document.createElement("div");
+                dot.className = "dot";
+                dot.style.background = color;
+                titlebar.appendChild(dot);
+            }
+            const preview = document.createElement("div");
+            preview.className = "preview-text";
+            preview.style.color = palette.fg;
+            preview.appendChild(document.createTextNode("lorem "));
+            const sel = document.createElement("span");
+            sel.className = "sel";
+            sel.style.background = palette.selBg;
+            sel.style.color = palette.fg;
+            sel.textContent = "ipsum";
+            preview.appendChild(sel);
+            const cursor = document.createElement("span");
+            cursor.textContent = "\u00a0";
+            cursor.style.background = palette.cursor;
+            preview.appendChild(cursor);
+            terminal.appendChild(titlebar);
+            terminal.appendChild(preview);
+            terminal.appendChild(this.#swatchRow(palette.normal));
+            terminal.appendChild(this.#swatchRow(palette.bright));
+            this.#shadow.appendChild(header);
+            this.#shadow.appendChild(terminal);
+        }
+        #swatchRow(colors) {
+            const row = document.createElement("div");
+            row.className = "swatch-row";
+            for (const color of colors){
+                const s = document.createElement("div");
+                s.className = "swatch";
+                s.style.background = color;
+                row.appendChild(s);
+            }
+            return row;
+        }
+    }
+    customElements.define("b3tty-palette-card", B3ttyPaletteCardImpl);
+    class B3ttyDialogImpl extends HTMLElement {
+        #action = undefined;
+        constructor(){
+            super();
+            const shadow = this.attachShadow({
+                mode: "open"
+            });
+            shadow.innerHTML = `
                 <style>
                     :host { display: none; }
                     :host([open]) { display: block; }
@@ -82,6 +460,10 @@ ${V.join(`
                         font-family: sans-serif;
                         font-size: 14px;
                     }
+                    .buttons {
+                        display: flex;
+                        gap: 12px;
+                    }
                     button {
                         padding: 5px 20px;
                         border-radius: 4px;
@@ -98,7 +480,2207 @@ ${V.join(`
                 <div class="backdrop">
                     <div class="modal" role="dialog" aria-modal="true">
                         <p></p>
-                        <button>OK</button>
+                        <div class="buttons">
+                            <button class="action" hidden></button>
+                            <button class="ok">OK</button>
+                        </div>
                     </div>
                 </div>
-            `,G.querySelector("button").addEventListener("click",()=>this.hide())}show(G){this.shadowRoot.querySelector("p").textContent=G,this.setAttribute("open","")}hide(){this.removeAttribute("open")}}customElements.define("b3tty-dialog",q)}var Wq=["foreground","background","black","brightBlack","red","brightRed","green","brightGreen","yellow","brightYellow","blue","brightBlue","magenta","brightMagenta","cyan","brightCyan","white","brightWhite","selectionForeground","selectionBackground"];function Nq(q){return{wsProtocol:q?"wss":"ws",httpProto:q?"https":"http"}}function Uq(q){let G={};for(let Y of Wq){let H=q[Y];if(H)G[Y]=H}return G}function Dq(q,G){let Y={cursorBlink:q.cursorBlink,fontFamily:`${q.fontFamily}, Menlo, DejaVu Sans Mono, Ubuntu Mono, Inconsolata, Fira, monospace`,fontSize:q.fontSize};if(q.rows)Y.rows=q.rows;if(q.columns)Y.cols=q.columns;if(Object.keys(G).length>0)Y.theme=G;return Y}function Cq(q,G,Y,H,Z){if(!g9(q))throw Error(`Invalid HTTP protocol: "${q}"`);if(!I8(G))throw Error(`Invalid URI: "${G}"`);if(!T8(Y))throw Error(`Invalid port: ${Y}`);let $=new URL(`${q}://${G}:${Y}/size`);return $.searchParams.set("cols",String(H)),$.searchParams.set("rows",String(Z)),$.toString()}function Rq(q,G,Y){if(!f9(q))throw Error(`Invalid WebSocket protocol: "${q}"`);if(!I8(G))throw Error(`Invalid URI: "${G}"`);if(!T8(Y))throw Error(`Invalid port: ${Y}`);return new URL(`${q}://${G}:${Y}/ws`)}function Bq(q,G,Y,H){let Z=q.data instanceof ArrayBuffer?G.decode(q.data,{stream:!0}):q.data;if(H!==void 0)Y.write(Z,H);else Y.write(Z)}function Lq(q,G){console.log("Socket closed"),q.writeln("[exited]"),G("Connection closed")}function Oq(q,G,Y){if(q.readyState===1)q.send(JSON.stringify({type:"resize",cols:G,rows:Y}))}function Pq(q,G,Y,H){if(q._initialized)return;q._initialized=!0,q.onData((Z)=>{if(H!==void 0)H();G.send(Z)}),q.onBell(()=>{Y.style.display="block",setTimeout(()=>{Y.style.display="none"},500)})}async function xq(q){let{wsProtocol:G,httpProto:Y}=Nq(q.tls);document.documentElement.style.setProperty("--b3tty-font-size",`${q.fontSize}pt`);let H=Uq(q.theme),Z=Dq(q,H),$=new L9(Z),F=document.getElementById("terminal");$.open(F),$.loadAddon(new P9),$.loadAddon(new p9);let J;if(!q.columns)J=new O9,$.loadAddon(J),J.fit();if(q.theme.background)document.getElementById("container").style.background=q.theme.background;let z=Cq(Y,q.uri,q.port,$.cols,$.rows);await fetch(z,{method:"POST"});let X=Rq(G,q.uri,q.port),V=new WebSocket(X);V.binaryType="arraybuffer";let j=new TextDecoder("utf-8"),N=null,K=q.debug?()=>{N=performance.now()}:void 0,M=q.debug?()=>{if(N!==null){let R=(performance.now()-N).toFixed(2);console.log(`[b3tty] keypress round-trip: ${R}ms`),N=null}}:void 0;V.onmessage=(R)=>{if(V.readyState!==1)console.log("websocket not ready!");Bq(R,j,$,M)};let W=document.getElementById("dialog");V.onclose=()=>{$.options.cursorBlink=!1,$.options.cursorInactiveStyle="none",$.blur(),$.textarea?.addEventListener("focus",()=>$.blur()),Lq($,(R)=>W.show(R))},V.onerror=(R)=>console.log("A socket error occurred: ",R),V.onopen=()=>console.log("Socket opened");let U=document.getElementById("bell");if(Pq($,V,U,K),!q.columns)$.onResize(({cols:R,rows:T})=>{Oq(V,R,T)}),window.addEventListener("resize",()=>{J.fit()})}if(typeof window<"u"&&window.B3TTY)xq(window.B3TTY);export{Oq as sendResizeMessage,xq as main,Pq as initTerm,Bq as handleSocketMessage,Lq as handleSocketClose,Nq as getProtocols,Rq as buildWsUrl,Uq as buildTheme,Dq as buildTermOptions,Cq as buildSizeUrl,Wq as THEME_KEYS};
+            `;
+            shadow.querySelector(".ok").addEventListener("click", ()=>this.hide());
+            shadow.querySelector(".action").addEventListener("click", ()=>{
+                this.hide();
+                this.#action?.onClick();
+            });
+        }
+        show(message, action) {
+            const shadow = this.shadowRoot;
+            shadow.querySelector("p").textContent = message;
+            const button = shadow.querySelector(".action");
+            this.#action = action;
+            button.hidden = action === undefined;
+            button.textContent = action?.label ?? "";
+            this.setAttribute("open", "");
+        }
+        hide() {
+            this.removeAttribute("open");
+        }
+    }
+    customElements.define("b3tty-dialog", B3ttyDialogImpl);
+    class B3ttyThemeSelectorImpl extends HTMLElement {
+        constructor(){
+            super();
+            const shadow = this.attachShadow({
+                mode: "open"
+            });
+            const skipCard = ()=>{
+                const card = document.createElement("label");
+                card.className = "card skip-card";
+                const header = document.createElement("div");
+                header.className = "card-header";
+                const radio = document.createElement("input");
+                radio.type = "radio";
+                radio.name = "theme";
+                radio.id = "skip";
+                radio.value = "skip";
+                const labelSpan = document.createElement("span");
+                labelSpan.textContent = "No theme";
+                header.appendChild(radio);
+                header.appendChild(labelSpan);
+                const note = document.createElement("p");
+                note.className = "skip-note";
+                note.textContent = "Configure a theme later in conf.yaml.";
+                card.appendChild(header);
+                card.appendChild(note);
+                return card;
+            };
+            const style = document.createElement("style");
+            style.textContent = `
+                :host { display: block; }
+                .backdrop {
+                    position: fixed; inset: 0;
+                    background: rgba(0,0,0,0.72);
+                    z-index: 10000;
+                    display: flex; align-items: center; justify-content: center;
+                }
+                .modal {
+                    background: #e0e0e0;
+                    border-radius: 10px;
+                    padding: 28px 32px;
+                    display: flex; flex-direction: column; align-items: center;
+                    gap: 20px;
+                    box-shadow: 0 8px 40px rgba(0,0,0,0.55);
+                }
+                .subtitle { margin: 0; font-size: 13px; font-family: sans-serif; color: #555; text-align: center; }
+                .options { display: flex; gap: 14px; flex-wrap: wrap; justify-content: center; }
+                .card {
+                    display: flex; flex-direction: column; gap: 10px;
+                    padding: 12px; border-radius: 8px;
+                    border: 2px solid transparent;
+                    background: #cecece;
+                    cursor: pointer;
+                    transition: border-color 0.15s;
+                    user-select: none;
+                }
+                .card:has(input:checked) { border-color: #444; }
+                .card-header {
+                    display: flex; align-items: center; gap: 7px;
+                    font-family: sans-serif; font-size: 13px; font-weight: 600; color: #222;
+                }
+                input[type=radio] { cursor: pointer; accent-color: #444; }
+                .skip-card { justify-content: center; min-width: 196px; }
+                .skip-note {
+                    margin: 0; font-family: sans-serif; font-size: 12px; color: #666;
+                    max-width: 180px; line-height: 1.5;
+                }
+                .ok-btn {
+                    padding: 9px 36px; border-radius: 5px; border: none;
+                    background: #444; color: #fff;
+                    font-size: 14px; font-family: sans-serif;
+                    cursor: pointer; transition: background 0.15s;
+                }
+                .ok-btn:disabled { background: #aaa; cursor: not-allowed; }
+                .ok-btn:not(:disabled):hover { background: #222; }
+            `;
+            const backdrop = document.createElement("div");
+            backdrop.className = "backdrop";
+            const modal = document.createElement("div");
+            modal.className = "modal";
+            modal.setAttribute("role", "dialog");
+            modal.setAttribute("aria-modal", "true");
+            const subtitle = document.createElement("p");
+            subtitle.className = "subtitle";
+            subtitle.textContent = "Choose a default theme to get started, or skip to configure one later.";
+            const options = document.createElement("div");
+            options.className = "options";
+            options.appendChild(skipCard());
+            const okBtn = document.createElement("button");
+            okBtn.className = "ok-btn";
+            okBtn.id = "ok-btn";
+            okBtn.textContent = "OK";
+            okBtn.disabled = true;
+            modal.appendChild(subtitle);
+            modal.appendChild(options);
+            modal.appendChild(okBtn);
+            backdrop.appendChild(modal);
+            shadow.appendChild(style);
+            shadow.appendChild(backdrop);
+            let selectedValue = null;
+            options.addEventListener("b3tty-card-select", (e)=>{
+                for (const card of Array.from(options.querySelectorAll("b3tty-palette-card"))){
+                    if (card !== e.target) card.removeAttribute("selected");
+                }
+                const skipRadio = shadow.querySelector("#skip");
+                if (skipRadio) skipRadio.checked = false;
+                selectedValue = e.detail.value;
+                okBtn.disabled = false;
+            });
+            options.addEventListener("change", (e)=>{
+                const target = e.target;
+                if (target.id === "skip") {
+                    for (const card of Array.from(options.querySelectorAll("b3tty-palette-card"))){
+                        card.removeAttribute("selected");
+                    }
+                    selectedValue = "skip";
+                    okBtn.disabled = false;
+                }
+            });
+            Promise.all([
+                getThemePalette("b3tty-dark"),
+                getThemePalette("b3tty-light")
+            ]).then(([dark, light])=>{
+                const lightCard = document.createElement("b3tty-palette-card");
+                lightCard.setup("b3tty-light", "B3tty Light", light);
+                const darkCard = document.createElement("b3tty-palette-card");
+                darkCard.setup("b3tty-dark", "B3tty Dark", dark);
+                options.prepend(lightCard);
+                options.prepend(darkCard);
+            }).catch(()=>{});
+            okBtn.addEventListener("click", async ()=>{
+                if (!selectedValue) return;
+                await postSaveConfig(selectedValue);
+                window.location.reload();
+            });
+        }
+    }
+    customElements.define("b3tty-theme-selector", B3ttyThemeSelectorImpl);
+    class B3ttyMenuBarImpl extends HTMLElement {
+        #hideTimer = null;
+        #activeSection = null;
+        #shadow;
+        #menubar;
+        #trigger;
+        #onDocumentPointerDown = (e)=>{
+            if (this.#activeSection === null) return;
+            if (!e.composedPath().includes(this)) {
+                this.#toggleSection(this.#activeSection);
+            }
+        };
+        constructor(){
+            super();
+            this.#shadow = this.attachShadow({
+                mode: "open"
+            });
+            const style = document.createElement("style");
+            style.textContent = `
+                :host {
+                    display: block;
+                    height: 0;
+                    overflow: visible;
+                    flex-shrink: 0;
+                }
+                :host([open]) {
+                    height: 32px;
+                }
+                .trigger {
+                    position: fixed;
+                    top: 0;
+                    left: 50%;
+                    transform: translateX(-50%);
+                    width: 100px;
+                    height: 6px;
+                    background: #808080;
+                    border-radius: 0 0 4px 4px;
+                    cursor: pointer;
+                    z-index: 1000;
+                    opacity: 0.5;
+                    transition: opacity 0.15s;
+                }
+                .trigger:hover {
+                    opacity: 1;
+                }
+                :host([open]) .trigger {
+                    display: none;
+                }
+                .menubar {
+                    display: none;
+                    width: 100%;
+                    height: 32px;
+                    box-sizing: border-box;
+                    flex-direction: row;
+                    align-items: stretch;
+                    background: var(--menu-bg, #fff);
+                    color: var(--menu-fg, #000);
+                    font-family: sans-serif;
+                    font-size: 13px;
+                    user-select: none;
+                    position: relative;
+                }
+                :host([open]) .menubar {
+                    display: flex;
+                }
+                .section {
+                    position: relative;
+                }
+                .section-label {
+                    display: flex;
+                    align-items: center;
+                    padding: 0 14px;
+                    height: 32px;
+                    cursor: pointer;
+                    box-sizing: border-box;
+                    color: var(--menu-fg, #000);
+                }
+                .section-label:hover,
+                .section.active .section-label {
+                    filter: brightness(0.85);
+                    background: var(--menu-bg, #fff);
+                }
+                .dropdown {
+                    display: none;
+                    position: absolute;
+                    top: 32px;
+                    left: 0;
+                    min-width: 140px;
+                    background: var(--menu-bg, #fff);
+                    color: var(--menu-fg, #000);
+                    box-shadow: 0 4px 12px rgba(0,0,0,0.3);
+                    flex-direction: column;
+                    z-index: 1001;
+                }
+                .section.active .dropdown {
+                    display: flex;
+                }
+                .menu-item {
+                    padding: 7px 16px;
+                    cursor: pointer;
+                    white-space: nowrap;
+                    color: var(--menu-fg, #000);
+                }
+                .menu-item:hover {
+                    filter: brightness(0.85);
+                    background: var(--menu-bg, #fff);
+                }
+                .menu-separator {
+                    height: 1px;
+                    background: var(--menu-fg, #000);
+                    opacity: 0.2;
+                    margin: 2px 8px;
+                }
+            `;
+            this.#trigger = document.createElement("div");
+            this.#trigger.className = "trigger";
+            this.#menubar = document.createElement("div");
+            this.#menubar.className = "menubar";
+            this.#shadow.appendChild(style);
+            this.#shadow.appendChild(this.#trigger);
+            this.#shadow.appendChild(this.#menubar);
+            this.#trigger.addEventListener("mouseenter", ()=>this.#open());
+            this.#menubar.addEventListener("mouseenter", ()=>this.#resetTimer());
+        }
+        connectedCallback() {
+            document.addEventListener("pointerdown", this.#onDocumentPointerDown);
+        }
+        disconnectedCallback() {
+            document.removeEventListener("pointerdown", this.#onDocumentPointerDown);
+        }
+        setup(themeNames, profileNames, colors) {
+            this.updateColors(colors);
+            this.#menubar.innerHTML = "";
+            this.#activeSection = null;
+            this.#menubar.appendChild(this.#buildSection("themes", "Themes", themeNames));
+            this.#menubar.appendChild(this.#buildSection("profiles", "Profiles", profileNames));
+        }
+        updateColors(colors) {
+            this.#shadow.host.style.setProperty("--menu-bg", colors.bg);
+            this.#shadow.host.style.setProperty("--menu-fg", colors.fg);
+        }
+        #buildSection(type, label, items) {
+            const section = document.createElement("div");
+            section.className = "section";
+            section.dataset["section"] = type;
+            const sectionLabel = document.createElement("span");
+            sectionLabel.className = "section-label";
+            sectionLabel.textContent = label;
+            const dropdown = document.createElement("div");
+            dropdown.className = "dropdown";
+            if (type === "themes") {
+                const selectItem = document.createElement("div");
+                selectItem.className = "menu-item";
+                selectItem.textContent = "Select Theme\u2026";
+                selectItem.addEventListener("click", (e)=>{
+                    e.stopPropagation();
+                    this.dispatchEvent(new CustomEvent("b3tty-open-theme-selector", {
+                        bubbles: true,
+                        composed: true
+                    }));
+                    this.#close();
+                });
+                dropdown.appendChild(selectItem);
+                const editItem = document.createElement("div");
+                editItem.className = "menu-item";
+                editItem.textContent = "Edit Theme…";
+                editItem.addEventListener("click", (e)=>{
+                    e.stopPropagation();
+                    this.dispatchEvent(new CustomEvent("b3tty-open-theme-editor", {
+                        bubbles: true,
+                        composed: true
+                    }));
+                    this.#close();
+                });
+                dropdown.appendChild(editItem);
+                if (items.length > 0) {
+                    const sep = document.createElement("div");
+                    sep.className = "menu-separator";
+                    dropdown.appendChild(sep);
+                }
+            }
+            if (type === "profiles") {
+                const editProfileItem = document.createElement("div");
+                editProfileItem.className = "menu-item";
+                editProfileItem.textContent = "Edit Profile…";
+                editProfileItem.addEventListener("click", (e)=>{
+                    e.stopPropagation();
+                    this.dispatchEvent(new CustomEvent("b3tty-open-profile-editor", {
+                        bubbles: true,
+                        composed: true
+                    }));
+                    this.#close();
+                });
+                dropdown.appendChild(editProfileItem);
+                const switchable = items.filter((n)=>n !== "default");
+                if (switchable.length > 0) {
+                    const sep = document.createElement("div");
+                    sep.className = "menu-separator";
+                    dropdown.appendChild(sep);
+                    for (const name of switchable){
+                        const item = document.createElement("div");
+                        item.className = "menu-item";
+                        item.textContent = name;
+                        item.addEventListener("click", (e)=>{
+                            e.stopPropagation();
+                            this.dispatchEvent(new CustomEvent("b3tty-profile-change", {
+                                detail: {
+                                    name
+                                },
+                                bubbles: true,
+                                composed: true
+                            }));
+                            this.#close();
+                        });
+                        dropdown.appendChild(item);
+                    }
+                }
+            } else {
+                for (const name of items){
+                    const item = document.createElement("div");
+                    item.className = "menu-item";
+                    item.textContent = name;
+                    item.addEventListener("click", (e)=>{
+                        e.stopPropagation();
+                        const eventName = type === "themes" ? "b3tty-theme-change" : "b3tty-profile-change";
+                        this.dispatchEvent(new CustomEvent(eventName, {
+                            detail: {
+                                name
+                            },
+                            bubbles: true,
+                            composed: true
+                        }));
+                        if (type === "themes") {
+                            this.#toggleSection(type);
+                        } else {
+                            this.#close();
+                        }
+                    });
+                    dropdown.appendChild(item);
+                }
+            }
+            sectionLabel.addEventListener("click", (e)=>{
+                e.stopPropagation();
+                this.#toggleSection(type);
+            });
+            section.appendChild(sectionLabel);
+            section.appendChild(dropdown);
+            return section;
+        }
+        #open() {
+            this.style.height = "32px";
+            this.setAttribute("open", "");
+            this.dispatchEvent(new CustomEvent("b3tty-menubar-open", {
+                bubbles: true,
+                composed: true
+            }));
+            this.#resetTimer();
+        }
+        #close() {
+            if (this.#hideTimer !== null) {
+                clearTimeout(this.#hideTimer);
+                this.#hideTimer = null;
+            }
+            this.#activeSection = null;
+            for (const s of Array.from(this.#menubar.querySelectorAll(".section.active"))){
+                s.classList.remove("active");
+            }
+            this.style.height = "0px";
+            this.removeAttribute("open");
+            this.dispatchEvent(new CustomEvent("b3tty-menubar-close", {
+                bubbles: true,
+                composed: true
+            }));
+        }
+        #resetTimer() {
+            if (this.#hideTimer !== null) clearTimeout(this.#hideTimer);
+            this.#hideTimer = setTimeout(()=>this.#close(), 5000);
+        }
+        #toggleSection(type) {
+            this.#resetTimer();
+            const section = this.#menubar.querySelector(`.section[data-section="${type}"]`);
+            if (!section) return;
+            if (this.#activeSection === type) {
+                section.classList.remove("active");
+                this.#activeSection = null;
+            } else {
+                for (const s of Array.from(this.#menubar.querySelectorAll(".section.active"))){
+                    s.classList.remove("active");
+                }
+                section.classList.add("active");
+                this.#activeSection = type;
+            }
+        }
+    }
+    customElements.define("b3tty-menu-bar", B3ttyMenuBarImpl);
+    class B3ttyThemePickerImpl extends HTMLElement {
+        #shadow;
+        #cards;
+        #okBtn;
+        constructor(){
+            super();
+            this.#shadow = this.attachShadow({
+                mode: "open"
+            });
+            const style = document.createElement("style");
+            style.textContent = `
+                :host { display: none; }
+                :host([open]) { display: block; }
+                .overlay {
+                    position: fixed; inset: 0;
+                    background: rgba(0,0,0,0.72);
+                    z-index: 10000;
+                    display: flex; align-items: center; justify-content: center;
+                    padding: 20px; box-sizing: border-box;
+                }
+                .modal {
+                    background: #e0e0e0;
+                    border-radius: 10px;
+                    padding: 24px 28px 20px;
+                    display: flex; flex-direction: column; gap: 16px;
+                    max-height: 85vh; max-width: 1000px; width: 100%;
+                    box-sizing: border-box;
+                    box-shadow: 0 8px 40px rgba(0,0,0,0.55);
+                }
+                h2 { margin: 0; font-family: sans-serif; font-size: 16px; font-weight: 600; color: #111; }
+                .cards {
+                    display: grid;
+                    grid-template-columns: repeat(auto-fill, minmax(210px, 1fr));
+                    gap: 12px;
+                    overflow-y: auto; flex: 1; min-height: 0;
+                    padding: 4px 2px;
+                }
+                ${PALETTE_CARD_VARS}
+                .loading {
+                    font-family: sans-serif; font-size: 13px; color: #555;
+                    text-align: center; padding: 20px; grid-column: 1 / -1;
+                }
+                .actions { display: flex; justify-content: flex-end; gap: 10px; }
+                ${BUTTON_STYLES}
+            `;
+            const overlay = document.createElement("div");
+            overlay.className = "overlay";
+            const modal = document.createElement("div");
+            modal.className = "modal";
+            modal.setAttribute("role", "dialog");
+            modal.setAttribute("aria-modal", "true");
+            const title = document.createElement("h2");
+            title.textContent = "Select a Theme";
+            this.#cards = document.createElement("div");
+            this.#cards.className = "cards";
+            const loading = document.createElement("div");
+            loading.className = "loading";
+            loading.textContent = "Loading themes\u2026";
+            this.#cards.appendChild(loading);
+            const actions = document.createElement("div");
+            actions.className = "actions";
+            const cancelBtn = document.createElement("button");
+            cancelBtn.className = "cancel-btn";
+            cancelBtn.textContent = "Cancel";
+            this.#okBtn = document.createElement("button");
+            this.#okBtn.className = "ok-btn";
+            this.#okBtn.textContent = "OK";
+            this.#okBtn.disabled = true;
+            actions.appendChild(cancelBtn);
+            actions.appendChild(this.#okBtn);
+            modal.appendChild(title);
+            modal.appendChild(this.#cards);
+            modal.appendChild(actions);
+            overlay.appendChild(modal);
+            this.#shadow.appendChild(style);
+            this.#shadow.appendChild(overlay);
+            this.#cards.addEventListener("b3tty-card-select", (e)=>{
+                for (const card of Array.from(this.#cards.querySelectorAll("b3tty-palette-card"))){
+                    if (card !== e.target) card.removeAttribute("selected");
+                }
+                this.#okBtn.disabled = false;
+            });
+            cancelBtn.addEventListener("click", ()=>this.close());
+            this.#okBtn.addEventListener("click", ()=>{
+                const selected = this.#cards.querySelector("b3tty-palette-card[selected]");
+                if (!selected) return;
+                this.dispatchEvent(new CustomEvent("b3tty-theme-selected", {
+                    detail: {
+                        name: selected.value
+                    },
+                    bubbles: true,
+                    composed: true
+                }));
+            });
+        }
+        open(themeNames) {
+            this.#okBtn.disabled = true;
+            this.#cards.innerHTML = "";
+            const loading = document.createElement("div");
+            loading.className = "loading";
+            loading.textContent = "Loading themes\u2026";
+            this.#cards.appendChild(loading);
+            this.setAttribute("open", "");
+            fetchPaletteCards(themeNames).then((entries)=>{
+                this.#cards.innerHTML = "";
+                for (const { card } of entries)this.#cards.appendChild(card);
+            });
+        }
+        close() {
+            this.removeAttribute("open");
+            this.#okBtn.disabled = true;
+        }
+    }
+    customElements.define("b3tty-theme-picker", B3ttyThemePickerImpl);
+    class B3ttyThemeEditorImpl extends HTMLElement {
+        #shadow;
+        #leftPanel;
+        #nameInput;
+        #nameError;
+        #colorInputs = new Map();
+        #swatches = new Map();
+        #okBtn;
+        #selectedName = null;
+        #selectedCard = null;
+        #selectedPalette = null;
+        #paletteCache = new Map();
+        #createCard;
+        #createRadio;
+        #isLoading = false;
+        #builtinThemeNames = new Set();
+        constructor(){
+            super();
+            this.#shadow = this.attachShadow({
+                mode: "open"
+            });
+            const style = document.createElement("style");
+            style.textContent = `
+                :host { display: none; }
+                :host([open]) { display: block; }
+                .overlay {
+                    position: fixed; inset: 0;
+                    background: rgba(0,0,0,0.72);
+                    z-index: 10000;
+                    display: flex; align-items: center; justify-content: center;
+                    padding: 20px; box-sizing: border-box;
+                }
+                .modal {
+                    background: #e0e0e0;
+                    border-radius: 10px;
+                    padding: 20px;
+                    display: flex; flex-direction: row;
+                    width: min(780px, 100%); height: min(560px, 90vh);
+                    box-sizing: border-box;
+                    box-shadow: 0 8px 40px rgba(0,0,0,0.55);
+                    overflow: hidden;
+                }
+                .left-panel {
+                    width: 240px; flex-shrink: 0;
+                    display: flex; flex-direction: column; gap: 6px;
+                    overflow-y: auto; min-height: 0;
+                    padding-right: 10px;
+                    border-right: 1px solid #c0c0c0;
+                }
+                .create-card {
+                    display: flex; align-items: center; gap: 7px;
+                    padding: 8px 10px;
+                    background: #c8c8c8;
+                    border: 2px solid transparent;
+                    border-radius: 4px;
+                    cursor: pointer;
+                    font-family: sans-serif; font-size: 13px; font-weight: 600; color: #222;
+                    user-select: none; flex-shrink: 0;
+                }
+                .create-card:hover { background: #bbb; }
+                .create-card[selected] { border-color: #444; background: #b8b8b8; }
+                .create-card input[type=radio] { cursor: pointer; accent-color: #444; }
+                ${PALETTE_CARD_VARS}
+                b3tty-palette-card { flex-shrink: 0; }
+                .right-panel {
+                    flex: 1; display: flex; flex-direction: column;
+                    padding-left: 16px; min-width: 0;
+                }
+                .name-section {
+                    display: flex; flex-direction: column; gap: 4px;
+                    flex-shrink: 0; padding-bottom: 10px;
+                    border-bottom: 1px solid #c8c8c8;
+                    margin-bottom: 8px;
+                }
+                .name-row {
+                    display: flex; align-items: center; gap: 8px;
+                }
+                .name-section label {
+                    font-family: sans-serif; font-size: 12px; color: #444;
+                    white-space: nowrap; min-width: 80px;
+                }
+                .name-input {
+                    flex: 1; font-family: sans-serif; font-size: 13px;
+                    padding: 4px 8px; border: 1px solid #aaa; border-radius: 3px;
+                    background: #f5f5f5;
+                }
+                .name-input:read-only { background: #e8e8e8; color: #555; }
+                .name-error {
+                    font-family: sans-serif; font-size: 11px; color: #c00;
+                    display: none;
+                }
+                .name-error.visible { display: block; }
+                .color-form {
+                    flex: 1; overflow-y: auto; min-height: 0;
+                    display: flex; flex-direction: column; gap: 4px;
+                }
+                .section-title {
+                    font-family: sans-serif; font-size: 11px; font-weight: 600;
+                    text-transform: uppercase; letter-spacing: 0.05em;
+                    color: #666; padding: 6px 0 2px;
+                }
+                .field-row {
+                    display: grid;
+                    grid-template-columns: 140px 1fr 22px;
+                    gap: 4px; align-items: center;
+                }
+                .field-row label {
+                    font-family: sans-serif; font-size: 12px; color: #444;
+                }
+                .ansi-header {
+                    display: grid;
+                    grid-template-columns: 68px 1fr 22px 1fr 22px;
+                    gap: 4px;
+                    font-family: sans-serif; font-size: 11px; font-weight: 600;
+                    color: #666; padding-bottom: 2px;
+                }
+                .ansi-row {
+                    display: grid;
+                    grid-template-columns: 68px 1fr 22px 1fr 22px;
+                    gap: 4px; align-items: center;
+                }
+                .ansi-row .color-label {
+                    font-family: sans-serif; font-size: 12px; color: #444;
+                }
+                .color-input {
+                    font-family: monospace; font-size: 12px;
+                    padding: 3px 6px; border: 1px solid #aaa; border-radius: 3px;
+                    background: #f5f5f5; width: 100%; box-sizing: border-box;
+                    min-width: 0;
+                }
+                .swatch {
+                    width: 18px; height: 18px;
+                    border-radius: 3px; border: 1px solid rgba(0,0,0,0.2);
+                    visibility: hidden;
+                }
+                .swatch.visible { visibility: visible; }
+                .actions {
+                    display: flex; justify-content: flex-end; gap: 10px;
+                    padding-top: 10px; flex-shrink: 0;
+                    border-top: 1px solid #c8c8c8; margin-top: 8px;
+                }
+                ${BUTTON_STYLES}
+            `;
+            const overlay = document.createElement("div");
+            overlay.className = "overlay";
+            const modal = document.createElement("div");
+            modal.className = "modal";
+            modal.setAttribute("role", "dialog");
+            modal.setAttribute("aria-modal", "true");
+            this.#leftPanel = document.createElement("div");
+            this.#leftPanel.className = "left-panel";
+            this.#createCard = document.createElement("div");
+            this.#createCard.className = "create-card";
+            this.#createRadio = document.createElement("input");
+            this.#createRadio.type = "radio";
+            this.#createRadio.name = "theme";
+            this.#createRadio.checked = true;
+            const createLabel = document.createElement("span");
+            createLabel.textContent = "Create new theme";
+            this.#createCard.appendChild(this.#createRadio);
+            this.#createCard.appendChild(createLabel);
+            this.#leftPanel.appendChild(this.#createCard);
+            const rightPanel = document.createElement("div");
+            rightPanel.className = "right-panel";
+            const nameSection = document.createElement("div");
+            nameSection.className = "name-section";
+            const nameRow = document.createElement("div");
+            nameRow.className = "name-row";
+            const nameLabel = document.createElement("label");
+            nameLabel.textContent = "Theme Name";
+            this.#nameInput = document.createElement("input");
+            this.#nameInput.type = "text";
+            this.#nameInput.className = "name-input";
+            this.#nameInput.placeholder = "Enter theme name";
+            this.#nameError = document.createElement("span");
+            this.#nameError.className = "name-error";
+            this.#nameError.textContent = "Cannot use a built-in theme name";
+            nameRow.appendChild(nameLabel);
+            nameRow.appendChild(this.#nameInput);
+            nameSection.appendChild(nameRow);
+            nameSection.appendChild(this.#nameError);
+            const colorForm = document.createElement("div");
+            colorForm.className = "color-form";
+            const coreTitle = document.createElement("div");
+            coreTitle.className = "section-title";
+            coreTitle.textContent = "Core Colors";
+            colorForm.appendChild(coreTitle);
+            for (const { key, label } of [
+                {
+                    key: "background",
+                    label: "Background"
+                },
+                {
+                    key: "foreground",
+                    label: "Foreground"
+                },
+                {
+                    key: "cursor",
+                    label: "Cursor"
+                },
+                {
+                    key: "cursorAccent",
+                    label: "Cursor Accent"
+                },
+                {
+                    key: "selectionBackground",
+                    label: "Selection Background"
+                },
+                {
+                    key: "selectionForeground",
+                    label: "Selection Foreground"
+                }
+            ]){
+                const [row, input, swatch] = this.#makeColorRow(label);
+                this.#colorInputs.set(key, input);
+                this.#swatches.set(key, swatch);
+                colorForm.appendChild(row);
+            }
+            const ansiTitle = document.createElement("div");
+            ansiTitle.className = "section-title";
+            ansiTitle.textContent = "ANSI Colors";
+            colorForm.appendChild(ansiTitle);
+            const ansiHeader = document.createElement("div");
+            ansiHeader.className = "ansi-header";
+            ansiHeader.appendChild(document.createElement("span"));
+            const hNormal = document.createElement("span");
+            hNormal.textContent = "Normal";
+            ansiHeader.appendChild(hNormal);
+            ansiHeader.appendChild(document.createElement("span"));
+            const hBright = document.createElement("span");
+            hBright.textContent = "Bright";
+            ansiHeader.appendChild(hBright);
+            ansiHeader.appendChild(document.createElement("span"));
+            colorForm.appendChild(ansiHeader);
+            for (const [colorName, normalKey, brightKey] of [
+                [
+                    "Black",
+                    "black",
+                    "brightBlack"
+                ],
+                [
+                    "Red",
+                    "red",
+                    "brightRed"
+                ],
+                [
+                    "Yellow",
+                    "yellow",
+                    "brightYellow"
+                ],
+                [
+                    "Green",
+                    "green",
+                    "brightGreen"
+                ],
+                [
+                    "Cyan",
+                    "cyan",
+                    "brightCyan"
+                ],
+                [
+                    "Blue",
+                    "blue",
+                    "brightBlue"
+                ],
+                [
+                    "Magenta",
+                    "magenta",
+                    "brightMagenta"
+                ],
+                [
+                    "White",
+                    "white",
+                    "brightWhite"
+                ]
+            ]){
+                const ansiRow = document.createElement("div");
+                ansiRow.className = "ansi-row";
+                const colorLabel = document.createElement("span");
+                colorLabel.className = "color-label";
+                colorLabel.textContent = colorName;
+                ansiRow.appendChild(colorLabel);
+                for (const key of [
+                    normalKey,
+                    brightKey
+                ]){
+                    const input = document.createElement("input");
+                    input.type = "text";
+                    input.className = "color-input";
+                    input.placeholder = "#rrggbb";
+                    const swatch = document.createElement("span");
+                    swatch.className = "swatch";
+                    input.addEventListener("input", ()=>{
+                        this.#updateSwatch(swatch, input.value);
+                        this.#validateForm();
+                    });
+                    this.#colorInputs.set(key, input);
+                    this.#swatches.set(key, swatch);
+                    ansiRow.appendChild(input);
+                    ansiRow.appendChild(swatch);
+                }
+                colorForm.appendChild(ansiRow);
+            }
+            const actions = document.createElement("div");
+            actions.className = "actions";
+            const cancelBtn = document.createElement("button");
+            cancelBtn.className = "cancel-btn";
+            cancelBtn.textContent = "Cancel";
+            this.#okBtn = document.createElement("button");
+            this.#okBtn.className = "ok-btn";
+            this.#okBtn.textContent = "OK";
+            this.#okBtn.disabled = true;
+            actions.appendChild(cancelBtn);
+            actions.appendChild(this.#okBtn);
+            rightPanel.appendChild(nameSection);
+            rightPanel.appendChild(colorForm);
+            rightPanel.appendChild(actions);
+            modal.appendChild(this.#leftPanel);
+            modal.appendChild(rightPanel);
+            overlay.appendChild(modal);
+            this.#shadow.appendChild(style);
+            this.#shadow.appendChild(overlay);
+            this.#nameInput.addEventListener("input", ()=>this.#validateForm());
+            this.#createCard.addEventListener("click", ()=>{
+                for (const card of Array.from(this.#leftPanel.querySelectorAll("b3tty-palette-card"))){
+                    card.removeAttribute("selected");
+                }
+                this.#createCard.setAttribute("selected", "");
+                this.#createRadio.checked = true;
+                this.#restoreSelectedCard();
+                this.#selectedName = null;
+                this.#nameInput.value = "";
+                this.#clearInputs();
+                this.#isLoading = false;
+                this.#validateForm();
+            });
+            this.#leftPanel.addEventListener("b3tty-card-select", (e)=>{
+                const target = e.target;
+                for (const card of Array.from(this.#leftPanel.querySelectorAll("b3tty-palette-card"))){
+                    if (card !== target) card.removeAttribute("selected");
+                }
+                this.#createCard.removeAttribute("selected");
+                this.#createRadio.checked = false;
+                const name = target.value;
+                this.#restoreSelectedCard();
+                this.#selectedName = name;
+                this.#selectedCard = target;
+                this.#selectedPalette = this.#paletteCache.get(name) ?? null;
+                this.#nameInput.value = name;
+                this.#isLoading = true;
+                this.#okBtn.disabled = true;
+                this.#clearInputs();
+                this.#loadThemeColors(name);
+            });
+            cancelBtn.addEventListener("click", ()=>this.close());
+            this.#okBtn.addEventListener("click", ()=>void this.#handleOk());
+        }
+        #makeColorRow(label) {
+            const row = document.createElement("div");
+            row.className = "field-row";
+            const lbl = document.createElement("label");
+            lbl.textContent = label;
+            const input = document.createElement("input");
+            input.type = "text";
+            input.className = "color-input";
+            input.placeholder = "#rrggbb";
+            const swatch = document.createElement("span");
+            swatch.className = "swatch";
+            input.addEventListener("input", ()=>{
+                this.#updateSwatch(swatch, input.value);
+                this.#validateForm();
+            });
+            row.appendChild(lbl);
+            row.appendChild(input);
+            row.appendChild(swatch);
+            return [
+                row,
+                input,
+                swatch
+            ];
+        }
+        #updateSwatch(swatch, value) {
+            if (value && isValidThemeColor(value)) {
+                swatch.style.backgroundColor = value;
+                swatch.classList.add("visible");
+            } else {
+                swatch.classList.remove("visible");
+            }
+        }
+        #validateForm() {
+            if (this.#isLoading) {
+                this.#okBtn.disabled = true;
+                this.#nameError.classList.remove("visible");
+                return;
+            }
+            this.#updatePreview();
+            const name = this.#nameInput.value.trim();
+            if (!name) {
+                this.#okBtn.disabled = true;
+                this.#nameError.classList.remove("visible");
+                return;
+            }
+            if (this.#builtinThemeNames.has(name)) {
+                this.#okBtn.disabled = true;
+                this.#nameError.classList.add("visible");
+                return;
+            }
+            this.#nameError.classList.remove("visible");
+            for (const input of this.#colorInputs.values()){
+                if (input.value && !isValidThemeColor(input.value)) {
+                    this.#okBtn.disabled = true;
+                    return;
+                }
+            }
+            this.#okBtn.disabled = false;
+        }
+        #restoreSelectedCard() {
+            if (!this.#selectedCard || !this.#selectedName) return;
+            const original = this.#paletteCache.get(this.#selectedName);
+            if (original) {
+                this.#selectedCard.setup(this.#selectedName, formatThemeName(this.#selectedName), original);
+            }
+            this.#selectedCard = null;
+            this.#selectedPalette = null;
+        }
+        #buildPreviewPalette() {
+            if (!this.#selectedPalette) return null;
+            const base = this.#selectedPalette;
+            const get = (key)=>{
+                const val = this.#colorInputs.get(key)?.value;
+                return val && isValidThemeColor(val) ? val : null;
+            };
+            const normalKeys = [
+                "black",
+                "red",
+                "yellow",
+                "green",
+                "cyan",
+                "blue",
+                "magenta",
+                "white"
+            ];
+            const brightKeys = [
+                "brightBlack",
+                "brightRed",
+                "brightYellow",
+                "brightGreen",
+                "brightCyan",
+                "brightBlue",
+                "brightMagenta",
+                "brightWhite"
+            ];
+            return {
+                bg: get("background") ?? base.bg,
+                fg: get("foreground") ?? base.fg,
+                selBg: get("selectionBackground") ?? base.selBg,
+                cursor: get("cursor") ?? base.cursor,
+                normal: normalKeys.map((k, i)=>get(k) ?? base.normal[i]),
+                bright: brightKeys.map((k, i)=>get(k) ?? base.bright[i])
+            };
+        }
+        #updatePreview() {
+            if (!this.#selectedCard || !this.#selectedName || !this.#selectedPalette) return;
+            const palette = this.#buildPreviewPalette();
+            if (!palette) return;
+            this.#selectedCard.setup(this.#selectedName, formatThemeName(this.#selectedName), palette);
+        }
+        #clearInputs() {
+            for (const [key, input] of this.#colorInputs){
+                input.value = "";
+                const swatch = this.#swatches.get(key);
+                if (swatch) swatch.classList.remove("visible");
+            }
+        }
+        async #loadThemeColors(name) {
+            try {
+                const config = await getThemeConfig(name);
+                const data = config;
+                for (const [key, input] of this.#colorInputs){
+                    const value = data[key] ?? "";
+                    input.value = value;
+                    const swatch = this.#swatches.get(key);
+                    if (swatch) this.#updateSwatch(swatch, value);
+                }
+            } catch  {} finally{
+                this.#isLoading = false;
+                this.#validateForm();
+            }
+        }
+        async #handleOk() {
+            const name = this.#nameInput.value.trim();
+            if (!name) return;
+            const themeData = {};
+            for (const [key, input] of this.#colorInputs){
+                if (input.value) themeData[key] = input.value;
+            }
+            try {
+                const response = await postEditTheme(name, themeData);
+                this.dispatchEvent(new CustomEvent("b3tty-theme-edited", {
+                    detail: {
+                        name,
+                        response
+                    },
+                    bubbles: true,
+                    composed: true
+                }));
+                this.close();
+            } catch  {}
+        }
+        open(themeNames, builtinThemeNames = []) {
+            this.#builtinThemeNames = new Set(builtinThemeNames);
+            this.#selectedName = null;
+            this.#selectedCard = null;
+            this.#selectedPalette = null;
+            this.#paletteCache.clear();
+            this.#isLoading = false;
+            this.#nameInput.value = "";
+            this.#nameError.classList.remove("visible");
+            this.#clearInputs();
+            this.#okBtn.disabled = true;
+            const existingCards = Array.from(this.#leftPanel.querySelectorAll("b3tty-palette-card"));
+            for (const card of existingCards)card.remove();
+            this.#createCard.setAttribute("selected", "");
+            this.#createRadio.checked = true;
+            this.setAttribute("open", "");
+            fetchPaletteCards(themeNames).then((entries)=>{
+                for (const c of Array.from(this.#leftPanel.querySelectorAll("b3tty-palette-card")))c.remove();
+                for (const { card, name, palette } of entries){
+                    this.#paletteCache.set(name, palette);
+                    this.#leftPanel.appendChild(card);
+                }
+            });
+        }
+        close() {
+            this.removeAttribute("open");
+            this.#restoreSelectedCard();
+            this.#selectedName = null;
+            this.#nameError.classList.remove("visible");
+        }
+    }
+    customElements.define("b3tty-theme-editor", B3ttyThemeEditorImpl);
+    class B3ttyProfileEditorImpl extends HTMLElement {
+        #shadow;
+        #leftPanel;
+        #createCard;
+        #createRadio;
+        #nameInput;
+        #nameError;
+        #shellInput;
+        #titleInput;
+        #wdInput;
+        #rootInput;
+        #commandsArea;
+        #lineNumbers;
+        #okBtn;
+        #deleteBtn;
+        #selectedName = null;
+        #selectedCard = null;
+        #isLoading = false;
+        constructor(){
+            super();
+            this.#shadow = this.attachShadow({
+                mode: "open"
+            });
+            const style = document.createElement("style");
+            style.textContent = `
+                :host { display: none; }
+                :host([open]) { display: block; }
+                .overlay {
+                    position: fixed; inset: 0;
+                    background: rgba(0,0,0,0.72);
+                    z-index: 10000;
+                    display: flex; align-items: center; justify-content: center;
+                    padding: 20px; box-sizing: border-box;
+                }
+                .modal {
+                    background: #e0e0e0;
+                    border-radius: 10px;
+                    padding: 20px;
+                    display: flex; flex-direction: row;
+                    width: min(680px, 100%); height: min(500px, 90vh);
+                    box-sizing: border-box;
+                    box-shadow: 0 8px 40px rgba(0,0,0,0.55);
+                    overflow: hidden;
+                }
+                .left-panel {
+                    width: 200px; flex-shrink: 0;
+                    display: flex; flex-direction: column; gap: 6px;
+                    overflow-y: auto; min-height: 0;
+                    padding-right: 10px;
+                    border-right: 1px solid #c0c0c0;
+                }
+                .create-card {
+                    display: flex; align-items: center; gap: 7px;
+                    padding: 8px 10px;
+                    background: #c8c8c8;
+                    border: 2px solid transparent;
+                    border-radius: 4px;
+                    cursor: pointer;
+                    font-family: sans-serif; font-size: 13px; font-weight: 600; color: #222;
+                    user-select: none; flex-shrink: 0;
+                }
+                .create-card:hover { background: #bbb; }
+                .create-card[selected] { border-color: #444; background: #b8b8b8; }
+                .create-card input[type=radio] { cursor: pointer; accent-color: #444; }
+                .profile-card {
+                    display: flex; align-items: center; gap: 7px;
+                    padding: 8px 10px;
+                    background: #c8c8c8;
+                    border: 2px solid transparent;
+                    border-radius: 4px;
+                    cursor: pointer;
+                    font-family: sans-serif; font-size: 13px; color: #222;
+                    user-select: none; flex-shrink: 0;
+                }
+                .profile-card:hover { background: #bbb; }
+                .profile-card[selected] { border-color: #444; background: #b8b8b8; }
+                .profile-card input[type=radio] { cursor: pointer; accent-color: #444; }
+                .right-panel {
+                    flex: 1; display: flex; flex-direction: column;
+                    padding-left: 16px; min-width: 0;
+                }
+                .fields-section {
+                    display: flex; flex-direction: column; gap: 6px;
+                    flex-shrink: 0; padding-bottom: 10px;
+                    border-bottom: 1px solid #c8c8c8;
+                    margin-bottom: 8px;
+                }
+                .field-row {
+                    display: grid;
+                    grid-template-columns: 120px 1fr;
+                    gap: 6px; align-items: center;
+                }
+                .field-row label {
+                    font-family: sans-serif; font-size: 12px; color: #444;
+                    white-space: nowrap;
+                }
+                .field-row label .required {
+                    color: #c00;
+                }
+                .field-input {
+                    font-family: sans-serif; font-size: 13px;
+                    padding: 4px 8px; border: 1px solid #aaa; border-radius: 3px;
+                    background: #f5f5f5; box-sizing: border-box;
+                }
+                .field-input:read-only { background: #e8e8e8; color: #555; }
+                .name-error {
+                    grid-column: 2;
+                    font-family: sans-serif; font-size: 11px; color: #c00;
+                    display: none;
+                }
+                .name-error.visible { display: block; }
+                .commands-section {
+                    flex: 1; display: flex; flex-direction: column; gap: 4px;
+                    min-height: 0;
+                }
+                .commands-label {
+                    font-family: sans-serif; font-size: 12px; color: #444;
+                    flex-shrink: 0;
+                }
+                .commands-wrapper {
+                    display: flex;
+                    border: 1px solid #aaa;
+                    border-radius: 3px;
+                    overflow: hidden;
+                    flex: 1; min-height: 0;
+                    background: #f5f5f5;
+                    font-family: monospace;
+                    font-size: 12px;
+                    line-height: 1.5em;
+                }
+                .line-numbers {
+                    width: 32px;
+                    padding: 4px 4px;
+                    background: #ddd;
+                    color: #888;
+                    text-align: right;
+                    user-select: none;
+                    overflow: hidden;
+                    white-space: pre;
+                    line-height: inherit;
+                    flex-shrink: 0;
+                    box-sizing: border-box;
+                }
+                .commands-area {
+                    flex: 1;
+                    padding: 4px 6px;
+                    border: none;
+                    outline: none;
+                    resize: none;
+                    background: transparent;
+                    font-family: inherit;
+                    font-size: inherit;
+                    line-height: inherit;
+                    overflow: auto;
+                    white-space: pre;
+                    box-sizing: border-box;
+                }
+                .actions {
+                    display: flex; justify-content: flex-end; gap: 10px;
+                    align-items: center;
+                    padding-top: 10px; flex-shrink: 0;
+                    border-top: 1px solid #c8c8c8; margin-top: 8px;
+                }
+                .delete-btn {
+                    margin-right: auto;
+                    padding: 8px 16px; border-radius: 5px;
+                    border: 1px solid #c44; background: #f5d5d5;
+                    font-size: 14px; font-family: sans-serif; cursor: pointer; color: #c00;
+                }
+                .delete-btn:hover { background: #f0b8b8; }
+                ${BUTTON_STYLES}
+            `;
+            const overlay = document.createElement("div");
+            overlay.className = "overlay";
+            const modal = document.createElement("div");
+            modal.className = "modal";
+            modal.setAttribute("role", "dialog");
+            modal.setAttribute("aria-modal", "true");
+            this.#leftPanel = document.createElement("div");
+            this.#leftPanel.className = "left-panel";
+            this.#createCard = document.createElement("div");
+            this.#createCard.className = "create-card";
+            this.#createCard.setAttribute("selected", "");
+            this.#createRadio = document.createElement("input");
+            this.#createRadio.type = "radio";
+            this.#createRadio.name = "profile";
+            this.#createRadio.checked = true;
+            const createLabel = document.createElement("span");
+            createLabel.textContent = "Create new profile";
+            this.#createCard.appendChild(this.#createRadio);
+            this.#createCard.appendChild(createLabel);
+            this.#leftPanel.appendChild(this.#createCard);
+            const rightPanel = document.createElement("div");
+            rightPanel.className = "right-panel";
+            const fieldsSection = document.createElement("div");
+            fieldsSection.className = "fields-section";
+            const makeFieldRow = (labelText, required, placeholder)=>{
+                const row = document.createElement("div");
+                row.className = "field-row";
+                const lbl = document.createElement("label");
+                if (required) {
+                    lbl.innerHTML = `${labelText} <span class="required">*</span>`;
+                } else {
+                    lbl.textContent = labelText;
+                }
+                const input = document.createElement("input");
+                input.type = "text";
+                input.className = "field-input";
+                input.placeholder = placeholder;
+                row.appendChild(lbl);
+                row.appendChild(input);
+                return [
+                    row,
+                    input
+                ];
+            };
+            const [nameRow, nameInput] = makeFieldRow("Profile Name", true, "Enter profile name");
+            this.#nameInput = nameInput;
+            this.#nameError = document.createElement("span");
+            this.#nameError.className = "name-error";
+            this.#nameError.textContent = "Cannot use 'default' as a profile name";
+            nameRow.appendChild(this.#nameError);
+            fieldsSection.appendChild(nameRow);
+            const [shellRow, shellInput] = makeFieldRow("Shell", false, "e.g. /bin/zsh");
+            this.#shellInput = shellInput;
+            fieldsSection.appendChild(shellRow);
+            const [titleRow, titleInput] = makeFieldRow("Title", false, "Terminal window title");
+            this.#titleInput = titleInput;
+            fieldsSection.appendChild(titleRow);
+            const [wdRow, wdInput] = makeFieldRow("Working Directory", false, "e.g. ~/projects");
+            this.#wdInput = wdInput;
+            fieldsSection.appendChild(wdRow);
+            const [rootRow, rootInput] = makeFieldRow("Root", false, "e.g. /");
+            this.#rootInput = rootInput;
+            fieldsSection.appendChild(rootRow);
+            const commandsSection = document.createElement("div");
+            commandsSection.className = "commands-section";
+            const commandsLabel = document.createElement("div");
+            commandsLabel.className = "commands-label";
+            commandsLabel.textContent = "Commands (one per line, run on startup):";
+            commandsSection.appendChild(commandsLabel);
+            const commandsWrapper = document.createElement("div");
+            commandsWrapper.className = "commands-wrapper";
+            this.#lineNumbers = document.createElement("div");
+            this.#lineNumbers.className = "line-numbers";
+            this.#lineNumbers.textContent = "1";
+            this.#commandsArea = document.createElement("textarea");
+            this.#commandsArea.className = "commands-area";
+            this.#commandsArea.placeholder = "npm start";
+            this.#commandsArea.spellcheck = false;
+            this.#commandsArea.setAttribute("wrap", "off");
+            commandsWrapper.appendChild(this.#lineNumbers);
+            commandsWrapper.appendChild(this.#commandsArea);
+            commandsSection.appendChild(commandsWrapper);
+            const actions = document.createElement("div");
+            actions.className = "actions";
+            this.#deleteBtn = document.createElement("button");
+            this.#deleteBtn.className = "delete-btn";
+            this.#deleteBtn.textContent = "Delete Profile";
+            this.#deleteBtn.style.display = "none";
+            const cancelBtn = document.createElement("button");
+            cancelBtn.className = "cancel-btn";
+            cancelBtn.textContent = "Cancel";
+            this.#okBtn = document.createElement("button");
+            this.#okBtn.className = "ok-btn";
+            this.#okBtn.textContent = "OK";
+            this.#okBtn.disabled = true;
+            actions.appendChild(this.#deleteBtn);
+            actions.appendChild(cancelBtn);
+            actions.appendChild(this.#okBtn);
+            rightPanel.appendChild(fieldsSection);
+            rightPanel.appendChild(commandsSection);
+            rightPanel.appendChild(actions);
+            modal.appendChild(this.#leftPanel);
+            modal.appendChild(rightPanel);
+            overlay.appendChild(modal);
+            this.#shadow.appendChild(style);
+            this.#shadow.appendChild(overlay);
+            this.#nameInput.addEventListener("input", ()=>this.#validateForm());
+            this.#commandsArea.addEventListener("input", ()=>this.#syncLineNumbers());
+            this.#commandsArea.addEventListener("scroll", ()=>{
+                this.#lineNumbers.scrollTop = this.#commandsArea.scrollTop;
+            });
+            this.#createCard.addEventListener("click", ()=>{
+                this.#selectCreateCard();
+            });
+            this.#leftPanel.addEventListener("click", (e)=>{
+                const card = e.target.closest(".profile-card");
+                if (!card) return;
+                const name = card.dataset["profileName"];
+                if (!name) return;
+                this.#selectProfileCard(card, name);
+            });
+            cancelBtn.addEventListener("click", ()=>this.close());
+            this.#deleteBtn.addEventListener("click", ()=>void this.#handleDelete());
+            this.#okBtn.addEventListener("click", ()=>void this.#handleOk());
+        }
+        open(profileNames) {
+            this.#selectedName = null;
+            this.#selectedCard = null;
+            this.#isLoading = false;
+            this.#clearForm();
+            this.#okBtn.disabled = true;
+            this.#deleteBtn.style.display = "none";
+            this.#nameInput.readOnly = false;
+            for (const card of Array.from(this.#leftPanel.querySelectorAll(".profile-card"))){
+                card.remove();
+            }
+            this.#selectCreateCard();
+            const filtered = profileNames.filter((n)=>n !== "default");
+            for (const name of filtered){
+                this.#leftPanel.appendChild(this.#makeProfileCard(name));
+            }
+            this.setAttribute("open", "");
+        }
+        close() {
+            this.removeAttribute("open");
+            this.#selectedName = null;
+            this.#nameError.classList.remove("visible");
+        }
+        #makeProfileCard(name) {
+            const card = document.createElement("div");
+            card.className = "profile-card";
+            card.dataset["profileName"] = name;
+            const radio = document.createElement("input");
+            radio.type = "radio";
+            radio.name = "profile";
+            const lbl = document.createElement("span");
+            lbl.textContent = name;
+            card.appendChild(radio);
+            card.appendChild(lbl);
+            return card;
+        }
+        #selectCreateCard() {
+            for (const c of Array.from(this.#leftPanel.querySelectorAll(".profile-card"))){
+                c.removeAttribute("selected");
+                c.querySelector("input[type=radio]").checked = false;
+            }
+            this.#createCard.setAttribute("selected", "");
+            this.#createRadio.checked = true;
+            this.#selectedName = null;
+            this.#selectedCard = null;
+            this.#nameInput.readOnly = false;
+            this.#deleteBtn.style.display = "none";
+            this.#clearForm();
+        }
+        #selectProfileCard(card, name) {
+            this.#createCard.removeAttribute("selected");
+            this.#createRadio.checked = false;
+            for (const c of Array.from(this.#leftPanel.querySelectorAll(".profile-card"))){
+                c.removeAttribute("selected");
+                c.querySelector("input[type=radio]").checked = false;
+            }
+            card.setAttribute("selected", "");
+            card.querySelector("input[type=radio]").checked = true;
+            this.#selectedName = name;
+            this.#selectedCard = card;
+            this.#nameInput.value = name;
+            this.#nameInput.readOnly = true;
+            this.#deleteBtn.style.display = "";
+            this.#isLoading = true;
+            this.#okBtn.disabled = true;
+            void this.#loadProfileConfig(name);
+        }
+        async #loadProfileConfig(name) {
+            try {
+                const cfg = await getProfileConfig(name);
+                this.#shellInput.value = cfg.shell ?? "";
+                this.#titleInput.value = cfg.title ?? "";
+                this.#wdInput.value = cfg.workingDirectory ?? "";
+                this.#rootInput.value = cfg.root ?? "";
+                this.#commandsArea.value = (cfg.commands ?? []).join("\n");
+                this.#syncLineNumbers();
+            } catch  {} finally{
+                this.#isLoading = false;
+                this.#validateForm();
+            }
+        }
+        #clearForm() {
+            this.#nameInput.value = "";
+            this.#shellInput.value = "";
+            this.#titleInput.value = "";
+            this.#wdInput.value = "";
+            this.#rootInput.value = "";
+            this.#commandsArea.value = "";
+            this.#syncLineNumbers();
+            this.#nameError.classList.remove("visible");
+        }
+        #syncLineNumbers() {
+            const lineCount = this.#commandsArea.value === "" ? 1 : this.#commandsArea.value.split("\n").length;
+            this.#lineNumbers.textContent = Array.from({
+                length: lineCount
+            }, (_, i)=>i + 1).join("\n");
+            this.#lineNumbers.scrollTop = this.#commandsArea.scrollTop;
+        }
+        #validateForm() {
+            if (this.#isLoading) {
+                this.#okBtn.disabled = true;
+                return;
+            }
+            const name = this.#nameInput.value.trim();
+            if (name === "default") {
+                this.#nameError.classList.add("visible");
+                this.#okBtn.disabled = true;
+                return;
+            }
+            this.#nameError.classList.remove("visible");
+            this.#okBtn.disabled = name === "";
+        }
+        async #handleOk() {
+            const name = this.#nameInput.value.trim();
+            if (!name) return;
+            const rawCommands = this.#commandsArea.value.split("\n");
+            const commands = rawCommands.filter((line)=>line !== "");
+            const profile = {
+                shell: this.#shellInput.value.trim(),
+                title: this.#titleInput.value.trim(),
+                workingDirectory: this.#wdInput.value.trim(),
+                root: this.#rootInput.value.trim(),
+                commands
+            };
+            try {
+                const response = await postEditProfile(name, profile);
+                this.dispatchEvent(new CustomEvent("b3tty-profile-edited", {
+                    detail: {
+                        name,
+                        response
+                    },
+                    bubbles: true,
+                    composed: true
+                }));
+                this.close();
+            } catch  {}
+        }
+        async #handleDelete() {
+            const name = this.#selectedName;
+            if (!name) return;
+            try {
+                const response = await postDeleteProfile(name);
+                this.dispatchEvent(new CustomEvent("b3tty-profile-edited", {
+                    detail: {
+                        name: null,
+                        response
+                    },
+                    bubbles: true,
+                    composed: true
+                }));
+                this.close();
+            } catch  {}
+        }
+    }
+    customElements.define("b3tty-profile-editor", B3ttyProfileEditorImpl);
+}
+
+
+const THEME_KEYS = [
+    "foreground",
+    "background",
+    "cursor",
+    "cursorAccent",
+    "black",
+    "brightBlack",
+    "red",
+    "brightRed",
+    "green",
+    "brightGreen",
+    "yellow",
+    "brightYellow",
+    "blue",
+    "brightBlue",
+    "magenta",
+    "brightMagenta",
+    "cyan",
+    "brightCyan",
+    "white",
+    "brightWhite",
+    "selectionForeground",
+    "selectionBackground"
+];
+function getProtocols(tls) {
+    return {
+        wsProtocol: tls ? "wss" : "ws",
+        httpProto: tls ? "https" : "http"
+    };
+}
+function hexToRgba(hex, alpha) {
+    const full = hex.replace(/^#([0-9a-fA-F])([0-9a-fA-F])([0-9a-fA-F])$/, "#$1$1$2$2$3$3");
+    const m = full.match(/^#([0-9a-fA-F]{2})([0-9a-fA-F]{2})([0-9a-fA-F]{2})$/);
+    if (!m) return `rgba(0, 0, 0, ${alpha})`;
+    return `rgba(${parseInt(m[1], 16)}, ${parseInt(m[2], 16)}, ${parseInt(m[3], 16)}, ${alpha})`;
+}
+function withAlpha(color, alpha) {
+    if (color.startsWith("#")) return hexToRgba(color, alpha);
+    return `rgba(0, 0, 0, ${alpha})`;
+}
+function setLight(color) {
+    return color || "white";
+}
+function setDark(color) {
+    return color || "black";
+}
+function buildTheme(themeConfig) {
+    const theme = {};
+    for (const k of THEME_KEYS){
+        const val = themeConfig[k];
+        if (val) theme[k] = val;
+    }
+    return theme;
+}
+function buildTermOptions(config, theme, allowTransparency = false) {
+    const options = {
+        cursorBlink: config.cursorBlink,
+        fontFamily: `${config.fontFamily}, Menlo, DejaVu Sans Mono, Ubuntu Mono, Inconsolata, Fira, monospace`,
+        fontSize: config.fontSize
+    };
+    if (allowTransparency) options.allowTransparency = true;
+    if (config.rows) options.rows = config.rows;
+    if (config.columns) options.cols = config.columns;
+    if (Object.keys(theme).length > 0) options.theme = theme;
+    return options;
+}
+function buildSizeUrl(httpProto, uri, port, cols, rows) {
+    if (!isValidHttpProtocol(httpProto)) throw new Error(`Invalid HTTP protocol: "${httpProto}"`);
+    if (!isValidUri(uri)) throw new Error(`Invalid URI: "${uri}"`);
+    if (!isValidPort(port)) throw new Error(`Invalid port: ${port}`);
+    const url = new URL(`${httpProto}://${uri}:${port}/size`);
+    url.searchParams.set("cols", String(cols));
+    url.searchParams.set("rows", String(rows));
+    return url.toString();
+}
+const PROTOCOL_V1 = "b3tty.v1";
+const CLOSE_GOING_AWAY = 1001;
+const CLOSE_POLICY_VIOLATION = 1008;
+const CLOSE_TRY_AGAIN_LATER = 1013;
+const CLOSE_ABNORMAL = 1006;
+const RECONNECT_DELAY_MS = 1000;
+const Opcode = {
+    Input: 0x30,
+    Output: 0x31,
+    Resize: 0x32,
+    Ping: 0x33,
+    Pong: 0x34,
+    Title: 0x35,
+    Exit: 0x36,
+    Error: 0x37,
+    Session: 0x38,
+    Ack: 0x39
+};
+const frameEncoder = new TextEncoder();
+function encodeFrame(op, payload) {
+    const body = frameEncoder.encode(payload);
+    const frame = new Uint8Array(body.length + 1);
+    frame[0] = op;
+    frame.set(body, 1);
+    return frame;
+}
+function isTypedSocket(socket) {
+    return socket.protocol === PROTOCOL_V1;
+}
+function buildWsUrl(wsProtocol, uri, port, sessionId) {
+    if (!isValidWsProtocol(wsProtocol)) throw new Error(`Invalid WebSocket protocol: "${wsProtocol}"`);
+    if (!isValidUri(uri)) throw new Error(`Invalid URI: "${uri}"`);
+    if (!isValidPort(port)) throw new Error(`Invalid port: ${port}`);
+    const url = new URL(`${wsProtocol}://${uri}:${port}/ws`);
+    if (sessionId) url.searchParams.set("session", sessionId);
+    return url;
+}
+function socketRelay(initial) {
+    return {
+        current: initial,
+        get readyState () {
+            return this.current.readyState;
+        },
+        get protocol () {
+            return this.current.protocol;
+        },
+        send (data) {
+            this.current.send(data);
+        }
+    };
+}
+function startKeepalive(socket, intervalMs, onMissed, timers = {
+    setInterval: (fn, ms)=>setInterval(fn, ms),
+    clearInterval: (id)=>clearInterval(id)
+}) {
+    let awaiting = false;
+    const id = timers.setInterval(()=>{
+        if (awaiting) {
+            timers.clearInterval(id);
+            onMissed();
+            return;
+        }
+        if (socket.readyState !== 1 || !isTypedSocket(socket)) return;
+        awaiting = true;
+        socket.send(encodeFrame(Opcode.Ping, ""));
+    }, intervalMs);
+    return {
+        onPong: ()=>{
+            awaiting = false;
+        },
+        stop: ()=>timers.clearInterval(id)
+    };
+}
+function handleSocketMessage(event, decoder, term, writeCallback) {
+    const data = event.data instanceof ArrayBuffer ? decoder.decode(event.data, {
+        stream: true
+    }) : event.data;
+    if (writeCallback !== undefined) {
+        term.write(data, writeCallback);
+    } else {
+        term.write(data);
+    }
+}
+function handleTypedMessage(event, decoder, term, handlers, writeCallback) {
+    if (!(event.data instanceof ArrayBuffer) || event.data.byteLength === 0) return;
+    const frame = new Uint8Array(event.data);
+    const op = frame[0];
+    const payload = frame.subarray(1);
+    const text = ()=>new TextDecoder("utf-8").decode(payload);
+    switch(op){
+        case Opcode.Output:
+            {
+                const data = decoder.decode(payload, {
+                    stream: true
+                });
+                const onConsumed = handlers.onConsumed;
+                if (onConsumed !== undefined) {
+                    const bytes = payload.length;
+                    term.write(data, ()=>{
+                        writeCallback?.();
+                        onConsumed(bytes);
+                    });
+                } else if (writeCallback !== undefined) {
+                    term.write(data, writeCallback);
+                } else {
+                    term.write(data);
+                }
+                break;
+            }
+        case Opcode.Title:
+            handlers.onTitle?.(text());
+            break;
+        case Opcode.Pong:
+            handlers.onPong?.(text());
+            break;
+        case Opcode.Exit:
+            handlers.onExit?.(JSON.parse(text()));
+            break;
+        case Opcode.Error:
+            handlers.onError?.(JSON.parse(text()).message);
+            break;
+        case Opcode.Session:
+            handlers.onSession?.(JSON.parse(text()).id);
+            break;
+        default:
+            console.log(`Ignoring message with unknown opcode ${op}`);
+    }
+}
+function formatExitStatus(status) {
+    const after = `after ${(status.duration_ms / 1000).toFixed(1)}s`;
+    if (status.signal) return `Process killed by ${status.signal} ${after}`;
+    return `Process exited with code ${status.code} ${after}`;
+}
+function handleSocketClose(term, alertFn, wasClean = false, code, reason = "") {
+    console.log("Socket closed");
+    term.writeln("[exited]");
+    if (code === CLOSE_GOING_AWAY) {
+        alertFn("The server shut down");
+    } else if (code === CLOSE_POLICY_VIOLATION) {
+        alertFn(reason ? `Session closed: ${reason}` : "Session closed by the server");
+    } else if (code === CLOSE_TRY_AGAIN_LATER) {
+        alertFn(reason ? `Session refused: ${reason}` : "Session refused by the server");
+    } else if (!wasClean) {
+        alertFn("Connection closed");
+    }
+}
+function buildDebugHooks(debug) {
+    if (!debug) return {};
+    let keypressTime = null;
+    return {
+        onBeforeSend: ()=>{
+            keypressTime = performance.now();
+        },
+        writeCallback: ()=>{
+            if (keypressTime !== null) {
+                const elapsed = (performance.now() - keypressTime).toFixed(2);
+                console.log(`[b3tty] keypress round-trip: ${elapsed}ms`);
+                keypressTime = null;
+            }
+        }
+    };
+}
+function sendResizeMessage(socket, cols, rows) {
+    if (socket.readyState === 1) {
+        if (isTypedSocket(socket)) {
+            socket.send(encodeFrame(Opcode.Resize, JSON.stringify({
+                cols,
+                rows
+            })));
+        } else {
+            socket.send(JSON.stringify({
+                type: "resize",
+                cols,
+                rows
+            }));
+        }
+    }
+}
+function sendInput(socket, data) {
+    socket.send(isTypedSocket(socket) ? encodeFrame(Opcode.Input, data) : data);
+}
+function sendAck(socket, bytes) {
+    if (socket.readyState === 1) {
+        socket.send(encodeFrame(Opcode.Ack, JSON.stringify({
+            bytes
+        })));
+    }
+}
+function createAckSender(socket, schedule = (flush)=>setTimeout(flush, 0)) {
+    let pending = 0;
+    let scheduled = false;
+    return (bytes)=>{
+        pending += bytes;
+        if (scheduled) return;
+        scheduled = true;
+        schedule(()=>{
+            sendAck(socket, pending);
+            pending = 0;
+            scheduled = false;
+        });
+    };
+}
+function requireElement(id) {
+    const el = document.getElementById(id);
+    if (!el) throw new Error(`Required element #${id} not found`);
+    return el;
+}
+function terminalFactory(config) {
+    const theme = buildTheme(config.theme);
+    if (config.backgroundImage) {
+        theme.background = withAlpha("#000", 0);
+    }
+    const termOptions = buildTermOptions(config, theme, true);
+    return new Terminal(termOptions);
+}
+function initTerm(term, socket, bellElement, onBeforeSend) {
+    if (term._initialized) return;
+    term._initialized = true;
+    term.onData((chunk)=>{
+        if (onBeforeSend !== undefined) onBeforeSend();
+        sendInput(socket, chunk);
+    });
+    term.onBell(()=>{
+        bellElement.style.display = "block";
+        setTimeout(()=>{
+            bellElement.style.display = "none";
+        }, 500);
+    });
+}
+function applyThemeStyles(theme, hasBackgroundImage) {
+    const containerEl = requireElement("container");
+    if (hasBackgroundImage) {
+        const bgColor = withAlpha(theme.background || "", 0.5);
+        document.body.style.background = `linear-gradient(${bgColor}, ${bgColor}), url('/background') center / cover fixed no-repeat`;
+        let bgStyle = document.getElementById("b3tty-bg-style");
+        if (!bgStyle) {
+            bgStyle = document.createElement("style");
+            bgStyle.id = "b3tty-bg-style";
+            document.head.appendChild(bgStyle);
+        }
+        bgStyle.textContent = `#terminal .xterm-viewport { background-color: transparent !important; }`;
+        containerEl.style.background = "";
+    } else {
+        document.body.style.background = "";
+        document.getElementById("b3tty-bg-style")?.remove();
+        containerEl.style.background = theme.background || "";
+    }
+    const profileEl = requireElement("profile");
+    if (profileEl.textContent?.trim()) {
+        profileEl.style.color = setLight(theme.foreground);
+        profileEl.style.background = hasBackgroundImage ? "" : setDark(theme.background);
+    }
+}
+function applyPageStyles(config) {
+    document.documentElement.style.setProperty("--b3tty-font-size", `${config.fontSize}px`);
+    document.documentElement.style.setProperty("--b3tty-font-family", `"${config.fontFamily}", monospace`);
+    applyThemeStyles(config.theme, !!config.backgroundImage);
+}
+async function handleThemeChange(e, term, menuBar, activeTheme) {
+    const { name } = e.detail;
+    if (name === activeTheme.current) return;
+    let newTheme;
+    try {
+        newTheme = await postThemeConfig(name);
+    } catch  {
+        return;
+    }
+    const builtTheme = buildTheme(newTheme);
+    if (newTheme.hasBackgroundImage) {
+        builtTheme.background = withAlpha(newTheme.background || "#000", 0);
+    }
+    term.options.theme = builtTheme;
+    applyThemeStyles(newTheme, newTheme.hasBackgroundImage);
+    menuBar.updateColors({
+        bg: setLight(newTheme.foreground),
+        fg: setDark(newTheme.background)
+    });
+    activeTheme.current = name;
+}
+function handleProfileChange(e) {
+    const { name } = e.detail;
+    const params = new URLSearchParams(window.location.search);
+    params.set("profile", name);
+    window.open(`/?${params.toString()}`, "_blank");
+}
+async function handleThemeSelected(e, term, menuBar, picker, config, activeTheme) {
+    const { name } = e.detail;
+    let newTheme;
+    try {
+        newTheme = await postAddTheme(name);
+    } catch  {
+        picker.close();
+        return;
+    }
+    picker.close();
+    const builtTheme = buildTheme(newTheme);
+    if (newTheme.hasBackgroundImage) {
+        builtTheme.background = withAlpha(newTheme.background || "#000", 0);
+    }
+    term.options.theme = builtTheme;
+    applyThemeStyles(newTheme, newTheme.hasBackgroundImage);
+    if (newTheme.themeNames) {
+        config.themeNames = newTheme.themeNames;
+        menuBar.setup(config.themeNames, config.profileNames ?? [], {
+            bg: setLight(newTheme.foreground),
+            fg: setDark(newTheme.background)
+        });
+    } else {
+        menuBar.updateColors({
+            bg: setLight(newTheme.foreground),
+            fg: setDark(newTheme.background)
+        });
+    }
+    activeTheme.current = name;
+}
+async function handleProfileEdited(e, menuBar, config) {
+    const { response } = e.detail;
+    config.profileNames = response.profileNames;
+    menuBar.setup(config.themeNames ?? [], config.profileNames, {
+        bg: setLight(config.theme.foreground),
+        fg: setDark(config.theme.background)
+    });
+}
+async function handleThemeEdited(e, term, menuBar, config, activeTheme) {
+    const { name, response: newTheme } = e.detail;
+    const builtTheme = buildTheme(newTheme);
+    if (newTheme.hasBackgroundImage) {
+        builtTheme.background = withAlpha(newTheme.background || "#000", 0);
+    }
+    term.options.theme = builtTheme;
+    applyThemeStyles(newTheme, newTheme.hasBackgroundImage);
+    if (newTheme.themeNames) {
+        config.themeNames = newTheme.themeNames;
+        if (!config.allThemeNames?.includes(name)) {
+            config.allThemeNames = [
+                ...config.allThemeNames ?? [],
+                name
+            ].sort();
+        }
+        menuBar.setup(config.themeNames, config.profileNames ?? [], {
+            bg: setLight(newTheme.foreground),
+            fg: setDark(newTheme.background)
+        });
+    } else {
+        menuBar.updateColors({
+            bg: setLight(newTheme.foreground),
+            fg: setDark(newTheme.background)
+        });
+    }
+    activeTheme.current = name;
+}
+function disableCursor(term) {
+    term.options.cursorBlink = false;
+    term.options.cursorInactiveStyle = "none";
+    term.blur();
+    term.textarea?.addEventListener("focus", ()=>term.blur());
+}
+async function main(config) {
+    const { wsProtocol, httpProto } = getProtocols(config.tls);
+    applyPageStyles(config);
+    const term = terminalFactory(config);
+    term.open(requireElement("terminal"));
+    let fitAddon;
+    if (!config.columns) {
+        fitAddon = new FitAddon();
+        term.loadAddon(fitAddon);
+        fitAddon.fit();
+    }
+    term.loadAddon(new WebLinksAddon());
+    term.loadAddon(new ImageAddon());
+    const sizeUrl = buildSizeUrl(httpProto, config.uri, config.port, term.cols, term.rows);
+    try {
+        await postSize(sizeUrl);
+    } catch (err) {
+        console.warn(err instanceof Error ? err.message : String(err));
+    }
+    const listenerController = new AbortController();
+    const { signal } = listenerController;
+    const { onBeforeSend, writeCallback } = buildDebugHooks(!!config.debug);
+    const dialogEl = requireElement("dialog");
+    if (!isB3ttyDialog(dialogEl)) throw new Error("Element #dialog is not a B3ttyDialog");
+    const dialog = dialogEl;
+    let sessionId = "";
+    let lostAt = 0;
+    const gracePeriod = config.detachGracePeriod ?? 0;
+    const protocolHandlers = {
+        onTitle: (title)=>{
+            document.title = title;
+        },
+        onExit: (status)=>{
+            if (status.restart) return;
+            const message = formatExitStatus(status);
+            term.writeln(`\r\n[${message}]`);
+            dialog.show(message, {
+                label: "Restart",
+                onClick: ()=>window.location.reload()
+            });
+        },
+        onError: (message)=>term.writeln(`\r\n[error: ${message}]`),
+        onSession: (id)=>{
+            sessionId = id;
+        }
+    };
+    const endSession = (wasClean, code, reason = "")=>{
+        listenerController.abort();
+        disableCursor(term);
+        handleSocketClose(term, (msg)=>dialog.show(msg), wasClean, code, reason);
+    };
+    const reconnect = ()=>{
+        if (!sessionId || gracePeriod <= 0) return false;
+        const now = Date.now();
+        if (!lostAt) {
+            lostAt = now;
+            term.writeln("\r\n[connection lost; reconnecting]");
+        }
+        const remaining = lostAt + gracePeriod - now;
+        if (remaining <= 0) return false;
+        setTimeout(()=>{
+            relay.current = connect();
+        }, Math.min(RECONNECT_DELAY_MS, remaining));
+        return true;
+    };
+    const connect = ()=>{
+        const wsUrl = buildWsUrl(wsProtocol, config.uri, config.port, sessionId || undefined);
+        const socket = new WebSocket(wsUrl, [
+            PROTOCOL_V1
+        ]);
+        socket.binaryType = "arraybuffer";
+        const decoder = new TextDecoder("utf-8");
+        let keepalive;
+        const handlers = {
+            ...protocolHandlers,
+            onConsumed: createAckSender(socket),
+            onPong: ()=>keepalive?.onPong()
+        };
+        socket.onmessage = (event)=>{
+            if (socket.readyState !== 1) {
+                console.log("websocket not ready!");
+            }
+            if (isTypedSocket(socket)) {
+                handleTypedMessage(event, decoder, term, handlers, writeCallback);
+            } else {
+                handleSocketMessage(event, decoder, term, writeCallback);
+            }
+        };
+        socket.onclose = (event)=>{
+            keepalive?.stop();
+            if (event.code === CLOSE_ABNORMAL && reconnect()) return;
+            endSession(event.wasClean, event.code, event.reason);
+        };
+        socket.onerror = (event)=>console.log("A socket error occurred: ", event);
+        socket.onopen = ()=>{
+            console.log("Socket opened");
+            if (!isTypedSocket(socket)) return;
+            sendAck(socket, 0);
+            if (lostAt) {
+                lostAt = 0;
+                sendResizeMessage(socket, term.cols, term.rows);
+            }
+            if (gracePeriod > 0 && config.pingInterval) {
+                keepalive = startKeepalive(socket, config.pingInterval, ()=>{
+                    socket.onclose = null;
+                    socket.onmessage = null;
+                    socket.close();
+                    if (!reconnect()) endSession(false, CLOSE_ABNORMAL);
+                });
+            }
+        };
+        return socket;
+    };
+    const relay = socketRelay(connect());
+    const bellElement = requireElement("bell");
+    initTerm(term, relay, bellElement, onBeforeSend);
+    const menuBarEl = document.getElementById("menubar");
+    if (menuBarEl) {
+        if (!isB3ttyMenuBar(menuBarEl)) throw new Error("Element #menubar is not a B3ttyMenuBar");
+        const menuBar = menuBarEl;
+        menuBar.setup(config.themeNames ?? [], config.profileNames ?? [], {
+            bg: setLight(config.theme.foreground),
+            fg: setDark(config.theme.background)
+        });
+        menuBarEl.addEventListener("b3tty-menubar-open", ()=>requestAnimationFrame(()=>fitAddon?.fit()), {
+            signal
+        });
+        menuBarEl.addEventListener("b3tty-menubar-close", ()=>requestAnimationFrame(()=>fitAddon?.fit()), {
+            signal
+        });
+        const activeTheme = {
+            current: config.activeTheme ?? ""
+        };
+        menuBarEl.addEventListener("b3tty-theme-change", (e)=>handleThemeChange(e, term, menuBar, activeTheme), {
+            signal
+        });
+        menuBarEl.addEventListener("b3tty-profile-change", handleProfileChange, {
+            signal
+        });
+        const pickerEl = document.getElementById("theme-picker");
+        let picker = null;
+        if (pickerEl && isB3ttyThemePicker(pickerEl)) {
+            picker = pickerEl;
+        }
+        menuBarEl.addEventListener("b3tty-open-theme-selector", ()=>{
+            picker?.open(config.allThemeNames ?? []);
+        }, {
+            signal
+        });
+        if (picker) {
+            picker.addEventListener("b3tty-theme-selected", (e)=>handleThemeSelected(e, term, menuBar, picker, config, activeTheme), {
+                signal
+            });
+        }
+        const editorEl = document.getElementById("theme-editor");
+        let editor = null;
+        if (editorEl && isB3ttyThemeEditor(editorEl)) {
+            editor = editorEl;
+        }
+        menuBarEl.addEventListener("b3tty-open-theme-editor", ()=>{
+            editor?.open(config.allThemeNames ?? [], config.builtinThemeNames ?? []);
+        }, {
+            signal
+        });
+        if (editor) {
+            editor.addEventListener("b3tty-theme-edited", (e)=>handleThemeEdited(e, term, menuBar, config, activeTheme), {
+                signal
+            });
+        }
+        const profileEditorEl = document.getElementById("profile-editor");
+        let profileEditor = null;
+        if (profileEditorEl && isB3ttyProfileEditor(profileEditorEl)) {
+            profileEditor = profileEditorEl;
+        }
+        menuBarEl.addEventListener("b3tty-open-profile-editor", ()=>{
+            const editableNames = (config.profileNames ?? []).filter((n)=>n !== "default");
+            profileEditor?.open(editableNames);
+        }, {
+            signal
+        });
+        if (profileEditor) {
+            profileEditor.addEventListener("b3tty-profile-edited", (e)=>handleProfileEdited(e, menuBar, config), {
+                signal
+            });
+        }
+    }
+    if (!config.columns) {
+        term.onResize(({ cols, rows })=>{
+            sendResizeMessage(relay, cols, rows);
+        });
+        let resizeTimer;
+        window.addEventListener("resize", ()=>{
+            clearTimeout(resizeTimer);
+            resizeTimer = setTimeout(()=>fitAddon.fit(), 100);
+        }, {
+            signal
+        });
+    }
+}
+if (typeof window !== "undefined" && window.B3TTY) {
+    main(window.B3TTY);
+}
+export { THEME_KEYS, getProtocols, hexToRgba, withAlpha, setLight, setDark, buildTheme, buildTermOptions, buildSizeUrl, PROTOCOL_V1, CLOSE_GOING_AWAY, CLOSE_POLICY_VIOLATION, CLOSE_TRY_AGAIN_LATER, CLOSE_ABNORMAL, RECONNECT_DELAY_MS, Opcode, encodeFrame, isTypedSocket, buildWsUrl, socketRelay, startKeepalive, handleSocketMessage, handleTypedMessage, formatExitStatus, handleSocketClose, buildDebugHooks, sendResizeMessage, sendInput, sendAck, createAckSender, requireElement, terminalFactory, initTerm, applyThemeStyles, applyPageStyles, handleThemeChange, handleProfileChange, handleThemeSelected, handleProfileEdited, handleThemeEdited, disableCursor, main };
@@ -0,0 +1,204 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Events recorded in the audit log.
+const (
+	auditSessionStart    = "session-start"
+	auditSessionReattach = "session-reattach"
+	auditSessionEnd      = "session-end"
+	auditSessionRejected = "session-rejected"
+	auditAuthFailure     = "auth-failure"
+	auditThemeEdit       = "theme-edit"
+	auditProfileEdit     = "profile-edit"
+	auditProfileDelete   = "profile-delete"
+)
+
+// auditEvent is one record of the audit log.
+type auditEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	// Remote is the client's address and Identity the subject of the TLS
+	// client certificate it presented, if any.
+	Remote   string `json:"remote,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	// Path is the request path of an auth failure.
+	Path    string `json:"path,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	Session string `json:"session,omitempty"`
+	Theme   string `json:"theme,omitempty"`
+	// Detail says why a session ended or was refused, or why a request
+	// failed authentication.
+	Detail string `json:"detail,omitempty"`
+	// Error is set when a config change was made in memory but could not
+	// be saved to the config file.
+	Error string `json:"error,omitempty"`
+	// Prev is the SHA-256 hash, in hex, of the line before this one, or
+	// empty for the first line; see VerifyAuditLog.
+	Prev string `json:"prev"`
+}
+
+// AuditLog records security-relevant events, such as sessions starting and
+// ending, config changes and auth failures, to an append-only file with one
+// JSON object per line. Each line carries the hash of the line before it, so
+// editing or removing a line, other than the last, breaks the chain; see
+// VerifyAuditLog. A nil *AuditLog records nothing.
+type AuditLog struct {
+	mu sync.Mutex
+	f  *os.File
+	// prev is the hash of the last line written.
+	prev string
+}
+
+// OpenAuditLog opens or creates the audit log at path, continuing the hash
+// chain of the lines already in it. The file is created readable only by its
+// owner.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("audit log: %w", err)
+	}
+	last, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit log %s: %w", path, err)
+	}
+	a := &AuditLog{f: f}
+	if len(last) > 0 {
+		a.prev = lineHash(last)
+	}
+	return a, nil
+}
+
+// lastLine returns the last non-empty line of f, without its newline.
+func lastLine(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := max(fi.Size()-MAX_AUDIT_LINE_SIZE, 0)
+	buf := make([]byte, fi.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		return buf[i+1:], nil
+	}
+	if start > 0 {
+		return nil, fmt.Errorf("last line is longer than %d bytes", MAX_AUDIT_LINE_SIZE)
+	}
+	return buf, nil
+}
+
+// lineHash returns the hex SHA-256 hash of an audit log line.
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// record appends e to the log, stamped with the current time, and syncs the
+// file. Failures are logged, as the event has already happened.
+func (a *AuditLog) record(e auditEvent) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		Errorf("audit log: %s event not recorded: the log is closed", e.Event)
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Prev = a.prev
+	line, err := json.Marshal(e)
+	if err != nil {
+		Errorf("audit log: %v", err)
+		return
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		Errorf("audit log: %v", err)
+		return
+	}
+	if err := a.f.Sync(); err != nil {
+		Errorf("audit log: %v", err)
+	}
+	a.prev = lineHash(line)
+}
+
+// Close closes the file. Events recorded after it is closed are lost.
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
+
+// VerifyAuditLog checks the hash chain of the audit log read from r and
+// returns the number of records in it. It fails at the first line that is not
+// a record or does not carry the hash of the line before it.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MAX_AUDIT_LINE_SIZE)
+	n, lineNo, prev := 0, 0, ""
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e auditEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return n, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if e.Prev != prev {
+			return n, fmt.Errorf("line %d: hash chain broken; the line before it was changed or removed", lineNo)
+		}
+		prev = lineHash(line)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, fmt.Errorf("line %d: %w", lineNo+1, err)
+	}
+	return n, nil
+}
+
+// audit records e in ts.Audit with the client's address and TLS identity
+// taken from r.
+func (ts *TerminalServer) audit(r *http.Request, e auditEvent) {
+	if ts.Audit == nil {
+		return
+	}
+	e.Remote = r.RemoteAddr
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		e.Identity = r.TLS.PeerCertificates[0].Subject.String()
+	}
+	ts.Audit.record(e)
+}
+
+// errorString returns err's message, or "" when err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,165 @@
+package src
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestAuditLog opens an audit log in a temporary directory, closing it
+// when t ends, and returns it with its path.
+func openTestAuditLog(t *testing.T) (*AuditLog, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := OpenAuditLog(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { a.Close() })
+	return a, path
+}
+
+// readAuditLog returns the records in the audit log at path.
+func readAuditLog(t *testing.T, path string) []auditEvent {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var events []auditEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var e auditEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &e), line)
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("records are chained across reopening", func(t *testing.T) {
+		a, path := openTestAuditLog(t)
+		a.record(auditEvent{Event: auditSessionStart, Session: "a"})
+		a.record(auditEvent{Event: auditSessionEnd, Session: "a"})
+		require.NoError(t, a.Close())
+		logged := captureLog(func() { a.record(auditEvent{Event: auditSessionStart, Session: "lost"}) })
+		assert.Contains(t, logged, "session-start event not recorded: the log is closed")
+
+		a, err := OpenAuditLog(path)
+		require.NoError(t, err)
+		a.record(auditEvent{Event: auditProfileDelete, Profile: "dev"})
+		require.NoError(t, a.Close())
+
+		events := readAuditLog(t, path)
+		require.Len(t, events, 3)
+		assert.Empty(t, events[0].Prev)
+		assert.Len(t, events[1].Prev, 64)
+		assert.Equal(t, "dev", events[2].Profile)
+		assert.WithinDuration(t, time.Now(), events[2].Time, time.Minute)
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		n, err := VerifyAuditLog(f)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("tampering breaks the chain", func(t *testing.T) {
+		a, path := openTestAuditLog(t)
+		for _, session := range []string{"a", "b", "c"} {
+			a.record(auditEvent{Event: auditSessionStart, Session: session})
+		}
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		lines := strings.SplitAfter(string(data), "\n")
+
+		edited := strings.Replace(string(data), `"session":"b"`, `"session":"x"`, 1)
+		n, err := VerifyAuditLog(strings.NewReader(edited))
+		assert.EqualError(t, err, "line 3: hash chain broken; the line before it was changed or removed")
+		assert.Equal(t, 2, n)
+
+		_, err = VerifyAuditLog(strings.NewReader(lines[0] + lines[2]))
+		assert.EqualError(t, err, "line 2: hash chain broken; the line before it was changed or removed")
+
+		_, err = VerifyAuditLog(strings.NewReader(lines[0] + "not json\n"))
+		assert.ErrorContains(t, err, "line 2: ")
+	})
+
+	t.Run("a nil log records nothing", func(t *testing.T) {
+		var a *AuditLog
+		a.record(auditEvent{Event: auditSessionStart})
+		assert.NoError(t, a.Close())
+	})
+}
+
+func TestServerAudit(t *testing.T) {
+	t.Run("auth failures", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		a, path := openTestAuditLog(t)
+		ts.Audit = a
+		req := httptest.NewRequest(http.MethodGet, "/sessions?token=guess", nil)
+		req.RemoteAddr = "192.0.2.7:40000"
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alice"}}}}
+		captureLog(func() { ts.sessionsHandler(httptest.NewRecorder(), req) })
+
+		events := readAuditLog(t, path)
+		require.Len(t, events, 1)
+		assert.Equal(t, auditAuthFailure, events[0].Event)
+		assert.Equal(t, "192.0.2.7:40000", events[0].Remote)
+		assert.Equal(t, "CN=alice", events[0].Identity)
+		assert.Equal(t, "/sessions", events[0].Path)
+		assert.Equal(t, "invalid token", events[0].Detail)
+	})
+
+	t.Run("profile edits and deletions", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.ConfigFile = writeTempConfig(t, "")
+		a, path := openTestAuditLog(t)
+		ts.Audit = a
+		body := bytes.NewBufferString(`{"name":"dev","profile":{"shell":"/bin/zsh"}}`)
+		ts.editProfileHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/edit-profile", body))
+		body = bytes.NewBufferString(`{"name":"dev"}`)
+		ts.deleteProfileHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/delete-profile", body))
+
+		events := readAuditLog(t, path)
+		require.Len(t, events, 2)
+		assert.Equal(t, auditProfileEdit, events[0].Event)
+		assert.Equal(t, "dev", events[0].Profile)
+		assert.Empty(t, events[0].Error)
+		assert.Equal(t, auditProfileDelete, events[1].Event)
+	})
+
+	t.Run("sessions starting and ending", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		a, path := openTestAuditLog(t)
+		ts.Audit = a
+		backend, conn := newFakeTerminal(t, ts, PROTOCOL_V1)
+		id := sessionID(t, conn)
+		backend.process(t, 0).exit()
+		readCloseError(t, conn)
+		require.Eventually(t, func() bool { return len(readAuditLog(t, path)) == 2 }, 2*time.Second, 5*time.Millisecond)
+
+		events := readAuditLog(t, path)
+		assert.Equal(t, auditSessionStart, events[0].Event)
+		assert.Equal(t, id, events[0].Session)
+		assert.Equal(t, "default", events[0].Profile)
+		assert.True(t, strings.HasPrefix(events[0].Remote, "127.0.0.1:"), events[0].Remote)
+		assert.Equal(t, auditSessionEnd, events[1].Event)
+		assert.Equal(t, "open for 0s", events[1].Detail)
+	})
+}
@@ -0,0 +1,295 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// Backend starts the process that a terminal session talks to. The backend
+// used by a session is chosen by the Type field of the session's profile.
+type Backend interface {
+	// Start launches a process for profile with an initial terminal size of
+	// cols x rows and returns a handle to it.
+	Start(profile Profile, cols, rows uint16) (Process, error)
+}
+
+// Process is a running terminal process started by a Backend. Read returns
+// the process's terminal output and Write delivers keyboard input to it.
+// Read must return io.EOF, or another error, once the process has exited or
+// Close has been called, and Close must unblock any pending Read.
+type Process interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	// Resize changes the terminal size seen by the process.
+	Resize(cols, rows uint16) error
+	// Wait blocks until the process exits and releases its resources.
+	Wait() error
+	// Signal delivers sig to the process.
+	Signal(sig os.Signal) error
+	// Close releases the terminal. It may be called more than once.
+	Close() error
+}
+
+// processGroup is implemented by a Process that leads a group of processes,
+// such as a shell and the jobs it starts, which can be signalled together.
+type processGroup interface {
+	// signalGroup delivers sig to every process in the group that has not
+	// exited. It returns syscall.ESRCH once none is left.
+	signalGroup(sig syscall.Signal) error
+	// reapGroup reaps the processes of the group, other than the leader,
+	// that have exited and been reparented to b3tty.
+	reapGroup()
+}
+
+// backend returns the Backend registered for a profile type. ts.Backends is
+// consulted first so tests and embedding programs can add or replace
+// backends; an empty type and DEFAULT_BACKEND fall back to LocalPTYBackend.
+func (ts *TerminalServer) backend(profileType string) (Backend, error) {
+	if profileType == "" {
+		profileType = DEFAULT_BACKEND
+	}
+	if b, ok := ts.Backends[profileType]; ok {
+		return b, nil
+	}
+	if profileType == DEFAULT_BACKEND {
+		return LocalPTYBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown profile type %q", profileType)
+}
+
+// ValidateProfiles reports an error naming the first profile that fails
+// validateProfile.
+func (ts *TerminalServer) ValidateProfiles() error {
+	for name, p := range ts.profilesSnapshot() {
+		if err := ts.validateProfile(p); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validateProfile reports an error when p's Type has no registered Backend,
+// or when its command, startup command waits, on-exit policy, environment,
+// sandbox, resource limits, user and group or session limit are invalid.
+func (ts *TerminalServer) validateProfile(p Profile) error {
+	if _, err := ts.backend(p.Type); err != nil {
+		return err
+	}
+	if err := p.validateCommand(); err != nil {
+		return err
+	}
+	if err := p.validateCommandWaits(); err != nil {
+		return err
+	}
+	if err := p.validateExitPolicy(); err != nil {
+		return err
+	}
+	if err := p.validateEnv(); err != nil {
+		return err
+	}
+	if err := p.validateSandbox(); err != nil {
+		return err
+	}
+	if err := p.validateLimits(); err != nil {
+		return err
+	}
+	if err := p.validateCredential(); err != nil {
+		return err
+	}
+	if p.MaxSessions < 0 {
+		return fmt.Errorf("max sessions must not be negative")
+	}
+	return nil
+}
+
+// LocalPTYBackend runs the profile's shell on this machine under a pty.
+type LocalPTYBackend struct{}
+
+// Start implements Backend. The process is started as described by
+// Profile.argv: the shell is started via /bin/sh -c so that shell flags and
+// paths are handled uniformly regardless of the configured shell binary,
+// unless the profile runs a command or a login shell directly. It leads a new
+// Unix session with the pty as its controlling terminal, so it and every job
+// it starts can be signalled together. A profile with a sandbox or resource
+// limits has its process started by the init process, which sets them up;
+// see Profile.initCommand. Memory and process limits also put the process in
+// a cgroup of its own when b3tty can manage cgroups. A profile that combines
+// a user or group with a sandbox is refused, since the sandbox's user
+// namespace would run the shell as b3tty's own user instead.
+func (LocalPTYBackend) Start(profile Profile, cols, rows uint16) (Process, error) {
+	if profile.sandboxed() && (profile.User != "" || profile.Group != "") {
+		return nil, fmt.Errorf("user and group cannot be used with a sandbox")
+	}
+	argv, err := profile.argv()
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(argv[0], argv[1:]...)
+	if profile.sandboxed() {
+		// The program is looked up inside the sandbox instead.
+		c = &exec.Cmd{Path: argv[0], Args: argv}
+	}
+	c, err = profile.ApplyToCommand(c)
+	if err != nil {
+		return nil, fmt.Errorf("apply profile to command: %w", err)
+	}
+	if profile.needsInit() {
+		if err := profile.initCommand(c); err != nil {
+			return nil, fmt.Errorf("init: %w", err)
+		}
+	}
+	cgroup, started, err := cgroupCommand(c, profile.Limits)
+	if err != nil {
+		return nil, err
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setsid = true
+	c.SysProcAttr.Setctty = true
+	ptmx, err := startPTY(c, &pty.Winsize{Cols: cols, Rows: rows})
+	started()
+	if err != nil {
+		if cgroup != "" {
+			os.Remove(cgroup)
+		}
+		return nil, fmt.Errorf("start pty: %w", err)
+	}
+	return &localProcess{cmd: c, ptmx: ptmx, cgroup: cgroup}, nil
+}
+
+// startPTY starts c with a new pty of the given size as its standard streams,
+// like pty.StartWithSize, and returns the pty's master. When c runs as
+// another user, the pty is first given to that user and TTY_GROUP with mode
+// 0620, as login and sshd do, so that programs which reopen their terminal by
+// name, such as tmux, screen and pinentry, can. That needs CAP_CHOWN; without
+// it the pty stays b3tty's and a warning is logged.
+func startPTY(c *exec.Cmd, size *pty.Winsize) (*os.File, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	if cred := c.SysProcAttr.Credential; cred != nil && cred.Uid != uint32(os.Getuid()) {
+		gid := int(cred.Gid)
+		if g, err := user.LookupGroup(TTY_GROUP); err == nil {
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+		err := tty.Chown(int(cred.Uid), gid)
+		if err == nil {
+			err = tty.Chmod(0620)
+		}
+		if err != nil {
+			Warnf("cannot give %s to uid %d: %v", tty.Name(), cred.Uid, err)
+		}
+	}
+	if err := pty.Setsize(ptmx, size); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	if c.Stdin == nil {
+		c.Stdin = tty
+	}
+	if c.Stdout == nil {
+		c.Stdout = tty
+	}
+	if c.Stderr == nil {
+		c.Stderr = tty
+	}
+	if err := startChild(c); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
+
+// children holds the PIDs of the processes b3tty started itself and waits
+// for with exec.Cmd.Wait, which the reaper of adopted processes must leave
+// alone; see startReaper.
+var children = struct {
+	sync.Mutex
+	pids map[int]struct{}
+}{pids: make(map[int]struct{})}
+
+// startChild starts c and records it in children until waitChild. The lock
+// is held while c starts, so that the reaper cannot take c's process for an
+// adopted one should it exit at once.
+func startChild(c *exec.Cmd) error {
+	children.Lock()
+	defer children.Unlock()
+	if err := c.Start(); err != nil {
+		return err
+	}
+	children.pids[c.Process.Pid] = struct{}{}
+	return nil
+}
+
+// waitChild waits for c, started by startChild, and forgets it.
+func waitChild(c *exec.Cmd) error {
+	err := c.Wait()
+	children.Lock()
+	delete(children.pids, c.Process.Pid)
+	children.Unlock()
+	return err
+}
+
+// isChild reports whether pid was started by startChild and has not been
+// waited for. The caller must hold children's lock.
+func isChild(pid int) bool {
+	_, ok := children.pids[pid]
+	return ok
+}
+
+// localProcess is the Process returned by LocalPTYBackend.
+type localProcess struct {
+	cmd  *exec.Cmd
+	ptmx *os.File
+	// cgroup is the session cgroup the process was started in, or "".
+	cgroup string
+}
+
+func (p *localProcess) Read(b []byte) (int, error)  { return p.ptmx.Read(b) }
+func (p *localProcess) Write(b []byte) (int, error) { return p.ptmx.Write(b) }
+func (p *localProcess) Close() error                { return p.ptmx.Close() }
+
+func (p *localProcess) Wait() error {
+	err := waitChild(p.cmd)
+	if p.cgroup != "" {
+		go removeCgroup(p.cgroup)
+	}
+	return err
+}
+
+func (p *localProcess) Resize(cols, rows uint16) error {
+	return pty.Setsize(p.ptmx, &pty.Winsize{Cols: cols, Rows: rows})
+}
+
+func (p *localProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+// signalGroup implements processGroup for the Unix session led by the shell.
+func (p *localProcess) signalGroup(sig syscall.Signal) error {
+	return signalSession(p.cmd.Process.Pid, sig)
+}
+
+// reapGroup implements processGroup for the Unix session led by the shell.
+func (p *localProcess) reapGroup() {
+	reapSession(p.cmd.Process.Pid)
+}
+
+// usage implements usageReporter. The figures come from the process's cgroup
+// when it has one, and otherwise from the processes in its Unix session.
+func (p *localProcess) usage() (ResourceUsage, error) {
+	if p.cgroup != "" {
+		return cgroupUsage(p.cgroup)
+	}
+	return sessionUsage(p.cmd.Process.Pid)
+}
@@ -0,0 +1,217 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupDelegation holds the cgroup v2 directory that session cgroups are
+// created in, found on first use. dir is empty when b3tty cannot manage
+// cgroups.
+var cgroupDelegation struct {
+	once sync.Once
+	dir  string
+}
+
+// sessionCgroupParent returns the directory that session cgroups are created
+// in, or "" when cgroup limits are unavailable. The first call sets up the
+// delegation and logs why cgroups are unavailable, if they are.
+func sessionCgroupParent() string {
+	cgroupDelegation.once.Do(func() {
+		dir, err := findCgroupDelegation()
+		if err != nil {
+			Infof("cgroup limits unavailable, using setrlimit only: %v", err)
+			return
+		}
+		cgroupDelegation.dir = dir
+	})
+	return cgroupDelegation.dir
+}
+
+// findCgroupDelegation checks that CGROUP_ROOT is a cgroup v2 hierarchy and
+// sets up the cgroup b3tty runs in for session cgroups.
+func findCgroupDelegation() (string, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(CGROUP_ROOT, &fs); err != nil {
+		return "", err
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return "", fmt.Errorf("%s is not a cgroup v2 hierarchy", CGROUP_ROOT)
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	path, ok := parseCgroupPath(data)
+	if !ok {
+		return "", fmt.Errorf("b3tty is not in a cgroup v2 cgroup")
+	}
+	return setupCgroupDelegation(filepath.Join(CGROUP_ROOT, path), os.Getpid())
+}
+
+// parseCgroupPath returns the cgroup v2 path in the contents of
+// /proc/<pid>/cgroup, which is on the line starting with "0::".
+func parseCgroupPath(data []byte) (string, bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// setupCgroupDelegation prepares dir, the cgroup that the b3tty process pid
+// runs in, to hold session cgroups. A cgroup with controllers enabled for its
+// children may not itself hold processes, so pid is first moved into a child
+// cgroup named CGROUP_SERVER_NAME. The memory and pids controllers are then
+// enabled for the children of dir, which is returned. Each step needs dir to
+// be delegated to the user b3tty runs as.
+func setupCgroupDelegation(dir string, pid int) (string, error) {
+	if filepath.Base(dir) == CGROUP_SERVER_NAME {
+		// A previous setup already moved this process.
+		dir = filepath.Dir(dir)
+	}
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	var enable []string
+	for _, controller := range strings.Fields(string(available)) {
+		if controller == "memory" || controller == "pids" {
+			enable = append(enable, "+"+controller)
+		}
+	}
+	if len(enable) == 0 {
+		return "", fmt.Errorf("neither the memory nor the pids controller is available in %s", dir)
+	}
+	server := filepath.Join(dir, CGROUP_SERVER_NAME)
+	if err := os.Mkdir(server, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(server, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// newSessionCgroup creates a cgroup for one session under parent with the
+// memory and process limits of l. Limits whose controller is not enabled are
+// left to setrlimit.
+func newSessionCgroup(parent string, l Limits) (string, error) {
+	id, err := generateToken(8)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(parent, "session-"+id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	settings := map[string]int64{"memory.max": l.Memory, "pids.max": int64(l.Processes)}
+	for file, value := range settings {
+		path := filepath.Join(dir, file)
+		if value <= 0 {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(strconv.FormatInt(value, 10)), 0); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// cgroupCommand places the process that c starts in a new session cgroup
+// with the limits of l, when l needs one and cgroups are available. It
+// returns the cgroup, or "" for none, and a function to call once the
+// process has started or failed to.
+func cgroupCommand(c *exec.Cmd, l Limits) (string, func(), error) {
+	parent := ""
+	if l.needsCgroup() {
+		parent = sessionCgroupParent()
+	}
+	if parent == "" {
+		return "", func() {}, nil
+	}
+	dir, err := newSessionCgroup(parent, l)
+	if err != nil {
+		return "", nil, fmt.Errorf("cgroup: %w", err)
+	}
+	fd, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return "", nil, fmt.Errorf("cgroup: %w", err)
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.UseCgroupFD = true
+	c.SysProcAttr.CgroupFD = fd
+	return dir, func() { unix.Close(fd) }, nil
+}
+
+// removeCgroup removes the session cgroup dir once no process is left in it.
+// Jobs that outlive the shell keep it in place until they exit.
+func removeCgroup(dir string) {
+	for {
+		events, err := os.ReadFile(filepath.Join(dir, "cgroup.events"))
+		if err != nil {
+			return
+		}
+		if bytes.Contains(events, []byte("populated 0")) {
+			if err := os.Remove(dir); err != nil {
+				Warnf("remove cgroup %s: %v", dir, err)
+			}
+			return
+		}
+		time.Sleep(CGROUP_REMOVE_POLL_INTERVAL)
+	}
+}
+
+// cgroupUsage returns the resources used by the processes of the cgroup dir.
+func cgroupUsage(dir string) (ResourceUsage, error) {
+	u := ResourceUsage{Source: "cgroup"}
+	stat, err := os.ReadFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return u, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if value, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			usec, _ := strconv.ParseInt(value, 10, 64)
+			u.CPUSeconds = float64(usec) / 1e6
+		}
+	}
+	if memory, err := readCgroupInt(dir, "memory.current"); err == nil {
+		u.MemoryBytes = memory
+	}
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return u, err
+	}
+	u.Processes = len(strings.Fields(string(procs)))
+	return u, nil
+}
+
+// readCgroupInt reads a cgroup interface file holding a single number.
+func readCgroupInt(dir, file string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
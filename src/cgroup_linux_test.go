@@ -0,0 +1,98 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCgroupFiles creates files in dir as a cgroup filesystem would.
+func writeCgroupFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+}
+
+func TestParseCgroupPath(t *testing.T) {
+	path, ok := parseCgroupPath([]byte("12:cpu:/ignored\n0::/user.slice/b3tty.service\n"))
+	assert.True(t, ok)
+	assert.Equal(t, "/user.slice/b3tty.service", path)
+
+	_, ok = parseCgroupPath([]byte("12:cpu:/ignored\n"))
+	assert.False(t, ok, "a cgroup v1 only system has no 0:: line")
+}
+
+func TestSetupCgroupDelegation(t *testing.T) {
+	t.Run("moves b3tty into a child and enables controllers", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "b3tty.service")
+		writeCgroupFiles(t, dir, map[string]string{"cgroup.controllers": "cpu io memory pids\n", "cgroup.subtree_control": ""})
+		writeCgroupFiles(t, filepath.Join(dir, CGROUP_SERVER_NAME), map[string]string{"cgroup.procs": ""})
+
+		got, err := setupCgroupDelegation(dir, 4242)
+		require.NoError(t, err)
+		assert.Equal(t, dir, got)
+		procs, _ := os.ReadFile(filepath.Join(dir, CGROUP_SERVER_NAME, "cgroup.procs"))
+		assert.Equal(t, "4242", string(procs))
+		control, _ := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+		assert.Equal(t, "+memory +pids", string(control))
+
+		again, err := setupCgroupDelegation(filepath.Join(dir, CGROUP_SERVER_NAME), 4242)
+		require.NoError(t, err)
+		assert.Equal(t, dir, again, "a process that was already moved is not nested further")
+	})
+
+	t.Run("fails without the memory and pids controllers", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCgroupFiles(t, dir, map[string]string{"cgroup.controllers": "cpu io\n"})
+		_, err := setupCgroupDelegation(dir, 4242)
+		assert.ErrorContains(t, err, "neither the memory nor the pids controller is available")
+	})
+
+	t.Run("fails outside a cgroup filesystem", func(t *testing.T) {
+		_, err := setupCgroupDelegation(t.TempDir(), 4242)
+		assert.Error(t, err)
+	})
+}
+
+func TestNewSessionCgroup(t *testing.T) {
+	parent := t.TempDir()
+	dir, err := newSessionCgroup(parent, Limits{Memory: 1 << 30, Processes: 64})
+	require.NoError(t, err)
+	assert.Equal(t, parent, filepath.Dir(dir))
+	assert.True(t, strings.HasPrefix(filepath.Base(dir), "session-"))
+	// The interface files only exist on a real cgroup filesystem, so nothing
+	// is written here.
+	assert.NoFileExists(t, filepath.Join(dir, "memory.max"))
+}
+
+func TestCgroupUsage(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFiles(t, dir, map[string]string{
+		"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"memory.current": "1048576\n",
+		"cgroup.procs":   "100\n101\n102\n",
+	})
+	u, err := cgroupUsage(dir)
+	require.NoError(t, err)
+	assert.Equal(t, ResourceUsage{CPUSeconds: 2.5, MemoryBytes: 1 << 20, Processes: 3, Source: "cgroup"}, u)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "memory.current")))
+	u, err = cgroupUsage(dir)
+	require.NoError(t, err)
+	assert.Zero(t, u.MemoryBytes, "memory.current is missing without the memory controller")
+}
+
+func TestRemoveCgroup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "session-x")
+	writeCgroupFiles(t, dir, map[string]string{"cgroup.events": "populated 0\nfrozen 0\n"})
+	// A real cgroup directory can be removed while it holds its interface
+	// files; a fake one cannot, so only the attempt is checked.
+	logs := captureLog(func() { removeCgroup(dir) })
+	assert.Contains(t, logs, "remove cgroup "+dir)
+}
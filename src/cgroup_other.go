@@ -0,0 +1,21 @@
+//go:build !linux
+
+package src
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// cgroupCommand does nothing, since cgroups only exist on Linux.
+func cgroupCommand(c *exec.Cmd, l Limits) (string, func(), error) {
+	return "", func() {}, nil
+}
+
+// removeCgroup does nothing, since cgroups only exist on Linux.
+func removeCgroup(dir string) {}
+
+// cgroupUsage fails, since cgroups only exist on Linux.
+func cgroupUsage(dir string) (ResourceUsage, error) {
+	return ResourceUsage{}, fmt.Errorf("cgroups are only supported on Linux")
+}
@@ -16,10 +16,20 @@ import { isValidThemeColor } from "./validators.ts";
  * bun test) does not throw a ReferenceError for HTMLElement.
  */
 export interface B3ttyDialog {
-    show(message: string): void;
+    /** Shows message. When action is given, a button for it is shown beside OK. */
+    show(message: string, action?: DialogAction): void;
     hide(): void;
 }
 
+/**
+ * An extra button offered by a B3ttyDialog. Clicking it hides the dialog and then
+ * calls onClick.
+ */
+export interface DialogAction {
+    label: string;
+    onClick(): void;
+}
+
 /**
  * Colors used to style the menu bar: bg is applied as the bar's background,
  * fg as its text/icon color.
@@ -356,6 +366,8 @@ if (typeof HTMLElement !== "undefined") {
     customElements.define("b3tty-palette-card", B3ttyPaletteCardImpl);
 
     class B3ttyDialogImpl extends HTMLElement implements B3ttyDialog {
+        #action: DialogAction | undefined = undefined;
+
         constructor() {
             super();
             const shadow = this.attachShadow({ mode: "open" });
@@ -387,6 +399,10 @@ if (typeof HTMLElement !== "undefined") {
                         font-family: sans-serif;
                         font-size: 14px;
                     }
+                    .buttons {
+                        display: flex;
+                        gap: 12px;
+                    }
                     button {
                         padding: 5px 20px;
                         border-radius: 4px;
@@ -403,15 +419,27 @@ if (typeof HTMLElement !== "undefined") {
                 <div class="backdrop">
                     <div class="modal" role="dialog" aria-modal="true">
                         <p></p>
-                        <button>OK</button>
+                        <div class="buttons">
+                            <button class="action" hidden></button>
+                            <button class="ok">OK</button>
+                        </div>
                     </div>
                 </div>
             `;
-            shadow.querySelector("button")!.addEventListener("click", () => this.hide());
+            shadow.querySelector(".ok")!.addEventListener("click", () => this.hide());
+            shadow.querySelector(".action")!.addEventListener("click", () => {
+                this.hide();
+                this.#action?.onClick();
+            });
         }
 
-        show(message: string): void {
-            this.shadowRoot!.querySelector("p")!.textContent = message;
+        show(message: string, action?: DialogAction): void {
+            const shadow = this.shadowRoot!;
+            shadow.querySelector("p")!.textContent = message;
+            const button = shadow.querySelector<HTMLButtonElement>(".action")!;
+            this.#action = action;
+            button.hidden = action === undefined;
+            button.textContent = action?.label ?? "";
             this.setAttribute("open", "");
         }
 
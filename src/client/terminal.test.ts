@@ -9,8 +9,22 @@ import {
     buildSizeUrl,
     buildWsUrl,
     handleSocketMessage,
+    handleTypedMessage,
     handleSocketClose,
+    CLOSE_GOING_AWAY,
+    CLOSE_POLICY_VIOLATION,
+    CLOSE_TRY_AGAIN_LATER,
+    formatExitStatus,
     sendResizeMessage,
+    sendInput,
+    sendAck,
+    createAckSender,
+    socketRelay,
+    startKeepalive,
+    encodeFrame,
+    isTypedSocket,
+    PROTOCOL_V1,
+    Opcode,
     initTerm,
     hexToRgba,
     withAlpha,
@@ -60,6 +74,24 @@ function makeMockSocket(readyState = 1) {
     };
 }
 
+function makeMockTypedSocket(readyState = 1) {
+    return {
+        readyState,
+        protocol: PROTOCOL_V1,
+        send: mock((_data: Uint8Array) => {}),
+    };
+}
+
+// frame builds an ArrayBuffer holding a v1 message, as received from the server.
+function frame(op: number, payload: string): ArrayBuffer {
+    return encodeFrame(op, payload).slice().buffer as ArrayBuffer;
+}
+
+// sentFrame decodes a v1 message passed to a mock socket's send.
+function sentFrame(data: Uint8Array): { op: number; payload: string } {
+    return { op: data[0]!, payload: new TextDecoder().decode(data.subarray(1)) };
+}
+
 function makeMockBellElement() {
     return {
         style: { display: "none" },
@@ -411,6 +443,11 @@ describe("buildWsUrl", () => {
         const url = buildWsUrl("ws", "localhost", 8080);
         expect(url).toBeInstanceOf(URL);
     });
+
+    it("asks to reattach to a session", () => {
+        const url = buildWsUrl("ws", "localhost", 8080, "a1b2c3");
+        expect(url.toString()).toBe("ws://localhost:8080/ws?session=a1b2c3");
+    });
 });
 
 // ---------------------------------------------------------------------------
@@ -689,6 +726,37 @@ describe("handleSocketClose", () => {
         handleSocketClose(term, alertFn, true);
         expect(term.writeln).toHaveBeenCalledTimes(1);
     });
+
+    it("reports a server shutdown even when the close was clean", () => {
+        const term = makeMockTerm();
+        const alertFn = mock((_msg: string) => {});
+        handleSocketClose(term, alertFn, true, CLOSE_GOING_AWAY);
+        expect(alertFn).toHaveBeenCalledWith("The server shut down");
+        expect(alertFn).toHaveBeenCalledTimes(1);
+    });
+
+    it("reports a session closed by a server policy with its reason", () => {
+        const term = makeMockTerm();
+        const alertFn = mock((_msg: string) => {});
+        handleSocketClose(term, alertFn, true, CLOSE_POLICY_VIOLATION, "idle timeout");
+        expect(alertFn).toHaveBeenCalledWith("Session closed: idle timeout");
+        expect(alertFn).toHaveBeenCalledTimes(1);
+    });
+
+    it("reports a session refused for a session limit with its reason", () => {
+        const term = makeMockTerm();
+        const alertFn = mock((_msg: string) => {});
+        handleSocketClose(term, alertFn, true, CLOSE_TRY_AGAIN_LATER, "too many sessions: the server allows 4");
+        expect(alertFn).toHaveBeenCalledWith("Session refused: too many sessions: the server allows 4");
+        expect(alertFn).toHaveBeenCalledTimes(1);
+    });
+
+    it("suppresses the dialog for a clean close with another code", () => {
+        const term = makeMockTerm();
+        const alertFn = mock((_msg: string) => {});
+        handleSocketClose(term, alertFn, true, 1000);
+        expect(alertFn).not.toHaveBeenCalled();
+    });
 });
 
 // ---------------------------------------------------------------------------
@@ -746,6 +814,258 @@ describe("sendResizeMessage", () => {
     });
 });
 
+// ---------------------------------------------------------------------------
+// v1 protocol
+// ---------------------------------------------------------------------------
+
+describe("encodeFrame", () => {
+    it("prefixes the UTF-8 payload with the opcode", () => {
+        const data = encodeFrame(Opcode.Input, "é");
+        expect(Array.from(data)).toEqual([0x30, 0xc3, 0xa9]);
+    });
+
+    it("encodes an empty payload as just the opcode", () => {
+        expect(Array.from(encodeFrame(Opcode.Ping, ""))).toEqual([0x33]);
+    });
+});
+
+describe("isTypedSocket", () => {
+    it("is true only when the server selected the v1 subprotocol", () => {
+        expect(isTypedSocket(makeMockTypedSocket())).toBe(true);
+        expect(isTypedSocket(makeMockSocket())).toBe(false);
+        expect(isTypedSocket({ ...makeMockSocket(), protocol: "" })).toBe(false);
+    });
+});
+
+describe("sendInput", () => {
+    it("sends raw strings on a legacy socket", () => {
+        const socket = makeMockSocket();
+        sendInput(socket, "ls\r");
+        expect(socket.send).toHaveBeenCalledWith("ls\r");
+    });
+
+    it("sends an input frame on a v1 socket", () => {
+        const socket = makeMockTypedSocket();
+        sendInput(socket, '{"type":"resize","cols":1,"rows":1}');
+        expect(sentFrame(socket.send.mock.calls[0]![0])).toEqual({
+            op: Opcode.Input,
+            payload: '{"type":"resize","cols":1,"rows":1}',
+        });
+    });
+});
+
+describe("sendResizeMessage on a v1 socket", () => {
+    it("sends a resize frame", () => {
+        const socket = makeMockTypedSocket();
+        sendResizeMessage(socket, 100, 30);
+        const sent = sentFrame(socket.send.mock.calls[0]![0]);
+        expect(sent.op).toBe(Opcode.Resize);
+        expect(JSON.parse(sent.payload)).toEqual({ cols: 100, rows: 30 });
+    });
+
+    it("does not send when the socket is not open", () => {
+        const socket = makeMockTypedSocket(3);
+        sendResizeMessage(socket, 100, 30);
+        expect(socket.send).not.toHaveBeenCalled();
+    });
+});
+
+describe("handleTypedMessage", () => {
+    let term: ReturnType<typeof makeMockTerm>;
+    let decoder: TextDecoder;
+
+    beforeEach(() => {
+        term = makeMockTerm();
+        decoder = new TextDecoder("utf-8");
+    });
+
+    it("writes output frames to the terminal", () => {
+        handleTypedMessage({ data: frame(Opcode.Output, "hello") }, decoder, term, {});
+        expect(term.write).toHaveBeenCalledWith("hello");
+    });
+
+    it("passes writeCallback through for output frames", () => {
+        const cb = mock(() => {});
+        handleTypedMessage({ data: frame(Opcode.Output, "x") }, decoder, term, {}, cb);
+        expect(term.write).toHaveBeenCalledWith("x", cb);
+    });
+
+    it("reassembles multi-byte characters split across output frames", () => {
+        const bytes = new TextEncoder().encode("é");
+        const first = new Uint8Array([Opcode.Output, bytes[0]!]);
+        const second = new Uint8Array([Opcode.Output, bytes[1]!]);
+        handleTypedMessage({ data: first.buffer }, decoder, term, {});
+        handleTypedMessage({ data: second.buffer }, decoder, term, {});
+        expect(term.write.mock.calls.map((c) => (c as unknown[])[0]).join("")).toBe("é");
+    });
+
+    it("dispatches title, pong, exit, error and session frames to their handlers", () => {
+        const handlers = {
+            onTitle: mock((_title: string) => {}),
+            onPong: mock((_payload: string) => {}),
+            onExit: mock((_status: unknown) => {}),
+            onError: mock((_message: string) => {}),
+            onSession: mock((_id: string) => {}),
+        };
+        handleTypedMessage({ data: frame(Opcode.Title, "Build box") }, decoder, term, handlers);
+        handleTypedMessage({ data: frame(Opcode.Pong, "t=1") }, decoder, term, handlers);
+        handleTypedMessage({ data: frame(Opcode.Exit, '{"code":1,"duration_ms":5}') }, decoder, term, handlers);
+        handleTypedMessage({ data: frame(Opcode.Error, '{"message":"no pty"}') }, decoder, term, handlers);
+        handleTypedMessage({ data: frame(Opcode.Session, '{"id":"abc123"}') }, decoder, term, handlers);
+        expect(handlers.onTitle).toHaveBeenCalledWith("Build box");
+        expect(handlers.onPong).toHaveBeenCalledWith("t=1");
+        expect(handlers.onExit).toHaveBeenCalledWith({ code: 1, duration_ms: 5 });
+        expect(handlers.onError).toHaveBeenCalledWith("no pty");
+        expect(handlers.onSession).toHaveBeenCalledWith("abc123");
+        expect(term.write).not.toHaveBeenCalled();
+    });
+
+    it("reports rendered output bytes to onConsumed after writeCallback", () => {
+        const order: string[] = [];
+        const writeCallback = mock(() => order.push("write"));
+        const onConsumed = mock((_bytes: number) => order.push("consumed"));
+        handleTypedMessage({ data: frame(Opcode.Output, "héllo") }, decoder, term, { onConsumed }, writeCallback);
+        expect(onConsumed).not.toHaveBeenCalled();
+        const rendered = (term.write.mock.calls[0] as unknown[])[1] as () => void;
+        rendered();
+        expect(onConsumed).toHaveBeenCalledWith(6);
+        expect(order).toEqual(["write", "consumed"]);
+    });
+
+    it("ignores messages without a handler", () => {
+        expect(() => handleTypedMessage({ data: frame(Opcode.Title, "x") }, decoder, term, {})).not.toThrow();
+    });
+
+    it("ignores unknown opcodes, empty frames and text frames", () => {
+        const logSpy = spyOn(console, "log").mockImplementation(() => {});
+        handleTypedMessage({ data: frame(0x7a, "future") }, decoder, term, {});
+        handleTypedMessage({ data: new ArrayBuffer(0) }, decoder, term, {});
+        handleTypedMessage({ data: "1hello" }, decoder, term, {});
+        expect(term.write).not.toHaveBeenCalled();
+        logSpy.mockRestore();
+    });
+});
+
+describe("formatExitStatus", () => {
+    it("describes a normal exit", () => {
+        expect(formatExitStatus({ code: 1, duration_ms: 2500 })).toBe("Process exited with code 1 after 2.5s");
+    });
+
+    it("names the signal that killed the process", () => {
+        expect(formatExitStatus({ code: 137, signal: "SIGKILL", duration_ms: 300 })).toBe(
+            "Process killed by SIGKILL after 0.3s"
+        );
+    });
+});
+
+describe("sendAck", () => {
+    it("sends an ack frame", () => {
+        const socket = makeMockTypedSocket();
+        sendAck(socket, 4096);
+        const sent = sentFrame(socket.send.mock.calls[0]![0]);
+        expect(sent.op).toBe(Opcode.Ack);
+        expect(JSON.parse(sent.payload)).toEqual({ bytes: 4096 });
+    });
+
+    it("does not send when the socket is not open", () => {
+        const socket = makeMockTypedSocket(3);
+        sendAck(socket, 1);
+        expect(socket.send).not.toHaveBeenCalled();
+    });
+});
+
+describe("createAckSender", () => {
+    it("batches bytes consumed before the flush into one ack", () => {
+        const socket = makeMockTypedSocket();
+        const flushes: (() => void)[] = [];
+        const onConsumed = createAckSender(socket, (flush) => flushes.push(flush));
+        onConsumed(100);
+        onConsumed(20);
+        expect(flushes).toHaveLength(1);
+        flushes[0]!();
+        onConsumed(5);
+        expect(flushes).toHaveLength(2);
+        flushes[1]!();
+        const acks = socket.send.mock.calls.map((c) => JSON.parse(sentFrame(c[0]).payload).bytes);
+        expect(acks).toEqual([120, 5]);
+    });
+});
+
+describe("socketRelay", () => {
+    it("forwards to the current socket", () => {
+        const first = makeMockTypedSocket();
+        const second = makeMockTypedSocket(0);
+        const relay = socketRelay(first);
+        sendInput(relay, "a");
+        relay.current = second;
+        expect(relay.readyState).toBe(0);
+        expect(isTypedSocket(relay)).toBe(true);
+        sendInput(relay, "b");
+        expect(sentFrame(first.send.mock.calls[0]![0]).payload).toBe("a");
+        expect(sentFrame(second.send.mock.calls[0]![0]).payload).toBe("b");
+    });
+});
+
+describe("startKeepalive", () => {
+    function fakeTimers() {
+        const timers = {
+            tick: () => {},
+            cleared: false,
+            setInterval: (fn: () => void, _ms: number) => {
+                timers.tick = fn;
+                return 1;
+            },
+            clearInterval: (_id: unknown) => {
+                timers.cleared = true;
+            },
+        };
+        return timers;
+    }
+
+    it("pings on every tick while pongs arrive", () => {
+        const socket = makeMockTypedSocket();
+        const timers = fakeTimers();
+        const onMissed = mock(() => {});
+        const keepalive = startKeepalive(socket, 1000, onMissed, timers);
+        timers.tick();
+        keepalive.onPong();
+        timers.tick();
+        expect(socket.send).toHaveBeenCalledTimes(2);
+        expect(sentFrame(socket.send.mock.calls[0]![0]).op).toBe(Opcode.Ping);
+        expect(onMissed).not.toHaveBeenCalled();
+    });
+
+    it("reports a missed pong once and stops", () => {
+        const socket = makeMockTypedSocket();
+        const timers = fakeTimers();
+        const onMissed = mock(() => {});
+        startKeepalive(socket, 1000, onMissed, timers);
+        timers.tick();
+        timers.tick();
+        expect(onMissed).toHaveBeenCalledTimes(1);
+        expect(timers.cleared).toBe(true);
+        expect(socket.send).toHaveBeenCalledTimes(1);
+    });
+
+    it("does not ping a legacy socket", () => {
+        const socket = makeMockSocket();
+        const timers = fakeTimers();
+        startKeepalive(socket, 1000, () => {}, timers);
+        timers.tick();
+        expect(socket.send).not.toHaveBeenCalled();
+    });
+});
+
+describe("initTerm on a v1 socket", () => {
+    it("sends keyboard input as input frames", () => {
+        const term = makeMockTerm();
+        const socket = makeMockTypedSocket();
+        initTerm(term, socket, makeMockBellElement());
+        term.onData.mock.calls[0]![0]("ls\r");
+        expect(sentFrame(socket.send.mock.calls[0]![0])).toEqual({ op: Opcode.Input, payload: "ls\r" });
+    });
+});
+
 // ---------------------------------------------------------------------------
 // buildDebugHooks
 // ---------------------------------------------------------------------------
@@ -11,6 +11,8 @@ import type {
     SocketLike,
     SocketMessageEvent,
     TerminalLike,
+    ExitStatus,
+    ProtocolHandlers,
     ClientConfig,
     ThemeConfig,
 } from "./types.ts";
@@ -151,13 +153,154 @@ export function buildSizeUrl(httpProto: string, uri: string, port: number, cols:
 }
 
 /**
- * Builds the URL used to open the terminal WebSocket connection.
+ * WebSocket subprotocol that selects the typed v1 framing. When the server does
+ * not select it, the client falls back to the legacy framing: raw input strings
+ * and JSON resize commands in, raw terminal output out.
  */
-export function buildWsUrl(wsProtocol: string, uri: string, port: number): URL {
+export const PROTOCOL_V1 = "b3tty.v1";
+
+/**
+ * WebSocket close code (going away) the server sends when it shuts down.
+ */
+export const CLOSE_GOING_AWAY = 1001;
+
+/**
+ * WebSocket close code (policy violation) the server sends when it closes a
+ * session for a server policy, such as an idle timeout. The close reason says
+ * which.
+ */
+export const CLOSE_POLICY_VIOLATION = 1008;
+
+/**
+ * WebSocket close code (try again later) the server sends when it refuses a
+ * new session because a session limit has been reached. The close reason
+ * names the limit.
+ */
+export const CLOSE_TRY_AGAIN_LATER = 1013;
+
+/**
+ * WebSocket close code (abnormal closure) the browser reports when a connection
+ * drops without a close frame. Only such a connection is reconnected.
+ */
+export const CLOSE_ABNORMAL = 1006;
+
+/**
+ * Milliseconds to wait between attempts to reconnect to a lost session.
+ */
+export const RECONNECT_DELAY_MS = 1000;
+
+/**
+ * Opcodes of the v1 framing. Each v1 message is a binary frame whose first byte is
+ * one of these opcodes and whose remaining bytes are the payload. Must be kept in
+ * sync with the msg* constants in src/protocol.go.
+ */
+export const Opcode = {
+    Input: 0x30,
+    Output: 0x31,
+    Resize: 0x32,
+    Ping: 0x33,
+    Pong: 0x34,
+    Title: 0x35,
+    Exit: 0x36,
+    Error: 0x37,
+    Session: 0x38,
+    Ack: 0x39,
+} as const;
+
+const frameEncoder = new TextEncoder();
+
+/**
+ * Encodes a v1 message: the opcode byte followed by payload as UTF-8.
+ */
+export function encodeFrame(op: number, payload: string): Uint8Array {
+    const body = frameEncoder.encode(payload);
+    const frame = new Uint8Array(body.length + 1);
+    frame[0] = op;
+    frame.set(body, 1);
+    return frame;
+}
+
+/**
+ * Reports whether the server accepted the v1 framing for socket.
+ */
+export function isTypedSocket(socket: SocketLike): boolean {
+    return socket.protocol === PROTOCOL_V1;
+}
+
+/**
+ * Builds the URL used to open the terminal WebSocket connection. When sessionId is
+ * given the URL asks the server to reattach to that session.
+ */
+export function buildWsUrl(wsProtocol: string, uri: string, port: number, sessionId?: string): URL {
     if (!isValidWsProtocol(wsProtocol)) throw new Error(`Invalid WebSocket protocol: "${wsProtocol}"`);
     if (!isValidUri(uri)) throw new Error(`Invalid URI: "${uri}"`);
     if (!isValidPort(port)) throw new Error(`Invalid port: ${port}`);
-    return new URL(`${wsProtocol}://${uri}:${port}/ws`);
+    const url = new URL(`${wsProtocol}://${uri}:${port}/ws`);
+    if (sessionId) url.searchParams.set("session", sessionId);
+    return url;
+}
+
+/**
+ * A SocketLike that forwards to the WebSocket currently carrying the session, so
+ * that input and resize handlers bound once keep working after a reconnect.
+ */
+export interface SocketRelay extends SocketLike {
+    current: SocketLike;
+}
+
+/**
+ * Returns a SocketRelay that forwards to initial until current is replaced.
+ */
+export function socketRelay(initial: SocketLike): SocketRelay {
+    return {
+        current: initial,
+        get readyState() {
+            return this.current.readyState;
+        },
+        get protocol() {
+            return this.current.protocol;
+        },
+        send(data) {
+            this.current.send(data);
+        },
+    };
+}
+
+/**
+ * Pings the server over a v1 socket every intervalMs. When a ping is still
+ * unanswered at the next tick, the connection is taken to be dead: the pings stop
+ * and onMissed is called. Pongs must be passed to the returned onPong. timers is
+ * injectable for testing.
+ */
+export function startKeepalive(
+    socket: SocketLike,
+    intervalMs: number,
+    onMissed: () => void,
+    timers: {
+        setInterval: (fn: () => void, ms: number) => unknown;
+        clearInterval: (id: unknown) => void;
+    } = {
+        setInterval: (fn, ms) => setInterval(fn, ms),
+        clearInterval: (id) => clearInterval(id as ReturnType<typeof setInterval>),
+    }
+): { onPong: () => void; stop: () => void } {
+    let awaiting = false;
+    const id = timers.setInterval(() => {
+        if (awaiting) {
+            timers.clearInterval(id);
+            onMissed();
+            return;
+        }
+        if (socket.readyState !== 1 || !isTypedSocket(socket)) return;
+        awaiting = true;
+        socket.send(encodeFrame(Opcode.Ping, ""));
+    }, intervalMs);
+    return {
+        onPong: () => {
+            awaiting = false;
+        },
+        stop: () => timers.clearInterval(id),
+    };
 }
 
 /**
@@ -180,16 +323,98 @@ export function handleSocketMessage(
     }
 }
 
+/**
+ * Handles an incoming v1 message. Output is decoded with the streaming decoder so
+ * multi-byte characters split across frames render correctly, then written to the
+ * terminal exactly like handleSocketMessage; once xterm.js has rendered it, its size
+ * is reported to handlers.onConsumed for flow control. Every other message is passed to the
+ * matching callback in handlers. Text frames, empty frames and unknown opcodes are
+ * ignored so a newer server can add message types without breaking this client.
+ */
+export function handleTypedMessage(
+    event: SocketMessageEvent,
+    decoder: TextDecoder,
+    term: TerminalLike,
+    handlers: ProtocolHandlers,
+    writeCallback?: () => void
+): void {
+    if (!(event.data instanceof ArrayBuffer) || event.data.byteLength === 0) return;
+    const frame = new Uint8Array(event.data);
+    const op = frame[0];
+    const payload = frame.subarray(1);
+    const text = () => new TextDecoder("utf-8").decode(payload);
+    switch (op) {
+        case Opcode.Output: {
+            const data = decoder.decode(payload, { stream: true });
+            const onConsumed = handlers.onConsumed;
+            if (onConsumed !== undefined) {
+                const bytes = payload.length;
+                term.write(data, () => {
+                    writeCallback?.();
+                    onConsumed(bytes);
+                });
+            } else if (writeCallback !== undefined) {
+                term.write(data, writeCallback);
+            } else {
+                term.write(data);
+            }
+            break;
+        }
+        case Opcode.Title:
+            handlers.onTitle?.(text());
+            break;
+        case Opcode.Pong:
+            handlers.onPong?.(text());
+            break;
+        case Opcode.Exit:
+            handlers.onExit?.(JSON.parse(text()) as ExitStatus);
+            break;
+        case Opcode.Error:
+            handlers.onError?.((JSON.parse(text()) as { message: string }).message);
+            break;
+        case Opcode.Session:
+            handlers.onSession?.((JSON.parse(text()) as { id: string }).id);
+            break;
+        default:
+            console.log(`Ignoring message with unknown opcode ${op}`);
+    }
+}
+
+/**
+ * Describes how the shell exited, e.g. "Process exited with code 1 after 2.5s" or
+ * "Process killed by SIGKILL after 0.3s".
+ */
+export function formatExitStatus(status: ExitStatus): string {
+    const after = `after ${(status.duration_ms / 1000).toFixed(1)}s`;
+    if (status.signal) return `Process killed by ${status.signal} ${after}`;
+    return `Process exited with code ${status.code} ${after}`;
+}
+
 /**
  * Handles a WebSocket close event by writing an exit notice to the terminal.
  * The "Connection closed" dialog is shown only when wasClean is false, indicating
  * an unexpected drop rather than a server- or client-initiated close handshake.
- * alertFn is injectable for testing.
+ * A close with code CLOSE_GOING_AWAY means the server shut down, which is
+ * reported as such. One with CLOSE_POLICY_VIOLATION is reported with its
+ * reason, as is one with CLOSE_TRY_AGAIN_LATER, which means the session was
+ * refused. alertFn is injectable for testing.
  */
-export function handleSocketClose(term: TerminalLike, alertFn: (msg: string) => void, wasClean = false): void {
+export function handleSocketClose(
+    term: TerminalLike,
+    alertFn: (msg: string) => void,
+    wasClean = false,
+    code?: number,
+    reason = ""
+): void {
     console.log("Socket closed");
     term.writeln("[exited]");
-    if (!wasClean) {
+    if (code === CLOSE_GOING_AWAY) {
+        alertFn("The server shut down");
+    } else if (code === CLOSE_POLICY_VIOLATION) {
+        alertFn(reason ? `Session closed: ${reason}` : "Session closed by the server");
+    } else if (code === CLOSE_TRY_AGAIN_LATER) {
+        alertFn(reason ? `Session refused: ${reason}` : "Session refused by the server");
+    } else if (!wasClean) {
         alertFn("Connection closed");
     }
 }
@@ -219,14 +444,60 @@ export function buildDebugHooks(debug: boolean): { onBeforeSend?: () => void; wr
 }
 
 /**
- * Sends a JSON resize message over the WebSocket if it is open (readyState === 1).
+ * Sends a resize message over the WebSocket if it is open (readyState === 1).
+ * v1 sockets get a resize frame; legacy sockets get a JSON {type: "resize"} string.
  */
 export function sendResizeMessage(socket: SocketLike, cols: number, rows: number): void {
     if (socket.readyState === 1) {
-        socket.send(JSON.stringify({ type: "resize", cols, rows }));
+        if (isTypedSocket(socket)) {
+            socket.send(encodeFrame(Opcode.Resize, JSON.stringify({ cols, rows })));
+        } else {
+            socket.send(JSON.stringify({ type: "resize", cols, rows }));
+        }
+    }
+}
+
+/**
+ * Sends keyboard input over the WebSocket, framed as a v1 input message when the
+ * server negotiated the v1 protocol and as a raw string otherwise.
+ */
+export function sendInput(socket: SocketLike, data: string): void {
+    socket.send(isTypedSocket(socket) ? encodeFrame(Opcode.Input, data) : data);
+}
+
+/**
+ * Acknowledges bytes of rendered output on a v1 socket if it is open. The first ack,
+ * even of zero bytes, turns on server-side flow control for the connection.
+ */
+export function sendAck(socket: SocketLike, bytes: number): void {
+    if (socket.readyState === 1) {
+        socket.send(encodeFrame(Opcode.Ack, JSON.stringify({ bytes })));
     }
 }
 
+/**
+ * Returns an onConsumed handler that batches acknowledgements: bytes reported while
+ * a flush is pending are added to it, so a burst of rendered frames costs a single
+ * ack. schedule is injectable for testing.
+ */
+export function createAckSender(
+    socket: SocketLike,
+    schedule: (flush: () => void) => void = (flush) => setTimeout(flush, 0)
+): (bytes: number) => void {
+    let pending = 0;
+    let scheduled = false;
+    return (bytes) => {
+        pending += bytes;
+        if (scheduled) return;
+        scheduled = true;
+        schedule(() => {
+            sendAck(socket, pending);
+            pending = 0;
+            scheduled = false;
+        });
+    };
+}
+
 /**
  * Returns the element with the given id or throws a descriptive error if it is absent.
  * Prefer this over getElementById(id)! so missing elements produce clear failure messages
@@ -281,7 +552,7 @@ export function initTerm(
 
     term.onData((chunk) => {
         if (onBeforeSend !== undefined) onBeforeSend();
-        socket.send(chunk);
+        sendInput(socket, chunk);
     });
 
     term.onBell(() => {
@@ -527,37 +798,118 @@ export async function main(config: TermConfig): Promise<void> {
         console.warn(err instanceof Error ? err.message : String(err));
     }
 
-    const wsUrl = buildWsUrl(wsProtocol, config.uri, config.port);
-    const socket = new WebSocket(wsUrl);
-    socket.binaryType = "arraybuffer";
-
     // listenerController cleans up all DOM event listeners when the session ends.
     const listenerController = new AbortController();
     const { signal } = listenerController;
 
-    const decoder = new TextDecoder("utf-8");
     const { onBeforeSend, writeCallback } = buildDebugHooks(!!config.debug);
 
-    socket.onmessage = (event) => {
-        if (socket.readyState !== 1) {
-            console.log("websocket not ready!");
-        }
-        handleSocketMessage(event as SocketMessageEvent, decoder, term, writeCallback);
-    };
-
     const dialogEl = requireElement("dialog");
     if (!isB3ttyDialog(dialogEl)) throw new Error("Element #dialog is not a B3ttyDialog");
     const dialog: B3ttyDialog = dialogEl;
-    socket.onclose = (event) => {
+
+    // sessionId identifies the server-side session, so that a lost connection can be
+    // reattached to it. lostAt is when the connection was lost, and 0 while connected.
+    let sessionId = "";
+    let lostAt = 0;
+    const gracePeriod = config.detachGracePeriod ?? 0;
+
+    const protocolHandlers: ProtocolHandlers = {
+        onTitle: (title) => {
+            document.title = title;
+        },
+        onExit: (status) => {
+            // The server prints its own note when it restarts the process.
+            if (status.restart) return;
+            const message = formatExitStatus(status);
+            term.writeln(`\r\n[${message}]`);
+            dialog.show(message, { label: "Restart", onClick: () => window.location.reload() });
+        },
+        onError: (message) => term.writeln(`\r\n[error: ${message}]`),
+        onSession: (id) => {
+            sessionId = id;
+        },
+    };
+
+    const endSession = (wasClean: boolean, code?: number, reason = "") => {
         listenerController.abort();
         disableCursor(term);
-        handleSocketClose(term, (msg) => dialog.show(msg), event.wasClean);
+        handleSocketClose(term, (msg) => dialog.show(msg), wasClean, code, reason);
     };
-    socket.onerror = (event) => console.log("A socket error occurred: ", event);
-    socket.onopen = () => console.log("Socket opened");
+
+    // reconnect schedules another attempt to reattach to the session while its
+    // grace period lasts, and reports whether it did.
+    const reconnect = (): boolean => {
+        if (!sessionId || gracePeriod <= 0) return false;
+        const now = Date.now();
+        if (!lostAt) {
+            lostAt = now;
+            term.writeln("\r\n[connection lost; reconnecting]");
+        }
+        const remaining = lostAt + gracePeriod - now;
+        if (remaining <= 0) return false;
+        setTimeout(() => {
+            relay.current = connect();
+        }, Math.min(RECONNECT_DELAY_MS, remaining));
+        return true;
+    };
+
+    const connect = (): WebSocket => {
+        const wsUrl = buildWsUrl(wsProtocol, config.uri, config.port, sessionId || undefined);
+        const socket = new WebSocket(wsUrl, [PROTOCOL_V1]);
+        socket.binaryType = "arraybuffer";
+
+        const decoder = new TextDecoder("utf-8");
+        let keepalive: ReturnType<typeof startKeepalive> | undefined;
+        const handlers: ProtocolHandlers = {
+            ...protocolHandlers,
+            onConsumed: createAckSender(socket),
+            onPong: () => keepalive?.onPong(),
+        };
+
+        socket.onmessage = (event) => {
+            if (socket.readyState !== 1) {
+                console.log("websocket not ready!");
+            }
+            if (isTypedSocket(socket)) {
+                handleTypedMessage(event as SocketMessageEvent, decoder, term, handlers, writeCallback);
+            } else {
+                handleSocketMessage(event as SocketMessageEvent, decoder, term, writeCallback);
+            }
+        };
+
+        socket.onclose = (event) => {
+            keepalive?.stop();
+            if (event.code === CLOSE_ABNORMAL && reconnect()) return;
+            endSession(event.wasClean, event.code, event.reason);
+        };
+        socket.onerror = (event) => console.log("A socket error occurred: ", event);
+        socket.onopen = () => {
+            console.log("Socket opened");
+            if (!isTypedSocket(socket)) return;
+            sendAck(socket, 0);
+            if (lostAt) {
+                lostAt = 0;
+                sendResizeMessage(socket, term.cols, term.rows);
+            }
+            if (gracePeriod > 0 && config.pingInterval) {
+                keepalive = startKeepalive(socket, config.pingInterval, () => {
+                    // A dead connection can take minutes to close; leave it
+                    // behind and reconnect now.
+                    socket.onclose = null;
+                    socket.onmessage = null;
+                    socket.close();
+                    if (!reconnect()) endSession(false, CLOSE_ABNORMAL);
+                });
+            }
+        };
+        return socket;
+    };
+
+    const relay = socketRelay(connect());
 
     const bellElement = requireElement("bell");
-    initTerm(term, socket, bellElement, onBeforeSend);
+    initTerm(term, relay, bellElement, onBeforeSend);
 
     const menuBarEl = document.getElementById("menubar");
     if (menuBarEl) {
@@ -649,7 +1001,7 @@ export async function main(config: TermConfig): Promise<void> {
 
     if (!config.columns) {
         term.onResize(({ cols, rows }) => {
-            sendResizeMessage(socket, cols, rows);
+            sendResizeMessage(relay, cols, rows);
         });
 
         let resizeTimer: ReturnType<typeof setTimeout>;
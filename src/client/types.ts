@@ -44,6 +44,10 @@ export interface TermConfig {
     builtinThemeNames?: string[];
     profileNames?: string[];
     activeTheme?: string;
+    /** How often to ping the server, in milliseconds. */
+    pingInterval?: number;
+    /** How long a lost session waits for the browser to reconnect, in milliseconds; 0 when it does not. */
+    detachGracePeriod?: number;
 }
 
 export interface ThemeActivateResponse extends ThemeConfigBase {
@@ -89,7 +93,35 @@ export interface ClientConfig {
 
 export interface SocketLike {
     readyState: number;
-    send(data: string): void;
+    // protocol is the subprotocol the server selected; "" or absent when none was negotiated.
+    protocol?: string;
+    send(data: string | Uint8Array): void;
+}
+
+/**
+ * Body of a v1 exit message, sent by the server once the shell process has exited.
+ * signal is only present when the process was terminated by a signal.
+ */
+export interface ExitStatus {
+    code: number;
+    signal?: string;
+    duration_ms: number;
+    /** Set when the server will restart the process on the same connection. */
+    restart?: "restart" | "prompt";
+}
+
+/**
+ * Callbacks for the v1 messages that are not terminal output. Every callback is
+ * optional; messages without a callback are ignored.
+ */
+export interface ProtocolHandlers {
+    onTitle?(title: string): void;
+    onExit?(status: ExitStatus): void;
+    onError?(message: string): void;
+    onPong?(payload: string): void;
+    onSession?(id: string): void;
+    /** Called once bytes of output payload have been rendered by the terminal. */
+    onConsumed?(bytes: number): void;
 }
 
 export interface BellElementLike {
@@ -6,16 +6,22 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // The following types mirror the YAML config file structure. They exist solely
-// for structural and type validation at startup and are intentionally separate
-// from the runtime structs in src/models.go.
+// for structural and type validation at startup and for generating the config
+// JSON Schema (see ConfigSchema), and are intentionally separate from the
+// runtime structs in src/models.go. Theme color fields carry a schema:"color"
+// tag so the generated schema applies the ValidateThemeColor patterns to them,
+// and duration fields carry schema:"duration" so it only accepts Go duration
+// strings such as "30s".
 
 type configFile struct {
 	Server   serverConfig             `yaml:"server"`
+	Log      logConfig                `yaml:"log"`
 	Terminal terminalConfig           `yaml:"terminal"`
 	Theme    string                   `yaml:"theme"`
 	Themes   map[string]themeConfig   `yaml:"themes"`
@@ -23,12 +29,45 @@ type configFile struct {
 }
 
 type serverConfig struct {
-	TLS       bool   `yaml:"tls"`
-	CertFile  string `yaml:"cert-file"`
-	KeyFile   string `yaml:"key-file"`
-	NoAuth    bool   `yaml:"no-auth"`
-	NoBrowser bool   `yaml:"no-browser"`
-	Port      int    `yaml:"port"`
+	TLS                 bool              `yaml:"tls"`
+	CertFile            string            `yaml:"cert-file"`
+	KeyFile             string            `yaml:"key-file"`
+	ClientCAFile        string            `yaml:"client-ca-file"`
+	NoAuth              bool              `yaml:"no-auth"`
+	NoBrowser           bool              `yaml:"no-browser"`
+	Port                int               `yaml:"port"`
+	PingInterval        string            `yaml:"ping-interval" schema:"duration"`
+	PongTimeout         string            `yaml:"pong-timeout" schema:"duration"`
+	DetachGracePeriod   string            `yaml:"detach-grace-period" schema:"duration"`
+	KillGracePeriod     string            `yaml:"kill-grace-period" schema:"duration"`
+	ShutdownDrainPeriod string            `yaml:"shutdown-drain-period" schema:"duration"`
+	ReadBufferSize      int               `yaml:"read-buffer-size"`
+	OutputBatchWindow   string            `yaml:"output-batch-window" schema:"duration"`
+	OutputBatchSize     int               `yaml:"output-batch-size"`
+	FlowControlWindow   int               `yaml:"flow-control-window"`
+	MaxSessions         int               `yaml:"max-sessions"`
+	AuditLog            string            `yaml:"audit-log"`
+	Compression         compressionConfig `yaml:"compression"`
+	Metrics             metricsConfig     `yaml:"metrics"`
+}
+
+type compressionConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	Level     int  `yaml:"level"`
+	Threshold int  `yaml:"threshold"`
+}
+
+type metricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+}
+
+type logConfig struct {
+	Format   string `yaml:"format"`
+	Level    string `yaml:"level"`
+	File     string `yaml:"file"`
+	MaxSize  string `yaml:"max-size" schema:"size"`
+	MaxFiles int    `yaml:"max-files"`
 }
 
 type terminalConfig struct {
@@ -37,40 +76,139 @@ type terminalConfig struct {
 	CursorBlink bool   `yaml:"cursor-blink"`
 	Rows        int    `yaml:"rows"`
 	Columns     int    `yaml:"columns"`
+	IdleTimeout string `yaml:"idle-timeout" schema:"duration"`
+	MaxDuration string `yaml:"max-session-duration" schema:"duration"`
 }
 
 type themeConfig struct {
-	Black               string `yaml:"black"`
-	BrightBlack         string `yaml:"bright-black"`
-	Red                 string `yaml:"red"`
-	BrightRed           string `yaml:"bright-red"`
-	Green               string `yaml:"green"`
-	BrightGreen         string `yaml:"bright-green"`
-	Yellow              string `yaml:"yellow"`
-	BrightYellow        string `yaml:"bright-yellow"`
-	Blue                string `yaml:"blue"`
-	BrightBlue          string `yaml:"bright-blue"`
-	Magenta             string `yaml:"magenta"`
-	BrightMagenta       string `yaml:"bright-magenta"`
-	Cyan                string `yaml:"cyan"`
-	BrightCyan          string `yaml:"bright-cyan"`
-	White               string `yaml:"white"`
-	BrightWhite         string `yaml:"bright-white"`
-	Foreground          string `yaml:"foreground"`
-	Background          string `yaml:"background"`
-	Cursor              string `yaml:"cursor"`
-	CursorAccent        string `yaml:"cursor-accent"`
-	SelectionForeground string `yaml:"selection-foreground"`
-	SelectionBackground string `yaml:"selection-background"`
+	Black               string `yaml:"black" schema:"color"`
+	BrightBlack         string `yaml:"bright-black" schema:"color"`
+	Red                 string `yaml:"red" schema:"color"`
+	BrightRed           string `yaml:"bright-red" schema:"color"`
+	Green               string `yaml:"green" schema:"color"`
+	BrightGreen         string `yaml:"bright-green" schema:"color"`
+	Yellow              string `yaml:"yellow" schema:"color"`
+	BrightYellow        string `yaml:"bright-yellow" schema:"color"`
+	Blue                string `yaml:"blue" schema:"color"`
+	BrightBlue          string `yaml:"bright-blue" schema:"color"`
+	Magenta             string `yaml:"magenta" schema:"color"`
+	BrightMagenta       string `yaml:"bright-magenta" schema:"color"`
+	Cyan                string `yaml:"cyan" schema:"color"`
+	BrightCyan          string `yaml:"bright-cyan" schema:"color"`
+	White               string `yaml:"white" schema:"color"`
+	BrightWhite         string `yaml:"bright-white" schema:"color"`
+	Foreground          string `yaml:"foreground" schema:"color"`
+	Background          string `yaml:"background" schema:"color"`
+	Cursor              string `yaml:"cursor" schema:"color"`
+	CursorAccent        string `yaml:"cursor-accent" schema:"color"`
+	SelectionForeground string `yaml:"selection-foreground" schema:"color"`
+	SelectionBackground string `yaml:"selection-background" schema:"color"`
 	BackgroundImage     string `yaml:"background-image"`
 }
 
 type profileConfig struct {
-	WorkingDirectory string   `yaml:"working-directory"`
-	Title            string   `yaml:"title"`
-	Shell            string   `yaml:"shell"`
-	Commands         []string `yaml:"commands"`
-	Root             string   `yaml:"root"`
+	Type             string            `yaml:"type"`
+	WorkingDirectory string            `yaml:"working-directory"`
+	Title            string            `yaml:"title"`
+	Shell            string            `yaml:"shell"`
+	Commands         []commandConfig   `yaml:"commands" schema:"commands"`
+	CommandWait      waitConfig        `yaml:"command-wait"`
+	Command          argvConfig        `yaml:"command" schema:"argv"`
+	LoginShell       bool              `yaml:"login-shell"`
+	Argv0            string            `yaml:"argv0"`
+	Root             string            `yaml:"root"`
+	OnExit           string            `yaml:"on-exit"`
+	RestartLimit     int               `yaml:"restart-limit"`
+	RestartBackoff   string            `yaml:"restart-backoff" schema:"duration"`
+	Env              map[string]string `yaml:"env"`
+	EnvFile          []string          `yaml:"env-file"`
+	InheritEnv       inheritEnvConfig  `yaml:"inherit-env"`
+	Sandbox          sandboxConfig     `yaml:"sandbox"`
+	Limits           limitsConfig      `yaml:"limits"`
+	User             string            `yaml:"user"`
+	Group            string            `yaml:"group"`
+	IdleTimeout      string            `yaml:"idle-timeout" schema:"duration"`
+	MaxDuration      string            `yaml:"max-session-duration" schema:"duration"`
+	MaxSessions      int               `yaml:"max-sessions"`
+}
+
+// argvConfig is a command given either as a list of arguments or as a single
+// string, which is split into arguments by SplitCommand.
+type argvConfig []string
+
+func (a *argvConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var command string
+		if err := node.Decode(&command); err != nil {
+			return err
+		}
+		argv, err := SplitCommand(command)
+		if err != nil {
+			return fmt.Errorf("line %d: command: %w", node.Line, err)
+		}
+		*a = argv
+		return nil
+	}
+	var argv []string
+	if err := node.Decode(&argv); err != nil {
+		return err
+	}
+	*a = argv
+	return nil
+}
+
+// commandConfig is a startup command given either as a string or as an
+// object that also says what to wait for before sending it.
+type commandConfig struct {
+	Run     string `yaml:"run"`
+	Until   string `yaml:"until"`
+	Pattern string `yaml:"pattern"`
+	Quiet   string `yaml:"quiet" schema:"duration"`
+	Timeout string `yaml:"timeout" schema:"duration"`
+}
+
+func (c *commandConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.Run)
+	}
+	// Decoding through a node drops the decoder's KnownFields setting, so
+	// unknown keys are rejected here.
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			switch key := node.Content[i]; key.Value {
+			case "run", "until", "pattern", "quiet", "timeout":
+			default:
+				return fmt.Errorf("line %d: field %s not found in startup command", key.Line, key.Value)
+			}
+		}
+	}
+	type plain commandConfig
+	return node.Decode((*plain)(c))
+}
+
+type waitConfig struct {
+	Until   string `yaml:"until"`
+	Pattern string `yaml:"pattern"`
+	Quiet   string `yaml:"quiet" schema:"duration"`
+	Timeout string `yaml:"timeout" schema:"duration"`
+}
+
+type inheritEnvConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+type sandboxConfig struct {
+	ReadOnly   []string `yaml:"read-only"`
+	PrivateTmp bool     `yaml:"private-tmp"`
+}
+
+type limitsConfig struct {
+	Memory    string `yaml:"memory" schema:"size"`
+	CPUTime   string `yaml:"cpu-time" schema:"duration"`
+	OpenFiles int    `yaml:"open-files"`
+	Processes int    `yaml:"processes"`
+	CoreSize  string `yaml:"core-size" schema:"size"`
 }
 
 // buildConfigYAML produces a conf.yaml string for the given theme name and color map.
@@ -240,8 +378,35 @@ func ReadThemeNames(path string) ([]string, error) {
 	return names, nil
 }
 
+// ReadProfileEnv reads the config file at path and returns the env section of
+// each profile, keyed by profile name in lower case as viper reports it. The
+// variable names keep their exact case as written in the YAML.
+func ReadProfileEnv(path string) (map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Profiles map[string]struct {
+			Env map[string]string `yaml:"env"`
+		} `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	envs := make(map[string]map[string]string, len(raw.Profiles))
+	for name, p := range raw.Profiles {
+		if len(p.Env) > 0 {
+			envs[strings.ToLower(name)] = p.Env
+		}
+	}
+	return envs, nil
+}
+
 // SaveProfileToConfig reads the existing config file at configPath (creating it if
 // absent), upserts the named profile in the profiles section, and writes the file back.
+// Keys already present in an existing entry that are not written here, such as
+// settings the browser profile editor does not expose, are preserved.
 func SaveProfileToConfig(configPath string, name string, p Profile) error {
 	if configPath == "" {
 		home, err := os.UserHomeDir()
@@ -267,12 +432,100 @@ func SaveProfileToConfig(configPath string, name string, p Profile) error {
 		cfg["profiles"] = profilesSection
 	}
 
-	entry := map[string]any{
-		"shell":             p.Shell,
-		"title":            p.Title,
-		"working-directory": p.WorkingDirectory,
-		"root":             p.Root,
-		"commands":         p.Commands,
+	entry, ok := profilesSection[name].(map[string]any)
+	if !ok {
+		entry = map[string]any{}
+	}
+	entry["shell"] = p.Shell
+	entry["title"] = p.Title
+	entry["working-directory"] = p.WorkingDirectory
+	entry["root"] = p.Root
+	entry["commands"] = commandEntries(p)
+	if w := waitEntry(p.CommandWait); len(w) > 0 {
+		entry["command-wait"] = w
+	} else {
+		delete(entry, "command-wait")
+	}
+	if p.Type != "" {
+		entry["type"] = p.Type
+	}
+	if len(p.Command) > 0 {
+		entry["command"] = p.Command
+	}
+	if p.LoginShell {
+		entry["login-shell"] = true
+	}
+	if p.Argv0 != "" {
+		entry["argv0"] = p.Argv0
+	}
+	if p.User != "" {
+		entry["user"] = p.User
+	}
+	if p.Group != "" {
+		entry["group"] = p.Group
+	}
+	if p.IdleTimeout != 0 {
+		entry["idle-timeout"] = max(p.IdleTimeout, 0).String()
+	}
+	if p.MaxSessionDuration != 0 {
+		entry["max-session-duration"] = max(p.MaxSessionDuration, 0).String()
+	}
+	if p.MaxSessions != 0 {
+		entry["max-sessions"] = p.MaxSessions
+	}
+	if p.OnExit != "" {
+		entry["on-exit"] = p.OnExit
+	}
+	if p.RestartLimit != 0 {
+		entry["restart-limit"] = p.RestartLimit
+	}
+	if p.RestartBackoff != 0 {
+		entry["restart-backoff"] = p.RestartBackoff.String()
+	}
+	if len(p.Env) > 0 {
+		entry["env"] = p.Env
+	}
+	if len(p.EnvFiles) > 0 {
+		entry["env-file"] = p.EnvFiles
+	}
+	if len(p.InheritEnv.Allow) > 0 || len(p.InheritEnv.Deny) > 0 {
+		inherit := map[string]any{}
+		if len(p.InheritEnv.Allow) > 0 {
+			inherit["allow"] = p.InheritEnv.Allow
+		}
+		if len(p.InheritEnv.Deny) > 0 {
+			inherit["deny"] = p.InheritEnv.Deny
+		}
+		entry["inherit-env"] = inherit
+	}
+	if len(p.Sandbox.ReadOnly) > 0 || p.Sandbox.PrivateTmp {
+		sandbox := map[string]any{}
+		if len(p.Sandbox.ReadOnly) > 0 {
+			sandbox["read-only"] = p.Sandbox.ReadOnly
+		}
+		if p.Sandbox.PrivateTmp {
+			sandbox["private-tmp"] = true
+		}
+		entry["sandbox"] = sandbox
+	}
+	if p.Limits.needsInit() {
+		limits := map[string]any{}
+		if p.Limits.Memory > 0 {
+			limits["memory"] = p.Limits.Memory
+		}
+		if p.Limits.CPUTime > 0 {
+			limits["cpu-time"] = p.Limits.CPUTime.String()
+		}
+		if p.Limits.OpenFiles > 0 {
+			limits["open-files"] = p.Limits.OpenFiles
+		}
+		if p.Limits.Processes > 0 {
+			limits["processes"] = p.Limits.Processes
+		}
+		if p.Limits.CoreSize != 0 {
+			limits["core-size"] = max(p.Limits.CoreSize, 0)
+		}
+		entry["limits"] = limits
 	}
 	profilesSection[name] = entry
 
@@ -286,6 +539,40 @@ func SaveProfileToConfig(configPath string, name string, p Profile) error {
 	return os.WriteFile(configPath, out, 0644)
 }
 
+// commandEntries returns the commands setting for p. Commands with their own
+// wait condition are written as objects and the rest as plain strings.
+func commandEntries(p Profile) []any {
+	entries := make([]any, len(p.Commands))
+	for i, command := range p.Commands {
+		entries[i] = command
+		if i < len(p.CommandWaits) {
+			if w := waitEntry(p.CommandWaits[i]); len(w) > 0 {
+				w["run"] = command
+				entries[i] = w
+			}
+		}
+	}
+	return entries
+}
+
+// waitEntry returns the set fields of w keyed by their config names.
+func waitEntry(w WaitCondition) map[string]any {
+	entry := map[string]any{}
+	if w.Until != "" {
+		entry["until"] = w.Until
+	}
+	if w.Pattern != "" {
+		entry["pattern"] = w.Pattern
+	}
+	if w.Quiet != 0 {
+		entry["quiet"] = w.Quiet.String()
+	}
+	if w.Timeout != 0 {
+		entry["timeout"] = w.Timeout.String()
+	}
+	return entry
+}
+
 // DeleteProfileFromConfig reads the existing config file at configPath, removes the
 // named entry from the profiles section, and writes the file back. No-ops if the
 // profiles section or the named entry is absent.
@@ -2,7 +2,9 @@ package src
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,12 +34,25 @@ server:
   no-auth: false
   no-browser: false
   port: 8443
+  max-sessions: 20
+  audit-log: /var/log/b3tty/audit.log
+  metrics:
+    enabled: true
+    address: 127.0.0.1:9090
+log:
+  format: json
+  level: info
+  file: /var/log/b3tty/b3tty.log
+  max-size: 50M
+  max-files: 3
 terminal:
   font-family: "monospace"
   font-size: 14
   cursor-blink: true
   rows: 24
   columns: 80
+  idle-timeout: 30m
+  max-session-duration: 24h
 theme: "my-theme"
 themes:
   my-theme:
@@ -54,10 +69,69 @@ profiles:
     shell: "/bin/zsh"
     commands:
       - "echo hello"
+      - run: make watch
+        until: pattern
+        pattern: '\$ $'
+        timeout: 30s
+    command-wait:
+      until: prompt
+      quiet: 1s
+    env:
+      EDITOR: vim
+      PORT: 8080
+    env-file:
+      - "~/.config/work.env"
+    inherit-env:
+      allow: ["PATH", "HOME", "LC_*"]
+      deny: ["AWS_*"]
+  bastion:
+    command: ["ssh", "prod-bastion"]
+  notes:
+    command: "nvim '~/my notes.md'"
+    argv0: editor
+  login:
+    login-shell: true
+  demo:
+    root: ~/sandboxes/demo
+    sandbox:
+      read-only: ["/usr", "~/demo:/srv/demo"]
+      private-tmp: true
+  capped:
+    limits:
+      memory: 2GiB
+      cpu-time: 1h
+      open-files: 1024
+      processes: 256
+      core-size: 0
+  locked:
+    user: deploy
+    group: www-data
+    idle-timeout: 5m
+    max-session-duration: 0s
+    max-sessions: 2
 `)
 		assert.NoError(t, ValidateConfig(path))
 	})
 
+	t.Run("command string with unbalanced quotes fails", func(t *testing.T) {
+		path := writeTempConfig(t, `
+profiles:
+  broken:
+    command: "echo 'oops"
+`)
+		assert.ErrorContains(t, ValidateConfig(path), "command")
+	})
+
+	t.Run("command of the wrong type fails", func(t *testing.T) {
+		path := writeTempConfig(t, `
+profiles:
+  broken:
+    command:
+      program: ssh
+`)
+		assert.Error(t, ValidateConfig(path))
+	})
+
 	t.Run("empty config passes", func(t *testing.T) {
 		path := writeTempConfig(t, "")
 		assert.NoError(t, ValidateConfig(path))
@@ -163,6 +237,17 @@ terminal:
 		assert.Error(t, err)
 	})
 
+	t.Run("unknown key in a startup command is rejected", func(t *testing.T) {
+		path := writeTempConfig(t, `
+profiles:
+  work:
+    commands:
+      - run: ls
+        wait: prompt
+`)
+		assert.ErrorContains(t, ValidateConfig(path), "field wait not found")
+	})
+
 	t.Run("wrong type for commands is rejected", func(t *testing.T) {
 		path := writeTempConfig(t, `
 profiles:
@@ -578,6 +663,26 @@ themes:
 // SaveProfileToConfig
 // ---------------------------------------------------------------------------
 
+func TestReadProfileEnv(t *testing.T) {
+	path := writeTempConfig(t, `
+profiles:
+  Work:
+    env:
+      GOPATH: ~/go
+      http_proxy: http://proxy:3128
+  plain:
+    shell: /bin/sh
+`)
+	envs, err := ReadProfileEnv(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		"work": {"GOPATH": "~/go", "http_proxy": "http://proxy:3128"},
+	}, envs)
+
+	_, err = ReadProfileEnv(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
 func TestSaveProfileToConfig(t *testing.T) {
 	readConfig := func(path string) map[string]any {
 		t.Helper()
@@ -615,6 +720,26 @@ func TestSaveProfileToConfig(t *testing.T) {
 		assert.Equal(t, "echo ready", commands[1])
 	})
 
+	t.Run("writes startup command wait conditions", func(t *testing.T) {
+		path := writeTempConfig(t, `
+profiles:
+  dev:
+    command-wait:
+      until: quiet
+`)
+		p := profile("/bin/zsh", "", "", "", []string{"ls", "make"})
+		p.CommandWaits = []WaitCondition{{}, {Until: WAIT_PATTERN, Pattern: `\$ $`, Timeout: time.Minute}}
+		require.NoError(t, SaveProfileToConfig(path, "dev", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["dev"].(map[string]any)
+		assert.Equal(t, []any{"ls", map[string]any{"run": "make", "until": "pattern", "pattern": `\$ $`, "timeout": "1m0s"}}, entry["commands"])
+		assert.NotContains(t, entry, "command-wait")
+
+		p.CommandWait = WaitCondition{Until: WAIT_PROMPT, Quiet: time.Second}
+		require.NoError(t, SaveProfileToConfig(path, "dev", p))
+		entry = readConfig(path)["profiles"].(map[string]any)["dev"].(map[string]any)
+		assert.Equal(t, map[string]any{"until": "prompt", "quiet": "1s"}, entry["command-wait"])
+	})
+
 	t.Run("overwrites existing profile entry", func(t *testing.T) {
 		path := writeTempConfig(t, `
 profiles:
@@ -629,6 +754,122 @@ profiles:
 		assert.Equal(t, "New Title", entry["title"])
 	})
 
+	t.Run("preserves keys it does not manage and writes type", func(t *testing.T) {
+		path := writeTempConfig(t, `
+profiles:
+  dev:
+    shell: /bin/bash
+    custom: kept
+`)
+		p := profile("/bin/zsh", "Dev", "~/dev", "/", nil)
+		p.Type = "local"
+		require.NoError(t, SaveProfileToConfig(path, "dev", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["dev"].(map[string]any)
+		assert.Equal(t, "kept", entry["custom"])
+		assert.Equal(t, "local", entry["type"])
+		assert.Equal(t, "/bin/zsh", entry["shell"])
+	})
+
+	t.Run("omits empty type", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		require.NoError(t, SaveProfileToConfig(path, "dev", profile("/bin/zsh", "", "", "", nil)))
+		entry := readConfig(path)["profiles"].(map[string]any)["dev"].(map[string]any)
+		assert.NotContains(t, entry, "type")
+		assert.NotContains(t, entry, "on-exit")
+		assert.NotContains(t, entry, "restart-limit")
+		assert.NotContains(t, entry, "restart-backoff")
+	})
+
+	t.Run("writes the on-exit policy", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("htop", "", "", "", nil)
+		p.OnExit = ON_EXIT_RESTART
+		p.RestartLimit = -1
+		p.RestartBackoff = 1500 * time.Millisecond
+		require.NoError(t, SaveProfileToConfig(path, "dash", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["dash"].(map[string]any)
+		assert.Equal(t, "restart", entry["on-exit"])
+		assert.Equal(t, -1, entry["restart-limit"])
+		assert.Equal(t, "1.5s", entry["restart-backoff"])
+	})
+
+	t.Run("writes the sandbox", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "/srv/jail", nil)
+		p.Sandbox = Sandbox{ReadOnly: []string{"/usr"}, PrivateTmp: true}
+		require.NoError(t, SaveProfileToConfig(path, "jail", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["jail"].(map[string]any)
+		assert.Equal(t, "/srv/jail", entry["root"])
+		assert.Equal(t, map[string]any{"read-only": []any{"/usr"}, "private-tmp": true}, entry["sandbox"])
+	})
+
+	t.Run("writes the limits", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "", nil)
+		p.Limits = Limits{Memory: 1 << 30, CPUTime: time.Hour, OpenFiles: 256, CoreSize: -1}
+		require.NoError(t, SaveProfileToConfig(path, "capped", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["capped"].(map[string]any)
+		assert.Equal(t, map[string]any{"memory": 1 << 30, "cpu-time": "1h0m0s", "open-files": 256, "core-size": 0}, entry["limits"])
+	})
+
+	t.Run("writes the command", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "", nil)
+		p.Command = []string{"htop", "-d", "5"}
+		p.Argv0 = "top"
+		require.NoError(t, SaveProfileToConfig(path, "top", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["top"].(map[string]any)
+		assert.Equal(t, []any{"htop", "-d", "5"}, entry["command"])
+		assert.Equal(t, "top", entry["argv0"])
+		assert.NotContains(t, entry, "login-shell")
+		assert.NotContains(t, entry, "user")
+	})
+
+	t.Run("writes the session timeouts", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "", nil)
+		p.IdleTimeout = 5 * time.Minute
+		p.MaxSessionDuration = -1
+		require.NoError(t, SaveProfileToConfig(path, "brief", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["brief"].(map[string]any)
+		assert.Equal(t, "5m0s", entry["idle-timeout"])
+		assert.Equal(t, "0s", entry["max-session-duration"], "a disabled timeout is written as zero")
+		assert.NotContains(t, entry, "max-sessions")
+	})
+
+	t.Run("writes the session limit", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "", nil)
+		p.MaxSessions = 2
+		require.NoError(t, SaveProfileToConfig(path, "shared", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["shared"].(map[string]any)
+		assert.Equal(t, 2, entry["max-sessions"])
+	})
+
+	t.Run("writes the user and group", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "", nil)
+		p.User = "deploy"
+		p.Group = "www-data"
+		require.NoError(t, SaveProfileToConfig(path, "deploy", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["deploy"].(map[string]any)
+		assert.Equal(t, "deploy", entry["user"])
+		assert.Equal(t, "www-data", entry["group"])
+	})
+
+	t.Run("writes the environment", func(t *testing.T) {
+		path := writeTempConfig(t, "")
+		p := profile("", "", "", "", nil)
+		p.Env = map[string]string{"EDITOR": "vim"}
+		p.EnvFiles = []string{".env"}
+		p.InheritEnv = EnvFilter{Deny: []string{"AWS_*"}}
+		require.NoError(t, SaveProfileToConfig(path, "dev", p))
+		entry := readConfig(path)["profiles"].(map[string]any)["dev"].(map[string]any)
+		assert.Equal(t, map[string]any{"EDITOR": "vim"}, entry["env"])
+		assert.Equal(t, []any{".env"}, entry["env-file"])
+		assert.Equal(t, map[string]any{"deny": []any{"AWS_*"}}, entry["inherit-env"])
+	})
+
 	t.Run("preserves other profiles", func(t *testing.T) {
 		path := writeTempConfig(t, `
 profiles:
@@ -0,0 +1,147 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// credential is the user and groups a profile's shell runs as, resolved
+// from the profile's User and Group.
+type credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+	// Name, Home and Shell are the user's name, home directory and login
+	// shell. They are empty when the profile only sets a Group, and Shell is
+	// empty when the user is not listed in PASSWD_FILE.
+	Name  string
+	Home  string
+	Shell string
+}
+
+// lookupCredential resolves p's User and Group from the system's user
+// database, usually /etc/passwd and /etc/group. Either may be a name or a
+// numeric ID. A User brings its primary group and supplementary groups, and
+// a Group replaces the primary group. It returns nil when p sets neither.
+func (p Profile) lookupCredential() (*credential, error) {
+	if p.User == "" && p.Group == "" {
+		return nil, nil
+	}
+	c := &credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if p.User != "" {
+		u, err := lookupUser(p.User)
+		if err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		c.Uid, c.Gid, c.Name, c.Home = uint32(uid), uint32(gid), u.Username, u.HomeDir
+		c.Shell = loginShell(PASSWD_FILE, u.Username)
+		groups, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("groups of user %q: %w", p.User, err)
+		}
+		for _, g := range groups {
+			if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+				c.Groups = append(c.Groups, uint32(id))
+			}
+		}
+	} else {
+		groups, err := os.Getgroups()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			c.Groups = append(c.Groups, uint32(g))
+		}
+	}
+	if p.Group != "" {
+		g, err := lookupGroup(p.Group)
+		if err != nil {
+			return nil, err
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		c.Gid = uint32(gid)
+	}
+	return c, nil
+}
+
+// lookupUser finds a user by name, or by ID when name is numeric.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+// loginShell returns the login shell of the user called name in passwd, a
+// file in the format of /etc/passwd, or "" when the user is not listed there.
+// os/user does not report it.
+func loginShell(passwd, name string) string {
+	f, err := os.Open(passwd)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == name {
+			return fields[6]
+		}
+	}
+	return ""
+}
+
+// lookupGroup finds a group by name, or by ID when name is numeric.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+// sysProcAttr returns the credential in the form exec.Cmd uses to switch to
+// it before starting a program.
+func (c *credential) sysProcAttr() *syscall.Credential {
+	return &syscall.Credential{Uid: c.Uid, Gid: c.Gid, Groups: c.Groups}
+}
+
+// checkPrivileges reports an error when b3tty lacks the privileges needed to
+// start a process as c. Setting the groups always needs CAP_SETGID, and
+// changing the user also needs CAP_SETUID.
+func (c *credential) checkPrivileges() error {
+	needed := []capability{capSetgid}
+	if c.Uid != uint32(os.Getuid()) {
+		needed = append(needed, capSetuid)
+	}
+	for _, capability := range needed {
+		ok, err := hasCapability(capability)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("starting a shell as another user or group needs %s", capability)
+		}
+	}
+	return nil
+}
+
+// validateCredential reports an error when p's User or Group does not exist,
+// when b3tty lacks the privileges to switch to them, or when they are
+// combined with a sandbox, whose user namespace only maps b3tty's own user.
+func (p Profile) validateCredential() error {
+	c, err := p.lookupCredential()
+	if err != nil || c == nil {
+		return err
+	}
+	if p.sandboxed() {
+		return fmt.Errorf("user and group cannot be used with a sandbox")
+	}
+	return c.checkPrivileges()
+}
@@ -0,0 +1,35 @@
+package src
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// capability is a Linux capability, named for error messages.
+type capability int
+
+const (
+	capSetgid capability = unix.CAP_SETGID
+	capSetuid capability = unix.CAP_SETUID
+)
+
+func (c capability) String() string {
+	switch c {
+	case capSetgid:
+		return "CAP_SETGID"
+	case capSetuid:
+		return "CAP_SETUID"
+	}
+	return "capability " + strconv.Itoa(int(c))
+}
+
+// hasCapability reports whether c is in b3tty's effective capability set.
+func hasCapability(c capability) (bool, error) {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return false, err
+	}
+	return data[c/32].Effective&(1<<(uint(c)%32)) != 0, nil
+}
@@ -0,0 +1,72 @@
+package src
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	if os.Geteuid() == 0 {
+		ok, err := hasCapability(capSetuid)
+		require.NoError(t, err)
+		assert.True(t, ok, "root has every capability")
+		assert.NoError(t, (&credential{Uid: 65534}).checkPrivileges())
+		return
+	}
+	assert.EqualError(t, (&credential{Uid: 0}).checkPrivileges(),
+		"starting a shell as another user or group needs CAP_SETGID")
+}
+
+func TestRunAsUser(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	if ok, _ := hasCapability(capSetuid); !ok {
+		t.Skip("switching users needs CAP_SETUID")
+	}
+
+	t.Run("the shell runs as the user with its home directory", func(t *testing.T) {
+		p := Profile{User: "nobody", WorkingDirectory: "/"}
+		out := runProfile(t, p, `id -u; id -g; echo $HOME $USER $LOGNAME`)
+		assert.Equal(t, nobody.Uid+"\n"+nobody.Gid+"\n"+nobody.HomeDir+" nobody nobody\n", out)
+	})
+
+	t.Run("b3tty's environment is withheld", func(t *testing.T) {
+		t.Setenv("B3TTY_TEST_SECRET", "s3cr3t")
+		out := runProfile(t, Profile{User: "nobody", WorkingDirectory: "/"}, `echo ${B3TTY_TEST_SECRET:-unset} $SHELL`)
+		assert.Equal(t, "unset "+loginShell(PASSWD_FILE, "nobody")+"\n", out)
+
+		p := Profile{User: "nobody", WorkingDirectory: "/", InheritEnv: EnvFilter{Allow: []string{"B3TTY_*"}}}
+		assert.Equal(t, "s3cr3t\n", runProfile(t, p, `echo ${B3TTY_TEST_SECRET:-unset}`))
+	})
+
+	t.Run("the pty belongs to the user", func(t *testing.T) {
+		out := runProfile(t, Profile{User: "nobody", WorkingDirectory: "/"}, `stat -c %u "$(tty)"`)
+		assert.Equal(t, nobody.Uid+"\n", out)
+	})
+
+	t.Run("a group alone keeps the user", func(t *testing.T) {
+		group, err := user.LookupGroupId(nobody.Gid)
+		require.NoError(t, err)
+		out := runProfile(t, Profile{Group: group.Name}, `id -u; id -g`)
+		assert.Equal(t, strconv.Itoa(os.Getuid())+"\n"+nobody.Gid+"\n", out)
+	})
+
+	t.Run("the user's home is the default working directory", func(t *testing.T) {
+		c, err := (&Profile{User: "daemon"}).lookupCredential()
+		if err != nil {
+			t.Skipf("no daemon user: %v", err)
+		}
+		if _, err := os.Stat(c.Home); err != nil {
+			t.Skipf("daemon has no home directory: %v", err)
+		}
+		out := runProfile(t, Profile{User: "daemon", WorkingDirectory: DEFAULT_WORKING_DIRECTORY}, `pwd`)
+		assert.Equal(t, c.Home+"\n", out)
+	})
+}
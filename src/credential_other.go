@@ -0,0 +1,19 @@
+//go:build !linux
+
+package src
+
+import "os"
+
+// capability is a Linux capability, named for error messages. Other systems
+// grant them all to root and none to other users.
+type capability string
+
+const (
+	capSetgid capability = "root privileges"
+	capSetuid capability = "root privileges"
+)
+
+// hasCapability reports whether b3tty runs as root.
+func hasCapability(c capability) (bool, error) {
+	return os.Geteuid() == 0, nil
+}
@@ -0,0 +1,72 @@
+package src
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupCredential(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	t.Run("no user or group", func(t *testing.T) {
+		c, err := Profile{}.lookupCredential()
+		assert.NoError(t, err)
+		assert.Nil(t, c)
+	})
+
+	t.Run("user by name and by ID", func(t *testing.T) {
+		for _, name := range []string{current.Username, current.Uid} {
+			c, err := Profile{User: name}.lookupCredential()
+			require.NoError(t, err)
+			assert.Equal(t, uint32(os.Getuid()), c.Uid)
+			assert.Equal(t, current.Username, c.Name)
+			assert.Equal(t, current.HomeDir, c.Home)
+		}
+	})
+
+	t.Run("group replaces the primary group", func(t *testing.T) {
+		group, err := user.LookupGroupId(current.Gid)
+		require.NoError(t, err)
+		c, err := Profile{Group: group.Name}.lookupCredential()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(os.Getuid()), c.Uid)
+		assert.Empty(t, c.Name, "a group alone keeps b3tty's home directory")
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, err := Profile{User: "b3tty-no-such-user"}.lookupCredential()
+		assert.ErrorContains(t, err, "b3tty-no-such-user")
+	})
+
+	t.Run("unknown group", func(t *testing.T) {
+		_, err := Profile{Group: "b3tty-no-such-group"}.lookupCredential()
+		assert.ErrorContains(t, err, "b3tty-no-such-group")
+	})
+}
+
+func TestLoginShell(t *testing.T) {
+	passwd := filepath.Join(t.TempDir(), "passwd")
+	require.NoError(t, os.WriteFile(passwd, []byte("root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000:Alice:/home/alice:/usr/bin/zsh\n"), 0644))
+	assert.Equal(t, "/usr/bin/zsh", loginShell(passwd, "alice"))
+	assert.Equal(t, "", loginShell(passwd, "bob"))
+	assert.Equal(t, "", loginShell(filepath.Join(t.TempDir(), "missing"), "alice"))
+}
+
+func TestValidateCredential(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	assert.NoError(t, Profile{}.validateCredential())
+	assert.EqualError(t, Profile{User: current.Username, Root: "/srv/jail"}.validateCredential(),
+		"user and group cannot be used with a sandbox")
+
+	ts := newTestTerminalServer()
+	ts.Profiles["ghost"] = Profile{User: "b3tty-no-such-user"}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "profile ghost: user: unknown user b3tty-no-such-user")
+}
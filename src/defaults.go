@@ -1,5 +1,7 @@
 package src
 
+import "time"
+
 const DEFAULT_TITLE = "b3tty"
 const DEFAULT_ROOT = "/"
 const DEFAULT_WORKING_DIRECTORY = "$HOME"
@@ -11,9 +13,85 @@ const DEFAULT_FONT_FAMILY = "na"
 const DEFAULT_FONT_SIZE = 14
 const DEFAULT_CURSOR_BLINK = true
 const DEFAULT_PROFILE_NAME = "default"
+const DEFAULT_BACKEND = "local"
+const DEFAULT_TERM = "xterm-256color"
+const DEFAULT_COLORTERM = "truecolor"
+const PASSWD_FILE = "/etc/passwd"
+const TTY_GROUP = "tty"
 const BUFFER_SIZE = 4096
+const MAX_READ_BUFFER_SIZE = 1 << 20
+const DEFAULT_OUTPUT_BATCH_WINDOW = 5 * time.Millisecond
+const DEFAULT_OUTPUT_BATCH_SIZE = 64 * 1024
+const DEFAULT_FLOW_CONTROL_WINDOW = 256 * 1024
+const DEFAULT_COMPRESSION_LEVEL = 1
+const DEFAULT_COMPRESSION_THRESHOLD = 256
+const PROTOCOL_V1 = "b3tty.v1"
+const WS_WRITE_TIMEOUT = 10 * time.Second
+const EXIT_WAIT_TIMEOUT = 5 * time.Second
+const DEFAULT_KILL_GRACE_PERIOD = 5 * time.Second
+const KILL_POLL_INTERVAL = 50 * time.Millisecond
+const DEFAULT_SHUTDOWN_DRAIN_PERIOD = 10 * time.Second
+const DRAIN_POLL_INTERVAL = 100 * time.Millisecond
+
+// READINESS_CACHE_TTL is how long /readyz serves the results of its checks
+// before making them again.
+const READINESS_CACHE_TTL = 5 * time.Second
+
+// SESSION_TIMEOUT_WARNING is how long before an idle timeout or the maximum
+// session duration closes a session that the client is warned.
+const SESSION_TIMEOUT_WARNING = time.Minute
+
+// On-exit policies of a profile; see Profile.OnExit.
+const ON_EXIT_CLOSE = "close"
+const ON_EXIT_RESTART = "restart"
+const ON_EXIT_RESTART_ON_FAILURE = "restart-on-failure"
+const ON_EXIT_PROMPT = "prompt"
+
+// Wait conditions of a profile's startup commands; see WaitCondition.
+const WAIT_AUTO = "auto"
+const WAIT_PROMPT = "prompt"
+const WAIT_PATTERN = "pattern"
+const WAIT_QUIET = "quiet"
+const WAIT_NONE = "none"
+
+const DEFAULT_COMMAND_QUIET = 500 * time.Millisecond
+const DEFAULT_COMMAND_TIMEOUT = 10 * time.Second
+const MAX_WATCH_BUFFER = 64 * 1024
+const MAX_SESSION_NOTICES = 8
+
+// INIT_PROCESS_NAME is the argv[0] under which the b3tty executable runs as
+// the init process that sets up a profile's sandbox and resource limits, and
+// INIT_FAILED_EXIT_CODE its exit code when it cannot.
+const INIT_PROCESS_NAME = "b3tty-init"
+const INIT_FAILED_EXIT_CODE = 125
+
+// CGROUP_ROOT is where the cgroup v2 hierarchy is expected to be mounted.
+// CGROUP_SERVER_NAME is the child cgroup b3tty moves itself into so that it
+// can enable controllers for the cgroups of sessions.
+const CGROUP_ROOT = "/sys/fs/cgroup"
+const CGROUP_SERVER_NAME = "b3tty"
+const CGROUP_REMOVE_POLL_INTERVAL = time.Second
+
+const DEFAULT_RESTART_LIMIT = 5
+const DEFAULT_RESTART_BACKOFF = time.Second
+const MAX_RESTART_BACKOFF = 30 * time.Second
+const RESTART_RESET_AFTER = time.Minute
+const DEFAULT_PING_INTERVAL = 25 * time.Second
+const DEFAULT_PONG_TIMEOUT = 60 * time.Second
 const MAX_REQUEST_BODY_SIZE = 4096
 const TOKEN_LENGTH = 24
 const CONFIG_FILE_NAME = "conf.yaml"
 const DOT_CONFIG_PATH = ".config"
 const B3TTY_CONFIG_PATH = "b3tty"
+
+// Log formats; see LogConfig.Format.
+const LOG_FORMAT_TEXT = "text"
+const LOG_FORMAT_JSON = "json"
+
+const DEFAULT_LOG_MAX_SIZE = 10 << 20
+const DEFAULT_LOG_MAX_FILES = 5
+const MAX_AUDIT_LINE_SIZE = 64 * 1024
+
+// METRICS_CONTENT_TYPE is the media type of the Prometheus text exposition
+// format served at /metrics.
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
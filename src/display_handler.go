@@ -3,6 +3,7 @@ package src
 import (
 	_ "embed"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
@@ -15,6 +16,10 @@ import (
 //go:embed templates/terminal.tmpl
 var templ string
 
+// termTemplate is parsed once at startup. It panics on error since the template
+// is embedded at compile time and must always be valid.
+var termTemplate = template.Must(template.New("b3tty").Parse(templ))
+
 const (
 	backoffBase = time.Second
 	backoffMax  = 30 * time.Second
@@ -92,9 +97,10 @@ func (ts *TerminalServer) setSizeHandler(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
-	ts.OrgCols, ts.OrgRows = parseSizeParams(r.URL.Query())
-	Debugf("extracted cols: %d", ts.OrgCols)
-	Debugf("extracted rows: %d", ts.OrgRows)
+	cols, rows := parseSizeParams(r.URL.Query())
+	ts.setInitialSize(cols, rows)
+	Debugf("extracted cols: %d", cols)
+	Debugf("extracted rows: %d", rows)
 }
 
 // displayTermHandler validates the auth token, selects the active profile, serialises
@@ -128,14 +134,17 @@ func (ts *TerminalServer) displayTermHandler(w http.ResponseWriter, r *http.Requ
 			Debug("requesting mutex lock")
 			ts.BackoffMu.Lock()
 			ts.FailedAttempts++
-			delay := authBackoffDelay(ts.FailedAttempts)
+			attempts := ts.FailedAttempts
+			delay := authBackoffDelay(attempts)
 			ts.BackoffMu.Unlock()
 			Debug("mutex unlocked")
-			Warnf("%s %s: forbidden: invalid or missing token (attempt %d, delay %s)", r.Method, r.URL.Path, ts.FailedAttempts, delay)
+			Warnf("%s %s: forbidden: invalid or missing token (attempt %d, delay %s)", r.Method, r.URL.Path, attempts, delay)
+			ts.audit(r, auditEvent{Event: auditAuthFailure, Path: r.URL.Path, Detail: fmt.Sprintf("invalid or missing token (attempt %d)", attempts)})
 			ts.AuthSleep(delay)
 		} else {
 			Warnf("%s %s: forbidden: invalid or missing token", r.Method, r.URL.Path)
 		}
+		ts.metrics.authFailure()
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
@@ -146,27 +155,21 @@ func (ts *TerminalServer) displayTermHandler(w http.ResponseWriter, r *http.Requ
 	ts.BackoffMu.Unlock()
 	Debug("mutex unlocked")
 
-	if ts.FirstRun {
+	if ts.isFirstRun() {
 		Debug("serving first run page....")
 		ts.renderSetupPage(w)
 		return
 	}
 
-	Debug("parsing HTML template....")
-	tmpl, err := template.New("b3tty").Parse(templ)
-	if err != nil {
-		Fatal(err)
-	}
+	// Work from snapshots so concurrent theme and profile edits cannot mutate
+	// the maps while they are being iterated below.
+	profiles := ts.profilesSnapshot()
+	profileName := resolveProfileName(query, profiles)
+	ts.setActiveProfileName(profileName)
+	Debugf("resolved profile name: %s", profileName)
+	profile := profiles[profileName]
 
-	ts.ProfileName = resolveProfileName(query, ts.Profiles)
-	Debugf("resolved profile name: %s", ts.ProfileName)
-	profile := ts.Profiles[ts.ProfileName]
-
-	themeNames := make([]string, 0, len(ts.Themes))
-	for name := range ts.Themes {
-		themeNames = append(themeNames, name)
-	}
-	sort.Strings(themeNames)
+	themeNames := ts.themeNames()
 	Debugf("Theme names: %s", strings.Join(themeNames, ", "))
 
 	// allThemeNames is the union of built-in and user-defined theme names, used
@@ -179,7 +182,7 @@ func (ts *TerminalServer) displayTermHandler(w http.ResponseWriter, r *http.Requ
 			allThemeNames = append(allThemeNames, name)
 		}
 	}
-	for name := range ts.Themes {
+	for _, name := range themeNames {
 		if _, seen := allNameSet[name]; !seen {
 			allNameSet[name] = struct{}{}
 			allThemeNames = append(allThemeNames, name)
@@ -194,15 +197,16 @@ func (ts *TerminalServer) displayTermHandler(w http.ResponseWriter, r *http.Requ
 	}
 	sort.Strings(builtinNames)
 
-	profileNames := make([]string, 0, len(ts.Profiles))
-	for name := range ts.Profiles {
+	profileNames := make([]string, 0, len(profiles))
+	for name := range profiles {
 		profileNames = append(profileNames, name)
 	}
 	sort.Strings(profileNames)
 	Debugf("Profile names: %s", strings.Join(profileNames, ", "))
 
-	thm := ts.Client.Theme
-	cfgJSON, err := buildConfigJSON(ts.Server, ts.Client, &thm, themeNames, allThemeNames, builtinNames, profileNames, ts.ActiveTheme)
+	clnt := ts.clientSnapshot()
+	thm, activeThemeName := ts.activeTheme()
+	cfgJSON, err := buildConfigJSON(ts.Server, &clnt, &thm, themeNames, allThemeNames, builtinNames, profileNames, activeThemeName)
 	if err != nil {
 		Errorf("config serialization error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -224,7 +228,7 @@ func (ts *TerminalServer) displayTermHandler(w http.ResponseWriter, r *http.Requ
 	Debugf("config response body: %s", cfgPayload)
 	Debugf("title: %s", profile.Title)
 	Debugf("nonce: %s", nonce)
-	err = tmpl.Execute(w, TemplateProps{ConfigJSON: cfgPayload, Title: profile.Title, ProfileName: ts.ProfileName, Nonce: nonce})
+	err = termTemplate.Execute(w, TemplateProps{ConfigJSON: cfgPayload, Title: profile.Title, ProfileName: profileName, Nonce: nonce})
 	if err != nil {
 		Errorf("response error: %v", err)
 		return
@@ -234,7 +238,8 @@ func (ts *TerminalServer) displayTermHandler(w http.ResponseWriter, r *http.Requ
 // backgroundHandler serves the configured background image file, if any.
 // Returns 404 when no background image is configured or the file cannot be found.
 func (ts *TerminalServer) backgroundHandler(w http.ResponseWriter, r *http.Request) {
-	imagePath := ts.Client.Theme.BackgroundImage
+	thm, _ := ts.activeTheme()
+	imagePath := thm.BackgroundImage
 	if imagePath == "" {
 		http.NotFound(w, r)
 		return
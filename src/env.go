@@ -0,0 +1,241 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// EnvFilter selects which of b3tty's own environment variables a profile's
+// shell inherits. Names in both lists may contain the wildcards understood by
+// path.Match, so LC_* matches every locale variable.
+type EnvFilter struct {
+	// Allow lists the variables that are inherited. An empty list inherits
+	// every variable that is not denied.
+	Allow []string
+	// Deny lists variables that are not inherited, even when allowed.
+	Deny []string
+}
+
+// keep reports whether the variable called name passes the filter.
+func (f EnvFilter) keep(name string) bool {
+	if len(f.Allow) > 0 && !matchesAny(f.Allow, name) {
+		return false
+	}
+	return !matchesAny(f.Deny, name)
+}
+
+// matchesAny reports whether name matches any of patterns. The patterns must
+// have been checked by EnvFilter.validate.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate reports an error naming the first malformed pattern.
+func (f EnvFilter) validate() error {
+	for _, pattern := range slices.Concat(f.Allow, f.Deny) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("inherit-env pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// validateEnv reports an error when p sets a variable with an invalid name or
+// has a malformed inherit-env pattern.
+func (p Profile) validateEnv() error {
+	for name := range p.Env {
+		if err := validateEnvName(name); err != nil {
+			return err
+		}
+	}
+	return p.InheritEnv.validate()
+}
+
+// validateEnvName reports an error unless name can be used as the name of an
+// environment variable.
+func validateEnvName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+	return nil
+}
+
+// credentialEnvNames are the variables of b3tty's environment that a shell
+// started as another user or group inherits when its profile has no
+// inherit-env allow list. The rest of b3tty's environment, which may hold its
+// own secrets, is withheld.
+var credentialEnvNames = []string{"PATH", "TERM", "LANG"}
+
+// minimalEnviron returns the variables of base named in credentialEnvNames.
+// When sameUser is set, as for a profile that only changes the group, the
+// variables describing the user, HOME, USER, LOGNAME and SHELL, are kept too.
+func minimalEnviron(base []string, sameUser bool) []string {
+	names := credentialEnvNames
+	if sameUser {
+		names = slices.Concat(names, []string{"HOME", "USER", "LOGNAME", "SHELL"})
+	}
+	var env []string
+	for _, kv := range base {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(names, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// environ builds the environment of p's shell from base, b3tty's own
+// environment in the form returned by os.Environ. The shell inherits the
+// variables of base that pass p.InheritEnv, with TERM and COLORTERM set to
+// match xterm.js. The variables of each of p.EnvFiles are then set in order,
+// followed by p.Env. Values in Env may refer to other variables as $NAME or
+// ${NAME}, which are expanded against the environment built before Env is
+// applied. A leading ~/ in an env file path is expanded to home.
+func (p Profile) environ(base []string, home string) ([]string, error) {
+	env := &environment{index: map[string]int{}}
+	for _, kv := range base {
+		name, value, _ := strings.Cut(kv, "=")
+		if p.InheritEnv.keep(name) {
+			env.set(name, value)
+		}
+	}
+	env.set("TERM", DEFAULT_TERM)
+	env.set("COLORTERM", DEFAULT_COLORTERM)
+
+	for _, file := range p.EnvFiles {
+		if strings.HasPrefix(file, "~/") {
+			file = strings.Replace(file, "~", home, 1)
+		}
+		vars, err := parseEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vars {
+			value := v.value
+			if v.expand {
+				value = os.Expand(value, env.get)
+			}
+			env.set(v.name, value)
+		}
+	}
+
+	names := make([]string, 0, len(p.Env))
+	for name := range p.Env {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	expanded := make([]string, len(names))
+	for i, name := range names {
+		expanded[i] = os.Expand(p.Env[name], env.get)
+	}
+	for i, name := range names {
+		env.set(name, expanded[i])
+	}
+	return env.vars, nil
+}
+
+// environment is an ordered set of environment variables.
+type environment struct {
+	// vars holds the variables in the NAME=value form used by exec.Cmd.Env.
+	vars  []string
+	index map[string]int
+}
+
+// set sets name to value, replacing an earlier value in place.
+func (e *environment) set(name, value string) {
+	if i, ok := e.index[name]; ok {
+		e.vars[i] = name + "=" + value
+		return
+	}
+	e.index[name] = len(e.vars)
+	e.vars = append(e.vars, name+"="+value)
+}
+
+// get returns the value of name, or "" when it is not set.
+func (e *environment) get(name string) string {
+	if i, ok := e.index[name]; ok {
+		_, value, _ := strings.Cut(e.vars[i], "=")
+		return value
+	}
+	return ""
+}
+
+// envVar is a variable read from an env file.
+type envVar struct {
+	name  string
+	value string
+	// expand is set unless the value was single-quoted, in which case it is
+	// used literally.
+	expand bool
+}
+
+// parseEnvFile reads the dotenv file at file. Each non-blank line that is not
+// a # comment has the form NAME=value, optionally preceded by export. A value
+// may be single-quoted, which makes it literal, or double-quoted, in which
+// case \n, \" and \\ are unescaped. Anything after " #" in an unquoted value
+// is a comment.
+func parseEnvFile(file string) ([]envVar, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+
+	var vars []envVar
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("env file %s:%d: expected NAME=value", file, n)
+		}
+		v := envVar{name: strings.TrimSpace(name), value: strings.TrimSpace(value), expand: true}
+		if err := validateEnvName(v.name); err != nil {
+			return nil, fmt.Errorf("env file %s:%d: %w", file, n, err)
+		}
+		switch quote := firstByte(v.value); quote {
+		case '"', '\'':
+			if len(v.value) < 2 || v.value[len(v.value)-1] != quote {
+				return nil, fmt.Errorf("env file %s:%d: unterminated quoted value", file, n)
+			}
+			v.value = v.value[1 : len(v.value)-1]
+			if quote == '\'' {
+				v.expand = false
+			} else {
+				v.value = dotenvUnescaper.Replace(v.value)
+			}
+		default:
+			if i := strings.Index(v.value, " #"); i >= 0 {
+				v.value = strings.TrimSpace(v.value[:i])
+			}
+		}
+		vars = append(vars, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("env file %s: %w", file, err)
+	}
+	return vars, nil
+}
+
+var dotenvUnescaper = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`)
+
+// firstByte returns the first byte of s, or 0 when s is empty.
+func firstByte(s string) byte {
+	if s == "" {
+		return 0
+	}
+	return s[0]
+}
@@ -0,0 +1,181 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEnvFile writes content to a file in a temporary directory and returns
+// its path.
+func writeEnvFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestEnvFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter EnvFilter
+		keep   []string
+		drop   []string
+	}{
+		{name: "zero value keeps everything", keep: []string{"PATH", "AWS_SECRET_ACCESS_KEY"}},
+		{
+			name:   "allow list",
+			filter: EnvFilter{Allow: []string{"PATH", "LC_*"}},
+			keep:   []string{"PATH", "LC_ALL", "LC_CTYPE"},
+			drop:   []string{"HOME", "LANG"},
+		},
+		{
+			name:   "deny list",
+			filter: EnvFilter{Deny: []string{"AWS_*", "TOKEN"}},
+			keep:   []string{"PATH", "TOKENS"},
+			drop:   []string{"AWS_REGION", "TOKEN"},
+		},
+		{
+			name:   "deny overrides allow",
+			filter: EnvFilter{Allow: []string{"*"}, Deny: []string{"SSH_AUTH_SOCK"}},
+			keep:   []string{"PATH"},
+			drop:   []string{"SSH_AUTH_SOCK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range tt.keep {
+				assert.True(t, tt.filter.keep(name), name)
+			}
+			for _, name := range tt.drop {
+				assert.False(t, tt.filter.keep(name), name)
+			}
+		})
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		errMsg  string
+	}{
+		{name: "empty"},
+		{name: "valid", profile: Profile{Env: map[string]string{"EDITOR": "vim"}, InheritEnv: EnvFilter{Allow: []string{"LC_*"}}}},
+		{name: "empty name", profile: Profile{Env: map[string]string{"": "x"}}, errMsg: "invalid environment variable name"},
+		{name: "name with =", profile: Profile{Env: map[string]string{"A=B": "x"}}, errMsg: "invalid environment variable name"},
+		{name: "bad pattern", profile: Profile{InheritEnv: EnvFilter{Deny: []string{"LC_["}}}, errMsg: "inherit-env pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.validateEnv()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	t.Run("parses dotenv syntax", func(t *testing.T) {
+		path := writeEnvFile(t, `# database settings
+DB_HOST=localhost
+export DB_PORT = 5432
+
+GREETING="hello\n\"world\""
+LITERAL='$HOME stays'
+URL=http://example.com/#anchor # trailing comment
+EMPTY=
+`)
+		vars, err := parseEnvFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, []envVar{
+			{name: "DB_HOST", value: "localhost", expand: true},
+			{name: "DB_PORT", value: "5432", expand: true},
+			{name: "GREETING", value: "hello\n\"world\"", expand: true},
+			{name: "LITERAL", value: "$HOME stays"},
+			{name: "URL", value: "http://example.com/#anchor", expand: true},
+			{name: "EMPTY", value: "", expand: true},
+		}, vars)
+	})
+
+	errorCases := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{name: "missing =", content: "A=1\nNOVALUE\n", errMsg: ":2: expected NAME=value"},
+		{name: "unterminated quote", content: `A="open`, errMsg: ":1: unterminated quoted value"},
+		{name: "empty name", content: "=1", errMsg: ":1: invalid environment variable name"},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseEnvFile(writeEnvFile(t, tt.content))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := parseEnvFile(filepath.Join(t.TempDir(), "missing"))
+		assert.ErrorContains(t, err, "env file")
+	})
+}
+
+func TestMinimalEnviron(t *testing.T) {
+	base := []string{"PATH=/usr/bin", "HOME=/home/me", "USER=me", "TERM=screen", "AWS_SECRET=s3cr3t", "LANG=C.UTF-8"}
+	assert.Equal(t, []string{"PATH=/usr/bin", "TERM=screen", "LANG=C.UTF-8"}, minimalEnviron(base, false))
+	assert.Equal(t, []string{"PATH=/usr/bin", "HOME=/home/me", "USER=me", "TERM=screen", "LANG=C.UTF-8"}, minimalEnviron(base, true))
+}
+
+func TestProfileEnviron(t *testing.T) {
+	base := []string{"PATH=/usr/bin", "HOME=/home/me", "TERM=screen", "AWS_SECRET=s3cr3t", "LC_ALL=C"}
+
+	t.Run("inherits everything and sets the terminal type", func(t *testing.T) {
+		env, err := Profile{}.environ(base, "/home/me")
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"PATH=/usr/bin", "HOME=/home/me", "TERM=xterm-256color", "AWS_SECRET=s3cr3t", "LC_ALL=C",
+			"COLORTERM=truecolor",
+		}, env)
+	})
+
+	t.Run("filters inherited variables", func(t *testing.T) {
+		p := Profile{InheritEnv: EnvFilter{Allow: []string{"PATH", "HOME", "LC_*", "AWS_*"}, Deny: []string{"AWS_*"}}}
+		env, err := p.environ(base, "/home/me")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"PATH=/usr/bin", "HOME=/home/me", "LC_ALL=C", "TERM=xterm-256color", "COLORTERM=truecolor"}, env)
+	})
+
+	t.Run("applies env files then env", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "base.env"), []byte("EDITOR=nano\nBIN=$HOME/bin\nRAW='$HOME'\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "local.env"), []byte("EDITOR=vi\n"), 0600))
+		p := Profile{
+			InheritEnv: EnvFilter{Allow: []string{"PATH", "HOME"}},
+			EnvFiles:   []string{"~/base.env", filepath.Join(dir, "local.env")},
+			Env: map[string]string{
+				"EDITOR":    "vim",
+				"PATH":      "${BIN}:$PATH",
+				"TERM":      "xterm",
+				"UNDEFINED": "[$NOPE]",
+			},
+		}
+		env, err := p.environ(base, dir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"PATH=/home/me/bin:/usr/bin", "HOME=/home/me", "TERM=xterm", "COLORTERM=truecolor",
+			"EDITOR=vim", "BIN=/home/me/bin", "RAW=$HOME", "UNDEFINED=[]",
+		}, env)
+	})
+
+	t.Run("reports unreadable env files", func(t *testing.T) {
+		p := Profile{EnvFiles: []string{filepath.Join(t.TempDir(), "missing.env")}}
+		_, err := p.environ(base, "/home/me")
+		assert.ErrorContains(t, err, "env file")
+	})
+}
@@ -0,0 +1,240 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Options configures a Handler created with NewHandler. Only Server is
+// required; every other field falls back to the same defaults used by
+// b3tty start.
+type Options struct {
+	// Server describes the address the browser uses to reach the handler.
+	// The terminal page builds its WebSocket and API URLs from Uri, Port and
+	// TLS.Enabled, so they must match the listener the Handler is served on.
+	Server *Server
+	// Client holds the terminal appearance settings. When nil, b3tty's
+	// default font, size and cursor settings are used.
+	Client *Client
+	// Profiles maps profile names to the shells they start. A "default"
+	// profile running $SHELL is added when none is provided.
+	Profiles map[string]Profile
+	// Themes maps user-defined theme names to their colors.
+	Themes map[string]Theme
+	// ActiveTheme names the entry in Themes, or a built-in theme, that is
+	// active when the page first loads.
+	ActiveTheme string
+	// ConfigFile is where theme and profile edits made in the browser are
+	// persisted. When empty, edits are written to $HOME/.config/b3tty/conf.yaml.
+	ConfigFile string
+	// Authorize, when set, is called for every request before it is routed,
+	// except for the /healthz and /readyz probes, which reveal nothing but
+	// the server's status. Requests for which it returns false receive 403
+	// Forbidden. Setting Authorize replaces b3tty's own access token, so no
+	// token is generated regardless of Server.NoAuth.
+	Authorize func(r *http.Request) bool
+	// Backends maps profile types to custom Backends, adding to or overriding
+	// the built-in "local" pty backend.
+	Backends map[string]Backend
+	// AuditLog, when set, records who started and ended terminal sessions,
+	// config changes made in the browser and failed authentication. See
+	// OpenAuditLog. The Handler does not close it.
+	AuditLog *AuditLog
+	// Logger, when set, receives all b3tty log output in the text format.
+	// When neither it nor LogHandler is set, the log is left as it is: the
+	// standard logger, or whatever ConfigureLogging set up. The logger is
+	// process-wide, so it also applies to any other Handler in the same
+	// program.
+	Logger *log.Logger
+	// LogHandler, when set, receives all b3tty log output as slog records,
+	// and takes precedence over Logger. It is process-wide like Logger.
+	LogHandler slog.Handler
+}
+
+// Handler is an http.Handler that serves the b3tty terminal page, its assets
+// and API endpoints, and the WebSocket that bridges the browser to a pty. It
+// expects to be mounted at the root path of the origin described by
+// Options.Server, because the terminal page requests its assets and endpoints
+// by absolute path.
+type Handler struct {
+	ts  *TerminalServer
+	mux *http.ServeMux
+}
+
+// NewHandler builds a Handler from opts. The returned Handler owns every
+// terminal session it starts; call Close to end them when the Handler is no
+// longer served.
+func NewHandler(opts Options) (*Handler, error) {
+	if opts.Server == nil {
+		return nil, errors.New("new handler: options must include a server")
+	}
+	if opts.LogHandler != nil {
+		SetLogHandler(opts.LogHandler)
+	} else if opts.Logger != nil {
+		SetLogger(opts.Logger)
+	}
+
+	var client *Client
+	if opts.Client != nil {
+		c := *opts.Client
+		client = &c
+	} else {
+		rows, cols := DEFAULT_ROWS, DEFAULT_COLS
+		blink, family, size := DEFAULT_CURSOR_BLINK, DEFAULT_FONT_FAMILY, DEFAULT_FONT_SIZE
+		client = NewClient(&rows, &cols, &blink, &family, &size, &Theme{})
+	}
+
+	profiles := make(map[string]Profile, len(opts.Profiles)+1)
+	for name, p := range opts.Profiles {
+		profiles[name] = p
+	}
+	if _, ok := profiles[DEFAULT_PROFILE_NAME]; !ok {
+		profiles[DEFAULT_PROFILE_NAME] = NewProfile(DEFAULT_SHELL, DEFAULT_WORKING_DIRECTORY, DEFAULT_ROOT, DEFAULT_TITLE, []string{})
+	}
+
+	themes := make(map[string]Theme, len(opts.Themes))
+	for name, t := range opts.Themes {
+		themes[name] = t
+	}
+	if opts.ActiveTheme != "" {
+		if t, ok := themes[opts.ActiveTheme]; ok {
+			client.Theme = t
+		} else if colors, ok := builtinThemes[opts.ActiveTheme]; ok {
+			client.Theme.MapToTheme(colors)
+			themes[opts.ActiveTheme] = client.Theme
+		} else {
+			return nil, errors.New("new handler: unknown active theme " + opts.ActiveTheme)
+		}
+	}
+	if err := ValidateTheme(&client.Theme); err != nil {
+		return nil, err
+	}
+
+	srv := *opts.Server
+	ts := &TerminalServer{
+		Client:         client,
+		Server:         &srv,
+		Profiles:       profiles,
+		Themes:         themes,
+		OrgCols:        DEFAULT_COLS,
+		OrgRows:        DEFAULT_ROWS,
+		StartupProfile: DEFAULT_PROFILE_NAME,
+		ActiveTheme:    opts.ActiveTheme,
+		ConfigFile:     opts.ConfigFile,
+		Authorize:      opts.Authorize,
+		Backends:       opts.Backends,
+		Audit:          opts.AuditLog,
+		AuthSleep:      time.Sleep,
+	}
+	if opts.Authorize != nil {
+		ts.Server.NoAuth = true
+	}
+	if err := ts.ValidateProfiles(); err != nil {
+		return nil, err
+	}
+	return ts.Handler()
+}
+
+// Handler prepares ts to be served and returns it wrapped in a Handler. It
+// fails when ts.Server has invalid settings. When token authentication is
+// enabled and ts.Token is empty, a new random token is generated; read it
+// back from ts.Token or Handler.Token.
+func (ts *TerminalServer) Handler() (*Handler, error) {
+	if err := ts.Server.Validate(); err != nil {
+		return nil, err
+	}
+	if !ts.Server.NoAuth && ts.Token == "" {
+		token, err := generateToken(TOKEN_LENGTH)
+		if err != nil {
+			return nil, err
+		}
+		ts.Token = token
+	}
+	if ts.AuthSleep == nil {
+		ts.AuthSleep = time.Sleep
+	}
+	return &Handler{ts: ts, mux: ts.routes()}, nil
+}
+
+// routes registers every b3tty endpoint on a new ServeMux.
+func (ts *TerminalServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ts.displayTermHandler)
+	mux.Handle("/assets/", http.StripPrefix("/", http.FileServer(http.FS(assets))))
+	mux.HandleFunc("/ws", ts.terminalHandler)
+	mux.HandleFunc("/size", ts.setSizeHandler)
+	mux.HandleFunc("/background", ts.backgroundHandler)
+	mux.HandleFunc("/theme", ts.themePaletteHandler)
+	mux.HandleFunc("/theme-config", ts.themeConfigHandler)
+	mux.HandleFunc("/theme-select", ts.themeSelectHandler)
+	mux.HandleFunc("/add-theme", ts.addThemeHandler)
+	mux.HandleFunc("/edit-theme", ts.editThemeHandler)
+	mux.HandleFunc("/save-config", ts.saveConfigHandler)
+	mux.HandleFunc("/profile-config", ts.profileConfigHandler)
+	mux.HandleFunc("/edit-profile", ts.editProfileHandler)
+	mux.HandleFunc("/delete-profile", ts.deleteProfileHandler)
+	mux.HandleFunc("/config-schema", ts.configSchemaHandler)
+	mux.HandleFunc("/sessions", ts.sessionsHandler)
+	mux.HandleFunc("/healthz", ts.healthzHandler)
+	mux.HandleFunc("/readyz", ts.readyzHandler)
+	if ts.Server.Metrics.Enabled && ts.Server.Metrics.Address == "" {
+		mux.HandleFunc("/metrics", ts.metricsHandler)
+	}
+	return mux
+}
+
+// ServeHTTP implements http.Handler. The latency of every request, except
+// WebSocket connections, which last as long as their session, is recorded
+// under the route it matched.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if _, route := h.mux.Handler(r); route != "" && route != "/ws" {
+		defer func() { h.ts.metrics.observeRequest(route, time.Since(start)) }()
+	}
+	if h.ts.Authorize != nil && !isProbePath(r.URL.Path) && !h.ts.Authorize(r) {
+		Warnf("%s %s: forbidden: rejected by authorize hook", r.Method, r.URL.Path)
+		h.ts.audit(r, auditEvent{Event: auditAuthFailure, Path: r.URL.Path, Detail: "rejected by authorize hook"})
+		h.ts.metrics.authFailure()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// MetricsHandler returns an http.Handler that serves the metrics described by
+// Server.Metrics without authentication, for mounting on a listener that
+// only trusted scrapers can reach. It serves them whether or not
+// Server.Metrics.Enabled is set.
+func (h *Handler) MetricsHandler() http.Handler {
+	return http.HandlerFunc(h.ts.serveMetrics)
+}
+
+// Token returns the access token that must be passed as ?token= when loading
+// the terminal page, or "" when token authentication is disabled.
+func (h *Handler) Token() string {
+	return h.ts.Token
+}
+
+// Close ends every active terminal session and causes subsequent WebSocket
+// connections to be refused. It returns once the sessions' processes, and
+// any jobs they started, have exited; see Server.KillGracePeriod. It does
+// not stop the listener the Handler is served on. Close always returns nil
+// and is safe to call more than once.
+func (h *Handler) Close() error {
+	h.ts.closeSessions()
+	return nil
+}
+
+// Shutdown refuses new WebSocket connections and tells every connected
+// terminal that the server is shutting down. It then waits for the sessions
+// to end on their own until ctx is done, and closes the rest as Close does.
+// Sessions are closed with the close code 1001 (going away), so clients can
+// tell a shutdown from a lost connection.
+func (h *Handler) Shutdown(ctx context.Context) {
+	h.ts.drainSessions(ctx)
+	h.ts.closeSessions()
+}
@@ -0,0 +1,307 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServerOptions returns Options for a handler reachable at localhost:8080.
+func testServerOptions() Options {
+	return Options{Server: &Server{Uri: "localhost", Port: 8080}}
+}
+
+// ---------------------------------------------------------------------------
+// NewHandler
+// ---------------------------------------------------------------------------
+
+func TestNewHandler(t *testing.T) {
+	t.Run("server is required", func(t *testing.T) {
+		_, err := NewHandler(Options{})
+		assert.ErrorContains(t, err, "server")
+	})
+
+	t.Run("adds a default profile and generates a token", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		assert.Contains(t, h.ts.Profiles, DEFAULT_PROFILE_NAME)
+		assert.Len(t, h.Token(), TOKEN_LENGTH)
+		assert.Equal(t, DEFAULT_FONT_SIZE, h.ts.Client.FontSize)
+	})
+
+	t.Run("no token when no-auth is set", func(t *testing.T) {
+		opts := testServerOptions()
+		opts.Server.NoAuth = true
+		h, err := NewHandler(opts)
+		require.NoError(t, err)
+		assert.Empty(t, h.Token())
+	})
+
+	t.Run("does not alias the caller's maps or client", func(t *testing.T) {
+		opts := testServerOptions()
+		opts.Profiles = map[string]Profile{"work": {Shell: "/bin/zsh"}}
+		opts.Client = &Client{FontSize: 20}
+		opts.Themes = map[string]Theme{"mine": {Foreground: "#fff"}}
+		opts.ActiveTheme = "mine"
+		h, err := NewHandler(opts)
+		require.NoError(t, err)
+		assert.Equal(t, "#fff", h.ts.Client.Theme.Foreground)
+		assert.Empty(t, opts.Client.Theme.Foreground)
+		h.ts.setProfile("new", Profile{})
+		h.ts.saveTheme("other", Theme{})
+		assert.NotContains(t, opts.Profiles, "new")
+		assert.NotContains(t, opts.Themes, "other")
+	})
+
+	t.Run("built-in active theme is registered", func(t *testing.T) {
+		opts := testServerOptions()
+		opts.ActiveTheme = "dracula"
+		h, err := NewHandler(opts)
+		require.NoError(t, err)
+		assert.Contains(t, h.ts.Themes, "dracula")
+		assert.NotEmpty(t, h.ts.Client.Theme.Background)
+	})
+
+	t.Run("unknown active theme is an error", func(t *testing.T) {
+		opts := testServerOptions()
+		opts.ActiveTheme = "nope"
+		_, err := NewHandler(opts)
+		assert.ErrorContains(t, err, "nope")
+	})
+
+	t.Run("invalid theme colors are an error", func(t *testing.T) {
+		opts := testServerOptions()
+		opts.Themes = map[string]Theme{"bad": {Foreground: "not#valid"}}
+		opts.ActiveTheme = "bad"
+		_, err := NewHandler(opts)
+		assert.Error(t, err)
+	})
+
+	t.Run("logger receives log output", func(t *testing.T) {
+		var buf bytes.Buffer
+		opts := testServerOptions()
+		opts.Logger = log.New(&buf, "", 0)
+		_, err := NewHandler(opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { SetLogger(nil) })
+		Info("hello from b3tty")
+		assert.Contains(t, buf.String(), "hello from b3tty")
+	})
+
+	t.Run("no logger keeps the log as configured", func(t *testing.T) {
+		var buf bytes.Buffer
+		SetLogger(log.New(&buf, "", 0))
+		t.Cleanup(func() { SetLogger(nil) })
+		_, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		Info("hello from b3tty")
+		assert.Contains(t, buf.String(), "hello from b3tty")
+	})
+
+	t.Run("log handler receives slog records", func(t *testing.T) {
+		var buf bytes.Buffer
+		opts := testServerOptions()
+		opts.Logger = log.New(io.Discard, "", 0)
+		opts.LogHandler = slog.NewJSONHandler(&buf, nil)
+		_, err := NewHandler(opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { SetLogger(nil) })
+		Info("hello from b3tty")
+		assert.Contains(t, buf.String(), `"msg":"hello from b3tty"`)
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Handler
+// ---------------------------------------------------------------------------
+
+func TestHandlerServeHTTP(t *testing.T) {
+	t.Run("serves the terminal page with the token", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?token="+h.Token(), nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "window.B3TTY")
+	})
+
+	t.Run("serves embedded assets", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/assets/terminal.css", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("authorize hook guards every route", func(t *testing.T) {
+		var calls atomic.Int32
+		opts := testServerOptions()
+		opts.Authorize = func(r *http.Request) bool {
+			calls.Add(1)
+			return r.Header.Get("X-Admin") == "yes"
+		}
+		h, err := NewHandler(opts)
+		require.NoError(t, err)
+		assert.Empty(t, h.Token(), "authorize hook replaces the token")
+
+		for _, path := range []string{"/", "/theme?name=dracula", "/ws", "/config-schema"} {
+			w := httptest.NewRecorder()
+			logged := captureLog(func() { h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil)) })
+			assert.Equal(t, http.StatusForbidden, w.Code, path)
+			assert.Contains(t, logged, "authorize hook")
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Admin", "yes")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 5, calls.Load())
+	})
+}
+
+func TestHandlerClose(t *testing.T) {
+	t.Run("ends active sessions", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		var closed atomic.Int32
+		for i := 0; i < 3; i++ {
+			require.True(t, h.ts.addSession(&session{Started: time.Now(), close: func() { closed.Add(1) }}))
+		}
+		assert.Equal(t, 3, h.ts.sessionCount())
+		require.NoError(t, h.Close())
+		assert.EqualValues(t, 3, closed.Load())
+	})
+
+	t.Run("refuses new sessions afterwards", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		require.NoError(t, h.Close())
+		require.NoError(t, h.Close(), "Close is idempotent")
+		assert.False(t, h.ts.addSession(&session{close: func() {}}))
+
+		w := httptest.NewRecorder()
+		logged := captureLog(func() { h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws", nil)) })
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, logged, "server is closed")
+	})
+
+	t.Run("removed sessions are not closed", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		var closed atomic.Int32
+		s := &session{close: func() { closed.Add(1) }}
+		require.True(t, h.ts.addSession(s))
+		h.ts.removeSession(s)
+		require.NoError(t, h.Close())
+		assert.Zero(t, closed.Load())
+	})
+}
+
+// shutdownTerminal serves a no-auth Handler whose sessions run on a
+// fakeBackend and returns it with a v1 WebSocket connected to it.
+func shutdownTerminal(t *testing.T) (*Handler, *fakeBackend, *websocket.Conn) {
+	t.Helper()
+	backend := &fakeBackend{}
+	opts := testServerOptions()
+	opts.Server.NoAuth = true
+	opts.Backends = map[string]Backend{DEFAULT_BACKEND: backend}
+	h, err := NewHandler(opts)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	conn := dialTerminal(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", PROTOCOL_V1)
+	backend.process(t, 0)
+	require.Eventually(t, func() bool { return h.ts.sessionCount() == 1 }, 2*time.Second, 5*time.Millisecond)
+	return h, backend, conn
+}
+
+// readCloseError reads from conn until the server closes it and returns the
+// close frame it sent.
+func readCloseError(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		return closeErr
+	}
+}
+
+func TestHandlerShutdown(t *testing.T) {
+	t.Run("notifies terminals and closes them after the drain period", func(t *testing.T) {
+		h, _, conn := shutdownTerminal(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 800*time.Millisecond)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			h.Shutdown(ctx)
+			close(done)
+		}()
+
+		op, payload := readFrame(t, conn)
+		require.Equal(t, msgOutput, op)
+		assert.Equal(t, "\r\n[server shutting down; this session will be closed in 1s]\r\n", payload)
+		select {
+		case <-done:
+			t.Fatal("Shutdown returned before the drain period ended")
+		default:
+		}
+
+		closeErr := readCloseError(t, conn)
+		assert.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+		assert.Equal(t, "server shutting down", closeErr.Text)
+		<-done
+	})
+
+	t.Run("returns once sessions end on their own", func(t *testing.T) {
+		h, backend, conn := shutdownTerminal(t)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			h.Shutdown(ctx)
+			close(done)
+		}()
+
+		op, _ := readFrame(t, conn)
+		require.Equal(t, msgOutput, op)
+		backend.process(t, 0).exit()
+		assert.Equal(t, websocket.CloseNormalClosure, readCloseError(t, conn).Code)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown did not return after the session ended")
+		}
+	})
+
+	t.Run("closes sessions at once without a drain period", func(t *testing.T) {
+		h, _, conn := shutdownTerminal(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		h.Shutdown(ctx)
+
+		closeErr := readCloseError(t, conn)
+		assert.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+
+		w := httptest.NewRecorder()
+		captureLog(func() { h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws", nil)) })
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
@@ -0,0 +1,263 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// Health and readiness statuses reported by /healthz and /readyz.
+const (
+	healthOK       = "ok"
+	healthReady    = "ready"
+	healthNotReady = "not ready"
+	checkFailed    = "failed"
+)
+
+// healthResponse is returned by GET /healthz and GET /readyz. Checks maps the
+// name of each readiness check to "ok" or "failed"; why a check failed is only
+// logged, so that the unauthenticated endpoints reveal nothing about the host.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// readinessCheck is one of the checks made by /readyz.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// readinessResult is the outcome of a readinessCheck.
+type readinessResult struct {
+	name string
+	err  error
+}
+
+// readinessCache holds the results of the readiness checks for
+// READINESS_CACHE_TTL. The checks parse the config file, load the TLS key
+// pair and allocate a pty, and /readyz needs no token, so without the cache
+// anyone who can reach the server could make it do this work without limit.
+type readinessCache struct {
+	mu      sync.Mutex
+	checked time.Time
+	results []readinessResult
+}
+
+// run returns the results of checks, running them again only once the last
+// results are older than READINESS_CACHE_TTL. Concurrent callers wait for a
+// run in progress rather than starting their own.
+func (c *readinessCache) run(checks func() []readinessCheck) []readinessResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked.IsZero() && time.Since(c.checked) < READINESS_CACHE_TTL {
+		return c.results
+	}
+	var results []readinessResult
+	for _, rc := range checks() {
+		err := rc.check()
+		if err != nil {
+			Warnf("readiness check %s failed: %v", rc.name, err)
+		}
+		results = append(results, readinessResult{name: rc.name, err: err})
+	}
+	c.results, c.checked = results, time.Now()
+	return results
+}
+
+// isProbePath reports whether path is one of the health endpoints, which are
+// served without authentication.
+func isProbePath(path string) bool {
+	return path == "/healthz" || path == "/readyz"
+}
+
+// healthzHandler reports that the server is up. It requires no token and
+// reveals nothing but the status, so that service managers and load
+// balancers can poll it.
+// GET /healthz
+func (ts *TerminalServer) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		Warnf("%s %s: method not allowed", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, http.StatusOK, healthResponse{Status: healthOK})
+}
+
+// readyzHandler reports whether the server can start terminal sessions: it
+// is not shutting down, its config file still loads, its TLS certificate and
+// key can be read and, when a profile uses the local backend, a pty can be
+// allocated. It responds 503 Service Unavailable when any check fails. Only
+// the shutdown check is made on every request; the others are cached, see
+// readinessCache.
+// GET /readyz
+func (ts *TerminalServer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		Warnf("%s %s: method not allowed", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	results := []readinessResult{{name: "server", err: ts.checkAccepting()}}
+	if results[0].err != nil {
+		Warnf("readiness check server failed: %v", results[0].err)
+	}
+	results = append(results, ts.readiness.run(ts.readinessChecks)...)
+	resp := healthResponse{Status: healthReady, Checks: make(map[string]string)}
+	code := http.StatusOK
+	for _, res := range results {
+		if res.err != nil {
+			resp.Checks[res.name] = checkFailed
+			resp.Status, code = healthNotReady, http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[res.name] = healthOK
+	}
+	writeHealth(w, code, resp)
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		Errorf("health response error: %v", err)
+	}
+}
+
+// readinessChecks returns the cached checks that apply to ts.
+func (ts *TerminalServer) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "config", check: ts.checkConfig},
+	}
+	if ts.Server.TLS.Enabled {
+		checks = append(checks, readinessCheck{name: "tls", check: ts.checkTLS})
+	}
+	if ts.usesLocalBackend() {
+		checks = append(checks, readinessCheck{name: "pty", check: checkPTY})
+	}
+	return checks
+}
+
+// checkAccepting fails once the server has started shutting down.
+func (ts *TerminalServer) checkAccepting() error {
+	if ts.isClosed() {
+		return errors.New("the server is shutting down")
+	}
+	return nil
+}
+
+// checkConfig fails when the config file no longer loads. A config file that
+// has not been created yet, as on the first run, passes.
+func (ts *TerminalServer) checkConfig() error {
+	if ts.ConfigFile == "" {
+		return nil
+	}
+	err := ValidateConfig(ts.ConfigFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// checkTLS fails when the TLS certificate and key cannot be read or do not
+// form a pair, or when the client CA file is set but cannot be loaded.
+func (ts *TerminalServer) checkTLS() error {
+	if _, err := tls.LoadX509KeyPair(ts.Server.CertFilePath, ts.Server.KeyFilePath); err != nil {
+		return err
+	}
+	_, err := ts.Server.TLS.config()
+	return err
+}
+
+// usesLocalBackend reports whether any profile starts its shell with the
+// built-in pty backend.
+func (ts *TerminalServer) usesLocalBackend() bool {
+	for _, p := range ts.profilesSnapshot() {
+		if b, err := ts.backend(p.Type); err == nil {
+			if _, ok := b.(LocalPTYBackend); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkPTY allocates a pty and releases it at once.
+func checkPTY() error {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("open pty: %w", err)
+	}
+	tty.Close()
+	return ptmx.Close()
+}
+
+// Status is the health of a running b3tty server, as reported by its
+// /healthz and /readyz endpoints.
+type Status struct {
+	Healthy bool
+	Ready   bool
+	// Checks maps the name of each readiness check to "ok" or "failed".
+	Checks map[string]string
+}
+
+// CheckNames returns the names of the readiness checks in s, sorted.
+func (s Status) CheckNames() []string {
+	names := make([]string, 0, len(s.Checks))
+	for name := range s.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QueryStatus asks the b3tty server at baseURL, such as
+// "https://localhost:8443", whether it is healthy and ready. It fails only
+// when the server cannot be reached or gives an unexpected response; a server
+// that is up but not ready is reported in the Status.
+func QueryStatus(ctx context.Context, client *http.Client, baseURL string) (Status, error) {
+	var status Status
+	health, code, err := getHealth(ctx, client, baseURL+"/healthz")
+	if err != nil {
+		return status, err
+	}
+	status.Healthy = code == http.StatusOK && health.Status == healthOK
+	ready, code, err := getHealth(ctx, client, baseURL+"/readyz")
+	if err != nil {
+		return status, err
+	}
+	status.Ready = code == http.StatusOK && ready.Status == healthReady
+	status.Checks = ready.Checks
+	return status, nil
+}
+
+// getHealth requests url and decodes its healthResponse, returning it with
+// the status code.
+func getHealth(ctx context.Context, client *http.Client, url string) (healthResponse, int, error) {
+	var resp healthResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return resp, 0, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return resp, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusServiceUnavailable {
+		return resp, res.StatusCode, fmt.Errorf("%s: unexpected response %s", url, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return resp, res.StatusCode, fmt.Errorf("%s: %w", url, err)
+	}
+	return resp, res.StatusCode, nil
+}
@@ -0,0 +1,168 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getProbe requests path from h and decodes the healthResponse.
+func getProbe(t *testing.T, h http.Handler, path string) (int, healthResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var resp healthResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	return w.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	t.Run("needs no token", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		code, resp := getProbe(t, h, "/healthz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, healthResponse{Status: "ok"}, resp)
+	})
+
+	t.Run("bypasses the authorize hook", func(t *testing.T) {
+		opts := testServerOptions()
+		opts.Authorize = func(r *http.Request) bool { return false }
+		h, err := NewHandler(opts)
+		require.NoError(t, err)
+		code, _ := getProbe(t, h, "/healthz")
+		assert.Equal(t, http.StatusOK, code)
+		code, _ = getProbe(t, h, "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("only GET and HEAD", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		w := httptest.NewRecorder()
+		ts.healthzHandler(w, httptest.NewRequest(http.MethodHead, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = httptest.NewRecorder()
+		captureLog(func() { ts.readyzHandler(w, httptest.NewRequest(http.MethodPost, "/readyz", nil)) })
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.ConfigFile = writeTempConfig(t, "server:\n  port: 8080\n")
+		code, resp := getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, healthResponse{Status: "ready", Checks: map[string]string{"server": "ok", "config": "ok", "pty": "ok"}}, resp)
+	})
+
+	t.Run("failed checks are named but not explained", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.ConfigFile = writeTempConfig(t, "server:\n  colour: blue\n")
+		ts.Server.TLS = TLS{Enabled: true, CertFilePath: "/b3tty-no-such-dir/cert.pem", KeyFilePath: "/b3tty-no-such-dir/key.pem"}
+		w := httptest.NewRecorder()
+		logged := captureLog(func() { ts.readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil)) })
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		var resp healthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "not ready", resp.Status)
+		assert.Equal(t, "failed", resp.Checks["config"])
+		assert.Equal(t, "failed", resp.Checks["tls"])
+		assert.NotContains(t, w.Body.String(), "b3tty-no-such-dir")
+		assert.Contains(t, logged, "readiness check config failed")
+		assert.Contains(t, logged, "readiness check tls failed: open /b3tty-no-such-dir/cert.pem")
+	})
+
+	t.Run("a config file not yet created passes", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.ConfigFile = "/b3tty-no-such-dir/conf.yaml"
+		assert.NoError(t, ts.checkConfig())
+	})
+
+	t.Run("not ready once shutting down", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.closeSessions()
+		w := httptest.NewRecorder()
+		captureLog(func() { ts.readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil)) })
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `"server":"failed"`)
+	})
+
+	t.Run("results are cached", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.ConfigFile = writeTempConfig(t, "server:\n  port: 8080\n")
+		code, _ := getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+
+		require.NoError(t, os.WriteFile(ts.ConfigFile, []byte("server:\n  colour: blue\n"), 0600))
+		code, _ = getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+
+		ts.readiness.checked = time.Now().Add(-READINESS_CACHE_TTL)
+		var resp healthResponse
+		captureLog(func() { code, resp = getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz") })
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "failed", resp.Checks["config"])
+	})
+
+	t.Run("shutting down is not cached", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		code, _ := getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+		ts.closeSessions()
+		var resp healthResponse
+		captureLog(func() { code, resp = getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz") })
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "failed", resp.Checks["server"])
+	})
+
+	t.Run("no pty check without the local backend", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Backends = map[string]Backend{"remote": &fakeBackend{}}
+		ts.Profiles = map[string]Profile{"default": {Type: "remote"}}
+		_, resp := getProbe(t, http.HandlerFunc(ts.readyzHandler), "/readyz")
+		assert.NotContains(t, resp.Checks, "pty")
+	})
+}
+
+func TestQueryStatus(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		status, err := QueryStatus(context.Background(), srv.Client(), srv.URL)
+		require.NoError(t, err)
+		assert.True(t, status.Healthy)
+		assert.True(t, status.Ready)
+		assert.Equal(t, []string{"config", "pty", "server"}, status.CheckNames())
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		h, err := NewHandler(testServerOptions())
+		require.NoError(t, err)
+		require.NoError(t, h.Close())
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		var status Status
+		captureLog(func() { status, err = QueryStatus(context.Background(), srv.Client(), srv.URL) })
+		require.NoError(t, err)
+		assert.True(t, status.Healthy)
+		assert.False(t, status.Ready)
+		assert.Equal(t, "failed", status.Checks["server"])
+	})
+
+	t.Run("not a b3tty server", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		_, err := QueryStatus(context.Background(), srv.Client(), srv.URL)
+		assert.ErrorContains(t, err, "/healthz: unexpected response 404 Not Found")
+	})
+}
@@ -0,0 +1,139 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const initSupported = true
+
+// initSpec tells the init process what to set up before it runs a profile's
+// program; see runInit.
+type initSpec struct {
+	// Sandbox is the sandbox to set up, or nil for none.
+	Sandbox *sandboxSpec
+	Limits  []rlimit
+	// Path is the program to run, looked up in the PATH of its environment
+	// once any sandbox is set up, and Args its arguments, starting with
+	// argv[0].
+	Path string
+	Args []string
+}
+
+// rlimit is a limit for setrlimit.
+type rlimit struct {
+	Resource int
+	Value    uint64
+}
+
+// rlimits returns the setrlimit limits of l.
+func (l Limits) rlimits() []rlimit {
+	var limits []rlimit
+	if l.Memory > 0 {
+		limits = append(limits, rlimit{unix.RLIMIT_DATA, uint64(l.Memory)})
+	}
+	if l.CPUTime > 0 {
+		seconds := (l.CPUTime + time.Second - 1) / time.Second
+		limits = append(limits, rlimit{unix.RLIMIT_CPU, uint64(seconds)})
+	}
+	if l.OpenFiles > 0 {
+		limits = append(limits, rlimit{unix.RLIMIT_NOFILE, uint64(l.OpenFiles)})
+	}
+	if l.Processes > 0 {
+		limits = append(limits, rlimit{unix.RLIMIT_NPROC, uint64(l.Processes)})
+	}
+	if l.CoreSize != 0 {
+		limits = append(limits, rlimit{unix.RLIMIT_CORE, uint64(max(l.CoreSize, 0))})
+	}
+	return limits
+}
+
+// initCommand rewrites c, prepared by Profile.ApplyToCommand, so that the
+// b3tty executable is started again as INIT_PROCESS_NAME to set up the
+// sandbox and limits of p before it replaces itself with c's program. A
+// sandboxed init process starts in new user and mount namespaces.
+func (p Profile) initCommand(c *exec.Cmd) error {
+	spec := initSpec{Limits: p.Limits.rlimits(), Path: c.Path, Args: c.Args}
+	if p.sandboxed() {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		sandbox, err := p.newSandboxSpec(c.Dir, home)
+		if err != nil {
+			return err
+		}
+		spec.Sandbox = &sandbox
+		c.Dir = ""
+		c.SysProcAttr = sandboxProcAttr()
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	c.Path = "/proc/self/exe"
+	c.Args = []string{INIT_PROCESS_NAME, string(data)}
+	return nil
+}
+
+func init() {
+	if len(os.Args) != 2 || os.Args[0] != INIT_PROCESS_NAME {
+		return
+	}
+	// Capabilities belong to threads, so the thread that drops them must be
+	// the one that starts the program.
+	runtime.LockOSThread()
+	err := runInit(os.Args[1])
+	fmt.Fprintf(os.Stderr, "b3tty: %v\n", err)
+	os.Exit(INIT_FAILED_EXIT_CODE)
+}
+
+// runInit sets up what the JSON encoded initSpec describes and runs its
+// program. It only returns on failure.
+func runInit(encoded string) error {
+	var spec initSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		return err
+	}
+	if spec.Sandbox != nil {
+		if err := setupSandbox(*spec.Sandbox); err != nil {
+			return fmt.Errorf("sandbox: %w", err)
+		}
+	}
+	if err := setRlimits(spec.Limits); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if spec.Sandbox != nil {
+		if err := dropCapabilities(); err != nil {
+			return fmt.Errorf("sandbox: %w", err)
+		}
+	}
+	path, err := exec.LookPath(spec.Path)
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, spec.Args, os.Environ())
+}
+
+// setRlimits lowers the soft and hard limits to the given values. A limit
+// whose hard limit is already lower is set to the hard limit, since raising
+// it needs privileges.
+func setRlimits(limits []rlimit) error {
+	for _, l := range limits {
+		var current unix.Rlimit
+		if err := unix.Getrlimit(l.Resource, &current); err != nil {
+			return err
+		}
+		value := min(l.Value, current.Max)
+		if err := unix.Setrlimit(l.Resource, &unix.Rlimit{Cur: value, Max: value}); err != nil {
+			return fmt.Errorf("setrlimit %d: %w", l.Resource, err)
+		}
+	}
+	return nil
+}
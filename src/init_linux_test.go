@@ -0,0 +1,47 @@
+package src
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestLimitsRlimits(t *testing.T) {
+	l := Limits{Memory: 1 << 30, CPUTime: 1500 * time.Millisecond, OpenFiles: 64, Processes: 100, CoreSize: -1}
+	assert.Equal(t, []rlimit{
+		{unix.RLIMIT_DATA, 1 << 30},
+		{unix.RLIMIT_CPU, 2},
+		{unix.RLIMIT_NOFILE, 64},
+		{unix.RLIMIT_NPROC, 100},
+		{unix.RLIMIT_CORE, 0},
+	}, l.rlimits())
+	assert.Empty(t, Limits{}.rlimits())
+}
+
+func TestInitLimits(t *testing.T) {
+	t.Run("limits are applied to the shell", func(t *testing.T) {
+		p := Profile{Limits: Limits{OpenFiles: 64, CoreSize: -1, CPUTime: time.Hour}}
+		out := runProfile(t, p, `ulimit -n; ulimit -c; ulimit -t`)
+		assert.Equal(t, "64\n0\n3600\n", out)
+	})
+
+	t.Run("limits are applied inside a sandbox", func(t *testing.T) {
+		requireUserNamespaces(t)
+		p := Profile{Sandbox: Sandbox{PrivateTmp: true}, Limits: Limits{OpenFiles: 32}}
+		out := runProfile(t, p, `ulimit -n; ls -A /tmp | wc -l`)
+		assert.Equal(t, "32\n0\n", out)
+	})
+
+	t.Run("a limit above the hard limit is lowered to it", func(t *testing.T) {
+		var current unix.Rlimit
+		if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &current); err != nil || current.Max > 1<<20 {
+			t.Skip("no small hard limit on open files")
+		}
+		p := Profile{Limits: Limits{OpenFiles: int(current.Max) + 1}}
+		out := runProfile(t, p, `ulimit -n`)
+		assert.Equal(t, strconv.FormatUint(current.Max, 10)+"\n", out)
+	})
+}
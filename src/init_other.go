@@ -0,0 +1,15 @@
+//go:build !linux
+
+package src
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+const initSupported = false
+
+// initCommand fails, since sandboxes and resource limits need Linux.
+func (p Profile) initCommand(c *exec.Cmd) error {
+	return fmt.Errorf("sandboxes and resource limits are only supported on Linux")
+}
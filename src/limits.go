@@ -0,0 +1,107 @@
+package src
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits caps the resources a profile's shell and the processes it starts can
+// use. A zero field sets no limit. Each limit is applied to every process
+// with setrlimit. Memory and Processes are also applied to the session as a
+// whole through a cgroup when b3tty runs in a delegated cgroup v2 subtree.
+type Limits struct {
+	// Memory is the most memory in bytes. Each process is limited to this
+	// much data memory (RLIMIT_DATA), and the session's cgroup, if any, to
+	// this much memory in total.
+	Memory int64
+	// CPUTime is the most CPU time each process may use before it is killed
+	// (RLIMIT_CPU). It is rounded up to whole seconds.
+	CPUTime time.Duration
+	// OpenFiles is the most files each process may have open (RLIMIT_NOFILE).
+	OpenFiles int
+	// Processes is the most processes. The kernel counts every process of the
+	// user against RLIMIT_NPROC, not just the session's, while the session's
+	// cgroup, if any, counts only the session's.
+	Processes int
+	// CoreSize is the largest core dump in bytes (RLIMIT_CORE). A negative
+	// value disables core dumps.
+	CoreSize int64
+}
+
+// needsInit reports whether l sets any limit that the init process applies.
+func (l Limits) needsInit() bool {
+	return l.Memory > 0 || l.CPUTime > 0 || l.OpenFiles > 0 || l.Processes > 0 || l.CoreSize != 0
+}
+
+// needsInit reports whether p's program must be started by the init process
+// to set up a sandbox or limits.
+func (p Profile) needsInit() bool {
+	return p.sandboxed() || p.Limits.needsInit()
+}
+
+// needsCgroup reports whether l sets any limit that a cgroup applies.
+func (l Limits) needsCgroup() bool {
+	return l.Memory > 0 || l.Processes > 0
+}
+
+// validateLimits reports an error when a limit of p is negative, or when p
+// sets limits on a system where they are not supported.
+func (p Profile) validateLimits() error {
+	l := p.Limits
+	if l.Memory < 0 || l.CPUTime < 0 || l.OpenFiles < 0 || l.Processes < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.needsInit() && !initSupported {
+		return fmt.Errorf("resource limits are only supported on Linux")
+	}
+	return nil
+}
+
+// reSize matches the sizes accepted by ParseSize.
+var reSize = regexp.MustCompile(`^([0-9]+)([KMGT]i?)?B?$`)
+
+// ParseSize parses a size in bytes such as "512M" or "2GiB". The suffixes K,
+// M, G and T, optionally followed by i, B or iB, all stand for powers of
+// 1024.
+func ParseSize(s string) (int64, error) {
+	m := reSize.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	shift := 0
+	if m[2] != "" {
+		shift = 10 * (strings.IndexByte("KMGT", m[2][0]) + 1)
+	}
+	if n > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return n << shift, nil
+}
+
+// ResourceUsage is what the processes of a session are using.
+type ResourceUsage struct {
+	// CPUSeconds is the CPU time used, including that of exited processes
+	// when it comes from a cgroup.
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// MemoryBytes is the memory in use. Without a cgroup it is the sum of
+	// the resident sizes of the processes, which counts shared pages more
+	// than once.
+	MemoryBytes int64 `json:"memoryBytes"`
+	Processes   int   `json:"processes"`
+	// Source is "cgroup" or "proc", depending on where the figures come
+	// from.
+	Source string `json:"source"`
+}
+
+// usageReporter is implemented by a Process that can report the resources
+// used by it and the processes it started.
+type usageReporter interface {
+	usage() (ResourceUsage, error)
+}
@@ -0,0 +1,57 @@
+package src
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   int64
+		errMsg string
+	}{
+		{in: "0", want: 0},
+		{in: "4096", want: 4096},
+		{in: "512K", want: 512 << 10},
+		{in: "512M", want: 512 << 20},
+		{in: "2GiB", want: 2 << 30},
+		{in: "1TB", want: 1 << 40},
+		{in: "100B", want: 100},
+		{in: "1.5G", errMsg: `invalid size "1.5G"`},
+		{in: "-1", errMsg: `invalid size "-1"`},
+		{in: "12X", errMsg: `invalid size "12X"`},
+		{in: "9999999999T", errMsg: "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSize(tt.in)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	assert.NoError(t, Profile{}.validateLimits())
+	assert.EqualError(t, Profile{Limits: Limits{Memory: -1}}.validateLimits(), "limits must not be negative")
+	assert.EqualError(t, Profile{Limits: Limits{CPUTime: -time.Second}}.validateLimits(), "limits must not be negative")
+
+	ts := newTestTerminalServer()
+	ts.Profiles["greedy"] = Profile{Limits: Limits{Processes: -5}}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "profile greedy: limits must not be negative")
+}
+
+func TestLimitsNeeds(t *testing.T) {
+	assert.False(t, Limits{}.needsInit())
+	assert.True(t, Limits{CoreSize: -1}.needsInit(), "a negative core size disables core dumps")
+	assert.False(t, Limits{OpenFiles: 64, CPUTime: time.Minute}.needsCgroup())
+	assert.True(t, Limits{Processes: 100}.needsCgroup())
+	assert.True(t, Profile{Limits: Limits{OpenFiles: 64}}.needsInit())
+}
@@ -0,0 +1,103 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an append-only log file that is rotated once it would grow
+// beyond maxSize bytes. Rotation renames the file to path.1, shifting older
+// files to path.2 and so on, and keeps at most maxFiles of them.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openRotatingFile opens or creates the log file at path. A maxSize or
+// maxFiles of zero selects DEFAULT_LOG_MAX_SIZE or DEFAULT_LOG_MAX_FILES.
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if maxSize == 0 {
+		maxSize = DEFAULT_LOG_MAX_SIZE
+	}
+	if maxFiles == 0 {
+		maxFiles = DEFAULT_LOG_MAX_FILES
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the file at r.path for appending. The file is created readable
+// only by its owner, as log lines can include request URLs and tokens.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("log file: %w", err)
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first when p would take it beyond
+// maxSize. A write larger than maxSize goes into a file of its own.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate closes the file, shifts it and the rotated files by one, dropping
+// the oldest, and opens a new file. When the file cannot be renamed it is
+// reopened, so that logging carries on in the oversized file.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	r.f = nil
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		// Missing files are skipped; there are fewer than maxFiles yet.
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("log file: %w", err)
+	}
+	return r.open()
+}
+
+// Close closes the file. Later writes fail with os.ErrClosed.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -1,15 +1,23 @@
 package src
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // warnWriter is an io.Writer that routes each line through the standard logger
 // via Warnf. The http.Server ErrorLog is constructed with flags=0 and no
 // prefix so the server writes only the raw message — no timestamp. Warnf then
-// calls log.Printf which prepends the timestamp, producing the correct order:
+// logs it like any other message, prepending the timestamp in the text
+// format and producing the correct order:
 //
 //	2026/03/15 02:10:09 [WARN ] http: TLS handshake error …
 type warnWriter struct{}
@@ -39,18 +47,249 @@ const (
 )
 
 // useColor is true when stdout is attached to an interactive terminal.
-// Colors are suppressed when output is piped or redirected.
+// Colors are suppressed when output is piped or redirected, and when the log
+// is written to a file or as JSON.
 var useColor bool
 
-// debugEnabled gates the Debug/Debugf helpers. Set via SetDebug.
-var debugEnabled bool
+// levelFatal is the level of Fatal and Fatalf messages.
+const levelFatal = slog.LevelError + 4
 
-// SetDebug enables or disables debug-level logging.
+// logLevel is the lowest level that is logged. It is set with SetLogLevel,
+// SetDebug and ConfigureLogging.
+var logLevel = new(slog.LevelVar)
+
+// logHandler holds the slog.Handler that receives every log record. It
+// defaults to the text format written through the standard logger and can be
+// replaced with SetLogger, SetLogHandler or ConfigureLogging while other
+// goroutines are logging.
+var logHandler atomic.Pointer[slog.Handler]
+
+func setLogHandler(h slog.Handler) {
+	logHandler.Store(&h)
+}
+
+// SetLogger routes all b3tty log output through l in the text format.
+// Passing nil restores the standard logger. The setting is process-wide.
+func SetLogger(l *log.Logger) {
+	setLogHandler(&textHandler{logger: l})
+}
+
+// SetLogHandler routes all b3tty log output to h as slog records, leaving
+// the choice of levels to h. Passing nil restores the text format on the
+// standard logger. The setting is process-wide.
+func SetLogHandler(h slog.Handler) {
+	if h == nil {
+		h = &textHandler{}
+	}
+	setLogHandler(h)
+}
+
+// SetLogLevel sets the lowest level that is logged.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// SetDebug enables or disables debug-level logging. Disabling it logs from
+// the info level up.
 func SetDebug(enabled bool) {
-	debugEnabled = enabled
+	if enabled {
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(slog.LevelInfo)
+	}
+}
+
+// debugEnabled reports whether debug messages are logged.
+func debugEnabled() bool {
+	return logLevel.Level() <= slog.LevelDebug
+}
+
+// LogConfig selects the format, level and destination of the log.
+type LogConfig struct {
+	// Format is LOG_FORMAT_TEXT or LOG_FORMAT_JSON. Empty selects text.
+	Format string
+	// Level is debug, info, warn or error. Empty selects info.
+	Level string
+	// File is the path of the log file. Empty logs to standard error.
+	File string
+	// MaxSize is how large in bytes the log file may grow before it is
+	// rotated. Zero selects DEFAULT_LOG_MAX_SIZE.
+	MaxSize int64
+	// MaxFiles is how many rotated log files are kept. Zero selects
+	// DEFAULT_LOG_MAX_FILES.
+	MaxFiles int
+}
+
+// ParseLogLevel parses a log level name: debug, info, warn or error.
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	switch name {
+	case "debug", "info", "warn", "error":
+		err := level.UnmarshalText([]byte(name))
+		return level, err
+	}
+	return level, fmt.Errorf("unknown log level %q", name)
+}
+
+// ConfigureLogging sets up the log as described by c. The returned Closer
+// closes the log file, if any; log output after it is closed is lost.
+func ConfigureLogging(c LogConfig) (io.Closer, error) {
+	level := slog.LevelInfo
+	if c.Level != "" {
+		var err error
+		if level, err = ParseLogLevel(c.Level); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxSize < 0 || c.MaxFiles < 0 {
+		return nil, fmt.Errorf("log file size and count must not be negative")
+	}
+
+	var w io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if c.File != "" {
+		f, err := openRotatingFile(c.File, c.MaxSize, c.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+	}
+
+	var h slog.Handler
+	switch c.Format {
+	case "", LOG_FORMAT_TEXT:
+		if c.File == "" {
+			h = &textHandler{}
+		} else {
+			h = &textHandler{logger: log.New(w, "", log.LstdFlags), plain: true}
+		}
+	case LOG_FORMAT_JSON:
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel, ReplaceAttr: replaceLevel})
+		// Messages such as the startup banner must not carry color codes
+		// into JSON.
+		useColor = false
+	default:
+		closer.Close()
+		return nil, fmt.Errorf("unknown log format %q", c.Format)
+	}
+	if c.File != "" {
+		useColor = false
+	}
+	logLevel.Set(level)
+	setLogHandler(h)
+	return closer, nil
+}
+
+// replaceLevel names levelFatal FATAL in JSON output, where slog would call
+// it ERROR+4.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == levelFatal {
+			a.Value = slog.StringValue("FATAL")
+		}
+	}
+	return a
+}
+
+// textHandler is a slog.Handler that writes b3tty's text format: a level
+// label and the message, followed by any attributes as key=value pairs. Lines
+// are written through a *log.Logger, which adds the timestamp.
+type textHandler struct {
+	// logger is where lines are written. Nil means the standard logger.
+	logger *log.Logger
+	// plain disables colored level labels, as for a log file.
+	plain bool
+	// attrs holds the attributes added with WithAttrs, already formatted.
+	attrs string
+	// group prefixes the keys of attributes added after WithGroup.
+	group string
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(h.label(r.Level))
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	l := h.logger
+	if l == nil {
+		l = log.Default()
+	}
+	return l.Output(0, b.String())
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	h2.attrs += b.String()
+	return &h2
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+// label returns the level label that starts each line.
+func (h *textHandler) label(level slog.Level) string {
+	color, label := ansiMagenta, "[DEBUG]"
+	switch {
+	case level >= levelFatal:
+		color, label = ansiBoldRed, "[FATAL]"
+	case level >= slog.LevelError:
+		color, label = ansiRed, "[ERROR]"
+	case level >= slog.LevelWarn:
+		color, label = ansiYellow, "[WARN ]"
+	case level >= slog.LevelInfo:
+		color, label = ansiCyan, "[INFO ]"
+	}
+	if useColor && !h.plain {
+		return color + label + ansiReset
+	}
+	return label
+}
+
+// appendAttr writes a as " key=value" to b, quoting the value when it is
+// empty or contains spaces, quotes or an equals sign.
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	b.WriteByte(' ')
+	b.WriteString(prefix)
+	b.WriteString(a.Key)
+	b.WriteByte('=')
+	b.WriteString(value)
 }
 
 func init() {
+	setLogHandler(&textHandler{})
 	fi, err := os.Stdout.Stat()
 	useColor = err == nil && (fi.Mode()&os.ModeCharDevice) != 0
 }
@@ -72,71 +311,102 @@ func BoldGreen(s string) string {
 	return s
 }
 
-func levelLabel(color, label string) string {
-	if useColor {
-		return color + label + ansiReset
+// logf logs a message formatted from format and args at level, with attrs
+// added. The message is only formatted when the level is enabled.
+func logf(level slog.Level, attrs []slog.Attr, format string, args ...any) {
+	ctx := context.Background()
+	h := *logHandler.Load()
+	if !h.Enabled(ctx, level) {
+		return
 	}
-	return label
+	r := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), 0)
+	r.AddAttrs(attrs...)
+	_ = h.Handle(ctx, r)
 }
 
-func infoLabel() string  { return levelLabel(ansiCyan, "[INFO ]") }
-func warnLabel() string  { return levelLabel(ansiYellow, "[WARN ]") }
-func errorLabel() string { return levelLabel(ansiRed, "[ERROR]") }
-func fatalLabel() string { return levelLabel(ansiBoldRed, "[FATAL]") }
-func debugLabel() string { return levelLabel(ansiMagenta, "[DEBUG]") }
-
 // Infof logs an informational message.
 func Infof(format string, args ...any) {
-	log.Printf(infoLabel()+" "+format, args...)
+	logf(slog.LevelInfo, nil, format, args...)
 }
 
 // Info logs an informational message.
 func Info(msg string) {
-	log.Println(infoLabel(), msg)
+	logf(slog.LevelInfo, nil, "%s", msg)
 }
 
 // Warnf logs a warning message.
 func Warnf(format string, args ...any) {
-	log.Printf(warnLabel()+" "+format, args...)
+	logf(slog.LevelWarn, nil, format, args...)
 }
 
 // Warn logs a warning message.
 func Warn(msg string) {
-	log.Println(warnLabel(), msg)
+	logf(slog.LevelWarn, nil, "%s", msg)
 }
 
 // Errorf logs an error message.
 func Errorf(format string, args ...any) {
-	log.Printf(errorLabel()+" "+format, args...)
+	logf(slog.LevelError, nil, format, args...)
 }
 
 // Error logs an error message.
 func Error(msg string) {
-	log.Println(errorLabel(), msg)
+	logf(slog.LevelError, nil, "%s", msg)
 }
 
 // Fatalf logs a fatal error message and terminates the process.
 func Fatalf(format string, args ...any) {
-	log.Fatalf(fatalLabel()+" "+format, args...)
+	logf(levelFatal, nil, format, args...)
+	os.Exit(1)
 }
 
 // Fatal logs a fatal error message and terminates the process.
 func Fatal(args ...any) {
-	log.Fatal(append([]any{fatalLabel() + " "}, args...)...)
+	logf(levelFatal, nil, "%s", fmt.Sprint(args...))
+	os.Exit(1)
 }
 
-// Debugf logs a debug message. Output is suppressed unless SetDebug(true) has
-// been called.
+// Debugf logs a debug message. Output is suppressed unless debug logging has
+// been enabled.
 func Debugf(format string, args ...any) {
-	if debugEnabled {
-		log.Printf(debugLabel()+" "+format, args...)
-	}
+	logf(slog.LevelDebug, nil, format, args...)
 }
 
-// Debug logs a debug message. Output is suppressed unless SetDebug(true) has
-// been called.
+// Debug logs a debug message. Output is suppressed unless debug logging has
+// been enabled.
 func Debug(msg string) {
-	if debugEnabled {
-		log.Println(debugLabel(), msg)
-	}
+	logf(slog.LevelDebug, nil, "%s", msg)
+}
+
+// scopedLogger logs like Infof and the other helpers, adding attributes such
+// as the remote address of a request or the ID of a session to every
+// message. The zero value adds none.
+type scopedLogger struct {
+	attrs []slog.Attr
+}
+
+// logWith returns a scopedLogger that adds attrs.
+func logWith(attrs ...slog.Attr) scopedLogger {
+	return scopedLogger{attrs: attrs}
+}
+
+// with returns a scopedLogger that adds attrs to those l adds.
+func (l scopedLogger) with(attrs ...slog.Attr) scopedLogger {
+	return scopedLogger{attrs: append(l.attrs[:len(l.attrs):len(l.attrs)], attrs...)}
+}
+
+func (l scopedLogger) Debugf(format string, args ...any) {
+	logf(slog.LevelDebug, l.attrs, format, args...)
+}
+
+func (l scopedLogger) Infof(format string, args ...any) {
+	logf(slog.LevelInfo, l.attrs, format, args...)
+}
+
+func (l scopedLogger) Warnf(format string, args ...any) {
+	logf(slog.LevelWarn, l.attrs, format, args...)
+}
+
+func (l scopedLogger) Errorf(format string, args ...any) {
+	logf(slog.LevelError, l.attrs, format, args...)
 }
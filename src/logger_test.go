@@ -0,0 +1,183 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restoreLogging puts the log back as it is by default once t ends.
+func restoreLogging(t *testing.T) {
+	t.Helper()
+	color := useColor
+	t.Cleanup(func() {
+		SetLogger(nil)
+		SetDebug(false)
+		useColor = color
+	})
+}
+
+func TestTextLog(t *testing.T) {
+	restoreLogging(t)
+	useColor = false
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+
+	Infof("server started on %s", "http://localhost:8080")
+	Debug("not logged")
+	lg := logWith(slog.String("remote", "127.0.0.1:5000")).with(slog.String("profile", "my dev"))
+	lg.Warnf("session rejected")
+	Errorf("100%% done")
+	assert.Equal(t, "[INFO ] server started on http://localhost:8080\n"+
+		"[WARN ] session rejected remote=127.0.0.1:5000 profile=\"my dev\"\n"+
+		"[ERROR] 100% done\n", buf.String())
+
+	buf.Reset()
+	SetLogLevel(slog.LevelWarn)
+	Info("not logged")
+	Warn("logged")
+	assert.Equal(t, "[WARN ] logged\n", buf.String())
+	assert.False(t, debugEnabled())
+	SetDebug(true)
+	assert.True(t, debugEnabled())
+}
+
+func TestSetLogHandlerWhileLogging(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			Debugf("not logged")
+		}
+	}()
+	SetLogHandler(slog.NewJSONHandler(&buf, nil))
+	SetLogger(log.New(io.Discard, "", 0))
+	SetLogHandler(nil)
+	<-done
+}
+
+func TestScopedLoggerWith(t *testing.T) {
+	base := logWith(slog.String("remote", "a"), slog.String("profile", "p"))
+	one := base.with(slog.String("session", "1"))
+	two := base.with(slog.String("session", "2"))
+	assert.Equal(t, "1", one.attrs[2].Value.String(), "deriving another logger must not overwrite the attributes of the first")
+	assert.Equal(t, "2", two.attrs[2].Value.String())
+	assert.Len(t, base.attrs, 2)
+}
+
+func TestConfigureLogging(t *testing.T) {
+	t.Run("json to a file", func(t *testing.T) {
+		restoreLogging(t)
+		path := filepath.Join(t.TempDir(), "b3tty.log")
+		f, err := ConfigureLogging(LogConfig{Format: LOG_FORMAT_JSON, Level: "debug", File: path})
+		require.NoError(t, err)
+		logWith(slog.String("session", "abc")).Debugf("resizing to %d, %d", 80, 24)
+		Warn("careful")
+		require.NoError(t, f.Close())
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var lines []map[string]any
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var line map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+			lines = append(lines, line)
+		}
+		require.Len(t, lines, 2)
+		assert.Equal(t, "DEBUG", lines[0]["level"])
+		assert.Equal(t, "resizing to 80, 24", lines[0]["msg"])
+		assert.Equal(t, "abc", lines[0]["session"])
+		assert.Contains(t, lines[0], "time")
+		assert.Equal(t, "WARN", lines[1]["level"])
+		assert.False(t, useColor, "JSON logs carry no color codes")
+	})
+
+	t.Run("text to a file has no colors", func(t *testing.T) {
+		restoreLogging(t)
+		useColor = true
+		path := filepath.Join(t.TempDir(), "b3tty.log")
+		f, err := ConfigureLogging(LogConfig{Level: "warn", File: path})
+		require.NoError(t, err)
+		defer f.Close()
+		Info("not logged")
+		Errorf("pty read: %v", os.ErrClosed)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Regexp(t, `^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d \[ERROR\] pty read: file already closed\n$`, string(data))
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("fatal is named in JSON", func(t *testing.T) {
+		a := replaceLevel(nil, slog.Any(slog.LevelKey, levelFatal))
+		assert.Equal(t, "FATAL", a.Value.String())
+		a = replaceLevel(nil, slog.Any(slog.LevelKey, slog.LevelError))
+		assert.Equal(t, slog.LevelError, a.Value.Any())
+	})
+
+	errorCases := []struct {
+		name   string
+		config LogConfig
+		errMsg string
+	}{
+		{name: "unknown format", config: LogConfig{Format: "xml"}, errMsg: `unknown log format "xml"`},
+		{name: "unknown level", config: LogConfig{Level: "verbose"}, errMsg: `unknown log level "verbose"`},
+		{name: "negative size", config: LogConfig{MaxSize: -1}, errMsg: "must not be negative"},
+		{name: "unwritable file", config: LogConfig{File: "/b3tty-no-such-dir/b3tty.log"}, errMsg: "log file"},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreLogging(t)
+			_, err := ConfigureLogging(tt.config)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "b3tty.log")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0600))
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "a line longer than the limit\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "a line longer than the limit\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3", "only maxFiles rotated files are kept")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, "b3tty.log b3tty.log.1 b3tty.log.2", strings.Join(names, " "))
+}
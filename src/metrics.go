@@ -0,0 +1,276 @@
+package src
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of every
+// latency histogram. They are the default buckets of the Prometheus client
+// libraries.
+var latencyBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations into latencyBuckets.
+type histogram struct {
+	// buckets holds the number of observations that fell into each bucket
+	// and no lower one; they are summed up when written.
+	buckets [len(latencyBuckets)]uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metrics counts what the server does, for the /metrics endpoint. The zero
+// value is ready to use and a nil *metrics counts nothing.
+type metrics struct {
+	authFailures atomic.Uint64
+	// bytesIn counts terminal input written to processes and bytesOut
+	// terminal output sent to clients.
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	mu sync.Mutex
+	// sessionsStarted and spawnFailures are counted by profile, and
+	// websocketErrors by the operation that failed.
+	sessionsStarted map[string]uint64
+	spawnFailures   map[string]uint64
+	websocketErrors map[string]uint64
+	// requests holds the latency of HTTP requests by route, and sessionStart
+	// how long it took from the WebSocket request to a started session.
+	requests     map[string]*histogram
+	sessionStart histogram
+}
+
+// Operations counted by b3tty_websocket_errors_total.
+const (
+	wsOpUpgrade = "upgrade"
+	wsOpRead    = "read"
+	wsOpWrite   = "write"
+)
+
+// increment adds one to counts[key], allocating counts if needed.
+func increment(counts *map[string]uint64, key string) {
+	if *counts == nil {
+		*counts = make(map[string]uint64)
+	}
+	(*counts)[key]++
+}
+
+func (m *metrics) authFailure() {
+	if m != nil {
+		m.authFailures.Add(1)
+	}
+}
+
+func (m *metrics) received(n int) {
+	if m != nil {
+		m.bytesIn.Add(uint64(n))
+	}
+}
+
+func (m *metrics) sent(n int) {
+	if m != nil {
+		m.bytesOut.Add(uint64(n))
+	}
+}
+
+func (m *metrics) websocketError(op string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	increment(&m.websocketErrors, op)
+}
+
+func (m *metrics) spawnFailed(profile string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	increment(&m.spawnFailures, profile)
+}
+
+// sessionStarted counts a session of profile that took d to start.
+func (m *metrics) sessionStarted(profile string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	increment(&m.sessionsStarted, profile)
+	m.sessionStart.observe(d)
+}
+
+// observeRequest records that a request to route took d.
+func (m *metrics) observeRequest(route string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[string]*histogram)
+	}
+	h := m.requests[route]
+	if h == nil {
+		h = &histogram{}
+		m.requests[route] = h
+	}
+	h.observe(d)
+}
+
+// metricsText returns ts's metrics in the Prometheus text exposition format.
+// Counters by profile include every configured profile, so that their series
+// exist before the first session starts.
+func (ts *TerminalServer) metricsText() string {
+	profiles := ts.profilesSnapshot()
+	active := make(map[string]uint64, len(profiles))
+	started := make(map[string]uint64, len(profiles))
+	spawnFailures := make(map[string]uint64, len(profiles))
+	for name := range profiles {
+		active[name], started[name], spawnFailures[name] = 0, 0, 0
+	}
+	ts.sessionsMu.Lock()
+	for s := range ts.sessions {
+		active[s.Profile]++
+	}
+	ts.sessionsMu.Unlock()
+
+	m := &ts.metrics
+	wsErrors := map[string]uint64{wsOpUpgrade: 0, wsOpRead: 0, wsOpWrite: 0}
+	m.mu.Lock()
+	maps.Copy(started, m.sessionsStarted)
+	maps.Copy(spawnFailures, m.spawnFailures)
+	maps.Copy(wsErrors, m.websocketErrors)
+	requests := make(map[string]histogram, len(m.requests))
+	for route, h := range m.requests {
+		requests[route] = *h
+	}
+	sessionStart := m.sessionStart
+	m.mu.Unlock()
+
+	var b strings.Builder
+	writeFamily(&b, "b3tty_sessions_active", "gauge", "Terminal sessions open, including detached ones, by profile.")
+	writeCounts(&b, "b3tty_sessions_active", "profile", active)
+	writeFamily(&b, "b3tty_sessions_started_total", "counter", "Terminal sessions started, by profile.")
+	writeCounts(&b, "b3tty_sessions_started_total", "profile", started)
+	writeFamily(&b, "b3tty_auth_failures_total", "counter", "Requests refused for an invalid or missing token or by the authorize hook.")
+	writeSample(&b, "b3tty_auth_failures_total", "", float64(m.authFailures.Load()))
+	writeFamily(&b, "b3tty_received_bytes_total", "counter", "Bytes of terminal input received from clients.")
+	writeSample(&b, "b3tty_received_bytes_total", "", float64(m.bytesIn.Load()))
+	writeFamily(&b, "b3tty_sent_bytes_total", "counter", "Bytes of terminal output sent to clients.")
+	writeSample(&b, "b3tty_sent_bytes_total", "", float64(m.bytesOut.Load()))
+	writeFamily(&b, "b3tty_websocket_errors_total", "counter", "WebSocket upgrades, reads and writes that failed, by operation.")
+	writeCounts(&b, "b3tty_websocket_errors_total", "op", wsErrors)
+	writeFamily(&b, "b3tty_pty_spawn_failures_total", "counter", "Terminal processes that failed to start or restart, by profile.")
+	writeCounts(&b, "b3tty_pty_spawn_failures_total", "profile", spawnFailures)
+	writeFamily(&b, "b3tty_session_start_duration_seconds", "histogram", "Time from a WebSocket request to its terminal session starting.")
+	writeHistogram(&b, "b3tty_session_start_duration_seconds", "", &sessionStart)
+	writeFamily(&b, "b3tty_http_request_duration_seconds", "histogram", "Latency of HTTP requests other than WebSocket connections, by route.")
+	for _, route := range slices.Sorted(maps.Keys(requests)) {
+		h := requests[route]
+		writeHistogram(&b, "b3tty_http_request_duration_seconds", labelPair("handler", route), &h)
+	}
+	return b.String()
+}
+
+// writeFamily writes the HELP and TYPE lines that introduce a metric.
+func writeFamily(b *strings.Builder, name, kind, help string) {
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// writeSample writes one sample of a metric. labels is empty or a
+// comma-separated list of label pairs.
+func writeSample(b *strings.Builder, name, labels string, value float64) {
+	b.WriteString(name)
+	if labels != "" {
+		b.WriteString("{" + labels + "}")
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatSampleValue(value))
+	b.WriteByte('\n')
+}
+
+// writeCounts writes a sample for each entry of counts, labelled with label,
+// in order of their keys.
+func writeCounts(b *strings.Builder, name, label string, counts map[string]uint64) {
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		writeSample(b, name, labelPair(label, key), float64(counts[key]))
+	}
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of h.
+func writeHistogram(b *strings.Builder, name, labels string, h *histogram) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	var cumulative uint64
+	for i, le := range latencyBuckets {
+		cumulative += h.buckets[i]
+		writeSample(b, name+"_bucket", prefix+labelPair("le", formatSampleValue(le)), float64(cumulative))
+	}
+	writeSample(b, name+"_bucket", prefix+labelPair("le", "+Inf"), float64(h.count))
+	writeSample(b, name+"_sum", labels, h.sum)
+	writeSample(b, name+"_count", labels, float64(h.count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelPair returns name="value" with value escaped for the text format.
+func labelPair(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func formatSampleValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsHandler serves the metrics on the main listener. It requires the
+// access token, like the terminal page; Prometheus can pass it with the
+// params setting of a scrape config.
+// GET /metrics?token=<token>
+func (ts *TerminalServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !validateToken(r.URL.Query().Get("token"), ts.Token) {
+		Warnf("%s %s: forbidden: invalid token", r.Method, r.URL.Path)
+		ts.audit(r, auditEvent{Event: auditAuthFailure, Path: r.URL.Path, Detail: "invalid token"})
+		ts.metrics.authFailure()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	ts.serveMetrics(w, r)
+}
+
+// serveMetrics writes the metrics in the Prometheus text format.
+func (ts *TerminalServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		Warnf("%s %s: method not allowed", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	if _, err := io.WriteString(w, ts.metricsText()); err != nil {
+		Errorf("metrics response error: %v", err)
+	}
+}
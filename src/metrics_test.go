@@ -0,0 +1,168 @@
+package src
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsText(t *testing.T) {
+	t.Run("series exist before anything happens", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		text := ts.metricsText()
+		for _, line := range []string{
+			"# TYPE b3tty_sessions_active gauge",
+			`b3tty_sessions_active{profile="default"} 0`,
+			`b3tty_sessions_active{profile="work"} 0`,
+			`b3tty_sessions_started_total{profile="work"} 0`,
+			"b3tty_auth_failures_total 0",
+			"b3tty_received_bytes_total 0",
+			"b3tty_sent_bytes_total 0",
+			`b3tty_websocket_errors_total{op="upgrade"} 0`,
+			`b3tty_pty_spawn_failures_total{profile="default"} 0`,
+			`b3tty_session_start_duration_seconds_bucket{le="+Inf"} 0`,
+			"# TYPE b3tty_http_request_duration_seconds histogram",
+		} {
+			assert.Contains(t, text, line+"\n")
+		}
+		assert.NotContains(t, text, "b3tty_http_request_duration_seconds_count")
+	})
+
+	t.Run("histograms are cumulative", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.metrics.observeRequest("/sessions", 3*time.Millisecond)
+		ts.metrics.observeRequest("/sessions", 200*time.Millisecond)
+		ts.metrics.observeRequest("/sessions", time.Minute)
+		text := ts.metricsText()
+		for _, line := range []string{
+			`b3tty_http_request_duration_seconds_bucket{handler="/sessions",le="0.005"} 1`,
+			`b3tty_http_request_duration_seconds_bucket{handler="/sessions",le="0.1"} 1`,
+			`b3tty_http_request_duration_seconds_bucket{handler="/sessions",le="0.25"} 2`,
+			`b3tty_http_request_duration_seconds_bucket{handler="/sessions",le="10"} 2`,
+			`b3tty_http_request_duration_seconds_bucket{handler="/sessions",le="+Inf"} 3`,
+			`b3tty_http_request_duration_seconds_sum{handler="/sessions"} 60.203`,
+			`b3tty_http_request_duration_seconds_count{handler="/sessions"} 3`,
+		} {
+			assert.Contains(t, text, line+"\n")
+		}
+	})
+
+	t.Run("label values are escaped", func(t *testing.T) {
+		assert.Equal(t, `profile="a\"b\\c\nd"`, labelPair("profile", "a\"b\\c\nd"))
+	})
+
+	t.Run("a nil metrics counts nothing", func(t *testing.T) {
+		var m *metrics
+		m.authFailure()
+		m.sent(1)
+		m.websocketError(wsOpRead)
+		m.sessionStarted("default", time.Second)
+	})
+}
+
+func TestMetricsHandler(t *testing.T) {
+	newHandler := func(t *testing.T, m Metrics) *Handler {
+		t.Helper()
+		opts := testServerOptions()
+		opts.Server.Metrics = m
+		h, err := NewHandler(opts)
+		require.NoError(t, err)
+		return h
+	}
+
+	t.Run("requires the token on the main listener", func(t *testing.T) {
+		h := newHandler(t, Metrics{Enabled: true})
+		w := httptest.NewRecorder()
+		captureLog(func() { h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil)) })
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?token="+h.Token(), nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, METRICS_CONTENT_TYPE, w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		assert.Contains(t, body, "b3tty_auth_failures_total 1\n")
+		assert.Contains(t, body, `b3tty_http_request_duration_seconds_count{handler="/metrics"} 1`+"\n")
+	})
+
+	t.Run("auth failures on the terminal page are counted", func(t *testing.T) {
+		h := newHandler(t, Metrics{Enabled: true})
+		h.ts.AuthSleep = func(time.Duration) {}
+		captureLog(func() {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?token=guess", nil))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+		assert.EqualValues(t, 2, h.ts.metrics.authFailures.Load())
+	})
+
+	t.Run("not served unless enabled", func(t *testing.T) {
+		h := newHandler(t, Metrics{})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?token="+h.Token(), nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("a separate listener takes it off the main one", func(t *testing.T) {
+		h := newHandler(t, Metrics{Enabled: true, Address: "127.0.0.1:9090"})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?token="+h.Token(), nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		h.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "b3tty_sessions_active")
+
+		w = httptest.NewRecorder()
+		captureLog(func() { h.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/metrics", nil)) })
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func TestTerminalHandlerMetrics(t *testing.T) {
+	t.Run("sessions and their traffic", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		backend, conn := newFakeTerminal(t, ts)
+		proc := backend.process(t, 0)
+		keepReading(conn)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ls\r")))
+		proc.emit("hello")
+		require.Eventually(t, func() bool {
+			return ts.metrics.bytesIn.Load() == 3 && ts.metrics.bytesOut.Load() == 5
+		}, 2*time.Second, 5*time.Millisecond)
+
+		text := ts.metricsText()
+		assert.Contains(t, text, `b3tty_sessions_active{profile="default"} 1`+"\n")
+		assert.Contains(t, text, `b3tty_sessions_started_total{profile="default"} 1`+"\n")
+		assert.Contains(t, text, "b3tty_session_start_duration_seconds_count 1\n")
+
+		proc.exit()
+		require.Eventually(t, func() bool { return ts.sessionCount() == 0 }, 2*time.Second, 5*time.Millisecond)
+		assert.Contains(t, ts.metricsText(), `b3tty_sessions_active{profile="default"} 0`+"\n")
+	})
+
+	t.Run("spawn failures", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Backends = map[string]Backend{"broken": &fakeBackend{startErr: errors.New("no pty available")}}
+		ts.Profiles["broken"] = Profile{Type: "broken"}
+		ts.setActiveProfileName("broken")
+		captureLog(func() {
+			_, conn := newFakeTerminal(t, ts)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					break
+				}
+			}
+		})
+		text := ts.metricsText()
+		assert.Contains(t, text, `b3tty_pty_spawn_failures_total{profile="broken"} 1`+"\n")
+		assert.Contains(t, text, `b3tty_sessions_started_total{profile="broken"} 0`+"\n")
+	})
+}
@@ -1,13 +1,19 @@
 package src
 
 import (
+	"compress/flate"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
 	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/shlex"
 )
@@ -38,6 +44,59 @@ type Server struct {
 	NoAuth   bool
 	FirstRun bool
 	TLS
+	// PingInterval is how often a WebSocket ping is sent to each connected
+	// browser. Zero selects DEFAULT_PING_INTERVAL.
+	PingInterval time.Duration
+	// PongTimeout is how long a connection may go without receiving anything
+	// from the browser, including pongs, before it is considered dead. It must
+	// be longer than PingInterval. Zero selects DEFAULT_PONG_TIMEOUT.
+	PongTimeout time.Duration
+	// DetachGracePeriod is how long a session whose connection died keeps its
+	// shell running while waiting for the browser to reattach. Zero ends the
+	// session as soon as the connection is lost.
+	DetachGracePeriod time.Duration
+	// KillGracePeriod is how long a shell and the jobs it started are given
+	// to exit after SIGHUP when their session ends, before they are sent
+	// SIGKILL. Zero selects DEFAULT_KILL_GRACE_PERIOD.
+	KillGracePeriod time.Duration
+	// ShutdownDrainPeriod is how long sessions are given to end on their own
+	// once the server has told connected terminals that it is shutting down,
+	// before they are closed. Zero selects DEFAULT_SHUTDOWN_DRAIN_PERIOD and
+	// a negative value closes them at once.
+	ShutdownDrainPeriod time.Duration
+	// ReadBufferSize is the size of the buffer each session reads terminal
+	// output into. Zero selects BUFFER_SIZE.
+	ReadBufferSize int
+	// OutputBatchWindow is how long terminal output is held back so that
+	// output following it can be sent in the same WebSocket frame. Output
+	// that arrives after a quiet period is sent at once, so typing is not
+	// delayed. Zero selects DEFAULT_OUTPUT_BATCH_WINDOW and a negative value
+	// disables batching.
+	OutputBatchWindow time.Duration
+	// OutputBatchSize caps the number of bytes batched into one frame. Zero
+	// selects DEFAULT_OUTPUT_BATCH_SIZE.
+	OutputBatchSize int
+	// FlowControlWindow is how many bytes of output may be sent to a client
+	// that acknowledges output before it must acknowledge some of them. While
+	// the window is full the session stops reading from the pty, so the
+	// kernel blocks the process writing to it. Zero selects
+	// DEFAULT_FLOW_CONTROL_WINDOW.
+	FlowControlWindow int
+	// Compression controls permessage-deflate compression of WebSocket
+	// frames. It is off unless Compression.Enabled is set.
+	Compression Compression
+	// IdleTimeout closes a session that has had no input or output for this
+	// long, and MaxSessionDuration one that has been open this long. Zero
+	// disables them. A profile can override both; see Profile.IdleTimeout.
+	IdleTimeout        time.Duration
+	MaxSessionDuration time.Duration
+	// MaxSessions caps the number of terminal sessions open at once. Zero
+	// means no limit. A profile can set a lower limit of its own; see
+	// Profile.MaxSessions.
+	MaxSessions int
+	// Metrics controls the Prometheus metrics endpoint. It is off unless
+	// Metrics.Enabled is set.
+	Metrics Metrics
 }
 
 func NewServer(uri *string, port *int, noAuth *bool, tls *TLS) *Server {
@@ -49,6 +108,122 @@ func NewServer(uri *string, port *int, noAuth *bool, tls *TLS) *Server {
 	}
 }
 
+// keepalive returns the effective ping interval and pong timeout, substituting
+// the defaults for unset values.
+func (s *Server) keepalive() (interval, timeout time.Duration) {
+	interval, timeout = s.PingInterval, s.PongTimeout
+	if interval == 0 {
+		interval = DEFAULT_PING_INTERVAL
+	}
+	if timeout == 0 {
+		timeout = DEFAULT_PONG_TIMEOUT
+	}
+	return interval, timeout
+}
+
+// killGrace returns the effective kill grace period, substituting the default
+// for an unset value.
+func (s *Server) killGrace() time.Duration {
+	if s.KillGracePeriod == 0 {
+		return DEFAULT_KILL_GRACE_PERIOD
+	}
+	return s.KillGracePeriod
+}
+
+// shutdownDrain returns the effective shutdown drain period, substituting the
+// default for an unset value. It is zero when draining is disabled.
+func (s *Server) shutdownDrain() time.Duration {
+	switch {
+	case s.ShutdownDrainPeriod == 0:
+		return DEFAULT_SHUTDOWN_DRAIN_PERIOD
+	case s.ShutdownDrainPeriod < 0:
+		return 0
+	}
+	return s.ShutdownDrainPeriod
+}
+
+// outputConfig controls how a session reads and batches terminal output.
+type outputConfig struct {
+	bufferSize  int
+	batchWindow time.Duration
+	batchSize   int
+	flowWindow  int
+}
+
+// output returns the effective output settings, substituting the defaults for
+// unset values.
+func (s *Server) output() outputConfig {
+	out := outputConfig{
+		bufferSize:  s.ReadBufferSize,
+		batchWindow: s.OutputBatchWindow,
+		batchSize:   s.OutputBatchSize,
+		flowWindow:  s.FlowControlWindow,
+	}
+	if out.bufferSize == 0 {
+		out.bufferSize = BUFFER_SIZE
+	}
+	if out.batchWindow == 0 {
+		out.batchWindow = DEFAULT_OUTPUT_BATCH_WINDOW
+	}
+	if out.batchSize == 0 {
+		out.batchSize = DEFAULT_OUTPUT_BATCH_SIZE
+	}
+	if out.flowWindow == 0 {
+		out.flowWindow = DEFAULT_FLOW_CONTROL_WINDOW
+	}
+	return out
+}
+
+// Validate reports an error when the keepalive, output or compression settings
+// are out of range, or when the pong timeout is too short for a pong to arrive between
+// pings.
+func (s *Server) Validate() error {
+	if s.PingInterval < 0 || s.PongTimeout < 0 || s.DetachGracePeriod < 0 {
+		return fmt.Errorf("keepalive durations must not be negative")
+	}
+	interval, timeout := s.keepalive()
+	if timeout <= interval {
+		return fmt.Errorf("pong timeout (%s) must be longer than ping interval (%s)", timeout, interval)
+	}
+	if s.KillGracePeriod < 0 {
+		return fmt.Errorf("kill grace period must not be negative")
+	}
+	if s.IdleTimeout < 0 || s.MaxSessionDuration < 0 {
+		return fmt.Errorf("session timeouts must not be negative")
+	}
+	if s.MaxSessions < 0 {
+		return fmt.Errorf("max sessions must not be negative")
+	}
+	if s.ReadBufferSize < 0 || s.ReadBufferSize > MAX_READ_BUFFER_SIZE {
+		return fmt.Errorf("read buffer size must be between 1 and %d bytes", MAX_READ_BUFFER_SIZE)
+	}
+	if s.OutputBatchSize < 0 {
+		return fmt.Errorf("output batch size must not be negative")
+	}
+	if s.FlowControlWindow < 0 {
+		return fmt.Errorf("flow control window must not be negative")
+	}
+	level, threshold := s.Compression.settings()
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return fmt.Errorf("compression level must be between %d and %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+	if threshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+	if s.ClientCAFilePath != "" && !s.Enabled {
+		return fmt.Errorf("client CA file is set but TLS is not enabled")
+	}
+	if s.Metrics.Address != "" {
+		if !s.Metrics.Enabled {
+			return fmt.Errorf("metrics address is set but metrics are not enabled")
+		}
+		if _, _, err := net.SplitHostPort(s.Metrics.Address); err != nil {
+			return fmt.Errorf("metrics address: %w", err)
+		}
+	}
+	return nil
+}
+
 func (s *Server) Addr() url.URL {
 	return url.URL{
 		Host: s.Uri + ":" + strconv.Itoa(s.Port),
@@ -59,14 +234,143 @@ type TLS struct {
 	Enabled      bool
 	CertFilePath string
 	KeyFilePath  string
+	// ClientCAFilePath names a PEM file of CA certificates. When set,
+	// clients may present a certificate signed by one of them, and its
+	// subject is recorded as the identity in the audit log. Clients without
+	// a certificate are still served.
+	ClientCAFilePath string
+}
+
+// config returns the TLS configuration for the HTTP server, which verifies
+// client certificates against ClientCAFilePath when it is set.
+func (t TLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if t.ClientCAFilePath == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(t.ClientCAFilePath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", t.ClientCAFilePath)
+	}
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	cfg.ClientCAs = pool
+	return cfg, nil
+}
+
+// Compression configures permessage-deflate compression of WebSocket frames.
+// Compressing output that mixes secrets with attacker-controlled input can
+// leak the secrets through the compressed size, as in the BREACH attack, so
+// it must be enabled explicitly.
+type Compression struct {
+	Enabled bool
+	// Level is the flate compression level, from -2 (Huffman only) to 9
+	// (best compression). Nil selects DEFAULT_COMPRESSION_LEVEL.
+	Level *int
+	// Threshold is the smallest message, in bytes, that is compressed.
+	// Smaller messages are sent uncompressed, since compressing them costs
+	// more than it saves. Zero compresses every message and nil selects
+	// DEFAULT_COMPRESSION_THRESHOLD.
+	Threshold *int
+}
+
+// Metrics configures the /metrics endpoint, which reports session counts,
+// traffic, failures and request latency in the Prometheus text format.
+type Metrics struct {
+	Enabled bool
+	// Address, when set, serves /metrics without authentication on a
+	// separate plain HTTP listener at this host:port instead of on the main
+	// listener, where it requires the access token.
+	Address string
+}
+
+// settings returns the effective compression level and threshold,
+// substituting the defaults for unset values.
+func (c Compression) settings() (level, threshold int) {
+	level, threshold = DEFAULT_COMPRESSION_LEVEL, DEFAULT_COMPRESSION_THRESHOLD
+	if c.Level != nil {
+		level = *c.Level
+	}
+	if c.Threshold != nil {
+		threshold = *c.Threshold
+	}
+	return level, threshold
 }
 
 type Profile struct {
+	// Type selects the Backend that starts the profile's shell. An empty
+	// value selects DEFAULT_BACKEND.
+	Type string
+	// Root is the directory the shell sees as /. Any directory other than /
+	// runs the shell in a sandbox; see Sandbox.
 	Root             string
 	WorkingDirectory string
 	Shell            string
 	Title            string
 	Commands         []string
+	// CommandWait is what to wait for before typing each of Commands into
+	// the shell; see WaitCondition.
+	CommandWait WaitCondition
+	// CommandWaits holds wait conditions for individual commands, by index
+	// into Commands. Their unset fields are taken from CommandWait. It may be
+	// shorter than Commands.
+	CommandWaits []WaitCondition
+	// Command is the program and arguments to run instead of the shell. It
+	// is started directly rather than through /bin/sh -c, so its arguments
+	// are passed as given. The program is looked up in b3tty's PATH.
+	Command []string
+	// LoginShell starts the shell as a login shell by passing it -l. It
+	// cannot be combined with Command.
+	LoginShell bool
+	// Argv0 replaces argv[0], the name the program is started under, such as
+	// "-zsh" to start zsh as a login shell by convention.
+	Argv0 string
+	// OnExit is what happens when the shell exits: ON_EXIT_CLOSE ends the
+	// session, ON_EXIT_RESTART starts the shell again,
+	// ON_EXIT_RESTART_ON_FAILURE starts it again only when it failed, and
+	// ON_EXIT_PROMPT starts it again once the user presses Enter. An empty
+	// value selects ON_EXIT_CLOSE. Restarts reuse the session's WebSocket.
+	OnExit string
+	// RestartLimit is how many times in a row the shell is restarted
+	// automatically before the session is ended. Zero selects
+	// DEFAULT_RESTART_LIMIT and a negative value removes the limit.
+	RestartLimit int
+	// RestartBackoff is the delay before the first automatic restart. It
+	// doubles with each consecutive restart. Zero selects
+	// DEFAULT_RESTART_BACKOFF.
+	RestartBackoff time.Duration
+	// Env sets environment variables for the shell, overriding inherited
+	// variables and those read from EnvFiles. Values may refer to other
+	// variables as $NAME or ${NAME}.
+	Env map[string]string
+	// EnvFiles are dotenv files whose variables are set for the shell, in
+	// order.
+	EnvFiles []string
+	// InheritEnv selects which of b3tty's environment variables the shell
+	// inherits. The zero value inherits all of them.
+	InheritEnv EnvFilter
+	// Sandbox adds read-only mounts and a private /tmp to the sandbox. Any
+	// setting runs the shell in a sandbox even when Root is /.
+	Sandbox Sandbox
+	// Limits caps the resources the shell and its jobs can use.
+	Limits Limits
+	// User and Group name the Unix user and group the shell runs as, by
+	// name or numeric ID. A User also sets the shell's supplementary groups
+	// and home directory. Empty values keep b3tty's own. Switching needs
+	// CAP_SETUID and CAP_SETGID; see credential.checkPrivileges.
+	User  string
+	Group string
+	// IdleTimeout and MaxSessionDuration override the server's settings of
+	// the same names for the profile's sessions. Zero uses the server's and
+	// a negative value disables the timeout.
+	IdleTimeout        time.Duration
+	MaxSessionDuration time.Duration
+	// MaxSessions caps the number of the profile's sessions open at once, on
+	// top of Server.MaxSessions. Zero means no limit.
+	MaxSessions int
 }
 
 // ParseCommands processes the Profile Commands and returns a slice of string slices.
@@ -78,7 +382,7 @@ type Profile struct {
 func (p *Profile) ParseCommands() ([][]string, error) {
 	commands := [][]string{}
 	for _, cmd := range p.Commands {
-		proto, err := shlex.Split(strings.TrimSpace(cmd))
+		proto, err := SplitCommand(cmd)
 		if err != nil {
 			return commands, err
 		}
@@ -87,17 +391,99 @@ func (p *Profile) ParseCommands() ([][]string, error) {
 	return commands, nil
 }
 
+// SplitCommand splits command into arguments the way a shell would, honoring
+// quotes and backslash escapes, after trimming surrounding whitespace.
+func SplitCommand(command string) ([]string, error) {
+	return shlex.Split(strings.TrimSpace(command))
+}
+
+// argv returns the program and arguments that start the profile's process.
+// Command is used as given when set. Otherwise, when LoginShell or Argv0 is
+// set, the shell is started directly, with $SHELL standing for b3tty's own
+// shell. Otherwise the shell is started via /bin/sh -c, so Shell may contain
+// flags or variables.
+func (p *Profile) argv() ([]string, error) {
+	switch {
+	case len(p.Command) > 0:
+		return p.Command, nil
+	case p.LoginShell || p.Argv0 != "":
+		shell := p.Shell
+		if shell == "" || shell == DEFAULT_SHELL {
+			shell = os.Getenv("SHELL")
+		}
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		argv, err := SplitCommand(shell)
+		if err != nil {
+			return nil, fmt.Errorf("shell: %w", err)
+		}
+		if len(argv) == 0 {
+			return nil, fmt.Errorf("shell is empty")
+		}
+		if p.LoginShell {
+			argv = append([]string{argv[0], "-l"}, argv[1:]...)
+		}
+		return argv, nil
+	}
+	return []string{"/bin/sh", "-c", p.Shell}, nil
+}
+
+// validateCommand reports an error when p's Command has no program or is
+// combined with LoginShell.
+func (p Profile) validateCommand() error {
+	if len(p.Command) > 0 && p.Command[0] == "" {
+		return fmt.Errorf("command has no program")
+	}
+	if len(p.Command) > 0 && p.LoginShell {
+		return fmt.Errorf("login-shell cannot be used with command")
+	}
+	return nil
+}
+
 // ApplyToCommand applies the Profile's settings to the given exec.Cmd.
 // It sets the working directory based on the Profile's WorkingDirectory field,
 // expanding $HOME and ~ to the user's home directory.
 // If a custom shell is specified in the Profile, it replaces the last argument
-// of the command with the custom shell.
+// of the command with the custom shell, unless the profile starts its process
+// directly; see argv. If Argv0 is set, it replaces the command's argv[0].
+// The command's environment is built from b3tty's own as described by
+// Profile.Env, Profile.EnvFiles and Profile.InheritEnv.
+// If User or Group is set, the command is started with that user's and
+// group's credentials, and the user's home directory takes the place of
+// b3tty's, both for the paths above and for HOME, USER, LOGNAME and SHELL.
+// Unless InheritEnv has an allow list, such a command then inherits only
+// the variables described by minimalEnviron.
 // Returns the modified exec.Cmd and any error encountered.
 func (p *Profile) ApplyToCommand(cmd *exec.Cmd) (*exec.Cmd, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
 	}
+	base := os.Environ()
+	cred, err := p.lookupCredential()
+	if err != nil {
+		return nil, err
+	}
+	if cred != nil {
+		if err := cred.checkPrivileges(); err != nil {
+			return nil, err
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = cred.sysProcAttr()
+		if len(p.InheritEnv.Allow) == 0 {
+			base = minimalEnviron(base, cred.Name == "")
+		}
+		if cred.Name != "" {
+			home = cred.Home
+			base = append(base, "HOME="+cred.Home, "USER="+cred.Name, "LOGNAME="+cred.Name)
+			if cred.Shell != "" {
+				base = append(base, "SHELL="+cred.Shell)
+			}
+		}
+	}
 	if p.WorkingDirectory == "" || p.WorkingDirectory == "$HOME" {
 		cmd.Dir = home
 	} else {
@@ -107,9 +493,18 @@ func (p *Profile) ApplyToCommand(cmd *exec.Cmd) (*exec.Cmd, error) {
 		}
 	}
 
-	if p.Shell != "" && p.Shell != "$SHELL" && strings.Contains(p.Shell, " ") == false {
+	direct := len(p.Command) > 0 || p.LoginShell || p.Argv0 != ""
+	if !direct && p.Shell != "" && p.Shell != "$SHELL" && strings.Contains(p.Shell, " ") == false {
 		cmd.Args[len(cmd.Args)-1] = p.Shell
 	}
+	if p.Argv0 != "" {
+		cmd.Args[0] = p.Argv0
+	}
+
+	cmd.Env, err = p.environ(base, home)
+	if err != nil {
+		return nil, err
+	}
 	return cmd, nil
 }
 
@@ -225,9 +620,15 @@ type TermConfig struct {
 	BuiltinThemeNames  []string `json:"builtinThemeNames"`
 	ProfileNames       []string `json:"profileNames"`
 	ActiveTheme        string   `json:"activeTheme"`
+	// PingInterval and DetachGracePeriod are in milliseconds. The browser
+	// pings the server at PingInterval and, when DetachGracePeriod is set,
+	// reconnects to its session within that time after losing the connection.
+	PingInterval      int64 `json:"pingInterval"`
+	DetachGracePeriod int64 `json:"detachGracePeriod"`
 }
 
 func NewTermConfig(srv *Server, clnt *Client, thm *Theme, themeNames []string, allThemeNames []string, builtinThemeNames []string, profileNames []string, activeTheme string) *TermConfig {
+	pingInterval, _ := srv.keepalive()
 	return &TermConfig{
 		TLS:                srv.TLS.Enabled,
 		CursorBlink:        clnt.CursorBlink,
@@ -238,13 +639,15 @@ func NewTermConfig(srv *Server, clnt *Client, thm *Theme, themeNames []string, a
 		Theme:              *thm,
 		Uri:                srv.Uri,
 		Port:               srv.Port,
-		Debug:              debugEnabled,
+		Debug:              debugEnabled(),
 		HasBackgroundImage: thm.BackgroundImage != "",
 		ThemeNames:         themeNames,
 		AllThemeNames:      allThemeNames,
 		BuiltinThemeNames:  builtinThemeNames,
 		ProfileNames:       profileNames,
 		ActiveTheme:        activeTheme,
+		PingInterval:       pingInterval.Milliseconds(),
+		DetachGracePeriod:  srv.DetachGracePeriod.Milliseconds(),
 	}
 }
 
@@ -278,6 +681,35 @@ type profileConfigResponse struct {
 	Commands         []string `json:"commands"`
 }
 
+// sessionResponse describes an active terminal session in the list returned
+// by GET /sessions. Usage is omitted when the session's process has exited
+// or its backend cannot report usage.
+type sessionResponse struct {
+	ID       string         `json:"id"`
+	Profile  string         `json:"profile"`
+	Started  time.Time      `json:"started"`
+	Detached bool           `json:"detached"`
+	Usage    *ResourceUsage `json:"usage,omitempty"`
+}
+
+// sessionsResponse is the JSON shape returned by GET /sessions: the number of
+// active sessions and the limit on them, overall and for each profile with
+// an active session or a limit, and the sessions themselves, oldest first. A
+// MaxSessions of zero means no limit.
+type sessionsResponse struct {
+	Active      int                     `json:"active"`
+	MaxSessions int                     `json:"maxSessions"`
+	Profiles    map[string]sessionCount `json:"profiles"`
+	Sessions    []sessionResponse       `json:"sessions"`
+}
+
+// sessionCount is the number of active sessions of a profile and the limit
+// on them.
+type sessionCount struct {
+	Active      int `json:"active"`
+	MaxSessions int `json:"maxSessions"`
+}
+
 // editProfileResponse is returned by POST /edit-profile and POST /delete-profile.
 // ProfileNames is the sorted list of all non-default profile names after the operation.
 type editProfileResponse struct {
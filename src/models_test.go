@@ -1,12 +1,24 @@
 package src
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServerAddr(t *testing.T) {
@@ -50,6 +62,175 @@ func TestServerAddr(t *testing.T) {
 	}
 }
 
+func TestServerValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		errMsg string
+	}{
+		{name: "defaults", server: Server{}},
+		{name: "custom keepalive", server: Server{PingInterval: time.Second, PongTimeout: 3 * time.Second, DetachGracePeriod: time.Minute}},
+		{name: "timeout not longer than interval", server: Server{PingInterval: time.Minute, PongTimeout: time.Minute}, errMsg: "must be longer"},
+		{name: "interval above the default timeout", server: Server{PingInterval: 2 * time.Minute}, errMsg: "must be longer"},
+		{name: "negative keepalive", server: Server{DetachGracePeriod: -time.Second}, errMsg: "negative"},
+		{name: "negative kill grace period", server: Server{KillGracePeriod: -time.Second}, errMsg: "kill grace period"},
+		{name: "session timeouts", server: Server{IdleTimeout: time.Hour, MaxSessionDuration: 24 * time.Hour}},
+		{name: "negative idle timeout", server: Server{IdleTimeout: -time.Second}, errMsg: "session timeouts"},
+		{name: "negative max sessions", server: Server{MaxSessions: -1}, errMsg: "max sessions"},
+		{name: "custom output", server: Server{ReadBufferSize: 32 * 1024, OutputBatchWindow: -1, OutputBatchSize: 1024}},
+		{name: "read buffer too large", server: Server{ReadBufferSize: MAX_READ_BUFFER_SIZE + 1}, errMsg: "read buffer size"},
+		{name: "negative read buffer", server: Server{ReadBufferSize: -1}, errMsg: "read buffer size"},
+		{name: "negative batch size", server: Server{OutputBatchSize: -1}, errMsg: "batch size"},
+		{name: "negative flow control window", server: Server{FlowControlWindow: -1}, errMsg: "flow control window"},
+		{name: "custom compression", server: Server{Compression: Compression{Enabled: true, Level: ref(-2), Threshold: ref(1)}}},
+		{name: "zero compression level and threshold", server: Server{Compression: Compression{Enabled: true, Level: ref(0), Threshold: ref(0)}}},
+		{name: "compression level too high", server: Server{Compression: Compression{Level: ref(10)}}, errMsg: "compression level"},
+		{name: "negative compression threshold", server: Server{Compression: Compression{Threshold: ref(-1)}}, errMsg: "compression threshold"},
+		{name: "metrics on a separate listener", server: Server{Metrics: Metrics{Enabled: true, Address: "127.0.0.1:9090"}}},
+		{name: "metrics address without metrics", server: Server{Metrics: Metrics{Address: "127.0.0.1:9090"}}, errMsg: "metrics are not enabled"},
+		{name: "metrics address without a port", server: Server{Metrics: Metrics{Enabled: true, Address: "localhost"}}, errMsg: "metrics address"},
+		{name: "client CA with TLS", server: Server{TLS: TLS{Enabled: true, ClientCAFilePath: "/ca.pem"}}},
+		{name: "client CA without TLS", server: Server{TLS: TLS{ClientCAFilePath: "/ca.pem"}}, errMsg: "TLS is not enabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.server.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestServerOutputDefaults(t *testing.T) {
+	out := (&Server{}).output()
+	assert.Equal(t, BUFFER_SIZE, out.bufferSize)
+	assert.Equal(t, DEFAULT_OUTPUT_BATCH_WINDOW, out.batchWindow)
+	assert.Equal(t, DEFAULT_OUTPUT_BATCH_SIZE, out.batchSize)
+	assert.Equal(t, DEFAULT_FLOW_CONTROL_WINDOW, out.flowWindow)
+
+	out = (&Server{ReadBufferSize: 1, OutputBatchWindow: -1, OutputBatchSize: 2, FlowControlWindow: 3}).output()
+	assert.Equal(t, outputConfig{bufferSize: 1, batchWindow: -1, batchSize: 2, flowWindow: 3}, out)
+}
+
+func TestServerShutdownDrain(t *testing.T) {
+	assert.Equal(t, DEFAULT_SHUTDOWN_DRAIN_PERIOD, (&Server{}).shutdownDrain())
+	assert.Equal(t, time.Minute, (&Server{ShutdownDrainPeriod: time.Minute}).shutdownDrain())
+	assert.Zero(t, (&Server{ShutdownDrainPeriod: -1}).shutdownDrain())
+}
+
+func TestCompressionSettings(t *testing.T) {
+	level, threshold := Compression{}.settings()
+	assert.Equal(t, DEFAULT_COMPRESSION_LEVEL, level)
+	assert.Equal(t, DEFAULT_COMPRESSION_THRESHOLD, threshold)
+
+	level, threshold = Compression{Level: ref(9), Threshold: ref(1)}.settings()
+	assert.Equal(t, 9, level)
+	assert.Equal(t, 1, threshold)
+
+	level, threshold = Compression{Level: ref(0), Threshold: ref(0)}.settings()
+	assert.Equal(t, 0, level)
+	assert.Equal(t, 0, threshold)
+}
+
+// ref returns a pointer to a copy of v.
+func ref[T any](v T) *T {
+	return &v
+}
+
+// writeTestClientCA writes a self-signed CA certificate to a temporary file
+// and returns its path with a client certificate for CN=alice signed by it.
+func writeTestClientCA(t *testing.T) (string, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "b3tty test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	return path, tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Run("without a client CA", func(t *testing.T) {
+		cfg, err := TLS{Enabled: true}.config()
+		require.NoError(t, err)
+		assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+		assert.Nil(t, cfg.ClientCAs)
+	})
+
+	t.Run("missing client CA file", func(t *testing.T) {
+		_, err := TLS{ClientCAFilePath: "/b3tty-no-such-dir/ca.pem"}.config()
+		assert.Error(t, err)
+	})
+
+	t.Run("client CA file without certificates", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0600))
+		_, err := TLS{ClientCAFilePath: path}.config()
+		assert.ErrorContains(t, err, "no certificates found")
+	})
+
+	t.Run("verified client certificates are optional", func(t *testing.T) {
+		caPath, clientCert := writeTestClientCA(t)
+		cfg, err := TLS{Enabled: true, ClientCAFilePath: caPath}.config()
+		require.NoError(t, err)
+		assert.Equal(t, tls.VerifyClientCertIfGiven, cfg.ClientAuth)
+
+		identities := make(chan string, 2)
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := ""
+			if len(r.TLS.PeerCertificates) > 0 {
+				identity = r.TLS.PeerCertificates[0].Subject.String()
+			}
+			identities <- identity
+		}))
+		srv.TLS = cfg
+		srv.StartTLS()
+		defer srv.Close()
+
+		transport := srv.Client().Transport.(*http.Transport)
+		anonymous := &http.Client{Transport: transport.Clone()}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+		resp, err := srv.Client().Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "CN=alice", <-identities)
+
+		resp, err = anonymous.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "", <-identities)
+	})
+}
+
 func TestParseCommands(t *testing.T) {
 	assert := assert.New(t)
 
@@ -105,6 +286,52 @@ func TestParseCommands(t *testing.T) {
 	}
 }
 
+func TestSplitCommand(t *testing.T) {
+	argv, err := SplitCommand(`  nvim '~/my notes.md' a\ b  `)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nvim", "~/my notes.md", "a b"}, argv)
+
+	_, err = SplitCommand(`echo "unterminated`)
+	assert.Error(t, err)
+}
+
+func TestProfileArgv(t *testing.T) {
+	t.Setenv("SHELL", "/bin/zsh")
+	tests := []struct {
+		name    string
+		profile Profile
+		want    []string
+		errMsg  string
+	}{
+		{name: "shell via sh -c", profile: Profile{Shell: "/bin/bash --norc"}, want: []string{"/bin/sh", "-c", "/bin/bash --norc"}},
+		{name: "default shell via sh -c", profile: Profile{Shell: DEFAULT_SHELL}, want: []string{"/bin/sh", "-c", "$SHELL"}},
+		{name: "command", profile: Profile{Shell: "/bin/bash", Command: []string{"htop", "-d", "5"}}, want: []string{"htop", "-d", "5"}},
+		{name: "login shell", profile: Profile{Shell: "/bin/bash --norc", LoginShell: true}, want: []string{"/bin/bash", "-l", "--norc"}},
+		{name: "login default shell", profile: Profile{Shell: DEFAULT_SHELL, LoginShell: true}, want: []string{"/bin/zsh", "-l"}},
+		{name: "argv0 starts the shell directly", profile: Profile{Argv0: "-zsh"}, want: []string{"/bin/zsh"}},
+		{name: "malformed shell", profile: Profile{Shell: "'/bin/bash", LoginShell: true}, errMsg: "shell"},
+		{name: "empty shell", profile: Profile{Shell: "  ", LoginShell: true}, errMsg: "shell is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := tt.profile.argv()
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, argv)
+		})
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	assert.NoError(t, Profile{}.validateCommand())
+	assert.NoError(t, Profile{Command: []string{"htop"}, Argv0: "top"}.validateCommand())
+	assert.ErrorContains(t, Profile{Command: []string{""}}.validateCommand(), "no program")
+	assert.ErrorContains(t, Profile{Command: []string{"htop"}, LoginShell: true}.validateCommand(), "login-shell")
+}
+
 func TestApplyToCommand(t *testing.T) {
 	// Setup
 	homeDir, err := os.UserHomeDir()
@@ -182,8 +409,24 @@ func TestApplyToCommand(t *testing.T) {
 			assert.Equal(t, tc.expected.Dir, result.Dir)
 			assert.Equal(t, tc.expected.Path, result.Path)
 			assert.Equal(t, tc.expected.Args, result.Args)
+			assert.Contains(t, result.Env, "TERM="+DEFAULT_TERM)
+			assert.Contains(t, result.Env, "COLORTERM="+DEFAULT_COLORTERM)
 		})
 	}
+
+	t.Run("Command keeps its arguments", func(t *testing.T) {
+		p := Profile{Shell: "/bin/customsh", Command: []string{"test", "-c", "echo"}, Argv0: "check"}
+		result, err := p.ApplyToCommand(exec.Command("test", "-c", "echo"))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"check", "-c", "echo"}, result.Args)
+		assert.Equal(t, testPath, result.Path)
+	})
+
+	t.Run("Unreadable env file", func(t *testing.T) {
+		p := Profile{EnvFiles: []string{filepath.Join(t.TempDir(), "missing.env")}}
+		_, err := p.ApplyToCommand(exec.Command("test"))
+		assert.ErrorContains(t, err, "env file")
+	})
 }
 
 func TestNewCSPHeader(t *testing.T) {
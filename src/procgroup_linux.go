@@ -0,0 +1,186 @@
+package src
+
+import (
+	"bytes"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalSession delivers sig to every process in the Unix session sid that
+// has not exited. Scanning /proc finds jobs that an interactive shell moved
+// into process groups of their own, which signalling the shell's process
+// group would miss. Zombies are skipped since they cannot be signalled and
+// are left for their parent to reap. It returns syscall.ESRCH when no
+// process was signalled.
+func signalSession(sid int, sig syscall.Signal) error {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return syscall.Kill(-sid, sig)
+	}
+	found := false
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		session, state, ok := procSession(pid)
+		if !ok || session != sid || state == 'Z' {
+			continue
+		}
+		if syscall.Kill(pid, sig) == nil {
+			found = true
+		}
+	}
+	if !found {
+		return syscall.ESRCH
+	}
+	return nil
+}
+
+// startReaper makes b3tty the subreaper of its descendants, so that jobs
+// left behind by a shell, and daemons that detached into sessions of their
+// own such as tmux servers and ssh-agent, are reparented to b3tty rather
+// than to init. Each SIGCHLD then reaps those that have exited, leaving the
+// processes b3tty started itself to exec.Cmd.Wait; see startChild. This
+// matters most when b3tty runs as PID 1 of a container, where nothing else
+// would reap them. stop ends the reaping and b3tty's role as subreaper.
+func startReaper() (stop func(), err error) {
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	sigchld := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	go func() {
+		for {
+			// Reap first, since children may have been adopted before
+			// SIGCHLD was caught.
+			reapZombies(func(pid, session int) bool { return true })
+			select {
+			case <-sigchld:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigchld)
+		close(done)
+		_ = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 0, 0, 0, 0)
+	}, nil
+}
+
+// reapSession reaps every process of the Unix session sid, other than its
+// leader, that has been reparented to b3tty and has exited. The leader is left
+// for exec.Cmd.Wait.
+func reapSession(sid int) {
+	reapZombies(func(pid, session int) bool { return pid != sid && session == sid })
+}
+
+// reapZombies reaps the exited children of b3tty for which match, given
+// their PID and session ID, reports true. Children started by startChild are
+// left for waitChild.
+func reapZombies(match func(pid, session int) bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	self := os.Getpid()
+	children.Lock()
+	defer children.Unlock()
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || isChild(pid) {
+			continue
+		}
+		fields, ok := procStat(pid)
+		// state ppid pgrp session ...
+		if !ok || len(fields) < 4 || string(fields[0]) != "Z" {
+			continue
+		}
+		ppid, _ := strconv.Atoi(string(fields[1]))
+		session, _ := strconv.Atoi(string(fields[3]))
+		if ppid != self || !match(pid, session) {
+			continue
+		}
+		var status unix.WaitStatus
+		if _, err := unix.Wait4(pid, &status, unix.WNOHANG, nil); err != nil {
+			Debugf("reap %d: %v", pid, err)
+		}
+	}
+}
+
+// userHZ is the unit of the CPU times in /proc/<pid>/stat, which is 100 on
+// every Linux architecture b3tty builds for.
+const userHZ = 100
+
+// procSession returns the session ID and state of process pid from
+// /proc/<pid>/stat. It reports false if the process has gone or the file
+// cannot be parsed.
+func procSession(pid int) (session int, state byte, ok bool) {
+	fields, ok := procStat(pid)
+	// state ppid pgrp session ...
+	if !ok || len(fields) < 4 || len(fields[0]) != 1 {
+		return 0, 0, false
+	}
+	session, err := strconv.Atoi(string(fields[3]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return session, fields[0][0], true
+}
+
+// procStat returns the fields of /proc/<pid>/stat that follow the command
+// name, starting with the state. It reports false if the process has gone or
+// the file cannot be parsed.
+func procStat(pid int) ([][]byte, bool) {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil, false
+	}
+	// The command name is in parentheses and may itself contain spaces or
+	// parentheses, so the fields are counted from the last ')'.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, false
+	}
+	return bytes.Fields(stat[i+1:]), true
+}
+
+// sessionUsage returns the resources used by the processes in the Unix
+// session sid that have not exited, from /proc.
+func sessionUsage(sid int) (ResourceUsage, error) {
+	u := ResourceUsage{Source: "proc"}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return u, err
+	}
+	var ticks int64
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fields, ok := procStat(pid)
+		// Counting from the state, utime and stime are fields 11 and 12, and
+		// rss, in pages, is field 21.
+		if !ok || len(fields) < 22 {
+			continue
+		}
+		if session, err := strconv.Atoi(string(fields[3])); err != nil || session != sid {
+			continue
+		}
+		utime, _ := strconv.ParseInt(string(fields[11]), 10, 64)
+		stime, _ := strconv.ParseInt(string(fields[12]), 10, 64)
+		rss, _ := strconv.ParseInt(string(fields[21]), 10, 64)
+		ticks += utime + stime
+		u.MemoryBytes += rss * int64(os.Getpagesize())
+		u.Processes++
+	}
+	u.CPUSeconds = float64(ticks) / userHZ
+	return u, nil
+}
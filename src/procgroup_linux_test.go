@@ -0,0 +1,208 @@
+package src
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// ---------------------------------------------------------------------------
+// process group cleanup
+// ---------------------------------------------------------------------------
+
+// jobsScript is a shell that starts background jobs, some nested in
+// subshells and one in a process group of its own, records the PIDs of
+// itself and its jobs in pidFile and then waits for them.
+func jobsScript(pidFile string, ignoreHUP bool) string {
+	script := `echo $$ >> ` + pidFile + `
+sleep 1000 & echo $! >> ` + pidFile + `
+(sleep 1000 & echo $! >> ` + pidFile + `)
+(sh -c 'sleep 1000 & echo $! >> ` + pidFile + `; wait' &)
+set -m; sleep 1000 & echo $! >> ` + pidFile + `; set +m
+`
+	if ignoreHUP {
+		script += `sh -c 'trap "" HUP; echo $$ >> ` + pidFile + `; exec sleep 1000' &
+`
+	}
+	return script + `sleep 0.2; echo ready; wait`
+}
+
+// startJobs serves a session running jobsScript over a real pty and returns
+// the connection once every job has started, along with their PIDs. The
+// first PID is the shell's.
+func startJobs(t *testing.T, ts *TerminalServer, ignoreHUP bool) (*websocket.Conn, []int) {
+	t.Helper()
+	pidFile := filepath.Join(t.TempDir(), "pids")
+	ts.Profiles["jobs"] = Profile{Shell: jobsScript(pidFile, ignoreHUP)}
+	ts.setActiveProfileName("jobs")
+	srv := httptest.NewServer(http.HandlerFunc(ts.terminalHandler))
+	t.Cleanup(srv.Close)
+	conn := dialTerminal(t, "ws"+strings.TrimPrefix(srv.URL, "http"), PROTOCOL_V1)
+
+	var output string
+	for !strings.Contains(output, "ready") {
+		op, payload := readFrame(t, conn)
+		require.Equal(t, msgOutput, op)
+		output += payload
+	}
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		require.NoError(t, err)
+		pids = append(pids, pid)
+		t.Cleanup(func() { _ = syscall.Kill(pid, syscall.SIGKILL) })
+	}
+	want := 5
+	if ignoreHUP {
+		want = 6
+	}
+	require.Len(t, pids, want)
+	return conn, pids
+}
+
+// running reports whether pid is a process that has not exited.
+func running(pid int) bool {
+	_, state, ok := procSession(pid)
+	return ok && state != 'Z'
+}
+
+// requireCleanedUp fails unless the shell and every one of its jobs has been
+// reaped, leaving neither running processes nor zombies.
+func requireCleanedUp(t *testing.T, pids []int) {
+	t.Helper()
+	assert.ErrorIs(t, syscall.Kill(pids[0], 0), syscall.ESRCH, "shell %d was not reaped", pids[0])
+	for _, pid := range pids[1:] {
+		_, state, ok := procSession(pid)
+		assert.False(t, ok, "job %d was not reaped (state %c)", pid, state)
+	}
+}
+
+func TestSessionProcessCleanup(t *testing.T) {
+	// The jobs are reparented to the test process, as they would be to
+	// b3tty, instead of to init.
+	stop, err := startReaper()
+	require.NoError(t, err)
+	defer stop()
+
+	t.Run("disconnect hangs up the shell and its jobs", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		conn, pids := startJobs(t, ts, false)
+		for _, pid := range pids {
+			require.True(t, running(pid), "process %d is not running", pid)
+		}
+
+		conn.Close()
+		require.Eventually(t, func() bool { return ts.sessionCount() == 0 }, 5*time.Second, 10*time.Millisecond)
+		requireCleanedUp(t, pids)
+	})
+
+	t.Run("jobs ignoring SIGHUP are killed after the grace period", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.KillGracePeriod = 100 * time.Millisecond
+		conn, pids := startJobs(t, ts, true)
+
+		logs := captureLog(func() {
+			conn.Close()
+			require.Eventually(t, func() bool { return ts.sessionCount() == 0 }, 5*time.Second, 10*time.Millisecond)
+		})
+		assert.Contains(t, logs, "sending SIGKILL")
+		requireCleanedUp(t, pids)
+	})
+
+	t.Run("closing the server waits for every session", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.KillGracePeriod = 100 * time.Millisecond
+		_, pids := startJobs(t, ts, true)
+		_, more := startJobs(t, ts, false)
+
+		ts.closeSessions()
+		requireCleanedUp(t, pids)
+		requireCleanedUp(t, more)
+	})
+}
+
+func TestReaper(t *testing.T) {
+	stop, err := startReaper()
+	require.NoError(t, err)
+	defer stop()
+
+	t.Run("reaps daemons that detached into a session of their own", func(t *testing.T) {
+		// The daemon is forked twice and calls setsid, as tmux and ssh-agent
+		// do, and is adopted once the shell that started it has exited.
+		var out bytes.Buffer
+		c := exec.Command("sh", "-c", `setsid sh -c 'sleep 0.2' </dev/null >/dev/null 2>&1 & echo $!`)
+		c.Stdout = &out
+		require.NoError(t, startChild(c))
+		require.NoError(t, waitChild(c))
+		pid, err := strconv.Atoi(strings.TrimSpace(out.String()))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = syscall.Kill(pid, syscall.SIGKILL) })
+
+		fields, ok := procStat(pid)
+		require.True(t, ok, "daemon %d has gone", pid)
+		assert.Equal(t, strconv.Itoa(os.Getpid()), string(fields[1]), "daemon was not adopted")
+		assert.Equal(t, strconv.Itoa(pid), string(fields[3]), "daemon is not in a session of its own")
+		require.Eventually(t, func() bool {
+			_, _, ok := procSession(pid)
+			return !ok
+		}, 5*time.Second, 10*time.Millisecond, "daemon %d was not reaped", pid)
+	})
+
+	t.Run("leaves the processes b3tty started to waitChild", func(t *testing.T) {
+		for range 20 {
+			c := exec.Command("true")
+			require.NoError(t, startChild(c))
+			require.NoError(t, waitChild(c))
+		}
+	})
+}
+
+func TestProcSession(t *testing.T) {
+	session, state, ok := procSession(os.Getpid())
+	require.True(t, ok)
+	want, err := unix.Getsid(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, want, session)
+	assert.NotEqual(t, byte('Z'), state)
+
+	_, _, ok = procSession(-1)
+	assert.False(t, ok)
+}
+
+func TestSessionUsage(t *testing.T) {
+	c := exec.Command("sh", "-c", "sleep 10 & sleep 10")
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	require.NoError(t, c.Start())
+	defer func() {
+		signalSession(c.Process.Pid, syscall.SIGKILL)
+		c.Wait()
+	}()
+
+	require.Eventually(t, func() bool {
+		u, err := sessionUsage(c.Process.Pid)
+		return err == nil && u.Processes == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	u, err := sessionUsage(c.Process.Pid)
+	require.NoError(t, err)
+	assert.Equal(t, "proc", u.Source)
+	assert.Positive(t, u.MemoryBytes)
+
+	u, err = sessionUsage(1 << 30)
+	require.NoError(t, err)
+	assert.Zero(t, u.Processes)
+}
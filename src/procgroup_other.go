@@ -0,0 +1,29 @@
+//go:build !linux
+
+package src
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// signalSession delivers sig to the process group of the Unix session sid,
+// which the session leader created when it started. Jobs that an interactive
+// shell moved into process groups of their own are not reached.
+func signalSession(sid int, sig syscall.Signal) error {
+	return syscall.Kill(-sid, sig)
+}
+
+// startReaper does nothing: b3tty only adopts orphaned jobs on Linux.
+func startReaper() (stop func(), err error) {
+	return func() {}, nil
+}
+
+// reapSession does nothing, since jobs left behind are not reparented to
+// b3tty.
+func reapSession(sid int) {}
+
+// sessionUsage fails, since only Linux has a /proc to read usage from.
+func sessionUsage(sid int) (ResourceUsage, error) {
+	return ResourceUsage{}, fmt.Errorf("resource usage is only supported on Linux")
+}
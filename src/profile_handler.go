@@ -20,7 +20,7 @@ func (ts *TerminalServer) profileConfigHandler(w http.ResponseWriter, r *http.Re
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	p, ok := ts.Profiles[name]
+	p, ok := ts.profile(name)
 	if !ok {
 		Warnf("%s %s: profile %q not found", r.Method, r.URL.Path, name)
 		w.WriteHeader(http.StatusNotFound)
@@ -86,10 +86,25 @@ func (ts *TerminalServer) editProfileHandler(w http.ResponseWriter, r *http.Requ
 		shell = DEFAULT_SHELL
 	}
 
-	p := NewProfile(shell, req.Profile.WorkingDirectory, req.Profile.Root, req.Profile.Title, filtered)
-	ts.Profiles[req.Name] = p
+	// Start from the existing profile so settings the editor does not expose,
+	// such as the backend type, survive the edit.
+	p, _ := ts.profile(req.Name)
+	p.Shell = shell
+	p.WorkingDirectory = req.Profile.WorkingDirectory
+	p.Root = req.Profile.Root
+	p.Title = req.Profile.Title
+	p.CommandWaits = p.matchCommandWaits(filtered)
+	p.Commands = filtered
+	if err := ts.validateProfile(p); err != nil {
+		Warnf("%s %s: bad request: profile %s: %v", r.Method, r.URL.Path, req.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ts.setProfile(req.Name, p)
 
-	if err := SaveProfileToConfig(ts.ConfigFile, req.Name, p); err != nil {
+	err := ts.updateConfig(func(path string) error { return SaveProfileToConfig(path, req.Name, p) })
+	ts.audit(r, auditEvent{Event: auditProfileEdit, Profile: req.Name, Error: errorString(err)})
+	if err != nil {
 		Errorf("edit-profile: failed to save config: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -97,7 +112,7 @@ func (ts *TerminalServer) editProfileHandler(w http.ResponseWriter, r *http.Requ
 
 	Debugf("saved profile %q", req.Name)
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(editProfileResponse{ProfileNames: nonDefaultProfileNames(ts.Profiles)}); err != nil {
+	if err := json.NewEncoder(w).Encode(editProfileResponse{ProfileNames: nonDefaultProfileNames(ts.profilesSnapshot())}); err != nil {
 		Errorf("edit-profile response error: %v", err)
 	}
 }
@@ -131,9 +146,11 @@ func (ts *TerminalServer) deleteProfileHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	delete(ts.Profiles, req.Name)
+	ts.deleteProfile(req.Name)
 
-	if err := DeleteProfileFromConfig(ts.ConfigFile, req.Name); err != nil {
+	err := ts.updateConfig(func(path string) error { return DeleteProfileFromConfig(path, req.Name) })
+	ts.audit(r, auditEvent{Event: auditProfileDelete, Profile: req.Name, Error: errorString(err)})
+	if err != nil {
 		Errorf("delete-profile: failed to save config: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -141,7 +158,7 @@ func (ts *TerminalServer) deleteProfileHandler(w http.ResponseWriter, r *http.Re
 
 	Debugf("deleted profile %q", req.Name)
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(editProfileResponse{ProfileNames: nonDefaultProfileNames(ts.Profiles)}); err != nil {
+	if err := json.NewEncoder(w).Encode(editProfileResponse{ProfileNames: nonDefaultProfileNames(ts.profilesSnapshot())}); err != nil {
 		Errorf("delete-profile response error: %v", err)
 	}
 }
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/user"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -100,6 +102,20 @@ func TestProfileConfigHandler(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 func TestEditProfileHandler(t *testing.T) {
+	t.Run("edit keeps the existing profile type", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.ConfigFile = writeTempConfig(t, "")
+		ts.Backends = map[string]Backend{"remote": &fakeBackend{}}
+		ts.Profiles["dev"] = Profile{Type: "remote", Shell: "/bin/bash"}
+		body := bytes.NewBufferString(`{"name":"dev","profile":{"shell":"/bin/zsh"}}`)
+		req := httptest.NewRequest(http.MethodPost, "/edit-profile", body)
+		w := httptest.NewRecorder()
+		ts.editProfileHandler(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "remote", ts.Profiles["dev"].Type)
+		assert.Equal(t, "/bin/zsh", ts.Profiles["dev"].Shell)
+	})
+
 	newTS := func() *TerminalServer {
 		ts := newTestTerminalServer()
 		ts.ConfigFile = writeTempConfig(t, "")
@@ -157,6 +173,34 @@ func TestEditProfileHandler(t *testing.T) {
 		assert.Contains(t, logged, "invalid name")
 	})
 
+	t.Run("relative root returns 400 and saves nothing", func(t *testing.T) {
+		ts := newTS()
+		req := httptest.NewRequest(http.MethodPost, "/edit-profile", bytes.NewReader(encodeBody("myprofile", "", "", "", "jail", nil)))
+		w := httptest.NewRecorder()
+		logged := captureLog(func() { ts.editProfileHandler(w, req) })
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, logged, "profile myprofile")
+		assert.NotContains(t, ts.Profiles, "myprofile")
+		data, err := os.ReadFile(ts.ConfigFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "myprofile")
+	})
+
+	t.Run("root with a user returns 400 and saves nothing", func(t *testing.T) {
+		ts := newTS()
+		current, err := user.Current()
+		require.NoError(t, err)
+		ts.Profiles["dev"] = Profile{Shell: "/bin/bash", User: current.Username}
+		req := httptest.NewRequest(http.MethodPost, "/edit-profile", bytes.NewReader(encodeBody("dev", "", "", "", "/srv/jail", nil)))
+		w := httptest.NewRecorder()
+		captureLog(func() { ts.editProfileHandler(w, req) })
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Empty(t, ts.Profiles["dev"].Root)
+		data, err := os.ReadFile(ts.ConfigFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "/srv/jail")
+	})
+
 	t.Run("valid POST saves profile to ts.Profiles", func(t *testing.T) {
 		ts := newTS()
 		req := httptest.NewRequest(http.MethodPost, "/edit-profile", bytes.NewReader(encodeBody("myprofile", "/bin/zsh", "My Shell", "~/projects", "/", []string{"npm start"})))
@@ -192,6 +236,17 @@ func TestEditProfileHandler(t *testing.T) {
 		assert.Equal(t, []string{"echo hello", "npm start"}, p.Commands)
 	})
 
+	t.Run("commands keep their wait conditions", func(t *testing.T) {
+		ts := newTS()
+		wait := WaitCondition{Until: WAIT_PATTERN, Pattern: "ready"}
+		ts.Profiles["myprofile"] = Profile{Commands: []string{"make", "npm start"}, CommandWaits: []WaitCondition{{}, wait}}
+		req := httptest.NewRequest(http.MethodPost, "/edit-profile", bytes.NewReader(encodeBody("myprofile", "", "", "", "", []string{"npm start", "ls"})))
+		w := httptest.NewRecorder()
+		ts.editProfileHandler(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []WaitCondition{wait, {}}, ts.Profiles["myprofile"].CommandWaits)
+	})
+
 	t.Run("response contains sorted non-default profileNames", func(t *testing.T) {
 		ts := newTS()
 		ts.Profiles["zebra"] = Profile{Shell: "/bin/sh"}
@@ -0,0 +1,342 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message opcodes of the PROTOCOL_V1 framing. Every v1 message is a WebSocket
+// frame whose first byte is one of these opcodes and whose remaining bytes are
+// the payload. Opcodes are printable ASCII digits so frames stay readable in
+// browser devtools.
+const (
+	// msgInput (client → server) carries raw keyboard input for the process.
+	msgInput byte = '0'
+	// msgOutput (server → client) carries raw terminal output.
+	msgOutput byte = '1'
+	// msgResize (client → server) carries a JSON resizePayload.
+	msgResize byte = '2'
+	// msgPing (client → server) asks the server to echo the payload back in
+	// a msgPong, letting the client measure round-trip latency.
+	msgPing byte = '3'
+	// msgPong (server → client) echoes the payload of a msgPing.
+	msgPong byte = '4'
+	// msgTitle (server → client) carries the session title as UTF-8 text.
+	msgTitle byte = '5'
+	// msgExit (server → client) carries a JSON exitPayload once the process
+	// has exited.
+	msgExit byte = '6'
+	// msgError (server → client) carries a JSON errorPayload describing a
+	// failure the client should show to the user.
+	msgError byte = '7'
+	// msgSession (server → client) carries a JSON sessionPayload identifying
+	// the session, so the client can reattach to it after losing the
+	// connection.
+	msgSession byte = '8'
+	// msgAck (client → server) carries a JSON ackPayload reporting how many
+	// bytes of output the client has finished rendering. The first ack turns
+	// on flow control for the connection; see termConn.ack.
+	msgAck byte = '9'
+)
+
+// resizePayload is the body of a msgResize message.
+type resizePayload struct {
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// exitPayload is the body of a msgExit message. Signal names the signal that
+// terminated the process and is empty when the process exited normally.
+// Restart is ON_EXIT_RESTART when the process will be restarted automatically
+// and ON_EXIT_PROMPT when it will be restarted once the user presses Enter; it
+// is empty when the session is about to end.
+type exitPayload struct {
+	Code       int    `json:"code"`
+	Signal     string `json:"signal,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Restart    string `json:"restart,omitempty"`
+}
+
+// errorPayload is the body of a msgError message.
+type errorPayload struct {
+	Message string `json:"message"`
+}
+
+// sessionPayload is the body of a msgSession message.
+type sessionPayload struct {
+	ID string `json:"id"`
+}
+
+// ackPayload is the body of a msgAck message. Bytes counts the output payload
+// bytes consumed since the previous ack.
+type ackPayload struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// clientMessage is a decoded client → server message. Data holds the input
+// bytes of a msgInput or the payload of a msgPing; Cols and Rows are set for
+// a msgResize and Bytes for a msgAck.
+type clientMessage struct {
+	Op    byte
+	Data  []byte
+	Cols  uint16
+	Rows  uint16
+	Bytes int64
+}
+
+var errEmptyFrame = errors.New("empty frame")
+
+// decodeClientMessage decodes one WebSocket message read from the client.
+// With the v1 framing the first byte selects the message type. Without it,
+// the legacy rules apply: a text frame holding a JSON resize command is a
+// resize and anything else is input.
+func decodeClientMessage(typed bool, msgType int, data []byte) (clientMessage, error) {
+	if !typed {
+		if msgType == websocket.TextMessage {
+			if cols, rows, ok := parseResizeMessage(data); ok {
+				return clientMessage{Op: msgResize, Cols: cols, Rows: rows}, nil
+			}
+		}
+		return clientMessage{Op: msgInput, Data: data}, nil
+	}
+
+	if len(data) == 0 {
+		return clientMessage{}, errEmptyFrame
+	}
+	op, payload := data[0], data[1:]
+	switch op {
+	case msgInput, msgPing:
+		return clientMessage{Op: op, Data: payload}, nil
+	case msgResize:
+		var size resizePayload
+		if err := json.Unmarshal(payload, &size); err != nil {
+			return clientMessage{}, fmt.Errorf("resize: %w", err)
+		}
+		return clientMessage{Op: op, Cols: size.Cols, Rows: size.Rows}, nil
+	case msgAck:
+		var ack ackPayload
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return clientMessage{}, fmt.Errorf("ack: %w", err)
+		}
+		if ack.Bytes < 0 {
+			return clientMessage{}, fmt.Errorf("ack: negative byte count %d", ack.Bytes)
+		}
+		return clientMessage{Op: op, Bytes: ack.Bytes}, nil
+	default:
+		return clientMessage{}, fmt.Errorf("unknown opcode %q", op)
+	}
+}
+
+// termConn is the server side of a terminal WebSocket. It hides the framing
+// negotiated during the upgrade from terminalHandler and serializes writes,
+// since a websocket.Conn supports only one concurrent writer.
+type termConn struct {
+	ws    *websocket.Conn
+	typed bool
+	mu    sync.Mutex
+	// readTimeout is set by startHeartbeat. When non-zero, every message or
+	// pong received pushes the read deadline readTimeout into the future.
+	readTimeout time.Duration
+	// compressThreshold is set by enableCompression. Messages with fewer
+	// payload bytes are sent uncompressed.
+	compressThreshold int
+	// done is closed by close.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// flowControl is set by the first ack from the client. sent totals the
+	// output bytes written to the client and acked those the client has
+	// reported consuming. credit is signalled after every ack so a writer
+	// waiting for the window to open can retry.
+	flowControl atomic.Bool
+	sent        atomic.Int64
+	acked       atomic.Int64
+	credit      chan struct{}
+}
+
+// newTermConn wraps ws, using the v1 framing when the client negotiated the
+// PROTOCOL_V1 subprotocol and the legacy framing otherwise.
+func newTermConn(ws *websocket.Conn) *termConn {
+	return &termConn{
+		ws:     ws,
+		typed:  ws.Subprotocol() == PROTOCOL_V1,
+		done:   make(chan struct{}),
+		credit: make(chan struct{}, 1),
+	}
+}
+
+// enableCompression compresses messages of at least threshold bytes at the
+// given flate level. It has no effect unless the client negotiated
+// permessage-deflate during the upgrade.
+func (c *termConn) enableCompression(level, threshold int) error {
+	if err := c.ws.SetCompressionLevel(level); err != nil {
+		return err
+	}
+	c.compressThreshold = threshold
+	return nil
+}
+
+// ack records that the client has consumed n more bytes of output and turns
+// on flow control for the connection. Clients that never ack, including every
+// legacy client, are sent output as fast as it is produced.
+func (c *termConn) ack(n int64) {
+	c.flowControl.Store(true)
+	c.acked.Add(n)
+	select {
+	case c.credit <- struct{}{}:
+	default:
+	}
+}
+
+// windowFull reports whether flow control is on and at least window bytes of
+// the output sent are still unacknowledged.
+func (c *termConn) windowFull(window int) bool {
+	return c.flowControl.Load() && c.sent.Load()-c.acked.Load() >= int64(window)
+}
+
+// close closes the WebSocket, unblocking any pending read, and marks the
+// connection as finished. It is safe to call more than once.
+func (c *termConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.ws.Close()
+	})
+}
+
+// isClosed reports whether close has been called.
+func (c *termConn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// startHeartbeat sends a WebSocket ping every interval and makes reads fail
+// once nothing, not even a pong, has arrived from the peer for timeout. The
+// pings also keep idle proxies from dropping the connection. It must be
+// called before the first read, and the returned function stops the pings.
+func (c *termConn) startHeartbeat(interval, timeout time.Duration) (stop func()) {
+	c.readTimeout = timeout
+	c.ws.SetReadDeadline(time.Now().Add(timeout))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_TIMEOUT))
+				if err != nil {
+					Debugf("websocket ping: %v", err)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// read blocks until the next message arrives. Errors from the connection are
+// returned as-is; errors decoding a message are wrapped so the caller can
+// report them and keep reading.
+func (c *termConn) read() (clientMessage, error) {
+	msgType, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return clientMessage{}, err
+	}
+	if c.readTimeout > 0 {
+		c.ws.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	msg, err := decodeClientMessage(c.typed, msgType, data)
+	if err != nil {
+		return clientMessage{}, &decodeError{err: err}
+	}
+	return msg, nil
+}
+
+// decodeError reports a malformed client message. Unlike a connection error,
+// it does not end the session.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return "decode message: " + e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// writeOutput sends terminal output to the client.
+func (c *termConn) writeOutput(p []byte) error {
+	if err := c.write(msgOutput, p); err != nil {
+		return err
+	}
+	c.sent.Add(int64(len(p)))
+	return nil
+}
+
+// writeMessage sends a v1 message with the given opcode. Legacy clients have
+// no way to receive anything other than output, so for them it is a no-op.
+func (c *termConn) writeMessage(op byte, payload []byte) error {
+	if !c.typed {
+		return nil
+	}
+	return c.write(op, payload)
+}
+
+// writeJSON sends v as the JSON payload of a v1 message. Like writeMessage, it
+// is a no-op for legacy clients.
+func (c *termConn) writeJSON(op byte, v any) error {
+	if !c.typed {
+		return nil
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(op, payload)
+}
+
+// writeError reports a failure to a v1 client.
+func (c *termConn) writeError(message string) error {
+	return c.writeJSON(msgError, errorPayload{Message: message})
+}
+
+// writeClose sends a close frame with the given code and reason. The
+// connection must still be closed with close afterwards.
+func (c *termConn) writeClose(code int, reason string) error {
+	return c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(WS_WRITE_TIMEOUT))
+}
+
+func (c *termConn) write(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	if c.compressThreshold > 0 {
+		c.ws.EnableWriteCompression(len(payload) >= c.compressThreshold)
+	}
+	w, err := c.ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if c.typed {
+		if _, err := w.Write([]byte{op}); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,414 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------------
+// decodeClientMessage
+// ---------------------------------------------------------------------------
+
+func TestDecodeClientMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		typed    bool
+		msgType  int
+		data     string
+		expected clientMessage
+		errMsg   string
+	}{
+		{
+			name:     "legacy text input",
+			msgType:  websocket.TextMessage,
+			data:     "ls\r",
+			expected: clientMessage{Op: msgInput, Data: []byte("ls\r")},
+		},
+		{
+			name:     "legacy binary input",
+			msgType:  websocket.BinaryMessage,
+			data:     "ls\r",
+			expected: clientMessage{Op: msgInput, Data: []byte("ls\r")},
+		},
+		{
+			name:     "legacy resize",
+			msgType:  websocket.TextMessage,
+			data:     `{"type":"resize","cols":120,"rows":40}`,
+			expected: clientMessage{Op: msgResize, Cols: 120, Rows: 40},
+		},
+		{
+			name:     "legacy resize JSON in a binary frame is input",
+			msgType:  websocket.BinaryMessage,
+			data:     `{"type":"resize","cols":120,"rows":40}`,
+			expected: clientMessage{Op: msgInput, Data: []byte(`{"type":"resize","cols":120,"rows":40}`)},
+		},
+		{
+			name:     "v1 input",
+			typed:    true,
+			msgType:  websocket.BinaryMessage,
+			data:     "0ls\r",
+			expected: clientMessage{Op: msgInput, Data: []byte("ls\r")},
+		},
+		{
+			name:     "v1 input that looks like a resize command is typed verbatim",
+			typed:    true,
+			msgType:  websocket.TextMessage,
+			data:     `0{"type":"resize","cols":1,"rows":1}`,
+			expected: clientMessage{Op: msgInput, Data: []byte(`{"type":"resize","cols":1,"rows":1}`)},
+		},
+		{
+			name:     "v1 resize",
+			typed:    true,
+			msgType:  websocket.BinaryMessage,
+			data:     `2{"cols":100,"rows":30}`,
+			expected: clientMessage{Op: msgResize, Cols: 100, Rows: 30},
+		},
+		{
+			name:     "v1 ping",
+			typed:    true,
+			msgType:  websocket.BinaryMessage,
+			data:     "3abc",
+			expected: clientMessage{Op: msgPing, Data: []byte("abc")},
+		},
+		{
+			name:     "v1 ack",
+			typed:    true,
+			msgType:  websocket.BinaryMessage,
+			data:     `9{"bytes":4096}`,
+			expected: clientMessage{Op: msgAck, Bytes: 4096},
+		},
+		{
+			name:    "v1 negative ack",
+			typed:   true,
+			msgType: websocket.BinaryMessage,
+			data:    `9{"bytes":-1}`,
+			errMsg:  "negative byte count",
+		},
+		{
+			name:    "v1 malformed resize",
+			typed:   true,
+			msgType: websocket.BinaryMessage,
+			data:    "2{",
+			errMsg:  "resize",
+		},
+		{
+			name:    "v1 empty frame",
+			typed:   true,
+			msgType: websocket.BinaryMessage,
+			data:    "",
+			errMsg:  "empty frame",
+		},
+		{
+			name:    "v1 server-only opcode",
+			typed:   true,
+			msgType: websocket.BinaryMessage,
+			data:    "1hello",
+			errMsg:  "unknown opcode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := decodeClientMessage(tt.typed, tt.msgType, []byte(tt.data))
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected.Op, msg.Op)
+			assert.Equal(t, string(tt.expected.Data), string(msg.Data))
+			assert.Equal(t, tt.expected.Cols, msg.Cols)
+			assert.Equal(t, tt.expected.Rows, msg.Rows)
+			assert.Equal(t, tt.expected.Bytes, msg.Bytes)
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// terminalHandler with the v1 framing
+// ---------------------------------------------------------------------------
+
+// readRawFrame reads the next v1 message from conn and splits off its opcode.
+func readRawFrame(t *testing.T, conn *websocket.Conn) (byte, string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msgType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, websocket.BinaryMessage, msgType)
+	require.NotEmpty(t, data)
+	return data[0], string(data[1:])
+}
+
+// readFrame is readRawFrame but skips the session and title messages sent at
+// the start of every session.
+func readFrame(t *testing.T, conn *websocket.Conn) (byte, string) {
+	t.Helper()
+	for {
+		op, payload := readRawFrame(t, conn)
+		if op != msgSession && op != msgTitle {
+			return op, payload
+		}
+	}
+}
+
+func TestTerminalHandlerV1(t *testing.T) {
+	t.Run("negotiates the subprotocol", func(t *testing.T) {
+		_, conn := newFakeTerminal(t, newTestTerminalServer(), PROTOCOL_V1)
+		assert.Equal(t, PROTOCOL_V1, conn.Subprotocol())
+	})
+
+	t.Run("clients without the subprotocol get the legacy framing", func(t *testing.T) {
+		_, conn := newFakeTerminal(t, newTestTerminalServer(), "b3tty.v99")
+		assert.Empty(t, conn.Subprotocol())
+	})
+
+	t.Run("output is prefixed with the output opcode", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer(), PROTOCOL_V1)
+		go backend.process(t, 0).emit("hello")
+		op, payload := readFrame(t, conn)
+		assert.Equal(t, msgOutput, op)
+		assert.Equal(t, "hello", payload)
+	})
+
+	t.Run("announces the session and profile title first", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["titled"] = Profile{Title: "Build box"}
+		ts.setActiveProfileName("titled")
+		_, conn := newFakeTerminal(t, ts, PROTOCOL_V1)
+		op, payload := readRawFrame(t, conn)
+		assert.Equal(t, msgSession, op)
+		assert.Regexp(t, `^\{"id":"[A-Za-z0-9]{8}"\}$`, payload)
+		op, payload = readRawFrame(t, conn)
+		assert.Equal(t, msgTitle, op)
+		assert.Equal(t, "Build box", payload)
+	})
+
+	t.Run("input and resize are routed by opcode", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer(), PROTOCOL_V1)
+		proc := backend.process(t, 0)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(`0{"type":"resize","cols":1,"rows":1}`)))
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(`2{"cols":90,"rows":30}`)))
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("0x")))
+		assert.Eventually(t, func() bool {
+			return proc.inputString() == `{"type":"resize","cols":1,"rows":1}x`
+		}, time.Second, 5*time.Millisecond)
+		proc.mu.Lock()
+		defer proc.mu.Unlock()
+		assert.Equal(t, [][2]uint16{{90, 30}}, proc.resizes)
+	})
+
+	t.Run("ping is answered with pong", func(t *testing.T) {
+		_, conn := newFakeTerminal(t, newTestTerminalServer(), PROTOCOL_V1)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("3t=42")))
+		op, payload := readFrame(t, conn)
+		assert.Equal(t, msgPong, op)
+		assert.Equal(t, "t=42", payload)
+	})
+
+	t.Run("malformed messages are reported without ending the session", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer(), PROTOCOL_V1)
+		proc := backend.process(t, 0)
+		logged := captureLog(func() {
+			require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("z")))
+			op, payload := readFrame(t, conn)
+			assert.Equal(t, msgError, op)
+			assert.Contains(t, payload, "unknown opcode")
+		})
+		assert.Contains(t, logged, "ignoring client message")
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("0ok")))
+		assert.Eventually(t, func() bool { return proc.inputString() == "ok" }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("backend start failure is reported before closing", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Backends = map[string]Backend{"broken": &fakeBackend{startErr: errors.New("no pty available")}}
+		ts.Profiles["broken"] = Profile{Type: "broken"}
+		ts.setActiveProfileName("broken")
+		captureLog(func() {
+			_, conn := newFakeTerminal(t, ts, PROTOCOL_V1)
+			op, payload := readFrame(t, conn)
+			assert.Equal(t, msgError, op)
+			assert.JSONEq(t, `{"message":"no pty available"}`, payload)
+			_, _, err := conn.ReadMessage()
+			assert.Error(t, err)
+		})
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Flow control
+// ---------------------------------------------------------------------------
+
+// sendAck acknowledges n bytes of output.
+func sendAck(t *testing.T, conn *websocket.Conn, n int) {
+	t.Helper()
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(fmt.Sprintf(`9{"bytes":%d}`, n))))
+}
+
+// syncInput waits until every message sent on conn so far has been handled,
+// using a ping since the server processes client messages in order.
+func syncInput(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("3sync")))
+	op, payload := readFrame(t, conn)
+	require.Equal(t, msgPong, op)
+	require.Equal(t, "sync", payload)
+}
+
+func TestTerminalHandlerFlowControl(t *testing.T) {
+	const chunk, chunks, window = 10, 50, 100
+	flood := func(proc *fakeProcess, emitted *atomic.Int64) {
+		go func() {
+			for i := 0; i < chunks; i++ {
+				proc.emit(strings.Repeat("x", chunk))
+				emitted.Add(chunk)
+			}
+		}()
+	}
+	newServer := func() *TerminalServer {
+		ts := newTestTerminalServer()
+		ts.Server.FlowControlWindow = window
+		ts.Server.OutputBatchWindow = -1
+		return ts
+	}
+
+	t.Run("output pauses while the window is full", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newServer(), PROTOCOL_V1)
+		sendAck(t, conn, 0)
+		syncInput(t, conn)
+		var emitted atomic.Int64
+		flood(backend.process(t, 0), &emitted)
+
+		received := 0
+		for received < window {
+			op, payload := readFrame(t, conn)
+			require.Equal(t, msgOutput, op)
+			received += len(payload)
+		}
+		assert.Equal(t, window, received)
+
+		time.Sleep(50 * time.Millisecond)
+		stalled := emitted.Load()
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, stalled, emitted.Load(), "the process is blocked while the window is full")
+		assert.Less(t, stalled, int64(chunk*chunks))
+
+		for received < chunk*chunks {
+			sendAck(t, conn, chunk)
+			op, payload := readFrame(t, conn)
+			require.Equal(t, msgOutput, op)
+			received += len(payload)
+		}
+		assert.Equal(t, chunk*chunks, received)
+	})
+
+	t.Run("clients that never ack are not limited", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newServer(), PROTOCOL_V1)
+		var emitted atomic.Int64
+		flood(backend.process(t, 0), &emitted)
+		received := 0
+		for received < chunk*chunks {
+			_, payload := readFrame(t, conn)
+			received += len(payload)
+		}
+		assert.Equal(t, chunk*chunks, received)
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Exit status
+// ---------------------------------------------------------------------------
+
+// codeError is a Wait error from a backend that reports an exit code.
+type codeError int
+
+func (e codeError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e codeError) ExitCode() int { return int(e) }
+
+func TestExitStatus(t *testing.T) {
+	run := func(script string) error {
+		return exec.Command("/bin/sh", "-c", script).Run()
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected exitPayload
+	}{
+		{name: "success", err: nil, expected: exitPayload{Code: 0, DurationMs: 1500}},
+		{name: "exit code", err: run("exit 3"), expected: exitPayload{Code: 3, DurationMs: 1500}},
+		{name: "killed by a signal", err: run("kill -TERM $$"), expected: exitPayload{Code: 143, Signal: "SIGTERM", DurationMs: 1500}},
+		{name: "backend exit code", err: fmt.Errorf("wait: %w", codeError(2)), expected: exitPayload{Code: 2, DurationMs: 1500}},
+		{name: "unknown error", err: errors.New("lost track of the process"), expected: exitPayload{Code: -1, DurationMs: 1500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, exitStatus(tt.err, 1500*time.Millisecond))
+		})
+	}
+}
+
+func TestTerminalHandlerExit(t *testing.T) {
+	t.Run("reports the exit status and closes normally", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer(), PROTOCOL_V1)
+		proc := backend.process(t, 0)
+		proc.waitErr = codeError(4)
+		proc.exit()
+
+		op, payload := readFrame(t, conn)
+		require.Equal(t, msgExit, op)
+		var status exitPayload
+		require.NoError(t, json.Unmarshal([]byte(payload), &status))
+		assert.Equal(t, 4, status.Code)
+		assert.Empty(t, status.Signal)
+		assert.GreaterOrEqual(t, status.DurationMs, int64(0))
+
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+	})
+
+	t.Run("legacy clients get a normal close", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer())
+		backend.process(t, 0).exit()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+	})
+
+	t.Run("reports the exit code of a real shell", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["failing"] = Profile{Shell: "exit 7"}
+		ts.setActiveProfileName("failing")
+		srv := httptest.NewServer(http.HandlerFunc(ts.terminalHandler))
+		t.Cleanup(srv.Close)
+		conn := dialTerminal(t, "ws"+strings.TrimPrefix(srv.URL, "http"), PROTOCOL_V1)
+
+		for {
+			op, payload := readFrame(t, conn)
+			if op != msgExit {
+				continue
+			}
+			var status exitPayload
+			require.NoError(t, json.Unmarshal([]byte(payload), &status))
+			assert.Equal(t, 7, status.Code)
+			assert.Empty(t, status.Signal)
+			return
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package src
+
+import (
+	"fmt"
+	"time"
+)
+
+// exitPolicy is a profile's on-exit policy with the defaults applied.
+type exitPolicy struct {
+	onExit  string
+	limit   int
+	backoff time.Duration
+}
+
+// exitPolicy returns the effective on-exit policy of p, substituting the
+// defaults for unset values.
+func (p Profile) exitPolicy() exitPolicy {
+	policy := exitPolicy{onExit: p.OnExit, limit: p.RestartLimit, backoff: p.RestartBackoff}
+	if policy.onExit == "" {
+		policy.onExit = ON_EXIT_CLOSE
+	}
+	if policy.limit == 0 {
+		policy.limit = DEFAULT_RESTART_LIMIT
+	}
+	if policy.backoff == 0 {
+		policy.backoff = DEFAULT_RESTART_BACKOFF
+	}
+	return policy
+}
+
+// validateExitPolicy reports an error when p has an unknown on-exit policy or
+// a negative restart backoff.
+func (p Profile) validateExitPolicy() error {
+	switch p.OnExit {
+	case "", ON_EXIT_CLOSE, ON_EXIT_RESTART, ON_EXIT_RESTART_ON_FAILURE, ON_EXIT_PROMPT:
+	default:
+		return fmt.Errorf("unknown on-exit policy %q", p.OnExit)
+	}
+	if p.RestartBackoff < 0 {
+		return fmt.Errorf("restart backoff must not be negative")
+	}
+	return nil
+}
+
+// restartDecision is what happens after a run of a session's process ends.
+type restartDecision struct {
+	// mode is ON_EXIT_RESTART to restart after delay, ON_EXIT_PROMPT to
+	// restart once the user asks to, or empty to end the session.
+	mode  string
+	delay time.Duration
+	// limited is set when the process would have been restarted but had
+	// already been restarted limit times in a row.
+	limited bool
+}
+
+// decide applies the policy to a run that lasted ran and ended with status,
+// after restarts consecutive automatic restarts. It returns the decision and
+// the new count of consecutive restarts. A run lasting RESTART_RESET_AFTER or
+// longer resets the count, so a process that fails only now and then is
+// never given up on. The delay starts at the backoff and doubles with each
+// consecutive restart, up to MAX_RESTART_BACKOFF.
+func (p exitPolicy) decide(status exitPayload, ran time.Duration, restarts int) (restartDecision, int) {
+	switch p.onExit {
+	case ON_EXIT_PROMPT:
+		return restartDecision{mode: ON_EXIT_PROMPT}, 0
+	case ON_EXIT_RESTART:
+	case ON_EXIT_RESTART_ON_FAILURE:
+		if status.Code == 0 {
+			return restartDecision{}, restarts
+		}
+	default:
+		return restartDecision{}, restarts
+	}
+	if ran >= RESTART_RESET_AFTER {
+		restarts = 0
+	}
+	if p.limit > 0 && restarts >= p.limit {
+		return restartDecision{limited: true}, restarts
+	}
+	delay := p.backoff
+	for i := 0; i < restarts && delay < MAX_RESTART_BACKOFF; i++ {
+		delay *= 2
+	}
+	if delay > MAX_RESTART_BACKOFF {
+		delay = MAX_RESTART_BACKOFF
+	}
+	return restartDecision{mode: ON_EXIT_RESTART, delay: delay}, restarts + 1
+}
+
+// decide returns what happens after run r, which ended with status. The
+// decision is made once per run, so a client that reattaches while the
+// session is between runs is told the same thing.
+func (s *session) decide(r *run, status exitPayload) restartDecision {
+	if !r.decided {
+		r.decided = true
+		if s.spawn != nil {
+			r.next, s.restarts = s.policy.decide(status, r.ended.Sub(r.started), s.restarts)
+		}
+	}
+	return r.next
+}
+
+// describeExit describes status for logs and the terminal, for example
+// "exited with code 1" or "killed by SIGTERM".
+func describeExit(status exitPayload) string {
+	if status.Signal != "" {
+		return "killed by " + status.Signal
+	}
+	return fmt.Sprintf("exited with code %d", status.Code)
+}
@@ -0,0 +1,193 @@
+package src
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------------
+// exitPolicy
+// ---------------------------------------------------------------------------
+
+func TestExitPolicyDefaults(t *testing.T) {
+	assert.Equal(t, exitPolicy{onExit: ON_EXIT_CLOSE, limit: DEFAULT_RESTART_LIMIT, backoff: DEFAULT_RESTART_BACKOFF}, Profile{}.exitPolicy())
+
+	p := Profile{OnExit: ON_EXIT_PROMPT, RestartLimit: -1, RestartBackoff: time.Minute}
+	assert.Equal(t, exitPolicy{onExit: ON_EXIT_PROMPT, limit: -1, backoff: time.Minute}, p.exitPolicy())
+}
+
+func TestValidateExitPolicy(t *testing.T) {
+	for _, onExit := range []string{"", ON_EXIT_CLOSE, ON_EXIT_RESTART, ON_EXIT_RESTART_ON_FAILURE, ON_EXIT_PROMPT} {
+		assert.NoError(t, Profile{OnExit: onExit}.validateExitPolicy(), onExit)
+	}
+	assert.ErrorContains(t, Profile{OnExit: "respawn"}.validateExitPolicy(), "unknown on-exit policy")
+	assert.ErrorContains(t, Profile{RestartBackoff: -time.Second}.validateExitPolicy(), "negative")
+
+	ts := newTestTerminalServer()
+	ts.Profiles["bad"] = Profile{OnExit: "respawn"}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "profile bad")
+}
+
+func TestExitPolicyDecide(t *testing.T) {
+	failed := exitPayload{Code: 1}
+	succeeded := exitPayload{Code: 0}
+	restart := exitPolicy{onExit: ON_EXIT_RESTART, limit: 3, backoff: time.Second}
+
+	tests := []struct {
+		name     string
+		policy   exitPolicy
+		status   exitPayload
+		ran      time.Duration
+		restarts int
+		expected restartDecision
+		count    int
+	}{
+		{name: "close", policy: exitPolicy{onExit: ON_EXIT_CLOSE}, status: failed, expected: restartDecision{}},
+		{name: "restart after success", policy: restart, status: succeeded, expected: restartDecision{mode: ON_EXIT_RESTART, delay: time.Second}, count: 1},
+		{name: "backoff doubles", policy: restart, status: failed, restarts: 2, expected: restartDecision{mode: ON_EXIT_RESTART, delay: 4 * time.Second}, count: 3},
+		{name: "limit reached", policy: restart, status: failed, restarts: 3, expected: restartDecision{limited: true}, count: 3},
+		{name: "long run resets the count", policy: restart, status: failed, ran: RESTART_RESET_AFTER, restarts: 3, expected: restartDecision{mode: ON_EXIT_RESTART, delay: time.Second}, count: 1},
+		{name: "no limit", policy: exitPolicy{onExit: ON_EXIT_RESTART, limit: -1, backoff: time.Second}, status: failed, restarts: 100, expected: restartDecision{mode: ON_EXIT_RESTART, delay: MAX_RESTART_BACKOFF}, count: 101},
+		{name: "backoff is capped", policy: exitPolicy{onExit: ON_EXIT_RESTART, limit: 10, backoff: 20 * time.Second}, status: failed, restarts: 1, expected: restartDecision{mode: ON_EXIT_RESTART, delay: MAX_RESTART_BACKOFF}, count: 2},
+		{name: "restart on failure after success", policy: exitPolicy{onExit: ON_EXIT_RESTART_ON_FAILURE, limit: 3, backoff: time.Second}, status: succeeded, restarts: 1, expected: restartDecision{}, count: 1},
+		{name: "restart on failure after a signal", policy: exitPolicy{onExit: ON_EXIT_RESTART_ON_FAILURE, limit: 3, backoff: time.Second}, status: exitPayload{Code: 143, Signal: "SIGTERM"}, expected: restartDecision{mode: ON_EXIT_RESTART, delay: time.Second}, count: 1},
+		{name: "prompt", policy: exitPolicy{onExit: ON_EXIT_PROMPT, limit: 3}, status: failed, restarts: 3, expected: restartDecision{mode: ON_EXIT_PROMPT}, count: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, count := tt.policy.decide(tt.status, tt.ran, tt.restarts)
+			assert.Equal(t, tt.expected, decision)
+			assert.Equal(t, tt.count, count)
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// terminalHandler restarts
+// ---------------------------------------------------------------------------
+
+// newRestartTerminal serves a v1 session of a profile with the given on-exit
+// settings.
+func newRestartTerminal(t *testing.T, p Profile) (*fakeBackend, *websocket.Conn) {
+	t.Helper()
+	ts := newTestTerminalServer()
+	ts.Profiles["dashboard"] = p
+	ts.setActiveProfileName("dashboard")
+	return newFakeTerminal(t, ts, PROTOCOL_V1)
+}
+
+// readExit reads frames until an exit message arrives, returning it and the
+// output received before it.
+func readExit(t *testing.T, conn *websocket.Conn) (exitPayload, string) {
+	t.Helper()
+	var output string
+	for {
+		op, payload := readFrame(t, conn)
+		switch op {
+		case msgOutput:
+			output += payload
+		case msgExit:
+			var status exitPayload
+			require.NoError(t, json.Unmarshal([]byte(payload), &status))
+			return status, output
+		}
+	}
+}
+
+// readOutputFrame reads frames until an output message arrives.
+func readOutputFrame(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	for {
+		op, payload := readFrame(t, conn)
+		if op == msgOutput {
+			return payload
+		}
+	}
+}
+
+// requireNormalClose reads from conn until the server closes it and checks
+// that the close was normal.
+func requireNormalClose(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+		return
+	}
+}
+
+func TestTerminalHandlerRestart(t *testing.T) {
+	t.Run("restart respawns on the same connection", func(t *testing.T) {
+		backend, conn := newRestartTerminal(t, Profile{OnExit: ON_EXIT_RESTART, RestartBackoff: 10 * time.Millisecond, Commands: []string{}})
+		first := backend.process(t, 0)
+		first.waitErr = codeError(1)
+		first.exit()
+
+		status, _ := readExit(t, conn)
+		assert.Equal(t, 1, status.Code)
+		assert.Equal(t, ON_EXIT_RESTART, status.Restart)
+		assert.Equal(t, "\r\n[process exited with code 1; restarting in 10ms]\r\n", readOutputFrame(t, conn))
+
+		second := backend.process(t, 1)
+		go second.emit("back again")
+		assert.Equal(t, "back again", readOutputFrame(t, conn))
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("0q")))
+		assert.Eventually(t, func() bool { return second.inputString() == "q" }, time.Second, 5*time.Millisecond)
+		assert.Empty(t, first.inputString())
+	})
+
+	t.Run("restart-on-failure closes after a clean exit", func(t *testing.T) {
+		backend, conn := newRestartTerminal(t, Profile{OnExit: ON_EXIT_RESTART_ON_FAILURE})
+		backend.process(t, 0).exit()
+		status, _ := readExit(t, conn)
+		assert.Empty(t, status.Restart)
+		requireNormalClose(t, conn)
+	})
+
+	t.Run("the session ends at the restart limit", func(t *testing.T) {
+		backend, conn := newRestartTerminal(t, Profile{OnExit: ON_EXIT_RESTART, RestartLimit: 1, RestartBackoff: time.Millisecond})
+		backend.process(t, 0).exit()
+		status, _ := readExit(t, conn)
+		assert.Equal(t, ON_EXIT_RESTART, status.Restart)
+
+		logged := captureLog(func() {
+			backend.process(t, 1).exit()
+			status, _ = readExit(t, conn)
+			assert.Empty(t, status.Restart)
+			requireNormalClose(t, conn)
+		})
+		assert.Contains(t, logged, "not restarting after 1 consecutive restarts")
+	})
+
+	t.Run("prompt waits for Enter and keeps the last size", func(t *testing.T) {
+		backend, conn := newRestartTerminal(t, Profile{OnExit: ON_EXIT_PROMPT})
+		backend.process(t, 0).exit()
+		status, _ := readExit(t, conn)
+		assert.Equal(t, ON_EXIT_PROMPT, status.Restart)
+		assert.Equal(t, "\r\n[process exited with code 0; press Enter to restart]\r\n", readOutputFrame(t, conn))
+
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(`2{"cols":132,"rows":43}`)))
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("0x")))
+		syncInput(t, conn)
+		backend.mu.Lock()
+		assert.Len(t, backend.started, 1, "only Enter restarts the process")
+		backend.mu.Unlock()
+
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("0\r")))
+		second := backend.process(t, 1)
+		assert.Equal(t, uint16(132), second.cols)
+		assert.Equal(t, uint16(43), second.rows)
+		assert.Empty(t, second.inputString(), "the Enter that restarted the process is not typed into it")
+	})
+}
@@ -0,0 +1,115 @@
+package src
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Sandbox confines a profile's shell to part of the filesystem. A sandboxed
+// shell runs in its own Linux user and mount namespaces, so the mounts made
+// for it are invisible to the rest of the system and need no privileges. It
+// still shares the host's network, processes and users.
+type Sandbox struct {
+	// ReadOnly lists paths to bind mount into the sandbox read-only. Each
+	// entry is either a path, mounted at the same path inside the sandbox,
+	// or SOURCE:TARGET to mount the host path SOURCE at TARGET.
+	ReadOnly []string
+	// PrivateTmp gives the sandbox an empty /tmp of its own that disappears
+	// with the shell.
+	PrivateTmp bool
+}
+
+// bindMount is an entry of Sandbox.ReadOnly.
+type bindMount struct {
+	Source string
+	Target string
+}
+
+// sandboxed reports whether p's shell runs in a sandbox, which it does when
+// p has a Root other than / or any Sandbox setting.
+func (p Profile) sandboxed() bool {
+	return p.Root != "" && p.Root != "/" || len(p.Sandbox.ReadOnly) > 0 || p.Sandbox.PrivateTmp
+}
+
+// validateSandbox reports an error when p's Root or a read-only bind mount is
+// not an absolute path.
+func (p Profile) validateSandbox() error {
+	if !p.sandboxed() {
+		return nil
+	}
+	if !initSupported {
+		return fmt.Errorf("sandboxed profiles are only supported on Linux")
+	}
+	if p.Root != "" && !isAbsPath(p.Root) {
+		return fmt.Errorf("root %q is not an absolute path", p.Root)
+	}
+	for _, entry := range p.Sandbox.ReadOnly {
+		if _, err := parseBindMount(entry, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAbsPath reports whether path is absolute once a leading ~/ is expanded.
+func isAbsPath(path string) bool {
+	return filepath.IsAbs(path) || strings.HasPrefix(path, "~/")
+}
+
+// expandHome replaces a leading ~/ in path with home.
+func expandHome(path, home string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// parseBindMount parses an entry of Sandbox.ReadOnly, expanding a leading ~/
+// in either path to home.
+func parseBindMount(entry, home string) (bindMount, error) {
+	source, target, ok := strings.Cut(entry, ":")
+	if !ok {
+		target = source
+	}
+	if !isAbsPath(source) || !isAbsPath(target) {
+		return bindMount{}, fmt.Errorf("read-only mount %q: paths must be absolute", entry)
+	}
+	return bindMount{
+		Source: filepath.Clean(expandHome(source, home)),
+		Target: filepath.Clean(expandHome(target, home)),
+	}, nil
+}
+
+// sandboxSpec tells the init process how to set up a sandbox; see
+// setupSandbox.
+type sandboxSpec struct {
+	// Root is the host directory that becomes / in the sandbox.
+	Root       string
+	ReadOnly   []bindMount
+	PrivateTmp bool
+	// Dir is the working directory inside the sandbox. / is used instead
+	// when it does not exist there.
+	Dir string
+}
+
+// newSandboxSpec describes the sandbox of p with the working directory dir.
+// A leading ~/ in any path is expanded to home.
+func (p Profile) newSandboxSpec(dir, home string) (sandboxSpec, error) {
+	spec := sandboxSpec{
+		Root:       "/",
+		PrivateTmp: p.Sandbox.PrivateTmp,
+		Dir:        dir,
+	}
+	if p.Root != "" {
+		spec.Root = filepath.Clean(expandHome(p.Root, home))
+	}
+	for _, entry := range p.Sandbox.ReadOnly {
+		bind, err := parseBindMount(entry, home)
+		if err != nil {
+			return spec, err
+		}
+		spec.ReadOnly = append(spec.ReadOnly, bind)
+	}
+	return spec, nil
+}
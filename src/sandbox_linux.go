@@ -0,0 +1,168 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxProcAttr returns the attributes that start the init process of a
+// sandbox in new user and mount namespaces. The user and group IDs stay the
+// same inside the sandbox.
+func sandboxProcAttr() *syscall.SysProcAttr {
+	uid, gid := os.Getuid(), os.Getgid()
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+		// The init process needs these to mount and to drop capabilities
+		// afterwards, whatever its user ID.
+		AmbientCaps: []uintptr{unix.CAP_SYS_ADMIN, unix.CAP_SETPCAP},
+	}
+}
+
+// setupSandbox makes the mounts of spec and changes to its root and working
+// directory. It runs in the init process started with sandboxProcAttr, so
+// the mounts do not affect the host.
+func setupSandbox(spec sandboxSpec) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	if spec.Root != "/" {
+		// pivot_root needs the new root to be a mount point.
+		if err := unix.Mount(spec.Root, spec.Root, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("root %s: %w", spec.Root, err)
+		}
+		for _, dir := range []string{"/dev", "/proc"} {
+			if err := bindInto(spec.Root, dir, bindMount{Source: dir, Target: dir}, false); err != nil {
+				return err
+			}
+		}
+	}
+	// Open the sources of the read-only mounts first, so that they are
+	// found even under a /tmp that the private one hides.
+	sources := make([]string, len(spec.ReadOnly))
+	for i, bind := range spec.ReadOnly {
+		fd, err := unix.Open(bind.Source, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("mount %s: %w", bind.Source, err)
+		}
+		sources[i] = fmt.Sprintf("/proc/self/fd/%d", fd)
+	}
+	if spec.PrivateTmp {
+		tmp := filepath.Join(spec.Root, "tmp")
+		if err := os.MkdirAll(tmp, 01777); err != nil {
+			return fmt.Errorf("private /tmp: %w", err)
+		}
+		if err := unix.Mount("tmpfs", tmp, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("private /tmp: %w", err)
+		}
+	}
+	for i, bind := range spec.ReadOnly {
+		if err := bindInto(spec.Root, sources[i], bind, true); err != nil {
+			return err
+		}
+	}
+	if spec.Root != "/" {
+		// Pivoting to the current directory stacks the old root on top of
+		// the new one, from where it is detached.
+		if err := unix.Chdir(spec.Root); err != nil {
+			return fmt.Errorf("root %s: %w", spec.Root, err)
+		}
+		if err := unix.PivotRoot(".", "."); err != nil {
+			return fmt.Errorf("pivot to root %s: %w", spec.Root, err)
+		}
+		if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+			return fmt.Errorf("detach the host root: %w", err)
+		}
+		if err := unix.Chdir("/"); err != nil {
+			return err
+		}
+	}
+	if spec.Dir != "" && unix.Chdir(spec.Dir) != nil {
+		_ = unix.Chdir("/")
+	}
+	return nil
+}
+
+// lockedMountFlags pairs the statfs flags of a mount with the mount flags the
+// kernel refuses to clear when a mount copied from a more privileged
+// namespace is remounted.
+var lockedMountFlags = [][2]uintptr{
+	{unix.ST_NOSUID, unix.MS_NOSUID},
+	{unix.ST_NODEV, unix.MS_NODEV},
+	{unix.ST_NOEXEC, unix.MS_NOEXEC},
+	{unix.ST_NOATIME, unix.MS_NOATIME},
+	{unix.ST_NODIRATIME, unix.MS_NODIRATIME},
+	{unix.ST_RELATIME, unix.MS_RELATIME},
+}
+
+// bindInto bind mounts source, which is bind.Source or a path leading to it,
+// at bind.Target under root, creating the mount point when it is missing. A
+// read-only bind mount leaves the mounts below its source writable if they
+// are.
+func bindInto(root, source string, bind bindMount, readOnly bool) error {
+	target := filepath.Join(root, bind.Target)
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", bind.Source, err)
+	}
+	if err := mountPoint(target, info.IsDir()); err != nil {
+		return fmt.Errorf("mount point %s: %w", bind.Target, err)
+	}
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("mount %s at %s: %w", bind.Source, bind.Target, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return fmt.Errorf("mount %s at %s: %w", bind.Source, bind.Target, err)
+	}
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+	for _, f := range lockedMountFlags {
+		if uintptr(st.Flags)&f[0] != 0 {
+			flags |= f[1]
+		}
+	}
+	if err := unix.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("make %s read-only: %w", bind.Target, err)
+	}
+	return nil
+}
+
+// mountPoint creates path as a directory, or as an empty file when dir is
+// false, unless it exists.
+func mountPoint(path string, dir bool) error {
+	if dir {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// dropCapabilities empties the calling thread's capability bounding set, so
+// that nothing it executes, not even as root, gains capabilities in the
+// sandbox and could undo its mounts, and clears the ambient capabilities
+// raised by sandboxProcAttr.
+func dropCapabilities() error {
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return fmt.Errorf("drop capabilities: %w", err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("drop capabilities: %w", err)
+	}
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
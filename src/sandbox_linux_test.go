@@ -0,0 +1,125 @@
+package src
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireUserNamespaces skips the test when unprivileged user namespaces are
+// unavailable, as they are in some containers.
+func requireUserNamespaces(t *testing.T) {
+	t.Helper()
+	if err := exec.Command("unshare", "--user", "--mount", "true").Run(); err != nil {
+		t.Skipf("user namespaces are unavailable: %v", err)
+	}
+}
+
+// runProfile runs command with the settings of p on a real pty and returns
+// its output.
+func runProfile(t *testing.T, p Profile, command string) string {
+	t.Helper()
+	p.Command = []string{"sh", "-c", command}
+	proc, err := LocalPTYBackend{}.Start(p, 80, 24)
+	require.NoError(t, err)
+	defer proc.Close()
+	out := readAll(proc)
+	require.NoError(t, proc.Wait(), out)
+	return strings.ReplaceAll(out, "\r\n", "\n")
+}
+
+func TestValidateSandbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		errMsg  string
+	}{
+		{name: "not sandboxed", profile: Profile{Root: "/"}},
+		{name: "root", profile: Profile{Root: "~/sandbox"}},
+		{name: "mounts", profile: Profile{Sandbox: Sandbox{ReadOnly: []string{"/usr", "~/data:/data"}}}},
+		{name: "relative root", profile: Profile{Root: "sandbox"}, errMsg: `root "sandbox" is not an absolute path`},
+		{name: "relative mount", profile: Profile{Sandbox: Sandbox{ReadOnly: []string{"/data:data"}}}, errMsg: "paths must be absolute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.validateSandbox()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+
+	ts := newTestTerminalServer()
+	ts.Profiles["jail"] = Profile{Root: "jail"}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "profile jail: root")
+}
+
+func TestNewSandboxSpec(t *testing.T) {
+	p := Profile{Root: "~/jail/", Sandbox: Sandbox{ReadOnly: []string{"/usr", "~/data:/srv/data"}, PrivateTmp: true}}
+	spec, err := p.newSandboxSpec("/work", "/home/me")
+	require.NoError(t, err)
+	assert.Equal(t, sandboxSpec{
+		Root:       "/home/me/jail",
+		ReadOnly:   []bindMount{{Source: "/usr", Target: "/usr"}, {Source: "/home/me/data", Target: "/srv/data"}},
+		PrivateTmp: true,
+		Dir:        "/work",
+	}, spec)
+
+	assert.False(t, Profile{Root: "/"}.sandboxed())
+	assert.True(t, Profile{Sandbox: Sandbox{PrivateTmp: true}}.sandboxed())
+}
+
+func TestSandbox(t *testing.T) {
+	requireUserNamespaces(t)
+
+	t.Run("read-only mounts and a private /tmp", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "kept"), []byte("hello\n"), 0644))
+		p := Profile{Sandbox: Sandbox{ReadOnly: []string{dir}, PrivateTmp: true}}
+
+		out := runProfile(t, p, `cat `+dir+`/kept
+touch `+dir+`/new 2>/dev/null || echo read-only
+touch /tmp/b3tty-sandbox-test && ls -A /tmp | wc -l`)
+		// /tmp holds the new file and the mount point of dir, which is
+		// inside the host's /tmp.
+		assert.Equal(t, "hello\nread-only\n2\n", out)
+		assert.NoFileExists(t, filepath.Join(dir, "new"))
+		assert.NoFileExists(t, "/tmp/b3tty-sandbox-test")
+	})
+
+	t.Run("separate root", func(t *testing.T) {
+		root := t.TempDir()
+		host := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(host, "data"), []byte("from the host\n"), 0644))
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "work"), 0755))
+		p := Profile{
+			Root:             root,
+			WorkingDirectory: "/work",
+			Sandbox:          Sandbox{ReadOnly: []string{"/usr", "/bin", "/lib", "/lib64", "/etc", host + ":/srv/data"}},
+		}
+		for i := len(p.Sandbox.ReadOnly) - 2; i >= 0; i-- {
+			if _, err := os.Stat(p.Sandbox.ReadOnly[i]); err != nil {
+				p.Sandbox.ReadOnly = append(p.Sandbox.ReadOnly[:i], p.Sandbox.ReadOnly[i+1:]...)
+			}
+		}
+
+		out := runProfile(t, p, `pwd; cat /srv/data/data; test -e `+host+` || echo host hidden; test -e /proc/self && echo proc`)
+		assert.Equal(t, "/work\nfrom the host\nhost hidden\nproc\n", out)
+	})
+
+	t.Run("setup failures are shown in the terminal", func(t *testing.T) {
+		p := Profile{Command: []string{"true"}, Sandbox: Sandbox{ReadOnly: []string{"/b3tty-no-such-dir"}}}
+		proc, err := LocalPTYBackend{}.Start(p, 80, 24)
+		require.NoError(t, err)
+		defer proc.Close()
+		assert.Contains(t, readAll(proc), "b3tty: sandbox: mount /b3tty-no-such-dir")
+		assert.ErrorContains(t, proc.Wait(), "exit status 125")
+	})
+}
@@ -0,0 +1,143 @@
+package src
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// reDuration matches the strings accepted by time.ParseDuration, apart from a
+// leading sign, since no duration setting may be negative.
+var reDuration = regexp.MustCompile(`^(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`)
+
+// CONFIG_SCHEMA_ID is the $id advertised in the generated JSON Schema. Editors
+// use it only as an identifier, so it does not need to resolve.
+const CONFIG_SCHEMA_ID = "https://github.com/cmmorrow/b3tty/conf.schema.json"
+
+// ConfigSchema returns a JSON Schema (draft-07) describing the conf.yaml file.
+// The schema is generated by reflection from configFile and its nested config
+// structs, so it always matches the keys accepted by ValidateConfig. Fields
+// tagged with schema:"color" are constrained to the same hex and named color
+// patterns enforced by ValidateThemeColor, fields tagged with
+// schema:"duration" to Go duration strings, and fields tagged with
+// schema:"argv" accept either a list of arguments or a command string, and
+// fields tagged with schema:"size" a byte count or a size string accepted by
+// ParseSize. Items of fields tagged with schema:"commands" are either a string
+// or an object.
+func ConfigSchema() map[string]any {
+	schema := schemaForType(reflect.TypeOf(configFile{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = CONFIG_SCHEMA_ID
+	schema["title"] = "b3tty config file"
+	return schema
+}
+
+// MarshalConfigSchema returns ConfigSchema serialized as indented JSON.
+func MarshalConfigSchema() ([]byte, error) {
+	return json.MarshalIndent(ConfigSchema(), "", "  ")
+}
+
+// colorSchema returns the schema fragment applied to every theme color field.
+// An empty string is allowed because ValidateThemeColor treats it as unset.
+func colorSchema() map[string]any {
+	return map[string]any{
+		"type": "string",
+		"anyOf": []any{
+			map[string]any{"const": ""},
+			map[string]any{"pattern": reHexColor.String()},
+			map[string]any{"pattern": reNamedColor.String()},
+		},
+	}
+}
+
+// schemaForType converts a Go type used by the config structs into a JSON
+// Schema fragment. Structs become closed objects keyed by their yaml tags,
+// maps become objects whose values share a single schema, and slices become
+// arrays.
+func schemaForType(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			switch field.Tag.Get("schema") {
+			case "color":
+				props[name] = colorSchema()
+			case "duration":
+				props[name] = map[string]any{"type": "string", "pattern": reDuration.String()}
+			case "size":
+				props[name] = map[string]any{"anyOf": []any{
+					map[string]any{"type": "integer", "minimum": 0},
+					map[string]any{"type": "string", "pattern": reSize.String()},
+				}}
+			case "argv":
+				props[name] = map[string]any{"anyOf": []any{
+					map[string]any{"type": "string"},
+					schemaForType(field.Type),
+				}}
+			case "commands":
+				props[name] = map[string]any{
+					"type": "array",
+					"items": map[string]any{"anyOf": []any{
+						map[string]any{"type": "string"},
+						schemaForType(field.Type.Elem()),
+					}},
+				}
+			default:
+				props[name] = schemaForType(field.Type)
+			}
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": schemaForType(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": schemaForType(t.Elem()),
+		}
+	case reflect.Pointer:
+		return schemaForType(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// configSchemaHandler serves the config file JSON Schema.
+// GET /config-schema
+func (ts *TerminalServer) configSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		Warnf("%s %s: method not allowed: %s", r.Method, r.URL.Path, r.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	buf, err := MarshalConfigSchema()
+	if err != nil {
+		Errorf("config-schema response error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(buf)
+}
@@ -0,0 +1,182 @@
+package src
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// schemaProps returns the "properties" map of an object schema fragment.
+func schemaProps(t *testing.T, s map[string]any) map[string]any {
+	t.Helper()
+	props, ok := s["properties"].(map[string]any)
+	require.True(t, ok, "schema fragment has no properties: %v", s)
+	return props
+}
+
+// yamlKeys returns the yaml tag names of every field in the struct type of v.
+func yamlKeys(v any) []string {
+	typ := reflect.TypeOf(v)
+	keys := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		keys = append(keys, strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0])
+	}
+	return keys
+}
+
+// ---------------------------------------------------------------------------
+// ConfigSchema
+// ---------------------------------------------------------------------------
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+	root := schemaProps(t, schema)
+
+	t.Run("root metadata", func(t *testing.T) {
+		assert.Equal(t, "http://json-schema.org/draft-07/schema#", schema["$schema"])
+		assert.Equal(t, CONFIG_SCHEMA_ID, schema["$id"])
+		assert.Equal(t, "object", schema["type"])
+		assert.Equal(t, false, schema["additionalProperties"])
+	})
+
+	// The sync tests below fail whenever a field is added to or removed from
+	// the config structs without the schema reflecting it.
+	sections := []struct {
+		name   string
+		schema func() map[string]any
+		config any
+	}{
+		{"root", func() map[string]any { return schema }, configFile{}},
+		{"server", func() map[string]any { return root["server"].(map[string]any) }, serverConfig{}},
+		{"log", func() map[string]any { return root["log"].(map[string]any) }, logConfig{}},
+		{"terminal", func() map[string]any { return root["terminal"].(map[string]any) }, terminalConfig{}},
+		{"themes", func() map[string]any {
+			return root["themes"].(map[string]any)["additionalProperties"].(map[string]any)
+		}, themeConfig{}},
+		{"profiles", func() map[string]any {
+			return root["profiles"].(map[string]any)["additionalProperties"].(map[string]any)
+		}, profileConfig{}},
+	}
+	for _, sec := range sections {
+		t.Run(sec.name+" keys match struct fields", func(t *testing.T) {
+			props := schemaProps(t, sec.schema())
+			keys := yamlKeys(sec.config)
+			assert.Len(t, props, len(keys))
+			for _, key := range keys {
+				assert.Contains(t, props, key)
+			}
+			assert.Equal(t, false, sec.schema()["additionalProperties"])
+		})
+	}
+
+	t.Run("field types", func(t *testing.T) {
+		server := schemaProps(t, root["server"].(map[string]any))
+		assert.Equal(t, "boolean", server["tls"].(map[string]any)["type"])
+		assert.Equal(t, "integer", server["port"].(map[string]any)["type"])
+		assert.Equal(t, "string", server["cert-file"].(map[string]any)["type"])
+		assert.Equal(t, reDuration.String(), server["ping-interval"].(map[string]any)["pattern"])
+		assert.Equal(t, "string", root["theme"].(map[string]any)["type"])
+
+		profile := schemaProps(t, root["profiles"].(map[string]any)["additionalProperties"].(map[string]any))
+		commands := profile["commands"].(map[string]any)
+		assert.Equal(t, "array", commands["type"])
+		items := commands["items"].(map[string]any)["anyOf"].([]any)
+		require.Len(t, items, 2)
+		assert.Equal(t, "string", items[0].(map[string]any)["type"])
+		assert.Contains(t, schemaProps(t, items[1].(map[string]any)), "run")
+		assert.Contains(t, profile, "command-wait")
+
+		command := profile["command"].(map[string]any)["anyOf"].([]any)
+		require.Len(t, command, 2)
+		assert.Equal(t, "string", command[0].(map[string]any)["type"])
+		assert.Equal(t, "array", command[1].(map[string]any)["type"])
+
+		limits := schemaProps(t, profile["limits"].(map[string]any))
+		memory := limits["memory"].(map[string]any)["anyOf"].([]any)
+		require.Len(t, memory, 2)
+		assert.Equal(t, "integer", memory[0].(map[string]any)["type"])
+		assert.Equal(t, reSize.String(), memory[1].(map[string]any)["pattern"])
+		assert.Equal(t, reDuration.String(), limits["cpu-time"].(map[string]any)["pattern"])
+	})
+
+	t.Run("every theme color has the color patterns", func(t *testing.T) {
+		theme := schemaProps(t, root["themes"].(map[string]any)["additionalProperties"].(map[string]any))
+		want := colorSchema()
+		// Every runtime Theme color must be validated by the schema. Map the
+		// json tag (camelCase) to the yaml key via toColorMap.
+		colors := Theme{
+			Foreground: "x", Background: "x", Cursor: "x", CursorAccent: "x",
+			SelectionForeground: "x", SelectionBackground: "x",
+			Black: "x", BrightBlack: "x", Red: "x", BrightRed: "x",
+			Yellow: "x", BrightYellow: "x", Green: "x", BrightGreen: "x",
+			Blue: "x", BrightBlue: "x", Magenta: "x", BrightMagenta: "x",
+			Cyan: "x", BrightCyan: "x", White: "x", BrightWhite: "x",
+		}.toColorMap()
+		assert.Len(t, colors, reflect.TypeOf(Theme{}).NumField()-1, "every Theme color field must be set above")
+		for key := range colors {
+			assert.Equal(t, want, theme[key], "theme key %q", key)
+		}
+		assert.Equal(t, map[string]any{"type": "string"}, theme["background-image"])
+	})
+
+	t.Run("color patterns match the validation regexes", func(t *testing.T) {
+		anyOf := colorSchema()["anyOf"].([]any)
+		require.Len(t, anyOf, 3)
+		assert.Equal(t, "", anyOf[0].(map[string]any)["const"])
+		assert.Equal(t, reHexColor.String(), anyOf[1].(map[string]any)["pattern"])
+		assert.Equal(t, reNamedColor.String(), anyOf[2].(map[string]any)["pattern"])
+	})
+}
+
+func TestMarshalConfigSchema(t *testing.T) {
+	buf, err := MarshalConfigSchema()
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(buf, &decoded))
+	assert.Equal(t, CONFIG_SCHEMA_ID, decoded["$id"])
+}
+
+// ---------------------------------------------------------------------------
+// configSchemaHandler
+// ---------------------------------------------------------------------------
+
+func TestConfigSchemaHandler(t *testing.T) {
+	t.Run("GET returns the schema", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		req := httptest.NewRequest(http.MethodGet, "/config-schema", nil)
+		w := httptest.NewRecorder()
+		ts.configSchemaHandler(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+		assert.Contains(t, decoded["properties"], "profiles")
+	})
+
+	t.Run("POST is rejected with 405", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		req := httptest.NewRequest(http.MethodPost, "/config-schema", nil)
+		w := httptest.NewRecorder()
+		logged := captureLog(func() { ts.configSchemaHandler(w, req) })
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Contains(t, logged, "method not allowed")
+	})
+}
+
+func TestDurationPattern(t *testing.T) {
+	for _, s := range []string{"0", "30s", "1m30s", "1.5h", "250ms", "10us", "10µs"} {
+		_, err := time.ParseDuration(s)
+		require.NoError(t, err, s)
+		assert.True(t, reDuration.MatchString(s), s)
+	}
+	for _, s := range []string{"", "30", "-5s", "s", "1d", "1.s"} {
+		assert.False(t, reDuration.MatchString(s), s)
+	}
+}
@@ -33,9 +33,31 @@ type TerminalServer struct {
 	FailedAttempts int
 	FirstRun       bool
 	BackoffMu      sync.Mutex
+	// stateMu guards the mutable fields above once the server is running. All
+	// handler access goes through the accessors in state.go.
+	stateMu sync.RWMutex
+	// configMu serializes read-modify-write updates of ConfigFile.
+	configMu sync.Mutex
 	// AuthSleep is the function used to pause on auth failures. It defaults to
 	// time.Sleep and can be replaced in tests with a no-op to avoid real delays.
 	AuthSleep func(time.Duration)
+	// Authorize, when set, replaces token validation. See Options.Authorize.
+	Authorize func(r *http.Request) bool
+	// Audit, when set, records sessions, config changes and auth failures.
+	Audit *AuditLog
+	// Backends maps profile types to the Backend that starts their sessions,
+	// adding to or overriding the built-in "local" backend.
+	Backends map[string]Backend
+
+	sessionsMu sync.Mutex
+	sessions   map[*session]struct{}
+	// starting counts, by profile, the sessions whose process is being
+	// started; see reserveSession.
+	starting map[string]int
+	closed   bool
+
+	metrics   metrics
+	readiness readinessCache
 }
 
 // GetCSPHeaders returns the baseline Content-Security-Policy directives used by
@@ -148,7 +170,10 @@ func buildUIUrl(protocol, addr, tokenQuery, startupProfile string) string {
 	return url
 }
 
-// Serve wires up the HTTP mux and starts the server.
+// Serve builds a Handler from ts and runs it on a standalone HTTP server,
+// adding the behavior that only makes sense for the b3tty binary: printing the
+// startup URL, optionally opening the browser, and shutting down on SIGINT or
+// SIGTERM. Programs embedding b3tty should use NewHandler instead.
 func Serve(ts *TerminalServer, shouldOpenBrowser bool, useTLS bool) {
 	Debug("starting b3tty server....")
 
@@ -161,11 +186,16 @@ func Serve(ts *TerminalServer, shouldOpenBrowser bool, useTLS bool) {
 	}
 
 	Debugf("no-auth mode: %v", ts.Server.NoAuth)
-	if !ts.Server.NoAuth {
-		ts.Token, err = generateToken(TOKEN_LENGTH)
-		if err != nil {
-			Fatalf("error generating token: %v", err)
-		}
+	handler, err := ts.Handler()
+	if err != nil {
+		Fatalf("cannot start server: %v", err)
+	}
+	if stopReaper, err := startReaper(); err != nil {
+		Warnf("cannot adopt orphaned jobs; they will be left to init: %v", err)
+	} else {
+		defer stopReaper()
+	}
+	if ts.Token != "" {
 		tokenQuery = "?token=" + ts.Token
 	}
 
@@ -180,7 +210,6 @@ func Serve(ts *TerminalServer, shouldOpenBrowser bool, useTLS bool) {
 		}
 	}
 
-	mux := http.NewServeMux()
 	Infof("%s server started on %s", protocol, Bold(uiUrl))
 
 	// Display the available profiles in the config file
@@ -188,28 +217,23 @@ func Serve(ts *TerminalServer, shouldOpenBrowser bool, useTLS bool) {
 		logProfileURLs(ts.Profiles, uiUrl)
 	}
 
-	mux.HandleFunc("/", ts.displayTermHandler)
-	mux.Handle("/assets/", http.StripPrefix("/", http.FileServer(http.FS(assets))))
-	mux.HandleFunc("/ws", ts.terminalHandler)
-	mux.HandleFunc("/size", ts.setSizeHandler)
-	mux.HandleFunc("/background", ts.backgroundHandler)
-	mux.HandleFunc("/theme", ts.themePaletteHandler)
-	mux.HandleFunc("/theme-config", ts.themeConfigHandler)
-	mux.HandleFunc("/theme-select", ts.themeSelectHandler)
-	mux.HandleFunc("/add-theme", ts.addThemeHandler)
-	mux.HandleFunc("/edit-theme", ts.editThemeHandler)
-	mux.HandleFunc("/save-config", ts.saveConfigHandler)
-	mux.HandleFunc("/profile-config", ts.profileConfigHandler)
-	mux.HandleFunc("/edit-profile", ts.editProfileHandler)
-	mux.HandleFunc("/delete-profile", ts.deleteProfileHandler)
 	httpServer := &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      handler,
 		ErrorLog:     NewWarnLogger(),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	if useTLS {
+		if httpServer.TLSConfig, err = ts.Server.TLS.config(); err != nil {
+			Fatalf("cannot load TLS client CA: %v", err)
+		}
+	}
+	var metricsServer *http.Server
+	if ts.Server.Metrics.Enabled && ts.Server.Metrics.Address != "" {
+		metricsServer = serveMetrics(ts.Server.Metrics.Address, handler)
+	}
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -230,10 +254,51 @@ func Serve(ts *TerminalServer, shouldOpenBrowser bool, useTLS bool) {
 		}
 	case sig := <-quit:
 		Infof("received signal %v, shutting down...", sig)
+		drain, stopDraining := context.WithTimeout(context.Background(), ts.Server.shutdownDrain())
+		go func() {
+			select {
+			case sig := <-quit:
+				Warnf("received signal %v, closing terminal sessions now", sig)
+				stopDraining()
+			case <-drain.Done():
+			}
+		}()
+		// Hang up every shell and wait for it and its jobs to exit so none
+		// outlive the server.
+		handler.Shutdown(drain)
+		stopDraining()
+		Info("terminal sessions closed")
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
+		if metricsServer != nil {
+			if err = metricsServer.Shutdown(ctx); err != nil {
+				Warnf("metrics server shutdown error: %v", err)
+			}
+		}
 		if err = httpServer.Shutdown(ctx); err != nil {
 			Fatalf("server shutdown error: %v", err)
 		}
 	}
 }
+
+// serveMetrics serves the metrics of handler on a separate plain HTTP
+// listener at addr and returns its server. The process exits if the listener
+// fails.
+func serveMetrics(addr string, handler *Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler.MetricsHandler())
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ErrorLog:     NewWarnLogger(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	Infof("metrics served on http://%s/metrics", addr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			Fatalf("metrics server error: %v", err)
+		}
+	}()
+	return srv
+}
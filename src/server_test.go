@@ -3,15 +3,23 @@ package src
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -22,11 +30,15 @@ func captureLog(f func()) string {
 	var buf bytes.Buffer
 	log.SetOutput(&buf)
 	log.SetFlags(0)
-	defer func() {
+	restore := func() {
 		log.SetOutput(os.Stderr) // restore default (stderr)
 		log.SetFlags(log.LstdFlags)
-	}()
+	}
+	defer restore()
 	f()
+	// Restore before reading so handler goroutines that are still logging
+	// can no longer write to buf while it is being read.
+	restore()
 	return buf.String()
 }
 
@@ -75,6 +87,155 @@ func queryWith(pairs ...string) url.Values {
 	return q
 }
 
+// fakeBackend is an in-memory Backend that records every process it starts,
+// letting terminalHandler be tested without spawning a real shell.
+type fakeBackend struct {
+	mu       sync.Mutex
+	started  []*fakeProcess
+	startErr error
+}
+
+func (b *fakeBackend) Start(profile Profile, cols, rows uint16) (Process, error) {
+	if b.startErr != nil {
+		return nil, b.startErr
+	}
+	r, w := io.Pipe()
+	p := &fakeProcess{profile: profile, cols: cols, rows: rows, out: r, outW: w, exited: make(chan struct{})}
+	b.mu.Lock()
+	b.started = append(b.started, p)
+	b.mu.Unlock()
+	return p, nil
+}
+
+// process returns the i-th started process, waiting briefly for it to start.
+func (b *fakeBackend) process(t *testing.T, i int) *fakeProcess {
+	t.Helper()
+	var p *fakeProcess
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if len(b.started) > i {
+			p = b.started[i]
+			return true
+		}
+		return false
+	}, 2*time.Second, 5*time.Millisecond, "process %d was never started", i)
+	return p
+}
+
+// fakeProcess is the Process returned by fakeBackend. Output is fed with emit,
+// and input, resizes and signals are recorded for inspection.
+type fakeProcess struct {
+	profile Profile
+	out     *io.PipeReader
+	outW    *io.PipeWriter
+	exited  chan struct{}
+	// waitErr is returned by Wait.
+	waitErr error
+
+	mu        sync.Mutex
+	cols      uint16
+	rows      uint16
+	input     bytes.Buffer
+	resizes   [][2]uint16
+	signals   []os.Signal
+	closeOnce sync.Once
+}
+
+func (p *fakeProcess) Read(b []byte) (int, error) { return p.out.Read(b) }
+
+func (p *fakeProcess) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.input.Write(b)
+}
+
+func (p *fakeProcess) Resize(cols, rows uint16) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cols, p.rows = cols, rows
+	p.resizes = append(p.resizes, [2]uint16{cols, rows})
+	return nil
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.exited
+	return p.waitErr
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.signals = append(p.signals, sig)
+	return nil
+}
+
+func (p *fakeProcess) Close() error {
+	p.closeOnce.Do(func() {
+		p.outW.Close()
+		close(p.exited)
+	})
+	return nil
+}
+
+// emit writes s to the process output as if the shell had printed it.
+func (p *fakeProcess) emit(s string) {
+	_, _ = p.outW.Write([]byte(s))
+}
+
+// exit simulates the shell exiting: pending and future reads return io.EOF.
+func (p *fakeProcess) exit() { p.Close() }
+
+// isClosed reports whether Close has been called.
+func (p *fakeProcess) isClosed() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// inputString returns everything written to the process so far.
+func (p *fakeProcess) inputString() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.input.String()
+}
+
+// newFakeTerminal starts an httptest server running ts.terminalHandler with
+// every profile type served by a fakeBackend, and returns the backend and a
+// WebSocket connected to it that requested subprotocols during the handshake.
+func newFakeTerminal(t *testing.T, ts *TerminalServer, subprotocols ...string) (*fakeBackend, *websocket.Conn) {
+	t.Helper()
+	backend, wsURL := startFakeTerminal(t, ts)
+	return backend, dialTerminal(t, wsURL, subprotocols...)
+}
+
+// startFakeTerminal is newFakeTerminal without the connection; it returns the
+// ws:// URL of the handler instead.
+func startFakeTerminal(t *testing.T, ts *TerminalServer) (*fakeBackend, string) {
+	t.Helper()
+	backend := &fakeBackend{}
+	if ts.Backends == nil {
+		ts.Backends = map[string]Backend{}
+	}
+	ts.Backends[DEFAULT_BACKEND] = backend
+	srv := httptest.NewServer(http.HandlerFunc(ts.terminalHandler))
+	t.Cleanup(srv.Close)
+	return backend, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialTerminal opens a WebSocket to wsURL requesting subprotocols.
+func dialTerminal(t *testing.T, wsURL string, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
 // ---------------------------------------------------------------------------
 // parseSizeParams
 // ---------------------------------------------------------------------------
@@ -479,6 +640,17 @@ func TestBuildConfigJSON(t *testing.T) {
 		assert.Equal(t, true, result["tls"])
 	})
 
+	t.Run("keepalive settings are in milliseconds", func(t *testing.T) {
+		detachSrv := &Server{Uri: "localhost", Port: 8080, PingInterval: 5 * time.Second, DetachGracePeriod: 2 * time.Minute}
+		data, err := buildConfigJSON(detachSrv, clnt, thm, nil, nil, nil, nil, "")
+		require.NoError(t, err)
+
+		var result map[string]any
+		require.NoError(t, json.Unmarshal(data, &result))
+		assert.Equal(t, float64(5000), result["pingInterval"])
+		assert.Equal(t, float64(120000), result["detachGracePeriod"])
+	})
+
 	t.Run("empty theme produces valid JSON", func(t *testing.T) {
 		emptyTheme := &Theme{}
 		data, err := buildConfigJSON(srv, clnt, emptyTheme, nil, nil, nil, nil, "")
@@ -1580,3 +1752,748 @@ func TestEditThemeHandler(t *testing.T) {
 		assert.NotContains(t, w.Body.String(), "/etc/passwd")
 	})
 }
+
+// ---------------------------------------------------------------------------
+// terminalHandler
+// ---------------------------------------------------------------------------
+
+func TestTerminalHandler(t *testing.T) {
+	t.Run("process output is sent as binary frames", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer())
+		proc := backend.process(t, 0)
+		go proc.emit("hello")
+		msgType, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, msgType)
+		assert.Equal(t, "hello", string(msg))
+	})
+
+	t.Run("input is written to the process", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer())
+		proc := backend.process(t, 0)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ls\r")))
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("pwd\r")))
+		assert.Eventually(t, func() bool { return proc.inputString() == "ls\rpwd\r" }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("resize messages resize the process instead of being typed", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer())
+		proc := backend.process(t, 0)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"resize","cols":100,"rows":40}`)))
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("x")))
+		assert.Eventually(t, func() bool { return proc.inputString() == "x" }, time.Second, 5*time.Millisecond)
+		proc.mu.Lock()
+		defer proc.mu.Unlock()
+		assert.Equal(t, [][2]uint16{{100, 40}}, proc.resizes)
+	})
+
+	t.Run("zero-dimension resize is ignored", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer())
+		proc := backend.process(t, 0)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"resize","cols":0,"rows":40}`)))
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("x")))
+		assert.Eventually(t, func() bool { return proc.inputString() == "x" }, time.Second, 5*time.Millisecond)
+		proc.mu.Lock()
+		defer proc.mu.Unlock()
+		assert.Empty(t, proc.resizes)
+	})
+
+	t.Run("starts with the stored size and active profile", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.setInitialSize(132, 43)
+		ts.setActiveProfileName("work")
+		backend, _ := newFakeTerminal(t, ts)
+		proc := backend.process(t, 0)
+		assert.Equal(t, uint16(132), proc.cols)
+		assert.Equal(t, uint16(43), proc.rows)
+		assert.Equal(t, "/bin/zsh", proc.profile.Shell)
+	})
+
+	t.Run("process exit closes the WebSocket", func(t *testing.T) {
+		backend, conn := newFakeTerminal(t, newTestTerminalServer())
+		backend.process(t, 0).exit()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		assert.Error(t, err)
+	})
+
+	t.Run("client disconnect closes the process and ends the session", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		backend, conn := newFakeTerminal(t, ts)
+		proc := backend.process(t, 0)
+		require.Eventually(t, func() bool { return ts.sessionCount() == 1 }, time.Second, 5*time.Millisecond)
+		conn.Close()
+		assert.Eventually(t, proc.isClosed, time.Second, 5*time.Millisecond)
+		// The handler only returns once the output side has drained.
+		assert.Eventually(t, func() bool { return ts.sessionCount() == 0 }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("profile type selects the backend", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["remote"] = Profile{Type: "remote", Shell: "ssh host"}
+		ts.setActiveProfileName("remote")
+		remote := &fakeBackend{}
+		ts.Backends = map[string]Backend{"remote": remote}
+		local, _ := newFakeTerminal(t, ts)
+		assert.Equal(t, "ssh host", remote.process(t, 0).profile.Shell)
+		local.mu.Lock()
+		defer local.mu.Unlock()
+		assert.Empty(t, local.started)
+	})
+
+	t.Run("unknown profile type closes the connection", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["odd"] = Profile{Type: "nope"}
+		ts.setActiveProfileName("odd")
+		var conn *websocket.Conn
+		logged := captureLog(func() {
+			_, conn = newFakeTerminal(t, ts)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := conn.ReadMessage()
+			assert.Error(t, err)
+		})
+		assert.Contains(t, logged, `unknown profile type "nope"`)
+	})
+
+	t.Run("backend start failure closes the connection", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Backends = map[string]Backend{"broken": &fakeBackend{startErr: errors.New("no pty available")}}
+		ts.Profiles["broken"] = Profile{Type: "broken"}
+		ts.setActiveProfileName("broken")
+		logged := captureLog(func() {
+			_, conn := newFakeTerminal(t, ts)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := conn.ReadMessage()
+			assert.Error(t, err)
+		})
+		assert.Contains(t, logged, "no pty available")
+	})
+}
+
+// ---------------------------------------------------------------------------
+// backend
+// ---------------------------------------------------------------------------
+
+func TestBackendLookup(t *testing.T) {
+	ts := newTestTerminalServer()
+
+	b, err := ts.backend("")
+	require.NoError(t, err)
+	assert.IsType(t, LocalPTYBackend{}, b)
+
+	b, err = ts.backend(DEFAULT_BACKEND)
+	require.NoError(t, err)
+	assert.IsType(t, LocalPTYBackend{}, b)
+
+	_, err = ts.backend("nope")
+	assert.ErrorContains(t, err, `unknown profile type "nope"`)
+
+	fake := &fakeBackend{}
+	ts.Backends = map[string]Backend{DEFAULT_BACKEND: fake}
+	b, err = ts.backend("")
+	require.NoError(t, err)
+	assert.Same(t, fake, b)
+}
+
+func TestValidateProfiles(t *testing.T) {
+	ts := newTestTerminalServer()
+	assert.NoError(t, ts.ValidateProfiles())
+
+	ts.Profiles["odd"] = Profile{Type: "nope"}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "profile odd")
+
+	ts.Backends = map[string]Backend{"nope": &fakeBackend{}}
+	assert.NoError(t, ts.ValidateProfiles())
+
+	ts.Profiles["odd"] = Profile{Type: "nope", InheritEnv: EnvFilter{Allow: []string{"["}}}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "inherit-env pattern")
+
+	ts.Profiles["odd"] = Profile{Type: "nope", Command: []string{"htop"}, LoginShell: true}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "login-shell")
+
+	ts.Profiles["odd"] = Profile{Type: "nope", Commands: []string{"ls"}, CommandWaits: []WaitCondition{{Until: WAIT_PATTERN}}}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "needs a pattern")
+}
+
+// readAll reads proc until it reports an error and returns what it read.
+func readAll(proc Process) string {
+	var out bytes.Buffer
+	buf := make([]byte, 1024)
+	for {
+		n, err := proc.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			return out.String()
+		}
+	}
+}
+
+func TestLocalPTYBackendCommand(t *testing.T) {
+	t.Run("passes arguments as given", func(t *testing.T) {
+		proc, err := LocalPTYBackend{}.Start(Profile{Command: []string{"printf", "[%s]", "a b", "$HOME", "c'd"}}, 80, 24)
+		require.NoError(t, err)
+		defer proc.Close()
+		assert.Equal(t, "[a b][$HOME][c'd]", readAll(proc))
+		assert.NoError(t, proc.Wait())
+	})
+
+	t.Run("sets argv0", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("reads /proc")
+		}
+		proc, err := LocalPTYBackend{}.Start(Profile{Command: []string{"cat", "/proc/self/cmdline"}, Argv0: "renamed"}, 80, 24)
+		require.NoError(t, err)
+		defer proc.Close()
+		assert.Equal(t, "renamed\x00/proc/self/cmdline\x00", readAll(proc))
+		assert.NoError(t, proc.Wait())
+	})
+
+	t.Run("reports a missing program", func(t *testing.T) {
+		_, err := LocalPTYBackend{}.Start(Profile{Command: []string{"b3tty-no-such-program"}}, 80, 24)
+		assert.ErrorContains(t, err, "b3tty-no-such-program")
+	})
+
+	t.Run("refuses a user with a sandbox", func(t *testing.T) {
+		_, err := LocalPTYBackend{}.Start(Profile{Command: []string{"true"}, User: "nobody", Root: "/srv/jail"}, 80, 24)
+		assert.ErrorContains(t, err, "cannot be used with a sandbox")
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Keepalive and detached sessions
+// ---------------------------------------------------------------------------
+
+// fastKeepalive returns a test server that pings every 10ms and gives up on a
+// silent client after 100ms.
+func fastKeepalive(grace time.Duration) *TerminalServer {
+	ts := newTestTerminalServer()
+	ts.Server.PingInterval = 10 * time.Millisecond
+	ts.Server.PongTimeout = 100 * time.Millisecond
+	ts.Server.DetachGracePeriod = grace
+	return ts
+}
+
+// keepReading reads conn in the background, which makes the client answer
+// pings, and returns a channel that receives every data message.
+func keepReading(conn *websocket.Conn) <-chan string {
+	messages := make(chan string, 64)
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(msg)
+		}
+	}()
+	return messages
+}
+
+// sessionID reads messages from a v1 connection until the session message and
+// returns the ID it carries.
+func sessionID(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	op, payload := readRawFrame(t, conn)
+	require.Equal(t, msgSession, op)
+	var msg sessionPayload
+	require.NoError(t, json.Unmarshal([]byte(payload), &msg))
+	return msg.ID
+}
+
+func TestTerminalHandlerKeepalive(t *testing.T) {
+	t.Run("pings the client", func(t *testing.T) {
+		_, conn := newFakeTerminal(t, fastKeepalive(0))
+		var pings atomic.Int32
+		conn.SetPingHandler(func(string) error {
+			pings.Add(1)
+			return nil
+		})
+		keepReading(conn)
+		assert.Eventually(t, func() bool { return pings.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("a client answering pings outlives the pong timeout", func(t *testing.T) {
+		ts := fastKeepalive(0)
+		backend, conn := newFakeTerminal(t, ts)
+		proc := backend.process(t, 0)
+		keepReading(conn)
+		time.Sleep(300 * time.Millisecond)
+		assert.False(t, proc.isClosed())
+		assert.Equal(t, 1, ts.sessionCount())
+	})
+
+	t.Run("a silent client ends the session", func(t *testing.T) {
+		ts := fastKeepalive(0)
+		var proc *fakeProcess
+		logged := captureLog(func() {
+			backend, _ := newFakeTerminal(t, ts)
+			proc = backend.process(t, 0)
+			assert.Eventually(t, proc.isClosed, time.Second, 5*time.Millisecond)
+			assert.Eventually(t, func() bool { return ts.sessionCount() == 0 }, time.Second, 5*time.Millisecond)
+		})
+		assert.Contains(t, logged, "no heartbeat from client")
+	})
+
+	t.Run("a silent client detaches the session and can reattach", func(t *testing.T) {
+		ts := fastKeepalive(5 * time.Second)
+		backend, wsURL := startFakeTerminal(t, ts)
+		first := dialTerminal(t, wsURL, PROTOCOL_V1)
+		id := sessionID(t, first)
+		proc := backend.process(t, 0)
+
+		// first stops reading, so it no longer answers pings.
+		require.Eventually(t, func() bool { return isDetached(ts, id) }, 2*time.Second, 5*time.Millisecond)
+		go proc.emit("while you were away")
+
+		second := dialTerminal(t, wsURL+"?session="+id, PROTOCOL_V1)
+		op, payload := readFrame(t, second)
+		assert.Equal(t, msgOutput, op)
+		assert.Equal(t, "while you were away", payload)
+		require.NoError(t, second.WriteMessage(websocket.BinaryMessage, []byte("0ls\r")))
+		assert.Eventually(t, func() bool { return proc.inputString() == "ls\r" }, time.Second, 5*time.Millisecond)
+
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		assert.Len(t, backend.started, 1, "reattaching must not start a new process")
+		assert.False(t, proc.isClosed())
+	})
+
+	t.Run("a detached session ends when the grace period expires", func(t *testing.T) {
+		ts := fastKeepalive(100 * time.Millisecond)
+		var proc *fakeProcess
+		logged := captureLog(func() {
+			backend, _ := newFakeTerminal(t, ts)
+			proc = backend.process(t, 0)
+			assert.Eventually(t, proc.isClosed, 2*time.Second, 5*time.Millisecond)
+		})
+		assert.Contains(t, logged, "detached")
+		assert.Contains(t, logged, "was not reattached")
+		assert.Eventually(t, func() bool { return ts.sessionCount() == 0 }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("a client closing the connection does not detach", func(t *testing.T) {
+		ts := fastKeepalive(5 * time.Second)
+		backend, conn := newFakeTerminal(t, ts)
+		proc := backend.process(t, 0)
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye")
+		require.NoError(t, conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)))
+		assert.Eventually(t, proc.isClosed, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("closing the handler ends a detached session", func(t *testing.T) {
+		ts := fastKeepalive(5 * time.Second)
+		backend, wsURL := startFakeTerminal(t, ts)
+		id := sessionID(t, dialTerminal(t, wsURL, PROTOCOL_V1))
+		proc := backend.process(t, 0)
+		require.Eventually(t, func() bool { return isDetached(ts, id) }, 2*time.Second, 5*time.Millisecond)
+		ts.closeSessions()
+		assert.Eventually(t, proc.isClosed, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("an unknown session ID starts a new session", func(t *testing.T) {
+		ts := fastKeepalive(0)
+		backend, wsURL := startFakeTerminal(t, ts)
+		logged := captureLog(func() {
+			keepReading(dialTerminal(t, wsURL+"?session=missing"))
+			backend.process(t, 0)
+		})
+		assert.Contains(t, logged, "session missing cannot be reattached")
+	})
+}
+
+// isDetached reports whether the session with the given ID is waiting to be
+// reattached.
+func isDetached(ts *TerminalServer, id string) bool {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	for s := range ts.sessions {
+		if s.ID == id {
+			return s.detached
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------
+// Session limits
+// ---------------------------------------------------------------------------
+
+func TestReserveSession(t *testing.T) {
+	ts := newTestTerminalServer()
+	ts.Server.MaxSessions = 3
+	require.True(t, ts.addSession(&session{ID: "a", Profile: "dev"}))
+
+	release, err := ts.reserveSession("dev", 2)
+	require.NoError(t, err)
+	_, err = ts.reserveSession("dev", 2)
+	assert.ErrorIs(t, err, errTooManySessions)
+	assert.EqualError(t, err, "too many sessions: profile dev allows 2")
+
+	other, err := ts.reserveSession("default", 0)
+	require.NoError(t, err)
+	_, err = ts.reserveSession("default", 0)
+	assert.EqualError(t, err, "too many sessions: the server allows 3")
+
+	release()
+	release()
+	total, ofProfile := ts.sessionCounts("dev")
+	assert.Equal(t, 2, total, "releasing twice frees one place")
+	assert.Equal(t, 1, ofProfile)
+	other()
+	_, err = ts.reserveSession("dev", 2)
+	assert.NoError(t, err)
+
+	ts.Profiles["dev"] = Profile{MaxSessions: -1}
+	assert.ErrorContains(t, ts.ValidateProfiles(), "profile dev: max sessions must not be negative")
+}
+
+func TestTerminalHandlerSessionLimits(t *testing.T) {
+	t.Run("server limit", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.MaxSessions = 1
+		backend, first := newFakeTerminal(t, ts, PROTOCOL_V1)
+		sessionID(t, first)
+
+		var closeErr *websocket.CloseError
+		logs := captureLog(func() {
+			_, wsURL := startFakeTerminal(t, ts)
+			closeErr = readCloseError(t, dialTerminal(t, wsURL, PROTOCOL_V1))
+		})
+		assert.Equal(t, websocket.CloseTryAgainLater, closeErr.Code)
+		assert.Equal(t, "too many sessions: the server allows 1", closeErr.Text)
+		assert.Contains(t, logs, "session rejected (profile default): too many sessions: the server allows 1; 1 sessions active, 1 of profile default")
+		backend.process(t, 0)
+	})
+
+	t.Run("profile limit", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["default"] = Profile{MaxSessions: 1}
+		_, wsURL := startFakeTerminal(t, ts)
+		sessionID(t, dialTerminal(t, wsURL, PROTOCOL_V1))
+
+		closeErr := readCloseError(t, dialTerminal(t, wsURL, PROTOCOL_V1))
+		assert.Equal(t, websocket.CloseTryAgainLater, closeErr.Code)
+		assert.Equal(t, "too many sessions: profile default allows 1", closeErr.Text)
+
+		ts.setActiveProfileName("work")
+		sessionID(t, dialTerminal(t, wsURL, PROTOCOL_V1))
+	})
+
+	t.Run("an ended session frees its place", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.MaxSessions = 1
+		backend, wsURL := startFakeTerminal(t, ts)
+		logs := captureLog(func() {
+			conn := dialTerminal(t, wsURL, PROTOCOL_V1)
+			sessionID(t, conn)
+			backend.process(t, 0).exit()
+			readCloseError(t, conn)
+			require.Eventually(t, func() bool { return ts.sessionCount() == 0 }, 2*time.Second, 5*time.Millisecond)
+		})
+		assert.Contains(t, logs, "1 sessions active, 1 of profile default")
+		assert.Contains(t, logs, "ended (profile default); 0 sessions active, 0 of profile default")
+		sessionID(t, dialTerminal(t, wsURL, PROTOCOL_V1))
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Output batching
+// ---------------------------------------------------------------------------
+
+func TestCoalesce(t *testing.T) {
+	t.Run("without a wait only takes output that is already waiting", func(t *testing.T) {
+		output := make(chan []byte, 2)
+		output <- []byte("b")
+		output <- []byte("c")
+		batch, open := coalesce(output, []byte("a"), 0, 100)
+		assert.True(t, open)
+		assert.Equal(t, "abc", string(batch))
+
+		batch, open = coalesce(output, []byte("d"), 0, 100)
+		assert.True(t, open)
+		assert.Equal(t, "d", string(batch))
+	})
+
+	t.Run("waits for output that follows", func(t *testing.T) {
+		output := make(chan []byte)
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			output <- []byte("b")
+		}()
+		batch, open := coalesce(output, []byte("a"), time.Second/2, 2)
+		assert.True(t, open)
+		assert.Equal(t, "ab", string(batch))
+	})
+
+	t.Run("stops after the wait", func(t *testing.T) {
+		start := time.Now()
+		batch, open := coalesce(make(chan []byte), []byte("a"), 20*time.Millisecond, 100)
+		assert.True(t, open)
+		assert.Equal(t, "a", string(batch))
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	})
+
+	t.Run("stops at the size limit", func(t *testing.T) {
+		output := make(chan []byte, 3)
+		output <- []byte("bb")
+		output <- []byte("cc")
+		output <- []byte("dd")
+		batch, open := coalesce(output, []byte("aa"), time.Second, 5)
+		assert.True(t, open)
+		assert.Equal(t, "aabbcc", string(batch))
+		assert.Len(t, output, 1)
+	})
+
+	t.Run("reports closed output with the batch so far", func(t *testing.T) {
+		output := make(chan []byte, 1)
+		output <- []byte("b")
+		close(output)
+		batch, open := coalesce(output, []byte("a"), time.Second, 100)
+		assert.False(t, open)
+		assert.Equal(t, "ab", string(batch))
+	})
+}
+
+// readOutput reads legacy frames from conn until want bytes have arrived and
+// returns them with the number of frames they came in.
+func readOutput(t testing.TB, conn *websocket.Conn, want int) (string, int) {
+	t.Helper()
+	var got strings.Builder
+	frames := 0
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for got.Len() < want {
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		got.Write(msg)
+		frames++
+	}
+	return got.String(), frames
+}
+
+func TestTerminalHandlerBatching(t *testing.T) {
+	const chunks = 200
+	flood := func(proc *fakeProcess) string {
+		var all strings.Builder
+		for i := 0; i < chunks; i++ {
+			all.WriteString(fmt.Sprintf("line %03d\n", i))
+		}
+		go func() {
+			for i := 0; i < chunks; i++ {
+				proc.emit(fmt.Sprintf("line %03d\n", i))
+			}
+		}()
+		return all.String()
+	}
+
+	t.Run("a flood of small reads is sent in fewer frames", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.OutputBatchWindow = 20 * time.Millisecond
+		backend, conn := newFakeTerminal(t, ts)
+		want := flood(backend.process(t, 0))
+		got, frames := readOutput(t, conn, len(want))
+		assert.Equal(t, want, got)
+		assert.Less(t, frames, chunks/4)
+	})
+
+	t.Run("frames respect the batch size", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.OutputBatchWindow = 20 * time.Millisecond
+		ts.Server.OutputBatchSize = 45
+		backend, conn := newFakeTerminal(t, ts)
+		want := flood(backend.process(t, 0))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var got strings.Builder
+		for got.Len() < len(want) {
+			_, msg, err := conn.ReadMessage()
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(msg), 45+8, "a batch may overshoot by less than one chunk")
+			got.Write(msg)
+		}
+		assert.Equal(t, want, got.String())
+	})
+
+	t.Run("a negative window sends every read in its own frame", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.OutputBatchWindow = -1
+		backend, conn := newFakeTerminal(t, ts)
+		want := flood(backend.process(t, 0))
+		got, frames := readOutput(t, conn, len(want))
+		assert.Equal(t, want, got)
+		assert.Equal(t, chunks, frames)
+	})
+
+	t.Run("output after a quiet period is not delayed", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.OutputBatchWindow = time.Second
+		backend, conn := newFakeTerminal(t, ts)
+		go backend.process(t, 0).emit("$ ")
+		start := time.Now()
+		got, _ := readOutput(t, conn, 2)
+		assert.Equal(t, "$ ", got)
+		assert.Less(t, time.Since(start), time.Second/2)
+	})
+}
+
+// countingConn counts the bytes read from the underlying connection, so tests
+// can see how large frames were on the wire.
+type countingConn struct {
+	net.Conn
+	read atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// dialCompressed connects to wsURL offering permessage-deflate and returns
+// the connection, the negotiated extensions and a byte counter for it.
+func dialCompressed(t *testing.T, wsURL string) (*websocket.Conn, string, *countingConn) {
+	t.Helper()
+	var counter *countingConn
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			counter = &countingConn{Conn: c}
+			return counter, nil
+		},
+	}
+	conn, resp, err := dialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp.Header.Get("Sec-WebSocket-Extensions"), counter
+}
+
+func TestTerminalHandlerCompression(t *testing.T) {
+	output := strings.Repeat("drwxr-xr-x  2 user user 4096 Jan  1 00:00 dir\n", 200)
+
+	t.Run("off by default", func(t *testing.T) {
+		_, wsURL := startFakeTerminal(t, newTestTerminalServer())
+		_, extensions, _ := dialCompressed(t, wsURL)
+		assert.Empty(t, extensions)
+	})
+
+	t.Run("compresses output when enabled", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.Compression = Compression{Enabled: true}
+		backend, wsURL := startFakeTerminal(t, ts)
+		conn, extensions, counter := dialCompressed(t, wsURL)
+		assert.Contains(t, extensions, "permessage-deflate")
+
+		before := counter.read.Load()
+		go backend.process(t, 0).emit(output)
+		got, _ := readOutput(t, conn, len(output))
+		assert.Equal(t, output, got)
+		assert.Less(t, counter.read.Load()-before, int64(len(output)/4))
+	})
+
+	t.Run("frames below the threshold are not compressed", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Server.Compression = Compression{Enabled: true, Level: ref(9), Threshold: ref(2 * len(output))}
+		backend, wsURL := startFakeTerminal(t, ts)
+		conn, _, counter := dialCompressed(t, wsURL)
+
+		before := counter.read.Load()
+		go backend.process(t, 0).emit(output)
+		got, _ := readOutput(t, conn, len(output))
+		assert.Equal(t, output, got)
+		assert.GreaterOrEqual(t, counter.read.Load()-before, int64(len(output)))
+	})
+}
+
+// echoBackend starts processes that echo their input back as output, like a
+// pty in cooked mode with nothing running.
+type echoBackend struct{}
+
+func (echoBackend) Start(Profile, uint16, uint16) (Process, error) {
+	r, w := io.Pipe()
+	return &echoProcess{r: r, w: w}, nil
+}
+
+type echoProcess struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p *echoProcess) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *echoProcess) Write(b []byte) (int, error) { return p.w.Write(b) }
+func (p *echoProcess) Resize(uint16, uint16) error { return nil }
+func (p *echoProcess) Wait() error                 { return nil }
+func (p *echoProcess) Signal(os.Signal) error      { return nil }
+func (p *echoProcess) Close() error                { return p.w.Close() }
+
+// benchmarkTerminal serves an echo session with the given batch window and
+// returns a legacy WebSocket connected to it.
+func benchmarkTerminal(b *testing.B, window time.Duration) *websocket.Conn {
+	b.Helper()
+	SetLogger(log.New(io.Discard, "", 0))
+	b.Cleanup(func() { SetLogger(nil) })
+	ts := newTestTerminalServer()
+	ts.Server.OutputBatchWindow = window
+	ts.Backends = map[string]Backend{DEFAULT_BACKEND: echoBackend{}}
+	srv := httptest.NewServer(http.HandlerFunc(ts.terminalHandler))
+	b.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(b, err)
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+var benchmarkWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"unbatched", -1},
+	{"batched", DEFAULT_OUTPUT_BATCH_WINDOW},
+}
+
+// BenchmarkOutputThroughput measures how fast a program printing short lines
+// reaches the browser. Each op is one 64-byte line; frames/op shows how many
+// WebSocket frames were needed per line.
+func BenchmarkOutputThroughput(b *testing.B) {
+	line := []byte(strings.Repeat("x", 63) + "\n")
+	for _, bw := range benchmarkWindows {
+		b.Run(bw.name, func(b *testing.B) {
+			conn := benchmarkTerminal(b, bw.window)
+			b.SetBytes(int64(len(line)))
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					// Input is echoed as output, so this floods the output side.
+					if conn.WriteMessage(websocket.BinaryMessage, line) != nil {
+						return
+					}
+				}
+			}()
+			_, frames := readOutput(b, conn, b.N*len(line))
+			b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+		})
+	}
+}
+
+// BenchmarkKeystrokeLatency measures the round trip of a keystroke through
+// the server and back, reported as rtt-ns/op. Keystrokes are spaced further
+// apart than the batch window, as they are when typing, so ns/op is dominated
+// by that spacing and should be ignored.
+func BenchmarkKeystrokeLatency(b *testing.B) {
+	for _, bw := range benchmarkWindows {
+		b.Run(bw.name, func(b *testing.B) {
+			conn := benchmarkTerminal(b, bw.window)
+			var total time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				time.Sleep(2 * DEFAULT_OUTPUT_BATCH_WINDOW)
+				start := time.Now()
+				require.NoError(b, conn.WriteMessage(websocket.BinaryMessage, []byte("a")))
+				readOutput(b, conn, 1)
+				total += time.Since(start)
+			}
+			b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "rtt-ns/op")
+		})
+	}
+}
@@ -0,0 +1,566 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/sys/unix"
+)
+
+// session records a running terminal so the server can find and terminate it
+// from outside terminalHandler, for example when the Handler is closed.
+type session struct {
+	ID      string
+	Profile string
+	Started time.Time
+	// close tears the session down. It must be safe to call more than once
+	// and concurrently with terminalHandler's own cleanup.
+	close func()
+
+	// The fields below are set by newTerminalSession.
+
+	out outputConfig
+	// pending holds a chunk that was read from output but could not be
+	// delivered because the connection failed. It is sent first to the
+	// connection that reattaches.
+	pending []byte
+	// attach hands a reattaching connection to a detached session.
+	attach chan *termConn
+	// stop is closed by close.
+	stop chan struct{}
+	// killGrace is how long close gives the process and its jobs to exit
+	// after SIGHUP before killing them.
+	killGrace time.Duration
+	// notices carries notes for the client, such as a warning that the
+	// server is shutting down, which are written into the terminal output;
+	// see notify.
+	notices chan string
+	// detached is guarded by the server's sessionsMu.
+	detached bool
+	// active holds when the session last had input or output, in Unix
+	// nanoseconds; see touch.
+	active atomic.Int64
+
+	// The fields below are set by terminalHandler before the session is
+	// served.
+
+	// log adds the session's ID, profile and remote address to the
+	// messages it logs.
+	log scopedLogger
+	// metrics counts the session's traffic and failures. It is nil for a
+	// session that is not counted.
+	metrics *metrics
+
+	// commands are typed into every run of the process, each once the wait
+	// condition at the same index of waits is met; see sendCommands.
+	commands []string
+	waits    []WaitCondition
+	// policy decides what happens when the process exits.
+	policy exitPolicy
+	// spawn starts the process for the next run. It is nil when the session
+	// cannot be restarted.
+	spawn func(cols, rows uint16) (Process, error)
+
+	// restarts counts consecutive automatic restarts. It is only used by the
+	// goroutine serving the session.
+	restarts int
+	// restart receives a request to restart the process while the session
+	// waits for one.
+	restart chan struct{}
+
+	mu sync.Mutex
+	// run is the current run of the process.
+	run *run
+	// cols and rows are the terminal size, kept so a restarted process
+	// starts at the size the client last asked for.
+	cols, rows uint16
+	// between is the on-exit policy, ON_EXIT_RESTART or ON_EXIT_PROMPT, whose
+	// restart the session is waiting for while it is between runs. It is
+	// empty while the process runs.
+	between string
+	// closeCode and closeReason are sent in the close frame of the
+	// connection when the session is closed by closeWith.
+	closeCode   int
+	closeReason string
+}
+
+// run is one run of a session's process.
+type run struct {
+	proc    Process
+	started time.Time
+	// output carries chunks read from proc. It is closed once proc stops
+	// producing output. Nothing reads from it while the session is detached,
+	// which stalls the reader and lets the pty apply backpressure to the
+	// process.
+	output <-chan []byte
+	// watch follows the output for sendCommands.
+	watch *outputWatch
+
+	// waitOnce guards the single call to proc.Wait made by wait. waited is
+	// closed once it returns, after waitErr and ended have been set.
+	waitOnce sync.Once
+	waited   chan struct{}
+	waitErr  error
+	ended    time.Time
+
+	// next is what happens after the run, decided once by the goroutine
+	// serving the session; see session.decide.
+	decided bool
+	next    restartDecision
+}
+
+// newTerminalSession wraps proc in a session and starts reading its output.
+// The session is not registered with the server; see addSession. Closing the
+// session terminates the process, giving it killGrace to exit after SIGHUP.
+func newTerminalSession(id, profile string, proc Process, out outputConfig, killGrace time.Duration) *session {
+	s := &session{
+		ID:        id,
+		Profile:   profile,
+		Started:   time.Now(),
+		out:       out,
+		attach:    make(chan *termConn, 1),
+		stop:      make(chan struct{}),
+		killGrace: killGrace,
+		notices:   make(chan string, MAX_SESSION_NOTICES),
+		restart:   make(chan struct{}, 1),
+	}
+	// Concurrent callers block in Do until the process has been terminated.
+	var once sync.Once
+	s.close = func() {
+		once.Do(func() {
+			close(s.stop)
+			s.current().terminate(s.killGrace)
+		})
+	}
+	s.run = s.newRun(proc)
+	s.touch()
+	return s
+}
+
+// closeWith closes the session, telling the client why with a close frame of
+// the given code and reason. Only the first reason given is sent.
+func (s *session) closeWith(code int, reason string) {
+	s.mu.Lock()
+	if s.closeCode == 0 {
+		s.closeCode, s.closeReason = code, reason
+	}
+	s.mu.Unlock()
+	s.close()
+}
+
+// notify queues note to be written into the terminal output for the client.
+// The note is dropped if too many are already queued.
+func (s *session) notify(note string) {
+	select {
+	case s.notices <- note:
+	default:
+		s.log.Warnf("session %s: notice dropped: %s", s.ID, note)
+	}
+}
+
+// writeCloseReason sends the close frame requested by closeWith, if any, on
+// conn.
+func (s *session) writeCloseReason(conn *termConn) {
+	s.mu.Lock()
+	code, reason := s.closeCode, s.closeReason
+	s.mu.Unlock()
+	if code != 0 {
+		_ = conn.writeClose(code, reason)
+	}
+}
+
+// newRun starts reading the output of proc.
+func (s *session) newRun(proc Process) *run {
+	watch := newOutputWatch()
+	return &run{
+		proc:    proc,
+		started: time.Now(),
+		output:  pumpOutput(proc, s.out.bufferSize, s.stop, watch),
+		watch:   watch,
+		waited:  make(chan struct{}),
+	}
+}
+
+// current returns the current run of the session's process.
+func (s *session) current() *run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run
+}
+
+// respawn replaces the finished run with a new run of proc. It fails, closing
+// proc, when the session has been closed.
+func (s *session) respawn(proc Process) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+		r := &run{proc: proc, waited: make(chan struct{})}
+		go r.terminate(s.killGrace)
+		return errSessionClosed
+	default:
+	}
+	s.run = s.newRun(proc)
+	s.between = ""
+	return nil
+}
+
+var errSessionClosed = errors.New("session closed")
+
+// size returns the terminal size last requested by the client.
+func (s *session) size() (cols, rows uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cols, s.rows
+}
+
+// resize changes the size of the terminal and remembers it for later runs.
+// Between runs the size is only remembered.
+func (s *session) resize(cols, rows uint16) error {
+	s.mu.Lock()
+	s.cols, s.rows = cols, rows
+	r, between := s.run, s.between
+	s.mu.Unlock()
+	if between != "" {
+		return nil
+	}
+	return r.proc.Resize(cols, rows)
+}
+
+// setBetween records the restart the session is waiting for.
+func (s *session) setBetween(mode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.between = mode
+}
+
+// inputTarget returns the process that client input is delivered to. Between
+// runs there is none, and waiting reports the restart being waited for.
+func (s *session) inputTarget() (proc Process, waiting string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.between != "" {
+		return nil, s.between
+	}
+	return s.run.proc, ""
+}
+
+// pumpOutput reads proc into a buffer of bufferSize bytes until it fails and
+// sends a copy of each chunk on the returned channel, which is closed when
+// reading stops. Each chunk is shown to watch as it is read. Sends are
+// abandoned once stop is closed so the goroutine never outlives its session.
+func pumpOutput(proc Process, bufferSize int, stop <-chan struct{}, watch *outputWatch) <-chan []byte {
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer watch.close()
+		buf := make([]byte, bufferSize)
+		for {
+			n, err := proc.Read(buf)
+			Debugf("bytes read from buffer: %d", n)
+			if n > 0 {
+				watch.observe(buf[:n])
+				select {
+				case out <- append([]byte(nil), buf[:n]...):
+				case <-stop:
+					return
+				}
+			}
+			if err != nil {
+				switch {
+				case errors.Is(err, io.EOF), errors.Is(err, syscall.EIO):
+					// Linux reports EIO once every process holding the
+					// pty open has exited.
+					Info("terminal session closed")
+				default:
+					Errorf("pty read: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// wait waits for the run's process to exit and returns the error from
+// Process.Wait. The process is only waited for once; later calls return the
+// same result.
+func (r *run) wait() error {
+	r.waitOnce.Do(func() {
+		r.waitErr = r.proc.Wait()
+		r.ended = time.Now()
+		close(r.waited)
+	})
+	return r.waitErr
+}
+
+// terminate ends the run's process together with every job it started. They
+// are sent SIGHUP, as when a terminal hangs up, and the pty is closed. Any
+// still running after grace are sent SIGKILL. terminate returns once the
+// process has been reaped, or EXIT_WAIT_TIMEOUT after SIGKILL if it never is.
+// Jobs that outlived the process were reparented to b3tty, as their
+// subreaper or as PID 1, and are reaped as well.
+func (r *run) terminate(grace time.Duration) {
+	group, _ := r.proc.(processGroup)
+	signal := func(sig syscall.Signal) error {
+		if group != nil {
+			return group.signalGroup(sig)
+		}
+		return r.proc.Signal(sig)
+	}
+	if group != nil {
+		defer group.reapGroup()
+	}
+	_ = signal(syscall.SIGHUP) // Best effort.
+	_ = r.proc.Close()
+	go r.wait()
+	if r.exited(group, grace) {
+		return
+	}
+	Warnf("process did not exit within %s of SIGHUP, sending SIGKILL", grace)
+	_ = signal(syscall.SIGKILL)
+	if !r.exited(group, EXIT_WAIT_TIMEOUT) {
+		Errorf("process did not exit within %s of SIGKILL", EXIT_WAIT_TIMEOUT)
+	}
+}
+
+// exited waits up to timeout for the run's process to be reaped and, when it
+// leads a process group, for the rest of the group to exit. It reports
+// whether they did.
+func (r *run) exited(group processGroup, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case <-r.waited:
+	case <-deadline.C:
+		return false
+	}
+	if group == nil {
+		return true
+	}
+	poll := time.NewTicker(KILL_POLL_INTERVAL)
+	defer poll.Stop()
+	for group.signalGroup(0) == nil {
+		select {
+		case <-poll.C:
+		case <-deadline.C:
+			return false
+		}
+	}
+	return true
+}
+
+// exitStatus builds the msgExit payload for a process that ran for d and whose
+// Wait returned err. A process killed by a signal gets the shell's exit code
+// for it, 128 plus the signal number. Errors that do not describe an exit
+// status give a code of -1.
+func exitStatus(err error, d time.Duration) exitPayload {
+	status := exitPayload{DurationMs: d.Milliseconds()}
+	if err == nil {
+		return status
+	}
+	var sys interface{ Sys() any }
+	if errors.As(err, &sys) {
+		if ws, ok := sys.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			status.Code = 128 + int(ws.Signal())
+			status.Signal = unix.SignalName(ws.Signal())
+			return status
+		}
+	}
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		status.Code = coded.ExitCode()
+		return status
+	}
+	status.Code = -1
+	return status
+}
+
+// addSession registers s as active. It returns false without registering s
+// when the server has been closed, in which case the caller must not start
+// serving the session.
+func (ts *TerminalServer) addSession(s *session) bool {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	if ts.closed {
+		return false
+	}
+	if ts.sessions == nil {
+		ts.sessions = make(map[*session]struct{})
+	}
+	ts.sessions[s] = struct{}{}
+	return true
+}
+
+// errTooManySessions is wrapped by the errors reserveSession returns when a
+// session limit has been reached.
+var errTooManySessions = errors.New("too many sessions")
+
+// reserveSession claims a place for a new session of the named profile
+// within Server.MaxSessions and maxSessions, the profile's own limit. The
+// place is held until release is called, which the caller must do once the
+// session has been registered with addSession or has failed to start. It
+// fails, wrapping errTooManySessions, when either limit has been reached.
+func (ts *TerminalServer) reserveSession(profile string, maxSessions int) (release func(), err error) {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	total, ofProfile := ts.countSessions(profile)
+	if limit := ts.Server.MaxSessions; limit > 0 && total >= limit {
+		return nil, fmt.Errorf("%w: the server allows %d", errTooManySessions, limit)
+	}
+	if maxSessions > 0 && ofProfile >= maxSessions {
+		return nil, fmt.Errorf("%w: profile %s allows %d", errTooManySessions, profile, maxSessions)
+	}
+	if ts.starting == nil {
+		ts.starting = make(map[string]int)
+	}
+	ts.starting[profile]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ts.sessionsMu.Lock()
+			defer ts.sessionsMu.Unlock()
+			if ts.starting[profile]--; ts.starting[profile] == 0 {
+				delete(ts.starting, profile)
+			}
+		})
+	}, nil
+}
+
+// countSessions returns the number of sessions, registered or starting, in
+// total and of the named profile. ts.sessionsMu must be held.
+func (ts *TerminalServer) countSessions(profile string) (total, ofProfile int) {
+	for s := range ts.sessions {
+		total++
+		if s.Profile == profile {
+			ofProfile++
+		}
+	}
+	for name, n := range ts.starting {
+		total += n
+		if name == profile {
+			ofProfile += n
+		}
+	}
+	return total, ofProfile
+}
+
+// sessionCounts is countSessions for callers not holding ts.sessionsMu.
+func (ts *TerminalServer) sessionCounts(profile string) (total, ofProfile int) {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	return ts.countSessions(profile)
+}
+
+// removeSession unregisters s. Removing an unknown session is a no-op.
+func (ts *TerminalServer) removeSession(s *session) {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	delete(ts.sessions, s)
+}
+
+// sessionCount returns the number of active sessions.
+func (ts *TerminalServer) sessionCount() int {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	return len(ts.sessions)
+}
+
+// closeSessions marks the server closed so no new sessions are accepted, then
+// tears down every active session, telling connected clients that the server
+// is going away. It returns once all their processes have been terminated.
+func (ts *TerminalServer) closeSessions() {
+	active := ts.closeServer()
+	var wg sync.WaitGroup
+	for _, s := range active {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Debugf("closing session %s (profile %s)", s.ID, s.Profile)
+			s.closeWith(websocket.CloseGoingAway, "server shutting down")
+		}()
+	}
+	wg.Wait()
+}
+
+// drainSessions marks the server closed so no new sessions are accepted and
+// tells every active session that it will be closed when ctx is done. It
+// returns once no session is left or ctx is done, whichever comes first.
+func (ts *TerminalServer) drainSessions(ctx context.Context) {
+	active := ts.closeServer()
+	if len(active) == 0 || ctx.Err() != nil {
+		return
+	}
+	var left time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		left = time.Until(deadline).Round(time.Second)
+	}
+	Infof("waiting up to %s for %d terminal sessions to end", left, len(active))
+	note := "server shutting down"
+	if left > 0 {
+		note = fmt.Sprintf("server shutting down; this session will be closed in %s", left)
+	}
+	for _, s := range active {
+		s.notify(note)
+	}
+	poll := time.NewTicker(DRAIN_POLL_INTERVAL)
+	defer poll.Stop()
+	for ts.sessionCount() > 0 {
+		select {
+		case <-poll.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// closeServer marks the server closed and returns the active sessions.
+func (ts *TerminalServer) closeServer() []*session {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	ts.closed = true
+	active := make([]*session, 0, len(ts.sessions))
+	for s := range ts.sessions {
+		active = append(active, s)
+	}
+	return active
+}
+
+// setDetached marks s as detached or not and returns the previous value.
+func (ts *TerminalServer) setDetached(s *session, detached bool) bool {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	prev := s.detached
+	s.detached = detached
+	return prev
+}
+
+// claimDetachedSession returns the detached session with the given ID and
+// marks it attached, or returns nil if there is no such session. Only one
+// caller can claim a session, and it must then send its connection on the
+// session's attach channel.
+func (ts *TerminalServer) claimDetachedSession(id string) *session {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	for s := range ts.sessions {
+		if s.ID == id && s.detached {
+			s.detached = false
+			return s
+		}
+	}
+	return nil
+}
+
+// isClosed reports whether closeSessions has been called.
+func (ts *TerminalServer) isClosed() bool {
+	ts.sessionsMu.Lock()
+	defer ts.sessionsMu.Unlock()
+	return ts.closed
+}
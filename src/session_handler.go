@@ -0,0 +1,87 @@
+package src
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// sessionsHandler lists the active terminal sessions with the resources
+// their processes are using, and counts them against the session limits. It
+// requires the access token, like the terminal page.
+// GET /sessions?token=<token>
+func (ts *TerminalServer) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		Warnf("%s %s: method not allowed", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !validateToken(r.URL.Query().Get("token"), ts.Token) {
+		Warnf("%s %s: forbidden: invalid token", r.Method, r.URL.Path)
+		ts.audit(r, auditEvent{Event: auditAuthFailure, Path: r.URL.Path, Detail: "invalid token"})
+		ts.metrics.authFailure()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	resp := ts.listSessions()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		Errorf("sessions response error: %v", err)
+	}
+}
+
+// listSessions describes the active sessions, oldest first, and counts them
+// overall and by profile.
+func (ts *TerminalServer) listSessions() sessionsResponse {
+	resp := sessionsResponse{
+		MaxSessions: ts.Server.MaxSessions,
+		Profiles:    make(map[string]sessionCount),
+	}
+	for name, p := range ts.profilesSnapshot() {
+		if p.MaxSessions > 0 {
+			resp.Profiles[name] = sessionCount{MaxSessions: p.MaxSessions}
+		}
+	}
+
+	ts.sessionsMu.Lock()
+	active := make([]*session, 0, len(ts.sessions))
+	resp.Sessions = make([]sessionResponse, 0, len(ts.sessions))
+	for s := range ts.sessions {
+		active = append(active, s)
+		resp.Sessions = append(resp.Sessions, sessionResponse{ID: s.ID, Profile: s.Profile, Started: s.Started, Detached: s.detached})
+		count := resp.Profiles[s.Profile]
+		count.Active++
+		resp.Profiles[s.Profile] = count
+	}
+	ts.sessionsMu.Unlock()
+	resp.Active = len(active)
+
+	// Reading usage can scan /proc, so it is done without holding the lock.
+	for i, s := range active {
+		if r := s.current(); r != nil {
+			resp.Sessions[i].Usage = r.usage()
+		}
+	}
+	sort.Slice(resp.Sessions, func(i, j int) bool { return resp.Sessions[i].Started.Before(resp.Sessions[j].Started) })
+	return resp
+}
+
+// usage returns the resources used by r's process and its jobs, or nil when
+// the process has exited or cannot report them.
+func (r *run) usage() *ResourceUsage {
+	select {
+	case <-r.waited:
+		return nil
+	default:
+	}
+	reporter, ok := r.proc.(usageReporter)
+	if !ok {
+		return nil
+	}
+	u, err := reporter.usage()
+	if err != nil {
+		Debugf("resource usage unavailable: %v", err)
+		return nil
+	}
+	return &u
+}
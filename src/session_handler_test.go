@@ -0,0 +1,100 @@
+package src
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// usageProcess is a fakeProcess that reports fixed resource usage.
+type usageProcess struct {
+	*fakeProcess
+	used ResourceUsage
+}
+
+func (p usageProcess) usage() (ResourceUsage, error) { return p.used, nil }
+
+func TestSessionsHandler(t *testing.T) {
+	newTS := func(t *testing.T) *TerminalServer {
+		ts := newTestTerminalServer()
+		proc, err := (&fakeBackend{}).Start(Profile{}, 80, 24)
+		require.NoError(t, err)
+		used := ResourceUsage{CPUSeconds: 1.5, MemoryBytes: 4096, Processes: 2, Source: "proc"}
+		started := time.Date(2026, 3, 15, 2, 10, 9, 0, time.UTC)
+		require.True(t, ts.addSession(&session{
+			ID:      "newer",
+			Profile: "dev",
+			Started: started.Add(time.Minute),
+			run:     &run{proc: usageProcess{proc.(*fakeProcess), used}, waited: make(chan struct{})},
+		}))
+		ended := &run{proc: usageProcess{proc.(*fakeProcess), used}, waited: make(chan struct{})}
+		close(ended.waited)
+		require.True(t, ts.addSession(&session{ID: "older", Profile: "default", Started: started, run: ended, detached: true}))
+		return ts
+	}
+
+	t.Run("lists sessions oldest first with their usage", func(t *testing.T) {
+		ts := newTS(t)
+		ts.Server.MaxSessions = 10
+		ts.Profiles["dev"] = Profile{MaxSessions: 3}
+		ts.Profiles["ops"] = Profile{MaxSessions: 1}
+		req := httptest.NewRequest(http.MethodGet, "/sessions?token=test-token-1234", nil)
+		w := httptest.NewRecorder()
+		ts.sessionsHandler(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var got struct {
+			Active      int                       `json:"active"`
+			MaxSessions int                       `json:"maxSessions"`
+			Profiles    map[string]map[string]int `json:"profiles"`
+			Sessions    []map[string]any          `json:"sessions"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, 2, got.Active)
+		assert.Equal(t, 10, got.MaxSessions)
+		assert.Equal(t, map[string]map[string]int{
+			"default": {"active": 1, "maxSessions": 0},
+			"dev":     {"active": 1, "maxSessions": 3},
+			"ops":     {"active": 0, "maxSessions": 1},
+		}, got.Profiles)
+		require.Len(t, got.Sessions, 2)
+		assert.Equal(t, map[string]any{
+			"id": "older", "profile": "default", "started": "2026-03-15T02:10:09Z", "detached": true,
+		}, got.Sessions[0], "an exited process reports no usage")
+		assert.Equal(t, "newer", got.Sessions[1]["id"])
+		assert.Equal(t, map[string]any{"cpuSeconds": 1.5, "memoryBytes": 4096.0, "processes": 2.0, "source": "proc"}, got.Sessions[1]["usage"])
+	})
+
+	t.Run("no sessions is an empty list", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		w := httptest.NewRecorder()
+		ts.sessionsHandler(w, httptest.NewRequest(http.MethodGet, "/sessions?token=test-token-1234", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"active": 0, "maxSessions": 0, "profiles": {}, "sessions": []}`, w.Body.String())
+	})
+
+	t.Run("wrong token returns 403", func(t *testing.T) {
+		ts := newTS(t)
+		w := httptest.NewRecorder()
+		logged := captureLog(func() {
+			ts.sessionsHandler(w, httptest.NewRequest(http.MethodGet, "/sessions?token=guess", nil))
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, logged, "forbidden: invalid token")
+	})
+
+	t.Run("POST is rejected with 405", func(t *testing.T) {
+		ts := newTS(t)
+		w := httptest.NewRecorder()
+		captureLog(func() {
+			ts.sessionsHandler(w, httptest.NewRequest(http.MethodPost, "/sessions?token=test-token-1234", nil))
+		})
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
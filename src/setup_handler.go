@@ -14,16 +14,20 @@ var setupTempl string
 //go:embed templates/theme-select.tmpl
 var themeSelectTempl string
 
+// setupTemplate and themeSelectTemplate are parsed once at startup. They panic
+// on error since the templates are embedded at compile time and must always be
+// valid.
+var (
+	setupTemplate       = template.Must(template.New("setup").Parse(setupTempl))
+	themeSelectTemplate = template.Must(template.New("theme-select").Parse(themeSelectTempl))
+)
+
 // renderSetupPage renders the theme selection setup page.
 func (ts *TerminalServer) renderSetupPage(w http.ResponseWriter) {
 	csp := GetCSPHeaders()
 	w.Header().Set("Content-Security-Policy", csp.String())
 
-	tmpl, err := template.New("setup").Parse(setupTempl)
-	if err != nil {
-		Fatal(err)
-	}
-	if err = tmpl.Execute(w, nil); err != nil {
+	if err := setupTemplate.Execute(w, nil); err != nil {
 		Errorf("setup response error: %v", err)
 	}
 }
@@ -39,22 +43,20 @@ func (ts *TerminalServer) themeSelectHandler(w http.ResponseWriter, r *http.Requ
 	query := r.URL.Query()
 	if !validateToken(query.Get("token"), ts.Token) {
 		Warnf("%s %s: forbidden: invalid or missing token", r.Method, r.URL.Path)
+		ts.audit(r, auditEvent{Event: auditAuthFailure, Path: r.URL.Path, Detail: "invalid or missing token"})
+		ts.metrics.authFailure()
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
-	if ts.FirstRun {
+	if ts.isFirstRun() {
 		http.NotFound(w, r)
 		return
 	}
 
 	w.Header().Set("Content-Security-Policy", GetCSPHeaders().String())
 
-	tmpl, err := template.New("theme-select").Parse(themeSelectTempl)
-	if err != nil {
-		Fatal(err)
-	}
 	Debug("loading theme-select over-panel")
-	if err = tmpl.Execute(w, nil); err != nil {
+	if err := themeSelectTemplate.Execute(w, nil); err != nil {
 		Errorf("theme-select response error: %v", err)
 	}
 }
@@ -63,7 +65,7 @@ func (ts *TerminalServer) themeSelectHandler(w http.ResponseWriter, r *http.Requ
 // field ("b3tty-dark", "b3tty-light", or "skip"). For b3tty-dark/b3tty-light, it writes a default config
 // file to $HOME/.config/b3tty/conf.yaml. Sets firstRun to false on success.
 func (ts *TerminalServer) saveConfigHandler(w http.ResponseWriter, r *http.Request) {
-	if !ts.FirstRun {
+	if !ts.isFirstRun() {
 		http.NotFound(w, r)
 		return
 	}
@@ -102,17 +104,11 @@ func (ts *TerminalServer) saveConfigHandler(w http.ResponseWriter, r *http.Reque
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		ts.Client.Theme.MapToTheme(themeColors)
-		// Register the selected theme in ts.Themes so it appears in the Themes
-		// menu after the browser reloads into the normal terminal flow.
-		if ts.Themes == nil {
-			ts.Themes = make(map[string]Theme)
-		}
-		ts.Themes[req.Theme] = ts.Client.Theme
-		ts.ActiveTheme = req.Theme
 		Infof("created default %s theme config", req.Theme)
 	}
 
-	ts.FirstRun = false
+	// Register the selected theme in ts.Themes so it appears in the Themes
+	// menu after the browser reloads into the normal terminal flow.
+	ts.completeFirstRun(req.Theme, themeColors)
 	w.WriteHeader(http.StatusOK)
 }
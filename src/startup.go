@@ -0,0 +1,379 @@
+package src
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WaitCondition describes when the shell is ready for a profile's next
+// startup command.
+type WaitCondition struct {
+	// Until is what to wait for. WAIT_PROMPT waits for the shell to mark a
+	// new prompt with OSC 133, as shells with terminal integration do.
+	// WAIT_PATTERN waits for output matching Pattern. WAIT_QUIET waits for
+	// the shell to print something and then fall silent for Quiet. WAIT_AUTO
+	// waits for a prompt or a quiet period, whichever comes first, and
+	// WAIT_NONE does not wait. An empty value selects WAIT_AUTO.
+	Until string
+	// Pattern is the regular expression WAIT_PATTERN waits for. It is
+	// matched against the output printed since the previous command was
+	// sent, escape sequences included.
+	Pattern string
+	// Quiet is the quiet period of WAIT_QUIET and WAIT_AUTO. Zero selects
+	// DEFAULT_COMMAND_QUIET.
+	Quiet time.Duration
+	// Timeout is how long to wait before giving up and sending no further
+	// commands. Zero selects DEFAULT_COMMAND_TIMEOUT.
+	Timeout time.Duration
+}
+
+// or returns w with each unset field taken from def.
+func (w WaitCondition) or(def WaitCondition) WaitCondition {
+	if w.Until == "" {
+		w.Until = def.Until
+	}
+	if w.Pattern == "" {
+		w.Pattern = def.Pattern
+	}
+	if w.Quiet == 0 {
+		w.Quiet = def.Quiet
+	}
+	if w.Timeout == 0 {
+		w.Timeout = def.Timeout
+	}
+	return w
+}
+
+// validate reports an error when w has an unknown Until, a missing or
+// malformed Pattern, or a negative duration.
+func (w WaitCondition) validate() error {
+	switch w.Until {
+	case "", WAIT_AUTO, WAIT_PROMPT, WAIT_QUIET, WAIT_NONE:
+	case WAIT_PATTERN:
+		if w.Pattern == "" {
+			return fmt.Errorf("wait until %s needs a pattern", WAIT_PATTERN)
+		}
+	default:
+		return fmt.Errorf("unknown wait condition %q", w.Until)
+	}
+	if w.Pattern != "" {
+		if _, err := regexp.Compile(w.Pattern); err != nil {
+			return fmt.Errorf("wait pattern: %w", err)
+		}
+	}
+	if w.Quiet < 0 || w.Timeout < 0 {
+		return fmt.Errorf("wait durations must not be negative")
+	}
+	return nil
+}
+
+// commandWait returns the effective wait condition for the command at index
+// i of p.Commands: the command's own condition, then the profile's
+// CommandWait, then the defaults.
+func (p Profile) commandWait(i int) WaitCondition {
+	var w WaitCondition
+	if i < len(p.CommandWaits) {
+		w = p.CommandWaits[i]
+	}
+	return w.or(p.CommandWait).or(WaitCondition{
+		Until:   WAIT_AUTO,
+		Quiet:   DEFAULT_COMMAND_QUIET,
+		Timeout: DEFAULT_COMMAND_TIMEOUT,
+	})
+}
+
+// validateCommandWaits reports an error describing the first invalid wait
+// condition of p.
+func (p Profile) validateCommandWaits() error {
+	if err := p.CommandWait.validate(); err != nil {
+		return fmt.Errorf("command-wait: %w", err)
+	}
+	for i, w := range p.CommandWaits {
+		if err := w.or(p.CommandWait).validate(); err != nil {
+			return fmt.Errorf("command %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// matchCommandWaits returns the wait conditions for commands, a new list of
+// startup commands for p, keeping the condition of each command that p
+// already has. The browser profile editor only edits the command strings, so
+// this stops an edit from losing the conditions set in the config file.
+func (p Profile) matchCommandWaits(commands []string) []WaitCondition {
+	var waits []WaitCondition
+	for i, command := range commands {
+		j := slices.Index(p.Commands, command)
+		if j < 0 || j >= len(p.CommandWaits) || p.CommandWaits[j] == (WaitCondition{}) {
+			continue
+		}
+		if waits == nil {
+			waits = make([]WaitCondition, len(commands))
+		}
+		waits[i] = p.CommandWaits[j]
+	}
+	return waits
+}
+
+// ParseStartupCommands converts a profile's commands setting, as decoded from
+// YAML, into the commands to type and their wait conditions. Each entry is
+// either a command string or a map with the command under "run" and any of
+// the keys "until", "pattern", "quiet" and "timeout". The returned waits are
+// nil unless some entry is a map.
+func ParseStartupCommands(raw []any) ([]string, []WaitCondition, error) {
+	commands := make([]string, 0, len(raw))
+	var waits []WaitCondition
+	for i, entry := range raw {
+		switch entry := entry.(type) {
+		case string:
+			commands = append(commands, entry)
+		case map[string]any:
+			command, w, err := parseCommandEntry(entry)
+			if err != nil {
+				return nil, nil, fmt.Errorf("command %d: %w", i+1, err)
+			}
+			if waits == nil {
+				waits = make([]WaitCondition, len(raw))
+			}
+			commands = append(commands, command)
+			waits[i] = w
+		default:
+			return nil, nil, fmt.Errorf("command %d: expected a string or a map, got %T", i+1, entry)
+		}
+	}
+	return commands, waits, nil
+}
+
+// parseCommandEntry converts one map entry of a commands setting.
+func parseCommandEntry(entry map[string]any) (string, WaitCondition, error) {
+	var w WaitCondition
+	command, ok := entry["run"].(string)
+	if !ok {
+		return "", w, fmt.Errorf("run must be a command string")
+	}
+	w.Until, _ = entry["until"].(string)
+	w.Pattern, _ = entry["pattern"].(string)
+	for key, d := range map[string]*time.Duration{"quiet": &w.Quiet, "timeout": &w.Timeout} {
+		value, ok := entry[key]
+		if !ok {
+			continue
+		}
+		s, _ := value.(string)
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return "", w, fmt.Errorf("%s: invalid duration %v", key, value)
+		}
+		*d = parsed
+	}
+	return command, w, nil
+}
+
+// errNotReady is wrapped by the errors outputWatch.wait returns when the
+// shell did not become ready.
+var errNotReady = errors.New("shell not ready")
+
+// oscPrompt starts the OSC 133 sequences that shells with terminal
+// integration use to mark prompts and command results.
+var oscPrompt = []byte("\x1b]133;")
+
+// outputWatch follows the output of a run of a session's process so that
+// startup commands can be sent once the shell is ready for them. It sees the
+// output as it is read from the process, whether or not a client is
+// connected to receive it.
+type outputWatch struct {
+	mu sync.Mutex
+	// buf holds the output since the last mark, up to MAX_WATCH_BUFFER bytes
+	// of it. scanned is the offset in buf up to which OSC 133 sequences have
+	// been looked for.
+	buf     []byte
+	scanned int
+	// seen counts the bytes of output since the last mark.
+	seen int
+	// prompts counts the prompts marked since the last mark, and status is
+	// the exit status reported for the last command, or nil if none was.
+	prompts int
+	status  *int
+	// last is when output last arrived.
+	last time.Time
+	// changed is closed and replaced whenever output arrives or the watch
+	// is closed.
+	changed chan struct{}
+	closed  bool
+}
+
+func newOutputWatch() *outputWatch {
+	return &outputWatch{changed: make(chan struct{})}
+}
+
+// observe records a chunk of output.
+func (w *outputWatch) observe(p []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	w.seen += len(p)
+	w.last = time.Now()
+	w.scan()
+	if over := len(w.buf) - MAX_WATCH_BUFFER; over > 0 {
+		w.buf = append(w.buf[:0], w.buf[over:]...)
+		w.scanned = max(w.scanned-over, 0)
+	}
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// scan processes the complete OSC 133 sequences in buf after scanned. A
+// sequence cut off by the end of buf is left for the next call.
+func (w *outputWatch) scan() {
+	for {
+		i := bytes.Index(w.buf[w.scanned:], oscPrompt)
+		if i < 0 {
+			w.scanned = max(w.scanned, len(w.buf)-len(oscPrompt)+1)
+			return
+		}
+		start := w.scanned + i + len(oscPrompt)
+		body, end, ok := oscBody(w.buf[start:])
+		if !ok {
+			w.scanned = start - len(oscPrompt)
+			return
+		}
+		w.scanned = start + end
+		switch {
+		case bytes.HasPrefix(body, []byte("A")):
+			w.prompts++
+		case bytes.HasPrefix(body, []byte("D")):
+			// D;<status> reports how the last command exited.
+			fields := bytes.Split(body, []byte(";"))
+			if len(fields) > 1 {
+				if status, err := strconv.Atoi(string(fields[1])); err == nil {
+					w.status = &status
+				}
+			}
+		}
+	}
+}
+
+// oscBody returns the body of an OSC sequence that p starts inside of and the
+// length of the body and its BEL or ST terminator. It reports false when p
+// ends before the terminator.
+func oscBody(p []byte) (body []byte, n int, ok bool) {
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '\a':
+			return p[:i], i + 1, true
+		case p[i] == '\x1b' && i+1 < len(p) && p[i+1] == '\\':
+			return p[:i], i + 2, true
+		}
+	}
+	return nil, 0, false
+}
+
+// mark forgets the output seen so far, so that wait only considers output
+// that follows, such as the echo and output of a command just sent.
+func (w *outputWatch) mark() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = w.buf[:0]
+	w.scanned = 0
+	w.seen = 0
+	w.prompts = 0
+	w.status = nil
+}
+
+// close reports that the process will produce no more output.
+func (w *outputWatch) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.changed)
+	}
+}
+
+// wait blocks until the output since the last mark meets cond. It fails when
+// the previous command reported a non-zero exit status, when cond is not met
+// within its timeout, or when the process ends first. It returns
+// errSessionClosed once stop is closed.
+func (w *outputWatch) wait(cond WaitCondition, stop <-chan struct{}) error {
+	if cond.Until == WAIT_NONE {
+		return nil
+	}
+	var pattern *regexp.Regexp
+	if cond.Until == WAIT_PATTERN {
+		var err error
+		if pattern, err = regexp.Compile(cond.Pattern); err != nil {
+			return err
+		}
+	}
+	timeout := time.NewTimer(cond.Timeout)
+	defer timeout.Stop()
+	for {
+		w.mu.Lock()
+		if w.status != nil && *w.status != 0 {
+			status := *w.status
+			w.mu.Unlock()
+			return fmt.Errorf("previous command exited with status %d", status)
+		}
+		prompted := w.prompts > 0
+		matched := pattern != nil && pattern.Match(w.buf)
+		quietFor := cond.Quiet - time.Since(w.last)
+		if w.seen == 0 {
+			quietFor = cond.Quiet
+		}
+		output, changed, closed := w.seen > 0, w.changed, w.closed
+		w.mu.Unlock()
+
+		switch cond.Until {
+		case WAIT_PROMPT:
+			if prompted {
+				return nil
+			}
+		case WAIT_PATTERN:
+			if matched {
+				return nil
+			}
+		case WAIT_QUIET:
+			if output && quietFor <= 0 {
+				return nil
+			}
+		default:
+			if prompted || output && quietFor <= 0 {
+				return nil
+			}
+		}
+		if closed {
+			return fmt.Errorf("%w: the process exited", errNotReady)
+		}
+
+		var quiet <-chan time.Time
+		if output && (cond.Until == WAIT_QUIET || cond.Until == WAIT_AUTO) {
+			quiet = time.After(quietFor)
+		}
+		select {
+		case <-changed:
+		case <-quiet:
+		case <-timeout.C:
+			return fmt.Errorf("%w after %s waiting for %s", errNotReady, cond.Timeout, describeWait(cond))
+		case <-stop:
+			return errSessionClosed
+		}
+	}
+}
+
+// describeWait describes what cond waits for, for error messages.
+func describeWait(cond WaitCondition) string {
+	switch cond.Until {
+	case WAIT_PROMPT:
+		return "a prompt"
+	case WAIT_PATTERN:
+		return fmt.Sprintf("output matching %q", cond.Pattern)
+	case WAIT_QUIET:
+		return fmt.Sprintf("output followed by %s of quiet", cond.Quiet)
+	}
+	return fmt.Sprintf("a prompt or %s of quiet", cond.Quiet)
+}
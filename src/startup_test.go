@@ -0,0 +1,264 @@
+package src
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitConditionValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		cond   WaitCondition
+		errMsg string
+	}{
+		{name: "zero value"},
+		{name: "prompt", cond: WaitCondition{Until: WAIT_PROMPT, Timeout: time.Minute}},
+		{name: "pattern", cond: WaitCondition{Until: WAIT_PATTERN, Pattern: `\$ $`}},
+		{name: "unknown", cond: WaitCondition{Until: "forever"}, errMsg: `unknown wait condition "forever"`},
+		{name: "pattern missing", cond: WaitCondition{Until: WAIT_PATTERN}, errMsg: "needs a pattern"},
+		{name: "bad pattern", cond: WaitCondition{Until: WAIT_PATTERN, Pattern: "("}, errMsg: "wait pattern"},
+		{name: "negative quiet", cond: WaitCondition{Quiet: -time.Second}, errMsg: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cond.validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestProfileCommandWait(t *testing.T) {
+	p := Profile{
+		Commands:     []string{"a", "b", "c"},
+		CommandWait:  WaitCondition{Until: WAIT_QUIET, Timeout: time.Minute},
+		CommandWaits: []WaitCondition{{Until: WAIT_PATTERN, Pattern: "x"}, {Quiet: time.Second}},
+	}
+	assert.Equal(t, WaitCondition{Until: WAIT_PATTERN, Pattern: "x", Quiet: DEFAULT_COMMAND_QUIET, Timeout: time.Minute}, p.commandWait(0))
+	assert.Equal(t, WaitCondition{Until: WAIT_QUIET, Quiet: time.Second, Timeout: time.Minute}, p.commandWait(1))
+	assert.Equal(t, WaitCondition{Until: WAIT_QUIET, Quiet: DEFAULT_COMMAND_QUIET, Timeout: time.Minute}, p.commandWait(2))
+	assert.Equal(t, WaitCondition{Until: WAIT_AUTO, Quiet: DEFAULT_COMMAND_QUIET, Timeout: DEFAULT_COMMAND_TIMEOUT}, Profile{}.commandWait(0))
+
+	assert.NoError(t, p.validateCommandWaits())
+	p.CommandWaits[1].Until = WAIT_PATTERN
+	assert.ErrorContains(t, p.validateCommandWaits(), "command 2: wait until pattern needs a pattern")
+	p.CommandWait.Pattern = "$ "
+	assert.NoError(t, p.validateCommandWaits(), "the pattern is taken from command-wait")
+}
+
+func TestMatchCommandWaits(t *testing.T) {
+	wait := WaitCondition{Until: WAIT_PROMPT}
+	p := Profile{Commands: []string{"a", "b"}, CommandWaits: []WaitCondition{{}, wait}}
+	assert.Equal(t, []WaitCondition{wait, {}}, p.matchCommandWaits([]string{"b", "c"}))
+	assert.Nil(t, p.matchCommandWaits([]string{"a", "c"}))
+}
+
+func TestParseStartupCommands(t *testing.T) {
+	t.Run("strings only", func(t *testing.T) {
+		commands, waits, err := ParseStartupCommands([]any{"ls", "make"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ls", "make"}, commands)
+		assert.Nil(t, waits)
+	})
+
+	t.Run("maps", func(t *testing.T) {
+		commands, waits, err := ParseStartupCommands([]any{
+			"ls",
+			map[string]any{"run": "ssh host", "until": "pattern", "pattern": `\$ $`, "quiet": "2s", "timeout": "1m"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ls", "ssh host"}, commands)
+		assert.Equal(t, []WaitCondition{{}, {Until: WAIT_PATTERN, Pattern: `\$ $`, Quiet: 2 * time.Second, Timeout: time.Minute}}, waits)
+	})
+
+	errorCases := []struct {
+		name   string
+		raw    []any
+		errMsg string
+	}{
+		{name: "missing run", raw: []any{map[string]any{"until": "prompt"}}, errMsg: "command 1: run must be a command string"},
+		{name: "bad duration", raw: []any{"ls", map[string]any{"run": "ls", "timeout": "soon"}}, errMsg: "command 2: timeout: invalid duration soon"},
+		{name: "wrong type", raw: []any{42}, errMsg: "command 1: expected a string or a map"},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseStartupCommands(tt.raw)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// outputWatch
+// ---------------------------------------------------------------------------
+
+// waitAsync runs w.wait in a goroutine and returns a channel receiving its
+// result.
+func waitAsync(w *outputWatch, cond WaitCondition, stop <-chan struct{}) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- w.wait(cond, stop) }()
+	return done
+}
+
+// requireWaiting fails if done has a result within a short time.
+func requireWaiting(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitResult returns the result received on done.
+func waitResult(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait did not return")
+		return nil
+	}
+}
+
+func TestOutputWatch(t *testing.T) {
+	prompt := WaitCondition{Until: WAIT_PROMPT, Timeout: 5 * time.Second}
+
+	t.Run("prompt marker split across reads", func(t *testing.T) {
+		w := newOutputWatch()
+		done := waitAsync(w, prompt, nil)
+		w.observe([]byte("welcome\r\n\x1b]13"))
+		requireWaiting(t, done)
+		w.observe([]byte("3;A\x1b\\$ "))
+		assert.NoError(t, waitResult(t, done))
+	})
+
+	t.Run("only output after mark counts", func(t *testing.T) {
+		w := newOutputWatch()
+		w.observe([]byte("\x1b]133;A\a$ "))
+		require.NoError(t, w.wait(prompt, nil))
+		w.mark()
+		done := waitAsync(w, prompt, nil)
+		w.observe([]byte("ls\r\nfile\r\n"))
+		requireWaiting(t, done)
+		w.observe([]byte("\x1b]133;D;0\a\x1b]133;A\a$ "))
+		assert.NoError(t, waitResult(t, done))
+	})
+
+	t.Run("failed command", func(t *testing.T) {
+		w := newOutputWatch()
+		w.observe([]byte("\x1b]133;D;127\a\x1b]133;A\a$ "))
+		assert.EqualError(t, w.wait(prompt, nil), "previous command exited with status 127")
+	})
+
+	t.Run("pattern", func(t *testing.T) {
+		w := newOutputWatch()
+		done := waitAsync(w, WaitCondition{Until: WAIT_PATTERN, Pattern: `login: $`, Timeout: 5 * time.Second}, nil)
+		w.observe([]byte("Welcome\r\nlog"))
+		requireWaiting(t, done)
+		w.observe([]byte("in: "))
+		assert.NoError(t, waitResult(t, done))
+	})
+
+	t.Run("quiet period after output", func(t *testing.T) {
+		w := newOutputWatch()
+		done := waitAsync(w, WaitCondition{Until: WAIT_QUIET, Quiet: 20 * time.Millisecond, Timeout: 5 * time.Second}, nil)
+		requireWaiting(t, done)
+		w.observe([]byte("$ "))
+		assert.NoError(t, waitResult(t, done))
+	})
+
+	t.Run("auto takes a prompt without waiting for quiet", func(t *testing.T) {
+		w := newOutputWatch()
+		w.observe([]byte("\x1b]133;A\a$ "))
+		assert.NoError(t, w.wait(WaitCondition{Until: WAIT_AUTO, Quiet: time.Hour, Timeout: time.Hour}, nil))
+	})
+
+	t.Run("none does not wait", func(t *testing.T) {
+		assert.NoError(t, newOutputWatch().wait(WaitCondition{Until: WAIT_NONE}, nil))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		w := newOutputWatch()
+		err := w.wait(WaitCondition{Until: WAIT_PROMPT, Timeout: 20 * time.Millisecond}, nil)
+		assert.ErrorIs(t, err, errNotReady)
+		assert.EqualError(t, err, "shell not ready after 20ms waiting for a prompt")
+	})
+
+	t.Run("process exits", func(t *testing.T) {
+		w := newOutputWatch()
+		done := waitAsync(w, prompt, nil)
+		w.close()
+		assert.EqualError(t, waitResult(t, done), "shell not ready: the process exited")
+	})
+
+	t.Run("session closes", func(t *testing.T) {
+		stop := make(chan struct{})
+		done := waitAsync(newOutputWatch(), prompt, stop)
+		close(stop)
+		assert.ErrorIs(t, waitResult(t, done), errSessionClosed)
+	})
+
+	t.Run("keeps only the end of long output", func(t *testing.T) {
+		w := newOutputWatch()
+		w.observe([]byte(strings.Repeat("x", MAX_WATCH_BUFFER)))
+		w.observe([]byte("\x1b]133;A\a"))
+		assert.Len(t, w.buf, MAX_WATCH_BUFFER)
+		assert.NoError(t, w.wait(prompt, nil))
+	})
+}
+
+// ---------------------------------------------------------------------------
+// terminalHandler startup commands
+// ---------------------------------------------------------------------------
+
+func TestStartupCommands(t *testing.T) {
+	t.Run("each command waits for the shell to be ready", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["dev"] = Profile{
+			Commands:    []string{"cd ~/src", "make"},
+			CommandWait: WaitCondition{Until: WAIT_PROMPT},
+		}
+		ts.setActiveProfileName("dev")
+		backend, conn := newFakeTerminal(t, ts, PROTOCOL_V1)
+		proc := backend.process(t, 0)
+		keepReading(conn)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, proc.inputString())
+		proc.emit("\x1b]133;A\a$ ")
+		require.Eventually(t, func() bool { return proc.inputString() == "cd ~/src\n" }, 2*time.Second, 5*time.Millisecond)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, "cd ~/src\n", proc.inputString(), "the second command waits for the next prompt")
+		proc.emit("cd ~/src\r\n\x1b]133;D;0\a\x1b]133;A\a$ ")
+		require.Eventually(t, func() bool { return proc.inputString() == "cd ~/src\nmake\n" }, 2*time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("a failed wait stops the remaining commands and is reported", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["dev"] = Profile{
+			Commands:     []string{"ssh host", "uptime"},
+			CommandWaits: []WaitCondition{{Until: WAIT_PROMPT, Timeout: 200 * time.Millisecond}},
+		}
+		ts.setActiveProfileName("dev")
+		backend, conn := newFakeTerminal(t, ts, PROTOCOL_V1)
+		proc := backend.process(t, 0)
+
+		var output string
+		logs := captureLog(func() {
+			output = readOutputFrame(t, conn)
+		})
+		assert.Equal(t, "\r\n[startup command \"ssh host\" not sent: shell not ready after 200ms waiting for a prompt]\r\n", output)
+		assert.Contains(t, logs, `startup command 1 ("ssh host") not sent`)
+		assert.Empty(t, proc.inputString())
+	})
+}
@@ -0,0 +1,195 @@
+package src
+
+import (
+	"maps"
+	"slices"
+	"sort"
+)
+
+// The methods in this file are the only code paths that read or write the
+// mutable TerminalServer fields (Client, Profiles, Themes, ActiveTheme,
+// ProfileName, OrgCols, OrgRows and FirstRun) once the server is running.
+// Every accessor holds stateMu for the duration of the access, and every read
+// returns a copy so callers can use the result after the lock is released
+// without racing concurrent writers. The exported fields remain the way the
+// state is seeded before Serve is called.
+
+// clientSnapshot returns a copy of the current client settings, including the
+// active theme.
+func (ts *TerminalServer) clientSnapshot() Client {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	return *ts.Client
+}
+
+// activeTheme returns a copy of the active theme and its name.
+func (ts *TerminalServer) activeTheme() (Theme, string) {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	return ts.Client.Theme, ts.ActiveTheme
+}
+
+// activateTheme makes t the active client theme under the given name.
+func (ts *TerminalServer) activateTheme(name string, t Theme) {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	ts.Client.Theme = t
+	ts.ActiveTheme = name
+}
+
+// theme returns a copy of the named user-defined theme.
+func (ts *TerminalServer) theme(name string) (Theme, bool) {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	t, ok := ts.Themes[name]
+	return t, ok
+}
+
+// themeNames returns the sorted names of all user-defined themes.
+func (ts *TerminalServer) themeNames() []string {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	names := slices.Collect(maps.Keys(ts.Themes))
+	sort.Strings(names)
+	return names
+}
+
+// registerTheme adds t under name when no theme by that name exists yet, then
+// activates the stored theme. It returns the theme that is now active, which
+// is the existing entry when one was already registered.
+func (ts *TerminalServer) registerTheme(name string, t Theme) Theme {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	if ts.Themes == nil {
+		ts.Themes = make(map[string]Theme)
+	}
+	if existing, ok := ts.Themes[name]; ok {
+		t = existing
+	} else {
+		ts.Themes[name] = t
+	}
+	ts.Client.Theme = t
+	ts.ActiveTheme = name
+	return t
+}
+
+// saveTheme creates or overwrites the named theme and activates it. A
+// background image on an existing entry is carried over because the browser
+// never receives the server-side path and so cannot send it back. The stored
+// theme is returned.
+func (ts *TerminalServer) saveTheme(name string, t Theme) Theme {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	if ts.Themes == nil {
+		ts.Themes = make(map[string]Theme)
+	}
+	if existing, ok := ts.Themes[name]; ok && existing.BackgroundImage != "" {
+		t.BackgroundImage = existing.BackgroundImage
+	}
+	ts.Themes[name] = t
+	ts.Client.Theme = t
+	ts.ActiveTheme = name
+	return t
+}
+
+// profile returns a copy of the named profile.
+func (ts *TerminalServer) profile(name string) (Profile, bool) {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	p, ok := ts.Profiles[name]
+	p.Commands = slices.Clone(p.Commands)
+	p.CommandWaits = slices.Clone(p.CommandWaits)
+	return p, ok
+}
+
+// profilesSnapshot returns a copy of the profiles map.
+func (ts *TerminalServer) profilesSnapshot() map[string]Profile {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	profiles := make(map[string]Profile, len(ts.Profiles))
+	for name, p := range ts.Profiles {
+		p.Commands = slices.Clone(p.Commands)
+		p.CommandWaits = slices.Clone(p.CommandWaits)
+		profiles[name] = p
+	}
+	return profiles
+}
+
+// setProfile creates or overwrites the named profile.
+func (ts *TerminalServer) setProfile(name string, p Profile) {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	if ts.Profiles == nil {
+		ts.Profiles = make(map[string]Profile)
+	}
+	ts.Profiles[name] = p
+}
+
+// deleteProfile removes the named profile. Deleting an absent name is a no-op.
+func (ts *TerminalServer) deleteProfile(name string) {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	delete(ts.Profiles, name)
+}
+
+// activeProfileName returns the profile most recently selected by a page load.
+func (ts *TerminalServer) activeProfileName() string {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	return ts.ProfileName
+}
+
+// setActiveProfileName records the profile selected by a page load so the
+// WebSocket that follows starts the matching shell.
+func (ts *TerminalServer) setActiveProfileName(name string) {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	ts.ProfileName = name
+}
+
+// initialSize returns the pty dimensions stored by setSizeHandler.
+func (ts *TerminalServer) initialSize() (uint16, uint16) {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	return ts.OrgCols, ts.OrgRows
+}
+
+// setInitialSize stores the pty dimensions used by the next session.
+func (ts *TerminalServer) setInitialSize(cols, rows uint16) {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	ts.OrgCols, ts.OrgRows = cols, rows
+}
+
+// isFirstRun reports whether the first-run setup page should be served.
+func (ts *TerminalServer) isFirstRun() bool {
+	ts.stateMu.RLock()
+	defer ts.stateMu.RUnlock()
+	return ts.FirstRun
+}
+
+// completeFirstRun clears the first-run flag. When colors is non-nil they are
+// merged into the client theme, which is then registered under name and
+// activated in the same critical section.
+func (ts *TerminalServer) completeFirstRun(name string, colors map[string]any) {
+	ts.stateMu.Lock()
+	defer ts.stateMu.Unlock()
+	if colors != nil {
+		ts.Client.Theme.MapToTheme(colors)
+		if ts.Themes == nil {
+			ts.Themes = make(map[string]Theme)
+		}
+		ts.Themes[name] = ts.Client.Theme
+		ts.ActiveTheme = name
+	}
+	ts.FirstRun = false
+}
+
+// updateConfig runs fn with the config file path while holding configMu.
+// The config writers read, modify and rewrite the whole file, so concurrent
+// edits must be serialized or one would silently discard the other.
+func (ts *TerminalServer) updateConfig(fn func(path string) error) error {
+	ts.configMu.Lock()
+	defer ts.configMu.Unlock()
+	return fn(ts.ConfigFile)
+}
@@ -0,0 +1,168 @@
+package src
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------------
+// State accessors
+// ---------------------------------------------------------------------------
+
+func TestStateSnapshots(t *testing.T) {
+	t.Run("profilesSnapshot is independent of the live map", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["dev"] = Profile{Shell: "/bin/bash", Commands: []string{"ls"}}
+		snap := ts.profilesSnapshot()
+		snap["new"] = Profile{}
+		snap["dev"].Commands[0] = "rm"
+		assert.NotContains(t, ts.Profiles, "new")
+		assert.Equal(t, "ls", ts.Profiles["dev"].Commands[0])
+	})
+
+	t.Run("profile copies the commands slice", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Profiles["dev"] = Profile{Commands: []string{"ls"}}
+		p, ok := ts.profile("dev")
+		require.True(t, ok)
+		p.Commands[0] = "rm"
+		assert.Equal(t, "ls", ts.Profiles["dev"].Commands[0])
+	})
+
+	t.Run("profile reports unknown names", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		_, ok := ts.profile("missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("clientSnapshot is independent of the live client", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		c := ts.clientSnapshot()
+		c.Theme.Foreground = "#fff"
+		assert.Empty(t, ts.Client.Theme.Foreground)
+	})
+
+	t.Run("themeNames is sorted", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Themes = map[string]Theme{"b": {}, "c": {}, "a": {}}
+		assert.Equal(t, []string{"a", "b", "c"}, ts.themeNames())
+	})
+
+	t.Run("registerTheme keeps an existing entry", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Themes = map[string]Theme{"mine": {Foreground: "#111"}}
+		got := ts.registerTheme("mine", Theme{Foreground: "#222"})
+		assert.Equal(t, "#111", got.Foreground)
+		assert.Equal(t, "#111", ts.Client.Theme.Foreground)
+		assert.Equal(t, "mine", ts.ActiveTheme)
+	})
+
+	t.Run("registerTheme initializes a nil map", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Themes = nil
+		ts.registerTheme("mine", Theme{Foreground: "#222"})
+		assert.Equal(t, "#222", ts.Themes["mine"].Foreground)
+	})
+
+	t.Run("saveTheme preserves the background image", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.Themes = map[string]Theme{"mine": {BackgroundImage: "/img.png"}}
+		got := ts.saveTheme("mine", Theme{Foreground: "#222"})
+		assert.Equal(t, "/img.png", got.BackgroundImage)
+		assert.Equal(t, "/img.png", ts.Themes["mine"].BackgroundImage)
+		assert.Equal(t, "mine", ts.ActiveTheme)
+	})
+
+	t.Run("completeFirstRun without colors only clears the flag", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.FirstRun = true
+		ts.completeFirstRun("skip", nil)
+		assert.False(t, ts.FirstRun)
+		assert.Empty(t, ts.Themes)
+	})
+
+	t.Run("completeFirstRun registers and activates the theme", func(t *testing.T) {
+		ts := newTestTerminalServer()
+		ts.FirstRun = true
+		ts.completeFirstRun("b3tty-dark", defaultDarkTheme)
+		assert.False(t, ts.FirstRun)
+		assert.Equal(t, "b3tty-dark", ts.ActiveTheme)
+		assert.Equal(t, ts.Client.Theme, ts.Themes["b3tty-dark"])
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Concurrent handler access (run with -race)
+// ---------------------------------------------------------------------------
+
+// TestHandlersConcurrentAccess drives the state-mutating and state-reading
+// handlers in parallel against one TerminalServer. Before the state accessors
+// existed this panicked with "concurrent map writes" or was flagged by the race
+// detector; it must stay clean under go test -race.
+func TestHandlersConcurrentAccess(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ts := newTestTerminalServer()
+	ts.ConfigFile = writeTempConfig(t, "")
+	ts.Themes = map[string]Theme{"seed": {Foreground: "#fff"}}
+
+	post := func(handler http.HandlerFunc, target, body string) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler(httptest.NewRecorder(), req)
+	}
+	get := func(handler http.HandlerFunc, target string) {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	const workers = 8
+	const iterations = 10
+	ops := []func(i int){
+		func(i int) {
+			post(ts.editThemeHandler, "/edit-theme", fmt.Sprintf(`{"name":"theme-%d","theme":{"foreground":"#000"}}`, i%4))
+		},
+		func(i int) {
+			post(ts.editProfileHandler, "/edit-profile", fmt.Sprintf(`{"name":"prof-%d","profile":{"shell":"/bin/sh","commands":["ls"]}}`, i%4))
+		},
+		func(i int) { post(ts.deleteProfileHandler, "/delete-profile", fmt.Sprintf(`{"name":"prof-%d"}`, i%4)) },
+		func(i int) { post(ts.addThemeHandler, "/add-theme", `{"theme":"dracula"}`) },
+		func(i int) { post(ts.themeConfigHandler, "/theme-config?name=seed", "") },
+		func(i int) { post(ts.setSizeHandler, fmt.Sprintf("/size?cols=%d&rows=24", 80+i), "") },
+		func(i int) { get(ts.displayTermHandler, "/?token="+ts.Token+"&profile=work") },
+		func(i int) { get(ts.displayTermHandler, "/?token=wrong") },
+		func(i int) { get(ts.themePaletteHandler, "/theme?name=seed") },
+		func(i int) { get(ts.profileConfigHandler, fmt.Sprintf("/profile-config?name=prof-%d", i%4)) },
+		func(i int) { get(ts.backgroundHandler, "/background") },
+	}
+
+	captureLog(func() {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			for _, op := range ops {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < iterations; i++ {
+						op(i)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+	})
+
+	// Every edited theme must have survived the concurrent config rewrites.
+	names, err := ReadThemeNames(ts.ConfigFile)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		assert.Contains(t, names, fmt.Sprintf("theme-%d", i))
+		assert.Contains(t, ts.themeNames(), fmt.Sprintf("theme-%d", i))
+	}
+	assert.Equal(t, "work", ts.activeProfileName())
+}
@@ -1,23 +1,29 @@
 package src
 
 import (
+	"bytes"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
-	"os/exec"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/creack/pty"
 	"github.com/gorilla/websocket"
 )
 
+// upgrader holds the upgrade settings shared by every TerminalServer. Use
+// TerminalServer.upgrader, which applies the server's compression setting.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:    BUFFER_SIZE,
 	WriteBufferSize:   BUFFER_SIZE,
 	EnableCompression: false,
+	// Clients that request PROTOCOL_V1 get the typed framing in protocol.go;
+	// clients that request no subprotocol keep the legacy framing.
+	Subprotocols: []string{PROTOCOL_V1},
 	// CheckOrigin rejects cross-origin WebSocket upgrade requests. An absent
 	// Origin header (non-browser clients) is allowed; any browser-sent Origin
 	// must match the Host the browser used to reach this server, preventing
@@ -35,8 +41,16 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// parseResizeMessage tries to unmarshal message as a JSON resize command of the form
-// {"type":"resize","cols":N,"rows":N}. On success it returns (cols, rows, true).
+// upgrader returns the WebSocket upgrader for ts, offering permessage-deflate
+// when Server.Compression is enabled.
+func (ts *TerminalServer) upgrader() *websocket.Upgrader {
+	u := upgrader
+	u.EnableCompression = ts.Server.Compression.Enabled
+	return &u
+}
+
+// parseResizeMessage tries to unmarshal message as a legacy JSON resize command of
+// the form {"type":"resize","cols":N,"rows":N}. On success it returns (cols, rows, true).
 // Any parse failure or a non-"resize" type returns (0, 0, false).
 func parseResizeMessage(message []byte) (uint16, uint16, bool) {
 	var msg struct {
@@ -57,142 +71,526 @@ func formatCommand(command string) []byte {
 }
 
 // terminalHandler upgrades the HTTP connection to a WebSocket, starts the
-// active profile's shell under a pty sized to the dimensions stored by
-// setSizeHandler, then runs two goroutines bridging pty output → WebSocket
-// and WebSocket input → pty. A done channel coordinated with sync.Once lets
-// the input goroutine distinguish a clean PTY-initiated shutdown from an
-// unexpected WebSocket error.
+// active profile's shell through the profile's Backend with a terminal sized
+// to the dimensions stored by setSizeHandler, then bridges pty output →
+// WebSocket and WebSocket input → pty until the shell exits or the client goes
+// away. Messages are framed according to the subprotocol negotiated during the
+// upgrade; see termConn.
+//
+// A request carrying ?session=<id> for a detached session (see
+// Server.DetachGracePeriod) reattaches to that session's shell instead of
+// starting a new one.
 func (ts *TerminalServer) terminalHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	lg := logWith(slog.String("remote", r.RemoteAddr))
 	Debugf(" %s -> %s %s %s", r.RemoteAddr, r.Host, r.Method, r.URL)
 	Debugf("content length: %d", r.ContentLength)
-	ws, err := upgrader.Upgrade(w, r, nil)
+	if ts.isClosed() {
+		Warnf("%s %s: server is closed", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	ws, err := ts.upgrader().Upgrade(w, r, nil)
 	if err != nil {
-		Errorf("upgrader error: %v", err)
+		lg.Errorf("upgrader error: %v", err)
+		ts.metrics.websocketError(wsOpUpgrade)
 		return
 	}
 	defer ws.Close()
+	conn := newTermConn(ws)
+	Debugf("websocket subprotocol: %q", ws.Subprotocol())
+	if ts.Server.Compression.Enabled {
+		if err := conn.enableCompression(ts.Server.Compression.settings()); err != nil {
+			lg.Errorf("websocket compression: %v", err)
+			return
+		}
+	}
 
-	profile := ts.Profiles[ts.ProfileName]
+	if id := r.URL.Query().Get("session"); id != "" {
+		if sess := ts.claimDetachedSession(id); sess != nil {
+			lg.with(slog.String("session", id)).Infof("session %s reattached", id)
+			ts.audit(r, auditEvent{Event: auditSessionReattach, Profile: sess.Profile, Session: id})
+			sess.attach <- conn
+			// The session's goroutine now owns conn; hold the request open
+			// until it is done with it.
+			<-conn.done
+			return
+		}
+		lg.Warnf("session %s cannot be reattached; starting a new session", id)
+	}
+
+	profileName := ts.activeProfileName()
+	profile, _ := ts.profile(profileName)
+	lg = lg.with(slog.String("profile", profileName))
 
-	// Start the active profile's shell via /bin/sh -c so that shell flags and
-	// paths are handled uniformly regardless of the configured shell binary.
-	c := exec.Command("/bin/sh", "-c", profile.Shell)
-	c, err = profile.ApplyToCommand(c)
+	backend, err := ts.backend(profile.Type)
 	if err != nil {
-		Errorf("apply profile to command: %v", err)
+		lg.Errorf("profile %s: %v", profileName, err)
+		_ = conn.writeError(err.Error())
 		return
 	}
 
-	windowSize := &pty.Winsize{
-		Cols: ts.OrgCols,
-		Rows: ts.OrgRows,
+	release, err := ts.reserveSession(profileName, profile.MaxSessions)
+	if err != nil {
+		total, ofProfile := ts.sessionCounts(profileName)
+		lg.Warnf("session rejected (profile %s): %v; %d sessions active, %d of profile %s", profileName, err, total, ofProfile, profileName)
+		ts.audit(r, auditEvent{Event: auditSessionRejected, Profile: profileName, Detail: err.Error()})
+		_ = conn.writeClose(websocket.CloseTryAgainLater, err.Error())
+		return
 	}
+	defer release()
 
-	Debugf("cols: %d", windowSize.Cols)
-	Debugf("rows: %d", windowSize.Rows)
+	cols, rows := ts.initialSize()
+	Debugf("cols: %d", cols)
+	Debugf("rows: %d", rows)
 	Debug("starting pty....")
-	ptmx, err := pty.StartWithSize(c, windowSize)
+	proc, err := backend.Start(profile, cols, rows)
 	if err != nil {
-		Errorf("start pty: %v", err)
+		lg.Errorf("%v", err)
+		ts.metrics.spawnFailed(profileName)
+		_ = conn.writeError(err.Error())
 		return
 	}
 
-	defer func() { _ = ptmx.Close() }() // Best effort.
+	sessionID, err := generateToken(8)
+	if err != nil {
+		Errorf("session id: %v", err)
+		_ = proc.Close()
+		return
+	}
+	sess := newTerminalSession(sessionID, profileName, proc, ts.Server.output(), ts.Server.killGrace())
+	sess.log = lg.with(slog.String("session", sessionID))
+	sess.metrics = &ts.metrics
+	sess.commands = profile.Commands
+	sess.waits = make([]WaitCondition, len(profile.Commands))
+	for i := range sess.waits {
+		sess.waits[i] = profile.commandWait(i)
+	}
+	sess.policy = profile.exitPolicy()
+	sess.spawn = func(cols, rows uint16) (Process, error) {
+		return backend.Start(profile, cols, rows)
+	}
+	sess.cols, sess.rows = cols, rows
+	if !ts.addSession(sess) {
+		Warn("server closed before the terminal session started")
+		sess.close()
+		return
+	}
+	release()
+	// The session stays registered until its processes have exited.
+	defer func() {
+		ts.removeSession(sess)
+		total, ofProfile := ts.sessionCounts(profileName)
+		sess.log.Infof("session %s ended (profile %s); %d sessions active, %d of profile %s", sess.ID, profileName, total, ofProfile, profileName)
+		ts.audit(r, auditEvent{Event: auditSessionEnd, Profile: profileName, Session: sess.ID, Detail: fmt.Sprintf("open for %s", time.Since(sess.Started).Round(time.Second))})
+	}()
+	defer sess.close()
+	total, ofProfile := ts.sessionCounts(profileName)
+	sess.log.Infof("session %s started (profile %s); %d sessions active, %d of profile %s", sess.ID, profileName, total, ofProfile, profileName)
+	ts.metrics.sessionStarted(profileName, time.Since(start))
+	ts.audit(r, auditEvent{Event: auditSessionStart, Profile: profileName, Session: sess.ID})
+	_ = conn.writeJSON(msgSession, sessionPayload{ID: sess.ID})
+	if profile.Title != "" {
+		_ = conn.writeMessage(msgTitle, []byte(profile.Title))
+	}
 
-	// done is closed by the PTY output goroutine just before it calls
-	// ws.Close(). This lets the WebSocket input goroutine distinguish a
-	// planned shutdown (PTY exited) from a genuine unexpected error.
-	done := make(chan struct{})
-	var closeOnce sync.Once
-	signalDone := func() { closeOnce.Do(func() { close(done) }) }
+	go sess.sendCommands(sess.current())
+	idle, maxDuration := ts.sessionTimeouts(profile)
+	go sess.enforceTimeouts(idle, maxDuration, SESSION_TIMEOUT_WARNING)
+	ts.runSession(sess, conn)
+}
 
-	// Handle input from the WebSocket
-	go func() {
-		for {
-			msgType, message, err := ws.ReadMessage()
-			if err != nil {
-				select {
-				case <-done:
-					// ws.Close() was called by us after the PTY exited — not an error.
-					Warn("websocket closed after terminal session ended")
-				default:
-					switch err.(type) {
-					case *websocket.CloseError:
-						Warn("cannot read from websocket: unexpectedly closed")
-					default:
-						Errorf("websocket read: %v", err)
-					}
+// sendCommands types each of the session's startup commands into r, a run of
+// the session's process, once the shell is ready for it. When the shell does
+// not become ready, the remaining commands are not sent and the client is
+// told why.
+func (s *session) sendCommands(r *run) {
+	for i, command := range s.commands {
+		err := r.watch.wait(s.waits[i], s.stop)
+		if errors.Is(err, errSessionClosed) {
+			return
+		}
+		if err != nil {
+			s.log.Warnf("session %s: startup command %d (%q) not sent: %v", s.ID, i+1, command, err)
+			s.notify(fmt.Sprintf("startup command %q not sent: %v", command, err))
+			return
+		}
+		r.watch.mark()
+		if _, err := r.proc.Write(formatCommand(command)); err != nil {
+			Errorf("write to pty: %v", err)
+			s.close()
+			return
+		}
+	}
+}
+
+// runSession serves sess over conn and then, each time the connection is
+// lost, waits up to Server.DetachGracePeriod for a new connection to continue
+// with. It returns once the process has exited, the client has closed the
+// connection, the session has been closed, or no client reattached in time.
+func (ts *TerminalServer) runSession(sess *session, conn *termConn) {
+	interval, timeout := ts.Server.keepalive()
+	for {
+		lost := sess.serve(conn, interval, timeout)
+		conn.close()
+		grace := ts.Server.DetachGracePeriod
+		if !lost || grace <= 0 {
+			return
+		}
+		if conn = ts.waitForReattach(sess, grace); conn == nil {
+			return
+		}
+	}
+}
+
+// waitForReattach marks sess detached and returns the connection of the first
+// client to reattach within grace, or nil when none does or the session is
+// closed first. While detached the session's output is not read, so the shell
+// keeps running but blocks once the pty buffer is full.
+func (ts *TerminalServer) waitForReattach(sess *session, grace time.Duration) *termConn {
+	ts.setDetached(sess, true)
+	sess.log.Infof("session %s detached; waiting %s for the client to reconnect", sess.ID, grace)
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case conn := <-sess.attach:
+		return conn
+	case <-timer.C:
+		sess.log.Infof("session %s was not reattached within %s", sess.ID, grace)
+	case <-sess.stop:
+	}
+	if !ts.setDetached(sess, false) {
+		// A handler claimed the session just as the wait ended and is
+		// handing over its connection.
+		conn := <-sess.attach
+		select {
+		case <-sess.stop:
+			conn.close()
+			return nil
+		default:
+			return conn
+		}
+	}
+	return nil
+}
+
+// serve bridges the session's process and conn until one side goes away. It
+// reports whether the connection was lost, as opposed to the process exiting,
+// the client closing the connection deliberately, or the session being closed.
+//
+// Output is batched as described by Server.OutputBatchWindow: a chunk arriving
+// within the batch window of the previous write waits up to the window for
+// more output before it is sent, so a flood of small reads is delivered in a
+// few large frames while interactive echo goes out at once.
+//
+// Once the client starts acknowledging output, at most the flow control window
+// of unacknowledged output is in flight. While the window is full, output is
+// not consumed, so pumpOutput stops reading and the pty blocks the process.
+func (s *session) serve(conn *termConn, interval, timeout time.Duration) (lost bool) {
+	stopHeartbeat := conn.startHeartbeat(interval, timeout)
+	defer stopHeartbeat()
+
+	inputLost := make(chan bool, 1)
+	go func() { inputLost <- s.readInput(conn) }()
+	// finish closes conn, which ends readInput, and waits for it so that no
+	// input from this connection reaches the process after serve returns.
+	finish := func() {
+		conn.close()
+		<-inputLost
+	}
+
+	if s.pending != nil {
+		if err := conn.writeOutput(s.pending); err != nil {
+			Errorf("write from pty: %v", err)
+			s.metrics.websocketError(wsOpWrite)
+			finish()
+			return true
+		}
+		s.metrics.sent(len(s.pending))
+		s.pending = nil
+	}
+	var lastWrite time.Time
+	paused := false
+	for {
+		output := s.current().output
+		if conn.windowFull(s.out.flowWindow) {
+			if !paused {
+				s.log.Debugf("session %s: flow control window full; pausing output", s.ID)
+			}
+			output = nil
+		}
+		paused = output == nil
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				switch s.afterExit(conn, inputLost) {
+				case exitRestarted:
+					continue
+				case exitConnLost:
+					return true
+				case exitConnClosed:
+					return false
 				}
-				ptmx.Close()
-				break
+				finish()
+				return false
 			}
-			if msgType == websocket.TextMessage {
-				if cols, rows, ok := parseResizeMessage(message); ok {
-					if cols == 0 || rows == 0 {
-						Warnf("ignoring resize to invalid dimensions: cols=%d rows=%d", cols, rows)
-						continue
-					}
-					Debugf("resizing to %d, %d", cols, rows)
-					err = pty.Setsize(ptmx, &pty.Winsize{Cols: cols, Rows: rows})
-					if err != nil {
-						Errorf("error calling pty resize: %v", err)
-					}
+			open := true
+			if s.out.batchWindow >= 0 {
+				var wait time.Duration
+				if time.Since(lastWrite) < s.out.batchWindow {
+					wait = s.out.batchWindow
+				}
+				chunk, open = coalesce(output, chunk, wait, s.out.batchSize)
+			}
+			if err := conn.writeOutput(chunk); err != nil {
+				Errorf("write from pty: %v", err)
+				s.metrics.websocketError(wsOpWrite)
+				s.pending = chunk
+				finish()
+				return true
+			}
+			s.metrics.sent(len(chunk))
+			s.touch()
+			lastWrite = time.Now()
+			if !open {
+				switch s.afterExit(conn, inputLost) {
+				case exitRestarted:
 					continue
+				case exitConnLost:
+					return true
+				case exitConnClosed:
+					return false
 				}
+				finish()
+				return false
 			}
-			_, err = ptmx.Write(message)
-			if err != nil {
-				Errorf("write to pty: %v", err)
-				ptmx.Close()
-				break
+		case <-conn.credit:
+			// An ack arrived; re-check the window.
+		case note := <-s.notices:
+			if err := conn.writeOutput([]byte("\r\n[" + note + "]\r\n")); err != nil {
+				Errorf("write from pty: %v", err)
+				s.metrics.websocketError(wsOpWrite)
+				finish()
+				return true
 			}
+		case lost := <-inputLost:
+			return lost
+		case <-s.stop:
+			s.writeCloseReason(conn)
+			finish()
+			return false
 		}
-	}()
+	}
+}
 
-	// Handle output from the pty
-	go func() {
-		buf := make([]byte, BUFFER_SIZE)
-		for {
-			n, err := ptmx.Read(buf)
-			Debugf("bytes read from buffer: %d", n)
-			if err != nil {
-				switch err {
-				case io.EOF:
-					Info("terminal session closed")
-				default:
-					Errorf("pty read: %v", err)
+// exitOutcome is the result of session.afterExit.
+type exitOutcome int
+
+const (
+	// exitEnded means the session is over and conn must still be finished.
+	exitEnded exitOutcome = iota
+	// exitRestarted means a new run of the process has started.
+	exitRestarted
+	// exitConnLost and exitConnClosed mean readInput returned while the
+	// session waited to restart, reporting a lost or a closed connection.
+	exitConnLost
+	exitConnClosed
+)
+
+// afterExit handles the end of the current run of the session's process,
+// whose output has been closed. It waits for the process to exit and tells
+// the client how it exited. Then, as the session's exit policy decides, it
+// either starts a normal close of conn or waits to restart the process.
+// Nothing is reported for a process that does not exit within
+// EXIT_WAIT_TIMEOUT, and the session ends.
+func (s *session) afterExit(conn *termConn, inputLost <-chan bool) exitOutcome {
+	r := s.current()
+	go r.wait()
+	select {
+	case <-r.waited:
+	case <-time.After(EXIT_WAIT_TIMEOUT):
+		s.log.Warnf("session %s: process did not exit within %s of closing its terminal", s.ID, EXIT_WAIT_TIMEOUT)
+		return exitEnded
+	}
+	ran := r.ended.Sub(r.started)
+	status := exitStatus(r.waitErr, ran)
+	next := s.decide(r, status)
+	status.Restart = next.mode
+	s.log.Infof("session %s: process %s after %s", s.ID, describeExit(status), ran)
+	if next.limited {
+		s.log.Warnf("session %s: not restarting after %d consecutive restarts", s.ID, s.restarts)
+	}
+	if err := conn.writeJSON(msgExit, status); err != nil {
+		Errorf("write exit status: %v", err)
+		return exitEnded
+	}
+	if next.mode == "" {
+		_ = conn.writeClose(websocket.CloseNormalClosure, "process exited")
+		return exitEnded
+	}
+
+	// Release the finished run's pty, whose output has already been read, and
+	// end any jobs the process left behind.
+	r.terminate(s.killGrace)
+	var restart <-chan time.Time
+	var note string
+	if next.mode == ON_EXIT_PROMPT {
+		note = fmt.Sprintf("\r\n[process %s; press Enter to restart]\r\n", describeExit(status))
+	} else {
+		timer := time.NewTimer(next.delay)
+		defer timer.Stop()
+		restart = timer.C
+		note = fmt.Sprintf("\r\n[process %s; restarting in %s]\r\n", describeExit(status), next.delay)
+	}
+	if err := conn.writeOutput([]byte(note)); err != nil {
+		Errorf("write from pty: %v", err)
+		s.metrics.websocketError(wsOpWrite)
+		return exitEnded
+	}
+	// Discard a request left over from an earlier prompt.
+	select {
+	case <-s.restart:
+	default:
+	}
+	s.setBetween(next.mode)
+	select {
+	case <-restart:
+	case <-s.restart:
+	case lost := <-inputLost:
+		if lost {
+			return exitConnLost
+		}
+		return exitConnClosed
+	case <-s.stop:
+		s.writeCloseReason(conn)
+		return exitEnded
+	}
+
+	cols, rows := s.size()
+	proc, err := s.spawn(cols, rows)
+	if err != nil {
+		s.log.Errorf("session %s: restart: %v", s.ID, err)
+		s.metrics.spawnFailed(s.Profile)
+		_ = conn.writeError(err.Error())
+		_ = conn.writeClose(websocket.CloseInternalServerErr, "restart failed")
+		return exitEnded
+	}
+	if err := s.respawn(proc); err != nil {
+		return exitEnded
+	}
+	s.log.Infof("session %s: process restarted", s.ID)
+	go s.sendCommands(s.current())
+	return exitRestarted
+}
+
+// coalesce appends to batch the chunks that arrive on output within wait, or
+// that are already waiting when wait is zero, until batch holds at least max
+// bytes. It reports false if output was closed meanwhile, in which case the
+// returned batch must still be sent.
+func coalesce(output <-chan []byte, batch []byte, wait time.Duration, max int) ([]byte, bool) {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < max {
+		if timeout == nil {
+			select {
+			case chunk, ok := <-output:
+				if !ok {
+					return batch, false
 				}
-				ptmx.Close()
-				signalDone()
-				ws.Close()
-				return
+				batch = append(batch, chunk...)
+			default:
+				return batch, true
 			}
-			ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
-			if err != nil {
-				Errorf("write from pty: %v", err)
-				ptmx.Close()
-				signalDone()
-				break
+			continue
+		}
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				return batch, false
 			}
+			batch = append(batch, chunk...)
+		case <-timeout:
+			return batch, true
 		}
-	}()
+	}
+	return batch, true
+}
 
-	if len(profile.Commands) > 0 {
-		time.Sleep(time.Second * 1)
-		for _, command := range profile.Commands {
-			_, err = ptmx.Write(formatCommand(command))
-			if err != nil {
-				Errorf("write to pty: %v", err)
-				ptmx.Close()
-				return
+// readInput forwards messages from conn to the session's process until the
+// connection fails or is closed. It reports whether the connection was lost:
+// a missed heartbeat, a network error or a connection dropped without a close
+// frame. A close frame from the client or a close initiated by the server is
+// not a loss.
+func (s *session) readInput(conn *termConn) (lost bool) {
+	for {
+		msg, err := conn.read()
+		var decodeErr *decodeError
+		if errors.As(err, &decodeErr) {
+			Warnf("ignoring client message: %v", err)
+			_ = conn.writeError(err.Error())
+			continue
+		}
+		if err != nil {
+			var closeErr *websocket.CloseError
+			var netErr net.Error
+			switch {
+			case conn.isClosed():
+				// conn.close() was called by us after the session ended — not an error.
+				Warn("websocket closed after terminal session ended")
+				return false
+			case errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure:
+				Infof("websocket closed by client: %v", err)
+				return false
+			case errors.As(err, &closeErr):
+				Warn("cannot read from websocket: unexpectedly closed")
+			case errors.As(err, &netErr) && netErr.Timeout():
+				Warnf("no heartbeat from client in %s", conn.readTimeout)
+			default:
+				Errorf("websocket read: %v", err)
+			}
+			s.metrics.websocketError(wsOpRead)
+			return true
+		}
+		switch msg.Op {
+		case msgResize:
+			if msg.Cols == 0 || msg.Rows == 0 {
+				Warnf("ignoring resize to invalid dimensions: cols=%d rows=%d", msg.Cols, msg.Rows)
+				continue
+			}
+			Debugf("resizing to %d, %d", msg.Cols, msg.Rows)
+			if err := s.resize(msg.Cols, msg.Rows); err != nil {
+				Errorf("error calling pty resize: %v", err)
+			}
+			continue
+		case msgPing:
+			if err := conn.writeMessage(msgPong, msg.Data); err != nil {
+				Errorf("write pong: %v", err)
+			}
+			continue
+		case msgAck:
+			conn.ack(msg.Bytes)
+			continue
+		}
+		s.touch()
+		proc, waiting := s.inputTarget()
+		if proc == nil {
+			if waiting == ON_EXIT_PROMPT && bytes.ContainsAny(msg.Data, "\r\n") {
+				select {
+				case s.restart <- struct{}{}:
+				default:
+				}
 			}
-			time.Sleep(time.Millisecond * 200)
+			continue
 		}
+		if _, err := proc.Write(msg.Data); err != nil {
+			Errorf("write to pty: %v", err)
+			s.close()
+			return false
+		}
+		s.metrics.received(len(msg.Data))
 	}
-
-	// Wait for the PTY session to end. The output goroutine closes done
-	// when the PTY exits; waiting here lets the deferred ws.Close() and
-	// ptmx.Close() run on exit rather than leaking this goroutine forever.
-	<-done
 }
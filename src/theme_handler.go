@@ -4,7 +4,6 @@ import (
 	_ "embed"
 	"encoding/json"
 	"net/http"
-	"sort"
 )
 
 //go:embed default_themes/b3tty_dark.json
@@ -70,7 +69,7 @@ func (ts *TerminalServer) themePaletteHandler(w http.ResponseWriter, r *http.Req
 	}
 	name := r.URL.Query().Get("name")
 	var colors map[string]any
-	if t, ok := ts.Themes[name]; ok {
+	if t, ok := ts.theme(name); ok {
 		colors = t.toColorMap()
 	} else if builtinColors, ok := builtinThemes[name]; ok {
 		colors = builtinColors
@@ -144,7 +143,7 @@ func (ts *TerminalServer) themeConfigHandler(w http.ResponseWriter, r *http.Requ
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	theme, ok := ts.Themes[name]
+	theme, ok := ts.theme(name)
 	if !ok {
 		if builtinColors, ok := builtinThemes[name]; ok {
 			var t Theme
@@ -156,15 +155,15 @@ func (ts *TerminalServer) themeConfigHandler(w http.ResponseWriter, r *http.Requ
 		}
 	}
 	if r.Method == "POST" {
-		ts.Client.Theme = theme
-		ts.ActiveTheme = name
+		ts.activateTheme(name, theme)
 		var colors map[string]any
 		if builtinColors, ok := builtinThemes[name]; ok {
 			colors = builtinColors
 		} else {
 			colors = theme.toColorMap()
 		}
-		if err := UpdateThemeInConfig(ts.ConfigFile, name, colors); err != nil {
+		err := ts.updateConfig(func(path string) error { return UpdateThemeInConfig(path, name, colors) })
+		if err != nil {
 			Errorf("theme-config: failed to update config: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -206,43 +205,33 @@ func (ts *TerminalServer) addThemeHandler(w http.ResponseWriter, r *http.Request
 
 	// Resolve theme colors and ensure the theme is in ts.Themes.
 	var colors map[string]any
+	var active Theme
 	if builtinColors, ok := builtinThemes[req.Theme]; ok {
 		colors = builtinColors
-		if ts.Themes == nil {
-			ts.Themes = make(map[string]Theme)
-		}
-		if _, exists := ts.Themes[req.Theme]; !exists {
-			var t Theme
-			t.MapToTheme(colors)
-			ts.Themes[req.Theme] = t
-		}
-	} else if theme, ok := ts.Themes[req.Theme]; ok {
+		var t Theme
+		t.MapToTheme(colors)
+		active = ts.registerTheme(req.Theme, t)
+	} else if theme, ok := ts.theme(req.Theme); ok {
 		colors = theme.toColorMap()
+		active = ts.registerTheme(req.Theme, theme)
 	} else {
 		Warnf("%s %s: unknown theme %q", r.Method, r.URL.Path, req.Theme)
 		http.NotFound(w, r)
 		return
 	}
 
-	ts.Client.Theme = ts.Themes[req.Theme]
-	ts.ActiveTheme = req.Theme
-
-	if err := UpdateThemeInConfig(ts.ConfigFile, req.Theme, colors); err != nil {
+	err := ts.updateConfig(func(path string) error { return UpdateThemeInConfig(path, req.Theme, colors) })
+	if err != nil {
 		Errorf("add-theme: failed to update config: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	Debugf("added theme %q", req.Theme)
-	themeNames := make([]string, 0, len(ts.Themes))
-	for name := range ts.Themes {
-		themeNames = append(themeNames, name)
-	}
-	sort.Strings(themeNames)
 	resp := themeConfigResponse{
-		Theme:              ts.Client.Theme,
-		HasBackgroundImage: ts.Client.Theme.BackgroundImage != "",
-		ThemeNames:         themeNames,
+		Theme:              active,
+		HasBackgroundImage: active.BackgroundImage != "",
+		ThemeNames:         ts.themeNames(),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -285,32 +274,21 @@ func (ts *TerminalServer) editThemeHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if ts.Themes == nil {
-		ts.Themes = make(map[string]Theme)
-	}
-	if existing, ok := ts.Themes[req.Name]; ok && existing.BackgroundImage != "" {
-		req.Theme.BackgroundImage = existing.BackgroundImage
-	}
-	ts.Themes[req.Name] = req.Theme
-	ts.Client.Theme = req.Theme
-	ts.ActiveTheme = req.Name
+	req.Theme = ts.saveTheme(req.Name, req.Theme)
 
-	if err := SaveThemeToConfig(ts.ConfigFile, req.Name, req.Theme.toColorMap()); err != nil {
+	err := ts.updateConfig(func(path string) error { return SaveThemeToConfig(path, req.Name, req.Theme.toColorMap()) })
+	ts.audit(r, auditEvent{Event: auditThemeEdit, Theme: req.Name, Error: errorString(err)})
+	if err != nil {
 		Errorf("edit-theme: failed to save config: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	Debugf("saved theme %q", req.Name)
-	themeNames := make([]string, 0, len(ts.Themes))
-	for name := range ts.Themes {
-		themeNames = append(themeNames, name)
-	}
-	sort.Strings(themeNames)
 	resp := themeConfigResponse{
-		Theme:             req.Theme,
+		Theme:              req.Theme,
 		HasBackgroundImage: req.Theme.BackgroundImage != "",
-		ThemeNames:        themeNames,
+		ThemeNames:         ts.themeNames(),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
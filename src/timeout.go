@@ -0,0 +1,102 @@
+package src
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sessionTimeouts returns the idle timeout and maximum duration of sessions
+// of profile p, taking the server's settings for those p leaves at zero. Zero
+// means no limit.
+func (ts *TerminalServer) sessionTimeouts(p Profile) (idle, maxDuration time.Duration) {
+	pick := func(profile, server time.Duration) time.Duration {
+		switch {
+		case profile < 0:
+			return 0
+		case profile > 0:
+			return profile
+		}
+		return server
+	}
+	return pick(p.IdleTimeout, ts.Server.IdleTimeout), pick(p.MaxSessionDuration, ts.Server.MaxSessionDuration)
+}
+
+// touch records input or output on the session, which resets its idle
+// timeout.
+func (s *session) touch() {
+	s.active.Store(time.Now().UnixNano())
+}
+
+// lastActive returns when the session last had input or output.
+func (s *session) lastActive() time.Time {
+	return time.Unix(0, s.active.Load())
+}
+
+// Close reasons of sessions ended by enforceTimeouts.
+const (
+	reasonIdleTimeout = "idle timeout"
+	reasonMaxDuration = "maximum session duration reached"
+)
+
+// enforceTimeouts closes the session once it has had no input or output for
+// idle, or once it has been open for maxDuration, whichever comes first. A
+// zero value disables either. The client is warned warning before the session
+// is closed, or halfway there when a timeout is shorter than twice warning.
+// It returns when the session is closed.
+func (s *session) enforceTimeouts(idle, maxDuration, warning time.Duration) {
+	if idle <= 0 && maxDuration <= 0 {
+		return
+	}
+	var warned time.Time
+	for {
+		deadline, reason, limit := time.Time{}, "", time.Duration(0)
+		if maxDuration > 0 {
+			deadline, reason, limit = s.Started.Add(maxDuration), reasonMaxDuration, maxDuration
+		}
+		if idle > 0 {
+			if d := s.lastActive().Add(idle); deadline.IsZero() || d.Before(deadline) {
+				deadline, reason, limit = d, reasonIdleTimeout, idle
+			}
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			if reason == reasonIdleTimeout {
+				s.log.Infof("session %s closed: no input or output for %s", s.ID, idle)
+			} else {
+				s.log.Infof("session %s closed: open for the maximum session duration of %s", s.ID, maxDuration)
+			}
+			s.closeWith(websocket.ClosePolicyViolation, reason)
+			return
+		}
+		notice := min(warning, limit/2)
+		wake := left - notice
+		if wake <= 0 {
+			// Input or output after a warning moves an idle deadline, so
+			// each new deadline gets a warning of its own.
+			if !deadline.Equal(warned) {
+				warned = deadline
+				s.notify(timeoutWarning(reason, left))
+			}
+			wake = left
+		}
+		timer := time.NewTimer(wake)
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// timeoutWarning is the notice telling the client that the session will be
+// closed in left for reason.
+func timeoutWarning(reason string, left time.Duration) string {
+	left = left.Round(time.Second)
+	if reason == reasonIdleTimeout {
+		return fmt.Sprintf("this session is idle and will be closed in %s unless there is input or output", left)
+	}
+	return fmt.Sprintf("this session reaches its maximum duration and will be closed in %s", left)
+}
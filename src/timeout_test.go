@@ -0,0 +1,126 @@
+package src
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTimeouts(t *testing.T) {
+	ts := newTestTerminalServer()
+	ts.Server.IdleTimeout = time.Hour
+	ts.Server.MaxSessionDuration = 24 * time.Hour
+
+	idle, maxDuration := ts.sessionTimeouts(Profile{})
+	assert.Equal(t, time.Hour, idle)
+	assert.Equal(t, 24*time.Hour, maxDuration)
+
+	idle, maxDuration = ts.sessionTimeouts(Profile{IdleTimeout: time.Minute, MaxSessionDuration: -1})
+	assert.Equal(t, time.Minute, idle)
+	assert.Zero(t, maxDuration, "a negative profile value disables the timeout")
+}
+
+// newTimeoutSession returns a session around a fake process for
+// enforceTimeouts to act on.
+func newTimeoutSession(t *testing.T) *session {
+	t.Helper()
+	proc, err := (&fakeBackend{}).Start(Profile{}, 80, 24)
+	require.NoError(t, err)
+	s := newTerminalSession("idle1", "default", proc, outputConfig{bufferSize: BUFFER_SIZE}, time.Second)
+	t.Cleanup(s.close)
+	return s
+}
+
+// requireClosed waits for s to be closed.
+func requireClosed(t *testing.T, s *session, within time.Duration) {
+	t.Helper()
+	select {
+	case <-s.stop:
+	case <-time.After(within):
+		t.Fatal("session was not closed")
+	}
+}
+
+func TestEnforceTimeouts(t *testing.T) {
+	t.Run("idle sessions are warned and closed", func(t *testing.T) {
+		s := newTimeoutSession(t)
+		logs := captureLog(func() {
+			go s.enforceTimeouts(200*time.Millisecond, 0, 100*time.Millisecond)
+			select {
+			case note := <-s.notices:
+				assert.Contains(t, note, "this session is idle and will be closed in")
+			case <-time.After(time.Second):
+				t.Fatal("no warning")
+			}
+			requireClosed(t, s, time.Second)
+		})
+		assert.Equal(t, websocket.ClosePolicyViolation, s.closeCode)
+		assert.Equal(t, "idle timeout", s.closeReason)
+		assert.Contains(t, logs, "session idle1 closed: no input or output for 200ms")
+	})
+
+	t.Run("activity postpones the idle timeout", func(t *testing.T) {
+		s := newTimeoutSession(t)
+		go s.enforceTimeouts(150*time.Millisecond, 0, 50*time.Millisecond)
+		for range 6 {
+			time.Sleep(50 * time.Millisecond)
+			s.touch()
+		}
+		select {
+		case <-s.stop:
+			t.Fatal("an active session was closed")
+		default:
+		}
+		requireClosed(t, s, time.Second)
+	})
+
+	t.Run("the maximum duration applies despite activity", func(t *testing.T) {
+		s := newTimeoutSession(t)
+		logs := captureLog(func() {
+			go s.enforceTimeouts(time.Hour, 200*time.Millisecond, 50*time.Millisecond)
+			done := time.After(time.Second)
+			for {
+				select {
+				case <-s.stop:
+				case <-time.After(20 * time.Millisecond):
+					s.touch()
+					continue
+				case <-done:
+					t.Fatal("session was not closed")
+				}
+				break
+			}
+		})
+		assert.Equal(t, "maximum session duration reached", s.closeReason)
+		assert.Equal(t, "this session reaches its maximum duration and will be closed in 0s", <-s.notices)
+		assert.Contains(t, logs, "session idle1 closed: open for the maximum session duration of 200ms")
+	})
+
+	t.Run("no timeouts", func(t *testing.T) {
+		s := newTimeoutSession(t)
+		done := make(chan struct{})
+		go func() {
+			s.enforceTimeouts(0, 0, time.Minute)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("enforceTimeouts did not return")
+		}
+	})
+}
+
+func TestIdleTimeoutClosesConnection(t *testing.T) {
+	ts := newTestTerminalServer()
+	ts.Profiles["default"] = Profile{IdleTimeout: 300 * time.Millisecond}
+	_, conn := newFakeTerminal(t, ts, PROTOCOL_V1)
+
+	assert.Contains(t, readOutputFrame(t, conn), "this session is idle and will be closed in")
+	closeErr := readCloseError(t, conn)
+	assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+	assert.Equal(t, "idle timeout", closeErr.Text)
+}